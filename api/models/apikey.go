@@ -0,0 +1,23 @@
+package models
+
+import (
+	"payment/pkg/db"
+	"time"
+)
+
+// APIKey is a credential issued to a client by an operator. Only the SHA-256 of the key is
+// stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	db.StrictBaseModel
+	Name string `gorm:"not null" json:"name"`
+	// KeyID identifies the key in logs and in the audit log without revealing it.
+	KeyID string `gorm:"not null;unique" json:"key_id"`
+	Hash  string `gorm:"not null;unique" json:"-"`
+	// Admin keys are also accepted on the routes that manage the service.
+	Admin     bool       `gorm:"not null" json:"admin"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
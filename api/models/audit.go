@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditRecord is an entry of the append-only audit log. The records form a hash chain:
+// Hash covers the record and the hash of the record before it, so that changing or
+// removing a record invalidates every hash that follows.
+type AuditRecord struct {
+	Sequence   int64           `gorm:"primaryKey;autoIncrement:false" json:"sequence"`
+	CreatedAt  time.Time       `gorm:"not null" json:"created_at"`
+	Actor      string          `gorm:"not null" json:"actor"`
+	Action     string          `gorm:"not null" json:"action"`
+	EntityType string          `gorm:"not null" json:"entity_type"`
+	EntityID   string          `gorm:"not null" json:"entity_id"`
+	Before     json.RawMessage `gorm:"type:json" json:"before,omitempty"`
+	After      json.RawMessage `gorm:"type:json" json:"after,omitempty"`
+	RequestID  string          `gorm:"not null" json:"request_id,omitempty"`
+	IP         string          `gorm:"column:ip;not null" json:"ip,omitempty"`
+	PrevHash   string          `gorm:"not null" json:"prev_hash"`
+	Hash       string          `gorm:"not null" json:"hash"`
+}
+
+func (AuditRecord) TableName() string {
+	return "audit_log"
+}
+
+// AuditPending is a change recorded in the transaction that made it, waiting to be sealed into
+// the audit log, where it is numbered and chained.
+type AuditPending struct {
+	ID         int64           `gorm:"primaryKey"`
+	CreatedAt  time.Time       `gorm:"not null"`
+	Actor      string          `gorm:"not null"`
+	Action     string          `gorm:"not null"`
+	EntityType string          `gorm:"not null"`
+	EntityID   string          `gorm:"not null"`
+	Before     json.RawMessage `gorm:"type:json"`
+	After      json.RawMessage `gorm:"type:json"`
+	RequestID  string          `gorm:"not null"`
+	IP         string          `gorm:"column:ip;not null"`
+}
+
+func (AuditPending) TableName() string {
+	return "audit_pending"
+}
+
+// AuditFilter selects audit records. Empty fields match every record.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	RequestID  string
+	From       time.Time
+	To         time.Time
+	// After only returns records with a greater sequence, for paging.
+	After int64
+	Limit int
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log.
+type AuditVerification struct {
+	Checked  int64  `json:"checked"`
+	Valid    bool   `json:"valid"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// LimitName identifies a transaction limit.
+type LimitName string
+
+const (
+	LimitSingleWithdrawal   LimitName = "single_withdrawal"
+	LimitDailyWithdrawal    LimitName = "daily_withdrawal"
+	LimitMonthlyWithdrawal  LimitName = "monthly_withdrawal"
+	LimitDailyDeposit       LimitName = "daily_deposit"
+	LimitMonthlyDeposit     LimitName = "monthly_deposit"
+	LimitMaxBalance         LimitName = "max_balance"
+	LimitHourlyTransactions LimitName = "hourly_transactions"
+)
+
+// WalletLimits overrides the global limits for a single wallet. A nil field falls back
+// to the global limit, and zero lifts the limit for this wallet.
+type WalletLimits struct {
+	WalletID           uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	SingleWithdrawal   *int64    `json:"single_withdrawal" validate:"omitempty,gte=0"`
+	DailyWithdrawal    *int64    `json:"daily_withdrawal" validate:"omitempty,gte=0"`
+	MonthlyWithdrawal  *int64    `json:"monthly_withdrawal" validate:"omitempty,gte=0"`
+	DailyDeposit       *int64    `json:"daily_deposit" validate:"omitempty,gte=0"`
+	MonthlyDeposit     *int64    `json:"monthly_deposit" validate:"omitempty,gte=0"`
+	MaxBalance         *int64    `json:"max_balance" validate:"omitempty,gte=0"`
+	HourlyTransactions *int64    `json:"hourly_transactions" validate:"omitempty,gte=0"`
+	UpdatedAt          time.Time `gorm:"not null" json:"updated_at"`
+}
+
+// LimitUsage reports how much of one limit a wallet has used in the current period.
+// Limit and Remaining are nil when the limit is not set.
+type LimitUsage struct {
+	Name      LimitName  `json:"name"`
+	Limit     *int64     `json:"limit"`
+	Used      int64      `json:"used"`
+	Remaining *int64     `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+}
+
+// LimitExceeded is returned with a LIMIT_EXCEEDED error. Requested is what the rejected
+// transaction would have added to Used.
+type LimitExceeded struct {
+	LimitUsage
+	Requested int64 `json:"requested"`
+}
+
+// WalletLimitsReport is the usage of a wallet against its effective limits.
+type WalletLimitsReport struct {
+	WalletID  uuid.UUID     `json:"wallet_id"`
+	Overrides *WalletLimits `json:"overrides,omitempty"`
+	Limits    []LimitUsage  `json:"limits"`
+}
+
+// TransactionTotals sums the completed transactions of a wallet over a period.
+type TransactionTotals struct {
+	Deposits    int64
+	Withdrawals int64
+	Count       int64
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"payment/pkg/db"
+	"time"
+)
+
+// NotificationChannel is a way of reaching the owner of a wallet.
+type NotificationChannel string
+
+const (
+	NotificationSMS     NotificationChannel = "sms"
+	NotificationEmail   NotificationChannel = "email"
+	NotificationWebhook NotificationChannel = "webhook"
+)
+
+// NotificationChannels is a list of channels, stored as a JSON array.
+type NotificationChannels []NotificationChannel
+
+func (c NotificationChannels) Value() (driver.Value, error) {
+	if c == nil {
+		c = NotificationChannels{}
+	}
+	data, err := json.Marshal([]NotificationChannel(c))
+	return string(data), err
+}
+
+func (c *NotificationChannels) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("cannot scan %T into notification channels", value)
+	}
+}
+
+// Contains reports whether channel is in the list.
+func (c NotificationChannels) Contains(channel NotificationChannel) bool {
+	for _, candidate := range c {
+		if candidate == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationPreferences are the choices of the owner of a wallet about the messages they
+// receive. Wallets without preferences get every channel in the default locale.
+type NotificationPreferences struct {
+	WalletID  uuid.UUID            `gorm:"type:uuid;primaryKey" json:"-"`
+	Locale    string               `gorm:"not null" json:"locale,omitempty"`
+	Email     string               `gorm:"not null" json:"email,omitempty"`
+	OptOut    NotificationChannels `gorm:"type:jsonb;not null" json:"opt_out"`
+	UpdatedAt time.Time            `gorm:"not null" json:"updated_at"`
+}
+
+func (NotificationPreferences) TableName() string {
+	return "notification_preferences"
+}
+
+// NotificationPreferencesRequest replaces the preferences of a wallet. An empty locale
+// selects the default one.
+type NotificationPreferencesRequest struct {
+	Locale string                `json:"locale" validate:"omitempty,oneof=en fa"`
+	Email  string                `json:"email" validate:"omitempty,email,max=254"`
+	OptOut []NotificationChannel `json:"opt_out" validate:"dive,oneof=sms email webhook"`
+}
+
+type NotificationStatus string
+
+const (
+	// NotificationPending notifications are sent when NextAttemptAt is reached.
+	NotificationPending NotificationStatus = "pending"
+	// NotificationSent notifications were accepted by their channel.
+	NotificationSent NotificationStatus = "sent"
+	// NotificationFailed notifications ran out of attempts.
+	NotificationFailed NotificationStatus = "failed"
+	// NotificationSkipped notifications were not sent, for the reason in LastError: the owner
+	// opted out of the channel, or there is nowhere to send them.
+	NotificationSkipped NotificationStatus = "skipped"
+)
+
+// Notification is the message about one event sent to the owner of a wallet through one
+// channel, and the log of its delivery. The recipient is looked up when it is sent, so that
+// no phone number or address is kept with it.
+type Notification struct {
+	db.StrictBaseModel
+	EventID       uuid.UUID           `gorm:"type:uuid;not null" json:"event_id"`
+	EventType     string              `gorm:"not null" json:"event_type"`
+	WalletID      uuid.UUID           `gorm:"type:uuid;not null" json:"wallet_id"`
+	Channel       NotificationChannel `gorm:"not null" json:"channel"`
+	Locale        string              `gorm:"not null" json:"locale"`
+	Subject       string              `gorm:"not null" json:"subject"`
+	Body          string              `gorm:"not null" json:"body"`
+	Status        NotificationStatus  `gorm:"not null;type:notification_status" json:"status"`
+	Attempts      int                 `gorm:"not null" json:"attempts"`
+	NextAttemptAt time.Time           `gorm:"not null" json:"next_attempt_at"`
+	LastAttemptAt *time.Time          `json:"last_attempt_at,omitempty"`
+	LastError     string              `gorm:"not null" json:"last_error,omitempty"`
+	SentAt        *time.Time          `json:"sent_at,omitempty"`
+	// Lease identifies the claim of the dispatcher sending the notification. The outcome of an
+	// attempt is only recorded while the notification is still held by the same claim.
+	Lease *uuid.UUID `gorm:"type:uuid" json:"-"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// OTPCode is the one-time code last sent to a phone number, for Purpose. Only a salted SHA-256
+// of the code is stored. Attempts counts the wrong codes entered for the number since it was
+// last verified, across resends, and the record is kept until KeepUntil to remember them.
+type OTPCode struct {
+	Phone     string    `gorm:"primaryKey;type:varchar(20)"`
+	Hash      string    `gorm:"not null"`
+	Salt      string    `gorm:"not null"`
+	Purpose   string    `gorm:"not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+	SentAt    time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	KeepUntil time.Time `gorm:"not null"`
+	// ClientIP is the address of the client that asked for the code, which the limits on the
+	// codes sent to a client count by.
+	ClientIP string `gorm:"not null"`
+}
+
+func (OTPCode) TableName() string {
+	return "otp_codes"
+}
+
+// OwnershipToken proves, until it is used or expires, that the client verified a code sent to
+// Phone for Purpose. Only the SHA-256 of the token is stored.
+type OwnershipToken struct {
+	Hash      string    `gorm:"primaryKey"`
+	Phone     string    `gorm:"type:varchar(20);not null"`
+	Purpose   string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (OwnershipToken) TableName() string {
+	return "ownership_tokens"
+}
+
+// OTPRequest is the body of a request for a code. Purpose is the operation the code will
+// prove ownership for.
+type OTPRequest struct {
+	Phone   string `json:"phone" validate:"required"`
+	Purpose string `json:"purpose" validate:"required,oneof=registration discount"`
+}
+
+// OTPChallenge tells the client when the code it was sent expires, and when another one
+// can be requested.
+type OTPChallenge struct {
+	Phone     string    `json:"phone"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ResendAt  time.Time `json:"resend_at"`
+}
+
+// OTPVerifyRequest is the body of a code verification.
+type OTPVerifyRequest struct {
+	Phone string `json:"phone" validate:"required"`
+	Code  string `json:"code" validate:"required,numeric"`
+}
+
+// OwnershipProof carries the token issued for a verified phone number. It is only shown once,
+// and the token is accepted once, for its purpose.
+type OwnershipProof struct {
+	Phone     string    `json:"phone"`
+	Purpose   string    `json:"purpose"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OutboxEvent is a domain event waiting in the transactional outbox. It is written in the
+// transaction of the change it announces and published afterwards by the relay. Its JSON
+// form is what publishers deliver. The configured publisher keeps its own progress in the
+// Forward fields.
+type OutboxEvent struct {
+	ID              uuid.UUID       `gorm:"primary_key;type:uuid;default:gen_random_uuid()" json:"id"`
+	CreatedAt       time.Time       `gorm:"not null" json:"created_at"`
+	Type            string          `gorm:"not null" json:"type"`
+	AggregateType   string          `gorm:"not null" json:"aggregate_type"`
+	AggregateID     string          `gorm:"not null" json:"aggregate_id"`
+	Payload         json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	PublishedAt     *time.Time      `json:"-"`
+	Attempts        int             `gorm:"not null;default:0" json:"-"`
+	LastError       string          `gorm:"not null;default:''" json:"-"`
+	ForwardedAt     *time.Time      `json:"-"`
+	ForwardAttempts int             `gorm:"not null;default:0" json:"-"`
+	ForwardError    string          `gorm:"not null;default:''" json:"-"`
+	// ForwardLeasedUntil holds the event back from other relays while one forwards it.
+	ForwardLeasedUntil *time.Time `json:"-"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
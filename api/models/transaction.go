@@ -22,16 +22,17 @@ const (
 type Transaction struct {
 	db.StrictBaseModel
 	WalletID    uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
-	Type        Type      `json:"type" gorm:"not null;type:transaction_type"`
-	Amount      int64     `json:"amount"`
+	Type        Type      `json:"type" gorm:"not null;type:transaction_type" validate:"required,oneof=deposit withdrawal"`
+	Amount      int64     `json:"amount" validate:"gt=0"`
 	Status      Status    `json:"status" gorm:"not null;type:transaction_status"`
 	Description string    `json:"description" validate:"required,description"`
-	Wallet      Wallet    `gorm:"foreignKey:WalletID;constraint:OnDelete:CASCADE;" json:"-"`
+	Wallet      Wallet    `gorm:"foreignKey:WalletID;constraint:OnDelete:RESTRICT;" json:"-"`
 }
 
+// NewTransaction is the body of a deposit or withdrawal. Its phone is taken from the path.
 type NewTransaction struct {
 	Phone       string `json:"phone"`
-	Amount      int64  `json:"amount"`
-	Description string `json:"description"`
-	Type        Type   `json:"type"`
+	Amount      int64  `json:"amount" validate:"gt=0"`
+	Description string `json:"description" validate:"required,description"`
+	Type        Type   `json:"type" validate:"required,oneof=deposit withdrawal"`
 }
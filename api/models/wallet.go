@@ -1,10 +1,75 @@
 package models
 
-import "payment/pkg/db"
+import (
+	"github.com/google/uuid"
+	"payment/pkg/db"
+	"time"
+)
+
+// WalletStatus is the lifecycle state of a wallet.
+type WalletStatus string
+
+const (
+	// WalletActive allows every operation.
+	WalletActive WalletStatus = "active"
+	// WalletFrozen only accepts deposits, e.g. while a dispute is investigated.
+	WalletFrozen WalletStatus = "frozen"
+	// WalletSuspended blocks every balance change until the wallet is reactivated.
+	WalletSuspended WalletStatus = "suspended"
+	// WalletClosed blocks every balance change permanently.
+	WalletClosed WalletStatus = "closed"
+)
+
+// WalletTier is the verification level of the wallet owner. It decides which
+// operations the wallet allows and which limits apply to it.
+type WalletTier string
+
+const (
+	// TierUnverified wallets can receive deposits and discount credits but cannot withdraw.
+	TierUnverified WalletTier = "unverified"
+	// TierBasic wallets passed basic identity checks.
+	TierBasic WalletTier = "basic"
+	// TierFull wallets are fully verified.
+	TierFull WalletTier = "full"
+)
 
 type Wallet struct {
 	db.BaseModel
-	Phone        string         `gorm:"unique;type:varchar(20)" json:"phone,omitempty"`
-	Amount       int64          `gorm:"type:int;default:0" json:"amount,omitempty"`
-	Transactions []*Transaction `gorm:"constraint:OnDelete:CASCADE;" json:"transactions,omitempty"`
+	Phone           string         `gorm:"unique;type:varchar(20)" json:"phone,omitempty"`
+	Amount          int64          `gorm:"type:int;default:0" json:"amount,omitempty"`
+	Status          WalletStatus   `gorm:"type:wallet_status;not null;default:active" json:"status"`
+	StatusReason    string         `gorm:"not null;default:''" json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time     `json:"status_changed_at,omitempty"`
+	Tier            WalletTier     `gorm:"type:wallet_tier;not null;default:unverified" json:"tier"`
+	AnonymizedAt    *time.Time     `json:"anonymized_at,omitempty"`
+	DuplicateOf     *uuid.UUID     `gorm:"type:uuid" json:"duplicate_of,omitempty"`
+	Transactions    []*Transaction `gorm:"constraint:OnDelete:RESTRICT;" json:"transactions,omitempty"`
+}
+
+// WalletRequest is the body of a wallet registration. A new wallet always starts empty.
+type WalletRequest struct {
+	Phone string `json:"phone" validate:"required"`
+}
+
+// WalletStatusRequest is the body of a wallet status change.
+type WalletStatusRequest struct {
+	Status WalletStatus `json:"status" validate:"required,oneof=active frozen suspended closed"`
+	Reason string       `json:"reason" validate:"required,max=255"`
+}
+
+// WalletTierRequest is the body of a wallet tier change.
+type WalletTierRequest struct {
+	Tier   WalletTier `json:"tier" validate:"required,oneof=unverified basic full"`
+	Reason string     `json:"reason" validate:"required,max=255"`
+}
+
+// WalletTierChange records who changed the tier of a wallet, when and why.
+type WalletTierChange struct {
+	db.StrictBaseModel
+	WalletID uuid.UUID  `gorm:"type:uuid;not null;index" json:"wallet_id"`
+	From     WalletTier `gorm:"column:from_tier;type:wallet_tier;not null" json:"from"`
+	To       WalletTier `gorm:"column:to_tier;type:wallet_tier;not null" json:"to"`
+	Reason   string     `gorm:"not null" json:"reason"`
+	// Actor is the key ID of the API token that made the change.
+	Actor string `gorm:"not null" json:"actor"`
 }
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"payment/pkg/db"
+	"time"
+)
+
+// EventTypes is a list of event types, stored as a JSON array.
+type EventTypes []string
+
+func (t EventTypes) Value() (driver.Value, error) {
+	data, err := json.Marshal([]string(t))
+	return string(data), err
+}
+
+func (t *EventTypes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into event types", value)
+	}
+}
+
+// Contains reports whether eventType is in the list.
+func (t EventTypes) Contains(eventType string) bool {
+	for _, candidate := range t {
+		if candidate == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSubscription delivers the events of the listed types to URL. Every delivery is
+// signed with Secret, which is only returned when the subscription is created.
+type WebhookSubscription struct {
+	db.StrictBaseModel
+	URL        string     `gorm:"not null" json:"url"`
+	EventTypes EventTypes `gorm:"type:jsonb;not null" json:"event_types"`
+	Secret     string     `gorm:"not null" json:"secret,omitempty"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// WebhookSubscriptionRequest is the body of a subscription request. A secret is generated
+// when none is given.
+type WebhookSubscriptionRequest struct {
+	URL        string   `json:"url" validate:"required,http_url"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,oneof=wallet.created transaction.completed transaction.failed discount.redeemed"`
+	Secret     string   `json:"secret" validate:"omitempty,min=16,max=255"`
+}
+
+type DeliveryStatus string
+
+const (
+	// DeliveryPending deliveries are sent when NextAttemptAt is reached.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded deliveries were accepted by the receiver with a 2xx status.
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead deliveries ran out of attempts and are only sent again when redelivered.
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is the delivery of one event to one subscription, and the log of its attempts.
+type WebhookDelivery struct {
+	db.StrictBaseModel
+	SubscriptionID uuid.UUID       `gorm:"type:uuid;not null" json:"subscription_id"`
+	EventID        uuid.UUID       `gorm:"type:uuid;not null" json:"event_id"`
+	EventType      string          `gorm:"not null" json:"event_type"`
+	Body           json.RawMessage `gorm:"type:json;not null" json:"body"`
+	Status         DeliveryStatus  `gorm:"not null;type:webhook_delivery_status" json:"status"`
+	Attempts       int             `gorm:"not null" json:"attempts"`
+	NextAttemptAt  time.Time       `gorm:"not null" json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	LastStatusCode int             `gorm:"not null" json:"last_status_code,omitempty"`
+	LastError      string          `gorm:"not null" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	// Lease identifies the claim of the deliverer sending the delivery. The outcome of an
+	// attempt is only recorded while the delivery is still held by the same claim.
+	Lease *uuid.UUID `gorm:"type:uuid" json:"-"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -1,23 +1,40 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"net/http"
+	"payment/internal/apikeys"
+	"payment/internal/audit"
 	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/notifications"
+	"payment/internal/otp"
+	"payment/internal/rpc"
+	"payment/internal/transactions"
 	"payment/internal/wallets"
+	"payment/internal/webhooks"
 	"payment/pkg/config"
 	"payment/pkg/db"
+	"payment/pkg/health"
+	"payment/pkg/metrics"
+	"payment/pkg/middleware"
+	"payment/pkg/migrations"
+	"payment/pkg/openapi"
+	"payment/pkg/phone"
+	"payment/pkg/tracing"
 	"payment/pkg/utils"
 	"runtime"
 	"strings"
 	"time"
 )
 
-// Program Info
+// Program Info. build is set at link time, e.g. -ldflags "-X main.build=$(git rev-parse --short HEAD)".
 var (
 	version = "1.1.2"
 	build   = "Custom"
@@ -50,6 +67,7 @@ func main() {
 	logger = log.StandardLogger()
 	logger.SetFormatter(&log.JSONFormatter{})
 	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
 
 	err = validate.RegisterValidation("description", utils.DescriptionValidator)
 	if err != nil {
@@ -64,28 +82,158 @@ func main() {
 		logger.Fatal(err)
 	}
 	logger.Info("Configuration loaded")
+	if region := configuration.Phone.DefaultRegion; region != "" {
+		if err = phone.SetDefaultRegion(region); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), configuration.TracingConfig, Version())
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.WithError(err).Error("could not flush traces")
+		}
+	}()
 
-	dsn := db.LoadDSN(configuration)
-	database, err = db.New(dsn)
+	database, err = db.Connect(context.Background(), configuration, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
 	logger.Println("Connected to database")
 
-	var walletHandler = wallets.NewHandler(database, logger, validate,
-		&wallets.Config{AuthToken: configuration.Token})
+	auditService := audit.NewService(logger, audit.NewStore(database), database)
+	go auditService.Run(context.Background(), time.Second)
+	outboxStore := events.NewStore(database)
+	// API keys issued with payment-admin are accepted next to the configured token.
+	keys := apikeys.NewService(logger, apikeys.NewStore(database), database, auditService)
+	outbox := events.NewOutbox(outboxStore)
+
+	// Handlers read these on every request, so that a reloaded configuration applies without a restart.
+	discountConfig := config.NewValue(discounts.NewConfig(configuration))
+	walletConfig := config.NewValue(wallets.NewConfig(configuration))
+	auditConfig := config.NewValue(audit.NewConfig(configuration))
+	webhookConfig := config.NewValue(webhooks.NewConfig(configuration))
+	rpcConfig := config.NewValue(rpc.NewConfig(configuration))
+	otpConfig := config.NewValue(otp.NewConfig(configuration))
+	notificationConfig := config.NewValue(notifications.NewConfig(configuration))
+	reloader := config.NewReloader(*configFilePath, configuration, logger)
+	previous := configuration
+	reloader.OnReload(func(c *config.Config) {
+		discountConfig.Store(discounts.NewConfig(c))
+		walletConfig.Store(wallets.NewConfig(c))
+		auditConfig.Store(audit.NewConfig(c))
+		webhookConfig.Store(webhooks.NewConfig(c))
+		rpcConfig.Store(rpc.NewConfig(c))
+		otpConfig.Store(otp.NewConfig(c))
+		notificationConfig.Store(notifications.NewConfig(c))
+		if err := audit.RecordReload(context.Background(), auditService, previous, c); err != nil {
+			logger.WithError(err).Error("could not record the configuration reload in the audit log")
+		}
+		previous = c
+	})
+	go reloader.Watch(context.Background(), 10*time.Second)
+
+	transactionService := transactions.NewTransactionsService(logger, database)
+	walletService := wallets.NewWallet(logger, wallets.NewStore(database), transactionService, database, auditService, outbox, walletConfig)
+	discountService := discounts.NewDiscountService(discountConfig.Load(), logger, database)
+	discountTransaction := discounts.NewDiscountTransactionService(discountConfig.Load(), logger, database)
+
+	if after := configuration.Retention.AnonymizeAfter; after > 0 {
+		interval := configuration.Retention.Interval
+		if interval == 0 {
+			interval = time.Hour
+		}
+		retention := wallets.NewRetention(logger, wallets.NewStore(database), database, auditService, after)
+		go retention.Run(context.Background(), interval)
+	}
 
-	var discountHandler = discounts.NewHandler(&discounts.Config{
-		CreditExpiration: time.Duration(configuration.DiscountConfig.ExpireTime) * time.Minute,
-		CodeLength:       configuration.DiscountConfig.CodeLength,
-		AuthToken:        configuration.Token},
-		logger, database, validate)
+	webhookStore := webhooks.NewStore(database)
+	targets := webhooks.NewTargets(configuration)
+	webhookService := webhooks.NewService(logger, webhookStore, database, auditService, targets)
+	client := targets.Client(orDefault(configuration.Webhooks.Timeout, 10*time.Second))
+	deliverer := webhooks.NewDeliverer(logger, webhookStore, database, client, webhooks.NewSettings(configuration))
+	go deliverer.Run(context.Background(), orDefault(configuration.Webhooks.Interval, time.Second))
 
-	r := mux.NewRouter()
-	http.Handle("/", utils.RecoverHandler(r))
+	sender, err := otp.NewSender(configuration.OTP)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	channels, err := notifications.NewChannels(configuration.Notifications, sender, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	notificationStore := notifications.NewStore(database)
+	notificationSettings := notifications.NewSettings(configuration)
+	notificationService := notifications.NewService(logger, notificationStore, wallets.NewStore(database), database,
+		auditService, notificationSettings)
+	dispatcher := notifications.NewDispatcher(logger, notificationStore, wallets.NewStore(database), database,
+		channels, notificationSettings)
+	go dispatcher.Run(context.Background(), orDefault(configuration.Notifications.Interval, 5*time.Second))
+
+	// Events always reach the webhook subscriptions and the notifications; the configured
+	// publisher, if any, gets them too, on its own cursor so that it cannot hold them back.
+	publisher, err := events.NewPublisher(configuration.Events, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	relay := events.NewRelay(logger, outboxStore, database, events.Fanout(webhookService, notificationService), publisher,
+		configuration.Events.BatchSize, configuration.Events.KeepPublished)
+	go relay.Run(context.Background(), orDefault(configuration.Events.Interval, time.Second))
 
-	walletHandler.RegisterRoutes(r)
-	discountHandler.RegisterRoutes(r)
+	ownership := otp.NewService(logger, otp.NewStore(database), database, sender, otpConfig)
+
+	var walletHandler = wallets.NewHandler(walletService, transactionService, ownership, logger, validate, walletConfig, keys)
+
+	discountApplyService := discounts.NewService(discountConfig, logger, database, discountService, discountTransaction, walletService, auditService, outbox)
+	var discountHandler = discounts.NewHandler(discountConfig, logger, discountApplyService, discountService, ownership, validate, keys)
+	var auditHandler = audit.NewHandler(auditService, logger, auditConfig, keys)
+	var webhookHandler = webhooks.NewHandler(webhookService, logger, validate, webhookConfig, keys)
+	var otpHandler = otp.NewHandler(ownership, logger, validate)
+	var notificationHandler = notifications.NewHandler(notificationService, logger, validate, notificationConfig, keys)
+
+	migrator, err := migrations.New(database, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	probes := health.New(2 * time.Second)
+	probes.Add("database", health.Database(database))
+	probes.Add("migrations", health.Migrations(migrator))
+	probes.Add("worker", discountApplyService.CheckWorker)
+
+	if err = metrics.RegisterDB(database); err != nil {
+		logger.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	metrics.RegisterRoutes(r)
+	probes.RegisterRoutes(r, health.NewBuildInfo(name, Version(), build))
+	// Panics are recovered innermost, so that they are still counted, logged and traced as 500 responses.
+	handler := utils.RecoverHandler(r)
+	handler = metrics.Middleware(r)(handler)
+	handler = middleware.AccessLog(logger, r)(handler)
+	handler = tracing.Middleware(r)(handler)
+	handler = middleware.RequestID(handler)
+	http.Handle("/", handler)
+
+	openapi.Mount(r, walletHandler, discountHandler, auditHandler, webhookHandler, otpHandler, notificationHandler)
+	openapi.RegisterRoutes(r, openapi.Info{Title: name, Version: Version()})
+
+	if configuration.GRPCPort != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", configuration.GRPCPort))
+		if err != nil {
+			logger.Fatal(err)
+		}
+		grpcServer := rpc.NewServer(logger, validate, rpcConfig, keys, walletService, transactionService, discountApplyService, ownership)
+		go func() {
+			logger.Infof("%s is serving gRPC on port %d", name, configuration.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				logger.Fatal(err)
+			}
+		}()
+	}
 
 	logger.Infof("%s is listening on port %d", name, configuration.ServerPort)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", configuration.ServerPort), nil)
@@ -93,3 +241,11 @@ func main() {
 		panic(err)
 	}
 }
+
+// orDefault returns d, or fallback when it is not set.
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	return d
+}
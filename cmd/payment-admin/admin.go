@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"payment/internal/apikeys"
+	"payment/internal/discounts"
+	"payment/internal/transactions"
+	"payment/internal/wallets"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// admin runs the commands of the CLI on top of the service layer.
+type admin struct {
+	wallets      wallets.IWallet
+	phones       *wallets.PhoneMigration
+	transactions transactions.ITransaction
+	discounts    *discounts.Service
+	keys         *apikeys.Service
+	validate     *validator.Validate
+	out          *output
+}
+
+// usageError reports a command line that does not match any command.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// run executes the command named by the first two words of args.
+func (a *admin) run(ctx context.Context, args []string) error {
+	commands := map[string]func(context.Context, []string) error{
+		"wallet show":             a.walletShow,
+		"wallet adjust":           a.walletAdjust,
+		"wallet freeze":           a.walletFreeze,
+		"wallet unfreeze":         a.walletUnfreeze,
+		"wallet normalize-phones": a.walletNormalizePhones,
+		"discount usages":         a.discountUsages,
+		"discount export":         a.discountExport,
+		"discount generate":       a.discountGenerate,
+		"key create":              a.keyCreate,
+		"key list":                a.keyList,
+		"key revoke":              a.keyRevoke,
+		"redemption rerun":        a.redemptionRerun,
+	}
+	if len(args) < 2 {
+		return usageError(fmt.Sprintf("unknown command %q", strings.Join(args, " ")))
+	}
+	command, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %q", args[0]+" "+args[1]))
+	}
+	return command(ctx, args[2:])
+}
+
+// parse parses the flags of a command, which may come before or after its arguments, and
+// returns the arguments. A command line with other than n arguments is a usage error, unless n is negative.
+func parse(flags *flag.FlagSet, args []string, n int) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, usageError(fmt.Sprintf("%s: %v", flags.Name(), err))
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if n >= 0 && len(positional) != n {
+		return nil, usageError(fmt.Sprintf("%s takes %d argument(s), got %d", flags.Name(), n, len(positional)))
+	}
+	return positional, nil
+}
+
+// Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// table is what a command prints in the table format.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// output prints the results of commands.
+type output struct {
+	format string
+	w      io.Writer
+}
+
+// print writes value as indented JSON, or tables as aligned columns separated by blank lines.
+func (o *output) print(value interface{}, tables ...table) error {
+	if o.format == formatJSON {
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	w := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	for i, t := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+	return w.Flush()
+}
+
+// timestamp formats t for a table, or returns "-" when it is not set.
+func timestamp(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"payment/api/models"
+	"payment/internal/apikeys"
+	"payment/internal/audit"
+	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/wallets"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// walletPhone is the number of the test wallet as it is stored.
+const walletPhone = "+989121234567"
+
+type fixture struct {
+	admin  *admin
+	out    *bytes.Buffer
+	audit  *audit.Service
+	ctx    context.Context
+	wallet wallets.IWallet
+	store  wallets.Store
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+
+	database := memory.NewDB()
+	auditService := audit.NewService(logger, memory.NewAudit(database), database)
+	outbox := events.NewOutbox(memory.NewOutbox(database))
+	transactionRepository := memory.NewTransactions(database)
+	walletStore := memory.NewWallets(database)
+	walletService := wallets.NewWallet(logger, walletStore, transactionRepository, database, auditService,
+		outbox, config.NewValue(&wallets.Config{}))
+	discountRepository := memory.NewDiscounts(database)
+	discountService := discounts.NewService(config.NewValue(&discounts.Config{CreditExpiration: time.Hour, CodeLength: 8}),
+		logger, database, discountRepository, discountRepository, walletService, auditService, outbox)
+
+	out := &bytes.Buffer{}
+	ctx := logging.NewContext(context.Background(), "admin-test")
+	logging.SetOperator(ctx, "alice")
+	return &fixture{
+		admin: &admin{
+			wallets:      walletService,
+			phones:       wallets.NewPhoneMigration(logger, walletStore, database, auditService),
+			transactions: transactionRepository,
+			discounts:    discountService,
+			keys:         apikeys.NewService(logger, memory.NewAPIKeys(database), database, auditService),
+			validate:     validate,
+			out:          &output{format: formatJSON, w: out},
+		},
+		out:    out,
+		audit:  auditService,
+		ctx:    ctx,
+		wallet: walletService,
+		store:  walletStore,
+	}
+}
+
+// run runs a command line and decodes its JSON output into v, unless v is nil.
+func (f *fixture) run(t *testing.T, v interface{}, args ...string) error {
+	t.Helper()
+	f.out.Reset()
+	err := f.admin.run(f.ctx, args)
+	if err == nil && v != nil {
+		if decodeErr := json.Unmarshal(f.out.Bytes(), v); decodeErr != nil {
+			t.Fatalf("invalid output %q: %v", f.out, decodeErr)
+		}
+	}
+	return err
+}
+
+func (f *fixture) createWallet(t *testing.T) {
+	t.Helper()
+	if _, err := f.wallet.Create(f.ctx, &models.Wallet{Phone: walletPhone}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalletAdjustRecordsTheOperatorAndReason(t *testing.T) {
+	f := newFixture(t)
+	f.createWallet(t)
+
+	if err := f.run(t, nil, "wallet", "adjust", walletPhone, "--amount", "500"); err == nil {
+		t.Fatal("adjusted a wallet without a reason")
+	}
+	var adjusted struct {
+		Wallet      models.Wallet      `json:"wallet"`
+		Transaction models.Transaction `json:"transaction"`
+	}
+	if err := f.run(t, &adjusted, "wallet", "adjust", walletPhone, "--amount", "500", "--reason", "refund of ticket 42"); err != nil {
+		t.Fatal(err)
+	}
+	if adjusted.Wallet.Amount != 500 || adjusted.Transaction.Type != models.Deposit || adjusted.Transaction.Amount != 500 {
+		t.Fatalf("got %+v, want a deposit of 500", adjusted)
+	}
+	// The wallet is unverified, which only limits what its owner can do.
+	if err := f.run(t, &adjusted, "wallet", "adjust", walletPhone, "--amount", "-200", "--reason", "duplicate refund"); err != nil {
+		t.Fatal(err)
+	}
+	if adjusted.Wallet.Amount != 300 || adjusted.Transaction.Type != models.Withdrawal {
+		t.Fatalf("got %+v, want a withdrawal of 200", adjusted)
+	}
+
+	records, err := f.audit.Find(f.ctx, models.AuditFilter{Action: audit.ActionWalletAdjust})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Actor != "operator:alice" || !strings.Contains(string(records[0].After), "refund of ticket 42") {
+		t.Fatalf("got records %+v, want the adjustment by alice with its reason", records)
+	}
+}
+
+func TestWalletFreezeAndUnfreeze(t *testing.T) {
+	f := newFixture(t)
+	f.createWallet(t)
+
+	if err := f.run(t, nil, "wallet", "unfreeze", walletPhone, "--reason", "cleared"); !errors.Is(err, errors.ErrInvalidWalletStatus) {
+		t.Fatalf("got %v unfreezing an active wallet, want %v", err, errors.ErrInvalidWalletStatus)
+	}
+	var wallet models.Wallet
+	if err := f.run(t, &wallet, "wallet", "freeze", "--reason", "dispute", walletPhone); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Status != models.WalletFrozen || wallet.StatusReason != "dispute" {
+		t.Fatalf("got status %s (%s), want frozen", wallet.Status, wallet.StatusReason)
+	}
+	if err := f.run(t, &wallet, "wallet", "unfreeze", walletPhone, "--reason", "cleared"); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Status != models.WalletActive {
+		t.Fatalf("got status %s, want active", wallet.Status)
+	}
+}
+
+func TestWalletNormalizePhones(t *testing.T) {
+	f := newFixture(t)
+	f.createWallet(t)
+	// A wallet registered before phone numbers were normalized.
+	legacy := &models.Wallet{Phone: "09121234567", Status: models.WalletActive}
+	if err := f.store.Save(f.ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []wallets.PhoneChange
+	if err := f.run(t, &changes, "wallet", "normalize-phones"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].WalletID != legacy.ID || changes[0].Action != wallets.PhoneMerged {
+		t.Fatalf("got %+v, want the legacy wallet to be merged", changes)
+	}
+	if wallet, err := f.wallet.GetByID(f.ctx, legacy.ID); err != nil || wallet.Status != models.WalletActive {
+		t.Fatalf("got %+v, %v, want the dry run to change nothing", wallet, err)
+	}
+
+	if err := f.run(t, &changes, "wallet", "normalize-phones", "--apply"); err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := f.wallet.GetByID(f.ctx, legacy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Status != models.WalletClosed || wallet.DuplicateOf == nil {
+		t.Fatalf("got %+v, want the legacy wallet closed as a duplicate", wallet)
+	}
+	records, err := f.audit.Find(f.ctx, models.AuditFilter{Action: audit.ActionWalletPhone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Actor != "operator:alice" || strings.Contains(string(records[0].After), "0912") {
+		t.Fatalf("got records %+v, want the merge by alice without the phone number", records)
+	}
+
+	if err = f.run(t, &changes, "wallet", "normalize-phones", "--apply"); err != nil || len(changes) != 0 {
+		t.Fatalf("got %+v, %v running again, want nothing left to do", changes, err)
+	}
+}
+
+func TestSettleFlaggedDuplicateByID(t *testing.T) {
+	f := newFixture(t)
+	f.createWallet(t)
+	legacy := &models.Wallet{Phone: "09121234567", Amount: 300, Status: models.WalletFrozen}
+	if err := f.store.Save(f.ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	var changes []wallets.PhoneChange
+	if err := f.run(t, &changes, "wallet", "normalize-phones", "--apply"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != wallets.PhoneFlagged {
+		t.Fatalf("got %+v, want the frozen legacy wallet flagged", changes)
+	}
+
+	// Its old number now leads to the wallet that kept it.
+	var shown struct {
+		Wallet models.Wallet `json:"wallet"`
+	}
+	if err := f.run(t, &shown, "wallet", "show", "09121234567"); err != nil || shown.Wallet.ID == legacy.ID {
+		t.Fatalf("got %+v, %v, want the wallet that kept the number", shown.Wallet, err)
+	}
+	if err := f.run(t, &shown, "wallet", "show", legacy.ID.String()); err != nil || shown.Wallet.Amount != 300 {
+		t.Fatalf("got %+v, %v, want the flagged wallet", shown.Wallet, err)
+	}
+
+	if err := f.run(t, nil, "wallet", "unfreeze", legacy.ID.String(), "--reason", "settle duplicate"); err != nil {
+		t.Fatal(err)
+	}
+	var adjusted struct {
+		Wallet models.Wallet `json:"wallet"`
+	}
+	if err := f.run(t, &adjusted, "wallet", "adjust", legacy.ID.String(), "--amount", "-300", "--reason", "settle duplicate"); err != nil {
+		t.Fatal(err)
+	}
+	if adjusted.Wallet.ID != legacy.ID || adjusted.Wallet.Amount != 0 {
+		t.Fatalf("got %+v, want the flagged wallet emptied", adjusted.Wallet)
+	}
+	if err := f.run(t, &adjusted, "wallet", "adjust", walletPhone, "--amount", "300", "--reason", "settle duplicate"); err != nil {
+		t.Fatal(err)
+	}
+	if adjusted.Wallet.ID == legacy.ID || adjusted.Wallet.Amount != 300 {
+		t.Fatalf("got %+v, want the funds moved to the wallet that kept the number", adjusted.Wallet)
+	}
+
+	if err := f.run(t, &changes, "wallet", "normalize-phones", "--apply"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != wallets.PhoneMerged {
+		t.Fatalf("got %+v, want the emptied wallet merged", changes)
+	}
+}
+
+func TestDiscountGenerateUsagesAndExport(t *testing.T) {
+	f := newFixture(t)
+	var issued []models.Discount
+	if err := f.run(t, &issued, "discount", "generate", "--count", "3", "--amount", "200", "--usage-limit", "5",
+		"--description", "spring campaign"); err != nil {
+		t.Fatal(err)
+	}
+	if len(issued) != 3 || issued[0].Code == issued[1].Code || issued[0].Type != models.Voucher {
+		t.Fatalf("got %+v, want three distinct vouchers", issued)
+	}
+	if err := f.run(t, nil, "discount", "generate", "--description", "bad <script>"); err == nil {
+		t.Error("generated a discount with an invalid description")
+	}
+
+	var result []rerun
+	if err := f.run(t, &result, "redemption", "rerun", issued[0].Code, walletPhone); err != nil {
+		t.Fatal(err)
+	}
+	var usages models.Discount
+	if err := f.run(t, &usages, "discount", "usages", issued[0].Code); err != nil {
+		t.Fatal(err)
+	}
+	if len(usages.Transactions) != 1 || usages.Transactions[0].PhoneNum != walletPhone {
+		t.Fatalf("got usages %+v, want the redemption of %s", usages.Transactions, walletPhone)
+	}
+
+	file := filepath.Join(t.TempDir(), "usages.csv")
+	if err := f.run(t, nil, "discount", "export", issued[0].Code, "--file", file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][1] != issued[0].Code || records[1][2] != walletPhone || records[1][4] != "200" {
+		t.Fatalf("got export %q", records)
+	}
+}
+
+func TestRedemptionRerunIsIdempotent(t *testing.T) {
+	f := newFixture(t)
+	var issued []models.Discount
+	if err := f.run(t, &issued, "discount", "generate", "--amount", "300", "--description", "lost redemption"); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "redemptions.csv")
+	content := issued[0].Code + "," + walletPhone + "\n" + issued[0].Code + "," + walletPhone + "\nUNKNOWN," + walletPhone + "\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var results []rerun
+	if err := f.run(t, nil, "redemption", "rerun", "--file", file); err == nil {
+		t.Error("a failed redemption was not reported")
+	}
+	if err := json.Unmarshal(f.out.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{rerunRedeemed, rerunDone, rerunFailed}
+	if len(results) != len(want) {
+		t.Fatalf("got results %+v, want %v", results, want)
+	}
+	for i := range want {
+		if results[i].Result != want[i] {
+			t.Fatalf("got results %+v, want %v", results, want)
+		}
+	}
+
+	wallet, err := f.wallet.GetByPhone(f.ctx, walletPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Amount != 300 {
+		t.Fatalf("got balance %d, want the discount credited once", wallet.Amount)
+	}
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	f := newFixture(t)
+	var created struct {
+		models.APIKey
+		Key string `json:"key"`
+	}
+	if err := f.run(t, &created, "key", "create", "partner"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, "pay_") || created.KeyID == "" {
+		t.Fatalf("got %+v, want a new key", created)
+	}
+	if ok, err := f.admin.keys.Authenticate(f.ctx, created.Key, false); err != nil || !ok {
+		t.Fatalf("got %v (%v) authenticating the new key", ok, err)
+	}
+	if ok, _ := f.admin.keys.Authenticate(f.ctx, created.Key, true); ok {
+		t.Error("a client key authenticates on the admin routes")
+	}
+	var operator struct {
+		models.APIKey
+		Key string `json:"key"`
+	}
+	if err := f.run(t, &operator, "key", "create", "--admin", "back office"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := f.admin.keys.Authenticate(f.ctx, operator.Key, true); err != nil || !ok || !operator.Admin {
+		t.Fatalf("got %v (%v) authenticating the admin key %+v", ok, err, operator.APIKey)
+	}
+
+	if err := f.run(t, nil, "key", "revoke", created.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	var keys []models.APIKey
+	if err := f.run(t, &keys, "key", "list"); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].RevokedAt == nil || keys[1].RevokedAt != nil {
+		t.Fatalf("got keys %+v, want the revoked key and the admin key", keys)
+	}
+	if ok, _ := f.admin.keys.Authenticate(f.ctx, created.Key, false); ok {
+		t.Error("a revoked key still authenticates")
+	}
+}
+
+func TestTableOutputAndUsageErrors(t *testing.T) {
+	f := newFixture(t)
+	f.createWallet(t)
+	f.admin.out.format = formatTable
+
+	if err := f.run(t, nil, "wallet", "show", "0912 123 4567"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "WALLET") || !strings.Contains(lines[1], walletPhone) ||
+		!strings.HasPrefix(lines[3], "TRANSACTION") {
+		t.Fatalf("got table\n%s", f.out)
+	}
+
+	for _, args := range [][]string{{"wallet"}, {"wallet", "delete", walletPhone}, {"wallet", "show"}, {"key", "list", "--all"}} {
+		var usage usageError
+		if err := f.run(t, nil, args...); !errors.As(err, &usage) {
+			t.Errorf("got %v for %q, want a usage error", err, args)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// maxGenerate bounds how many codes one discount generate issues.
+const maxGenerate = 10000
+
+// discountUsages prints the redemptions of a discount.
+func (a *admin) discountUsages(ctx context.Context, args []string) error {
+	args, err := parse(flag.NewFlagSet("discount usages", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	discount, err := a.discounts.Usages(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(discount.Transactions))
+	for _, usage := range discount.Transactions {
+		rows = append(rows, []string{usage.ID.String(), usage.PhoneNum, usage.WalletID.String(), timestamp(&usage.CreatedAt)})
+	}
+	return a.out.print(discount, discountTable(discount), table{
+		header: []string{"REDEMPTION", "PHONE", "WALLET", "REDEEMED AT"},
+		rows:   rows,
+	})
+}
+
+// discountExport writes the redemptions of a discount as CSV, to a file or to the standard output.
+func (a *admin) discountExport(ctx context.Context, args []string) (err error) {
+	flags := flag.NewFlagSet("discount export", flag.ContinueOnError)
+	file := flags.String("file", "", "file to write, instead of the standard output")
+	if args, err = parse(flags, args, 1); err != nil {
+		return err
+	}
+	discount, err := a.discounts.Usages(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = a.out.w
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		w = f
+	}
+
+	records := csv.NewWriter(w)
+	records.Write([]string{"redemption_id", "code", "phone", "wallet_id", "amount", "redeemed_at"})
+	for _, usage := range discount.Transactions {
+		records.Write([]string{usage.ID.String(), discount.Code, usage.PhoneNum, usage.WalletID.String(),
+			strconv.FormatInt(discount.Amount, 10), usage.CreatedAt.Format(time.RFC3339)})
+	}
+	records.Flush()
+	return records.Error()
+}
+
+// discountGenerate issues discount codes in bulk. Each code gets its own discount, with its own usage limit.
+func (a *admin) discountGenerate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("discount generate", flag.ContinueOnError)
+	count := flags.Int("count", 1, "number of codes to issue")
+	amount := flags.Int64("amount", 0, "amount credited by each code")
+	usageLimit := flags.Int64("usage-limit", 1, "redemptions allowed per code")
+	discountType := flags.String("type", string(models.Voucher), "voucher or charge")
+	description := flags.String("description", "", "description of the discount")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	if *count < 1 || *count > maxGenerate {
+		return errors.ErrBadRequest.WithMessage("count must be between 1 and %d", maxGenerate)
+	}
+	if *usageLimit < 1 {
+		return errors.ErrBadRequest.WithMessage("usage limit must be positive")
+	}
+	if *amount < 1 {
+		return errors.ErrBadRequest.WithMessage("amount must be positive")
+	}
+
+	issued := make([]*models.Discount, 0, *count)
+	var err error
+	for i := 0; i < *count; i++ {
+		discount := &models.Discount{
+			Description: *description,
+			Amount:      *amount,
+			UsageLimit:  *usageLimit,
+			Type:        models.DiscountType(*discountType),
+		}
+		if err = a.validate.Struct(discount); err != nil {
+			err = errors.Validation(err)
+			break
+		}
+		if discount, err = a.discounts.Issue(ctx, discount); err != nil {
+			break
+		}
+		issued = append(issued, discount)
+	}
+
+	// Codes issued before a failure exist, so they are printed either way.
+	if len(issued) > 0 {
+		rows := make([][]string, 0, len(issued))
+		for _, discount := range issued {
+			rows = append(rows, discountTable(discount).rows[0])
+		}
+		if printErr := a.out.print(issued, table{header: discountTable(issued[0]).header, rows: rows}); printErr != nil {
+			return printErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("issued %d of %d codes: %w", len(issued), *count, err)
+	}
+	return nil
+}
+
+func discountTable(discount *models.Discount) table {
+	return table{
+		header: []string{"CODE", "TYPE", "AMOUNT", "USAGE LIMIT", "USED", "EXPIRES AT", "DESCRIPTION"},
+		rows: [][]string{{discount.Code, string(discount.Type), strconv.FormatInt(discount.Amount, 10),
+			strconv.FormatInt(discount.UsageLimit, 10), strconv.Itoa(len(discount.Transactions)),
+			timestamp(&discount.ExpirationTime), discount.Description}},
+	}
+}
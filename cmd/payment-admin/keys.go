@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"strconv"
+)
+
+// keyCreate issues an API key. The key is printed once and cannot be shown again.
+func (a *admin) keyCreate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("key create", flag.ContinueOnError)
+	scope := flags.Bool("admin", false, "also accept the key on the admin routes")
+	args, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	key, token, err := a.keys.Create(ctx, args[0], *scope)
+	if err != nil {
+		return err
+	}
+	t := keyTable(key)
+	t.header = append(t.header, "KEY")
+	t.rows[0] = append(t.rows[0], token)
+	return a.out.print(struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}{key, token}, t)
+}
+
+// keyList prints every API key, revoked ones included.
+func (a *admin) keyList(ctx context.Context, args []string) error {
+	if _, err := parse(flag.NewFlagSet("key list", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	keys, err := a.keys.List(ctx)
+	if err != nil {
+		return err
+	}
+	t := table{header: keyTable(&models.APIKey{}).header}
+	for _, key := range keys {
+		t.rows = append(t.rows, keyTable(key).rows[0])
+	}
+	return a.out.print(keys, t)
+}
+
+// keyRevoke revokes an API key.
+func (a *admin) keyRevoke(ctx context.Context, args []string) error {
+	args, err := parse(flag.NewFlagSet("key revoke", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(args[0])
+	if err != nil {
+		return errors.ErrBadRequest.WithMessage("invalid api key id %q", args[0])
+	}
+	key, err := a.keys.Revoke(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.out.print(key, keyTable(key))
+}
+
+func keyTable(key *models.APIKey) table {
+	return table{
+		header: []string{"ID", "KEY ID", "NAME", "ADMIN", "CREATED AT", "REVOKED AT"},
+		rows: [][]string{{key.ID.String(), key.KeyID, key.Name, strconv.FormatBool(key.Admin), timestamp(&key.CreatedAt),
+			timestamp(key.RevokedAt)}},
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/user"
+	"payment/internal/apikeys"
+	"payment/internal/audit"
+	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/transactions"
+	"payment/internal/wallets"
+	"payment/pkg/config"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/phone"
+	"payment/pkg/utils"
+)
+
+const usage = `Usage: payment-admin [--config path] [--output table|json] [--operator name] <command> [arguments]
+
+Commands:
+  wallet show <wallet>                            show a wallet and its transactions
+  wallet adjust <wallet> --amount n --reason text correct a balance, depositing n > 0 or withdrawing n < 0
+  wallet freeze <wallet> --reason text            only accept deposits to a wallet
+  wallet unfreeze <wallet> --reason text          make a frozen wallet active again
+  wallet normalize-phones [--apply]               rewrite stored phone numbers in E.164 form and merge
+                                                  duplicate wallets, once after upgrading; without
+                                                  --apply it only reports what would change
+  discount usages <code>                          list the redemptions of a discount
+  discount export <code> [--file path]            write the redemptions of a discount as CSV
+  discount generate --count n --amount n --usage-limit n --type voucher|charge --description text
+                                                  issue discount codes in bulk
+  key create <name> [--admin]                     issue an API key, which is shown only once; admin
+                                                  keys are also accepted on the admin routes
+  key list                                        list API keys
+  key revoke <id>                                 revoke an API key
+  redemption rerun [<code> <phone>] [--file path] redeem discounts again after their redemption was lost;
+                                                  the file lists one code,phone pair per line
+
+A <wallet> is a wallet ID or a phone number. Flagged duplicate wallets are only found by ID.
+
+Every change is recorded in the audit log under the name of the operator.
+`
+
+func main() {
+	logger := log.New()
+	logger.SetFormatter(&log.TextFormatter{})
+
+	configFilePath := flag.String("config", "configs/config.yaml", "Path to the YAML configuration file")
+	format := flag.String("output", formatTable, "Output format: table or json")
+	operator := flag.String("operator", currentUser(), "Operator name recorded in the audit log")
+	verbose := flag.Bool("verbose", false, "Log what the services do")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != formatTable && *format != formatJSON {
+		logger.Fatalf("unknown output format %q", *format)
+	}
+	if *operator == "" {
+		logger.Fatal("operator name is required, pass --operator")
+	}
+	if !*verbose {
+		logger.SetLevel(log.WarnLevel)
+	}
+
+	configuration, err := config.LoadConfig(*configFilePath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	database, err := db.Connect(context.Background(), configuration, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	a, err := newAdmin(logger, database, configuration, &output{format: *format, w: os.Stdout})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	ctx := logging.NewContext(context.Background(), uuid.NewString())
+	logging.SetOperator(ctx, *operator)
+	if err = a.run(ctx, flag.Args()); err != nil {
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(os.Stderr, "%s\n\n", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+		logger.Fatal(err)
+	}
+}
+
+// newAdmin wires the services of the payment service on top of database, the same way the server does.
+func newAdmin(logger *log.Logger, database *db.DB, configuration *config.Config, out *output) (*admin, error) {
+	if region := configuration.Phone.DefaultRegion; region != "" {
+		if err := phone.SetDefaultRegion(region); err != nil {
+			return nil, err
+		}
+	}
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		return nil, err
+	}
+
+	auditService := audit.NewService(logger, audit.NewStore(database), database)
+	outbox := events.NewOutbox(events.NewStore(database))
+	discountConfig := config.NewValue(discounts.NewConfig(configuration))
+	walletConfig := config.NewValue(wallets.NewConfig(configuration))
+
+	transactionService := transactions.NewTransactionsService(logger, database)
+	walletStore := wallets.NewStore(database)
+	walletService := wallets.NewWallet(logger, walletStore, transactionService, database, auditService, outbox, walletConfig)
+	discountService := discounts.NewService(discountConfig, logger, database,
+		discounts.NewDiscountService(discountConfig.Load(), logger, database),
+		discounts.NewDiscountTransactionService(discountConfig.Load(), logger, database),
+		walletService, auditService, outbox)
+
+	return &admin{
+		wallets:      walletService,
+		phones:       wallets.NewPhoneMigration(logger, walletStore, database, auditService),
+		transactions: transactionService,
+		discounts:    discountService,
+		keys:         apikeys.NewService(logger, apikeys.NewStore(database), database, auditService),
+		validate:     validate,
+		out:          out,
+	}, nil
+}
+
+// currentUser returns the login name of the user running the command, or an empty string.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"payment/pkg/errors"
+	"payment/pkg/phone"
+)
+
+// Outcomes of a rerun redemption.
+const (
+	rerunRedeemed = "redeemed"
+	rerunDone     = "already redeemed"
+	rerunFailed   = "failed"
+)
+
+// rerun is the outcome of running one redemption again.
+type rerun struct {
+	Code   string `json:"code"`
+	Phone  string `json:"phone"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// redemptionRerun runs lost redemptions again, either the one given on the command line or every
+// code,phone pair of a CSV file. Redemptions that did complete are reported and left alone.
+func (a *admin) redemptionRerun(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("redemption rerun", flag.ContinueOnError)
+	file := flags.String("file", "", "CSV file of code,phone pairs to redeem again")
+	args, err := parse(flags, args, -1)
+	if err != nil {
+		return err
+	}
+	if (*file == "" && len(args) != 2) || (*file != "" && len(args) != 0) {
+		return usageError("redemption rerun takes either a code and a phone number or --file")
+	}
+
+	pairs := [][]string{args}
+	if *file != "" {
+		if pairs, err = readPairs(*file); err != nil {
+			return err
+		}
+	}
+
+	results := make([]rerun, 0, len(pairs))
+	failed := 0
+	for _, pair := range pairs {
+		result := rerun{Code: pair[0], Phone: pair[1], Result: rerunDone}
+		number, err := phone.Normalize(pair[1])
+		redeemed := false
+		if err == nil {
+			redeemed, err = a.discounts.Rerun(ctx, pair[0], number)
+		}
+		switch {
+		case err != nil:
+			result.Result, result.Error = rerunFailed, errors.FromError(err).Message
+			failed++
+		case redeemed:
+			result.Result = rerunRedeemed
+		}
+		results = append(results, result)
+	}
+
+	t := table{header: []string{"CODE", "PHONE", "RESULT", "ERROR"}}
+	for _, result := range results {
+		t.rows = append(t.rows, []string{result.Code, result.Phone, result.Result, result.Error})
+	}
+	if err = a.out.print(results, t); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d redemptions failed", failed, len(results))
+	}
+	return nil
+}
+
+// readPairs reads the code,phone pairs of a CSV file.
+func readPairs(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	pairs, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%s: no redemptions to run", path)
+	}
+	return pairs, nil
+}
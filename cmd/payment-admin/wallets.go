@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"payment/pkg/phone"
+	"strconv"
+)
+
+// walletShow prints a wallet and its transactions.
+func (a *admin) walletShow(ctx context.Context, args []string) error {
+	args, err := parse(flag.NewFlagSet("wallet show", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	wallet, err := a.wallet(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	ledger, err := a.transactions.List(ctx, wallet.ID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(ledger))
+	for _, transaction := range ledger {
+		rows = append(rows, []string{transaction.ID.String(), timestamp(&transaction.CreatedAt), string(transaction.Type),
+			strconv.FormatInt(transaction.Amount, 10), string(transaction.Status), transaction.Description})
+	}
+	return a.out.print(struct {
+		Wallet       *models.Wallet        `json:"wallet"`
+		Transactions []*models.Transaction `json:"transactions"`
+	}{wallet, ledger}, walletTable(wallet), table{
+		header: []string{"TRANSACTION", "CREATED AT", "TYPE", "AMOUNT", "STATUS", "DESCRIPTION"},
+		rows:   rows,
+	})
+}
+
+// walletAdjust deposits or withdraws a correction, which is recorded with its reason.
+func (a *admin) walletAdjust(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("wallet adjust", flag.ContinueOnError)
+	amount := flags.Int64("amount", 0, "amount to deposit, or to withdraw when negative")
+	reason := flags.String("reason", "", "why the balance is corrected")
+	args, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	wallet, err := a.wallet(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	transaction, err := a.wallets.Adjust(ctx, wallet.ID, *amount, *reason)
+	if err != nil {
+		return err
+	}
+	if wallet, err = a.wallets.GetByID(ctx, wallet.ID); err != nil {
+		return err
+	}
+	return a.out.print(struct {
+		Wallet      *models.Wallet      `json:"wallet"`
+		Transaction *models.Transaction `json:"transaction"`
+	}{wallet, transaction}, walletTable(wallet))
+}
+
+// walletFreeze freezes a wallet, so that it only accepts deposits.
+func (a *admin) walletFreeze(ctx context.Context, args []string) error {
+	return a.changeStatus(ctx, "wallet freeze", args, models.WalletFrozen)
+}
+
+// walletUnfreeze makes a frozen wallet active again. Wallets in any other status are left alone,
+// so that a suspended or closed wallet is not reactivated by mistake.
+func (a *admin) walletUnfreeze(ctx context.Context, args []string) error {
+	return a.changeStatus(ctx, "wallet unfreeze", args, models.WalletActive)
+}
+
+func (a *admin) changeStatus(ctx context.Context, name string, args []string, status models.WalletStatus) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the status changes")
+	args, err := parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	request := models.WalletStatusRequest{Status: status, Reason: *reason}
+	if err = a.validate.Struct(request); err != nil {
+		return errors.Validation(err)
+	}
+
+	wallet, err := a.wallet(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if status == models.WalletActive && wallet.Status != models.WalletFrozen {
+		return errors.ErrInvalidWalletStatus.WithMessage("wallet is %s, not frozen", wallet.Status)
+	}
+	if wallet, err = a.wallets.ChangeStatusByID(ctx, wallet.ID, request.Status, request.Reason); err != nil {
+		return err
+	}
+	return a.out.print(wallet, walletTable(wallet))
+}
+
+// walletNormalizePhones reports the wallets whose phone numbers were stored before they were
+// normalized, and migrates them when --apply is given.
+func (a *admin) walletNormalizePhones(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("wallet normalize-phones", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "make the changes instead of only reporting them")
+	if _, err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	changes, err := a.phones.Run(ctx, *apply)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"WALLET", "PHONE", "ACTION", "NUMBER", "INTO", "BALANCE", "STATUS"}}
+	for _, change := range changes {
+		into := "-"
+		if change.Into != nil {
+			into = change.Into.String()
+		}
+		t.rows = append(t.rows, []string{change.WalletID.String(), change.Phone, change.Action, change.Number, into,
+			strconv.FormatInt(change.Balance, 10), string(change.Status)})
+	}
+	return a.out.print(changes, t)
+}
+
+// wallet returns the wallet with the ID given by reference, or else normalizes reference as a
+// phone number and returns its wallet. Flagged duplicates keep a number that no longer leads to
+// them, so they are only found by ID.
+func (a *admin) wallet(ctx context.Context, reference string) (*models.Wallet, error) {
+	if id, err := uuid.Parse(reference); err == nil {
+		return a.wallets.GetByID(ctx, id)
+	}
+	normalized, err := phone.Normalize(reference)
+	if err != nil {
+		return nil, err
+	}
+	return a.wallets.GetByPhone(ctx, normalized)
+}
+
+func walletTable(wallet *models.Wallet) table {
+	return table{
+		header: []string{"WALLET", "PHONE", "BALANCE", "STATUS", "TIER", "CREATED AT"},
+		rows: [][]string{{wallet.ID.String(), wallet.Phone, strconv.FormatInt(wallet.Amount, 10), string(wallet.Status),
+			string(wallet.Tier), timestamp(&wallet.CreatedAt)}},
+	}
+}
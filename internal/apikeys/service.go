@@ -0,0 +1,145 @@
+// Package apikeys manages the API keys operators issue to clients. A key authenticates the
+// same routes as the token in the configuration, but can be issued and revoked one client
+// at a time without a reload. Only the SHA-256 of a key is stored.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/auth"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
+)
+
+// prefix starts every key, so that a leaked one is easy to recognise.
+const prefix = "pay_"
+
+// Service issues, lists, revokes and authenticates API keys.
+type Service struct {
+	store      Store
+	transactor db.Transactor
+	auditor    audit.Recorder
+	logger     *log.Logger
+}
+
+// NewService creates the API key service on top of store. Issued and revoked keys are recorded by auditor.
+func NewService(logger *log.Logger, store Store, transactor db.Transactor, auditor audit.Recorder) *Service {
+	return &Service{store: store, transactor: transactor, auditor: auditor, logger: logger}
+}
+
+// keyState is what the audit log keeps of a key. The hash is left out.
+type keyState struct {
+	Name      string     `json:"name"`
+	KeyID     string     `json:"key_id"`
+	Admin     bool       `json:"admin"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func stateOf(key *models.APIKey) *keyState {
+	return &keyState{Name: key.Name, KeyID: key.KeyID, Admin: key.Admin, RevokedAt: key.RevokedAt}
+}
+
+// Create issues a key for the client called name, with the admin scope when admin is set. The
+// returned token is the only place the key is shown.
+func (s *Service) Create(ctx context.Context, name string, admin bool) (key *models.APIKey, token string, err error) {
+	if name == "" {
+		return nil, "", errors.ErrBadRequest.WithMessage("api key name is required")
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, "", errors.ErrInternal.Wrap(err)
+	}
+	token = prefix + hex.EncodeToString(secret)
+
+	key = &models.APIKey{Name: name, KeyID: auth.KeyID(token), Hash: hash(token), Admin: admin}
+	key.CreatedAt = time.Now()
+	err = s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.store.Add(ctx, key); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionAPIKeyCreate, key.ID, nil, stateOf(key))
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section": "apikeys",
+		"key_id":  key.KeyID,
+		"name":    name,
+		"admin":   admin,
+	}).Info("api key created")
+	return key, token, nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func (s *Service) List(ctx context.Context) ([]*models.APIKey, error) {
+	return s.store.List(ctx)
+}
+
+// Revoke stops the key with the given ID from authenticating any further request.
+func (s *Service) Revoke(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	var key *models.APIKey
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if key, err = s.store.Find(ctx, id); err != nil {
+			return err
+		}
+		if key.RevokedAt != nil {
+			return errors.ErrBadRequest.WithMessage("api key %s is already revoked", key.KeyID)
+		}
+		before := stateOf(key)
+		now := time.Now()
+		if err = s.store.Revoke(ctx, id, now); err != nil {
+			return err
+		}
+		key.RevokedAt = &now
+		return s.record(ctx, audit.ActionAPIKeyRevoke, id, before, stateOf(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section": "apikeys",
+		"key_id":  key.KeyID,
+	}).Info("api key revoked")
+	return key, nil
+}
+
+// Authenticate reports whether token is a key that has not been revoked and, when admin is set,
+// that it has the admin scope. It implements auth.Keys.
+func (s *Service) Authenticate(ctx context.Context, token string, admin bool) (bool, error) {
+	key, err := s.store.FindByHash(ctx, hash(token))
+	if err != nil {
+		if errors.Is(err, errors.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return key.RevokedAt == nil && (key.Admin || !admin), nil
+}
+
+func (s *Service) record(ctx context.Context, action string, key uuid.UUID, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Entry{
+		Action:     action,
+		EntityType: audit.EntityAPIKey,
+		EntityID:   key.String(),
+		Before:     before,
+		After:      after,
+	})
+}
+
+// hash returns the hex SHA-256 of token, which is what the store keeps of a key.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,77 @@
+package apikeys
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+var errKeyNotFound = errors.ErrNotFound.WithMessage("api key not found")
+
+// Store persists API keys.
+type Store interface {
+	Add(ctx context.Context, key *models.APIKey) error
+	// List returns every key, oldest first.
+	List(ctx context.Context) ([]*models.APIKey, error)
+	Find(ctx context.Context, id uuid.UUID) (*models.APIKey, error)
+	FindByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	Revoke(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) Add(ctx context.Context, key *models.APIKey) error {
+	if err := s.db.Conn(ctx).Create(key).Error; err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) List(ctx context.Context) ([]*models.APIKey, error) {
+	keys := make([]*models.APIKey, 0)
+	if err := s.db.Conn(ctx).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return keys, nil
+}
+
+func (s *store) Find(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	return s.first(ctx, "id = ?", id)
+}
+
+func (s *store) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	return s.first(ctx, "hash = ?", hash)
+}
+
+func (s *store) first(ctx context.Context, query string, arg interface{}) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := s.db.Conn(ctx).Where(query, arg).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errKeyNotFound
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &key, nil
+}
+
+func (s *store) Revoke(ctx context.Context, id uuid.UUID, at time.Time) error {
+	result := s.db.Conn(ctx).Model(&models.APIKey{}).Where("id = ?", id).Update("revoked_at", at)
+	if result.Error != nil {
+		return errors.ErrInternal.Wrap(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errKeyNotFound
+	}
+	return nil
+}
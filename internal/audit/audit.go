@@ -0,0 +1,277 @@
+// Package audit keeps an append-only, hash-chained log of every change made through the API.
+// Services record an Entry inside the transaction of the change it describes, so that the
+// log and the data can never disagree. Recorded entries are chained into the log once they
+// have committed, by Seal, so that audited transactions do not wait for each other.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionWalletCreate        = "wallet.create"
+	ActionWalletClose         = "wallet.close"
+	ActionWalletStatus        = "wallet.status"
+	ActionWalletTier          = "wallet.tier"
+	ActionWalletLimits        = "wallet.limits"
+	ActionWalletTransaction   = "wallet.transaction"
+	ActionWalletAdjust        = "wallet.adjust"
+	ActionWalletAnonymize     = "wallet.anonymize"
+	ActionWalletPhone         = "wallet.phone"
+	ActionWalletNotifications = "wallet.notifications"
+	ActionDiscountCreate      = "discount.create"
+	ActionDiscountApply       = "discount.apply"
+	ActionConfigReload        = "config.reload"
+	ActionWebhookSubscribe    = "webhook.subscribe"
+	ActionWebhookUnsubscribe  = "webhook.unsubscribe"
+	ActionWebhookRedeliver    = "webhook.redeliver"
+	ActionAPIKeyCreate        = "apikey.create"
+	ActionAPIKeyRevoke        = "apikey.revoke"
+)
+
+// Entity types recorded in the audit log.
+const (
+	EntityWallet   = "wallet"
+	EntityDiscount = "discount"
+	EntityConfig   = "config"
+	EntityWebhook  = "webhook"
+	EntityAPIKey   = "apikey"
+)
+
+// SystemActor is recorded for changes that neither an API key nor an operator asked for, such as a reload on SIGHUP.
+const SystemActor = "system"
+
+// Entry describes a change. Before and After are stored as JSON; either may be nil.
+type Entry struct {
+	Action     string
+	EntityType string
+	EntityID   string
+	Before     interface{}
+	After      interface{}
+}
+
+// Recorder appends entries to the audit log. It joins the transaction of ctx, so an
+// entry is committed or rolled back together with the change it describes.
+type Recorder interface {
+	Record(ctx context.Context, entry Entry) error
+}
+
+// Service writes and reads the audit log.
+type Service struct {
+	store      Store
+	transactor db.Transactor
+	logger     *log.Logger
+}
+
+// NewService creates the audit service on top of store.
+func NewService(logger *log.Logger, store Store, transactor db.Transactor) *Service {
+	return &Service{store: store, transactor: transactor, logger: logger}
+}
+
+// Record keeps entry with the actor, request ID and client IP carried by ctx until it is sealed.
+func (s *Service) Record(ctx context.Context, entry Entry) error {
+	before, err := marshal(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshal(entry.After)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Add(ctx, &models.AuditPending{
+		// Postgres keeps microseconds, so the hash is computed over what is stored.
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		Actor:      Actor(ctx),
+		Action:     entry.Action,
+		EntityType: entry.EntityType,
+		EntityID:   entry.EntityID,
+		Before:     before,
+		After:      after,
+		RequestID:  logging.RequestID(ctx),
+		IP:         logging.ClientIP(ctx),
+	})
+}
+
+// sealBatch bounds how many entries Seal chains in one transaction.
+const sealBatch = 500
+
+// Seal chains the committed entries into the log in the order it finds them, and returns how
+// many it sealed. Sealers wait for each other; the transactions that record entries do not.
+func (s *Service) Seal(ctx context.Context) (int, error) {
+	sealed := 0
+	for {
+		var batch int
+		err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			last, err := s.store.Last(ctx)
+			if err != nil {
+				return err
+			}
+			entries, err := s.store.Pending(ctx, sealBatch)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				record := &models.AuditRecord{
+					Sequence:   1,
+					CreatedAt:  entry.CreatedAt,
+					Actor:      entry.Actor,
+					Action:     entry.Action,
+					EntityType: entry.EntityType,
+					EntityID:   entry.EntityID,
+					Before:     entry.Before,
+					After:      entry.After,
+					RequestID:  entry.RequestID,
+					IP:         entry.IP,
+				}
+				if last != nil {
+					record.Sequence = last.Sequence + 1
+					record.PrevHash = last.Hash
+				}
+				record.Hash = Hash(record)
+				if err := s.store.Seal(ctx, record, entry.ID); err != nil {
+					return err
+				}
+				last = record
+			}
+			batch = len(entries)
+			return nil
+		})
+		if err != nil {
+			return sealed, err
+		}
+		sealed += batch
+		if batch < sealBatch {
+			return sealed, nil
+		}
+	}
+}
+
+// Run seals the recorded entries every interval until ctx is cancelled.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.Seal(ctx); err != nil {
+			s.logger.WithError(err).WithField("sealed", n).Error("audit log sealing failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Find returns the records matching filter, oldest first. It seals the committed entries
+// first, so that every change that has been made can be found.
+func (s *Service) Find(ctx context.Context, filter models.AuditFilter) ([]*models.AuditRecord, error) {
+	if _, err := s.Seal(ctx); err != nil {
+		return nil, err
+	}
+	return s.store.Find(ctx, filter)
+}
+
+// verifyBatch bounds how many records Verify reads at once.
+const verifyBatch = 500
+
+// Verify walks the whole log and checks that every record links to its predecessor and
+// still matches its hash. It reports the first record that does not.
+func (s *Service) Verify(ctx context.Context) (*models.AuditVerification, error) {
+	if _, err := s.Seal(ctx); err != nil {
+		return nil, err
+	}
+	result := &models.AuditVerification{Valid: true}
+	var previous *models.AuditRecord
+	for {
+		records, err := s.store.Find(ctx, models.AuditFilter{After: result.Checked, Limit: verifyBatch})
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if reason := check(previous, record); reason != "" {
+				result.Valid, result.BrokenAt, result.Reason = false, &record.Sequence, reason
+				logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+					"section":  "audit",
+					"sequence": record.Sequence,
+					"reason":   reason,
+				}).Error("audit log hash chain is broken")
+				return result, nil
+			}
+			previous = record
+			result.Checked = record.Sequence
+		}
+		if len(records) < verifyBatch {
+			return result, nil
+		}
+	}
+}
+
+// check returns why record does not follow previous, or an empty string when it does.
+func check(previous, record *models.AuditRecord) string {
+	switch {
+	case previous == nil && (record.Sequence != 1 || record.PrevHash != ""):
+		return "the first record is missing"
+	case previous != nil && record.Sequence != previous.Sequence+1:
+		return "a record is missing before this one"
+	case previous != nil && record.PrevHash != previous.Hash:
+		return "the previous hash does not match"
+	case record.Hash != Hash(record):
+		return "the record does not match its hash"
+	}
+	return ""
+}
+
+// Hash returns the hex SHA-256 of every field of record but its own hash.
+func Hash(record *models.AuditRecord) string {
+	data, _ := json.Marshal([]interface{}{
+		record.Sequence,
+		record.CreatedAt.UTC().Format(time.RFC3339Nano),
+		record.Actor,
+		record.Action,
+		record.EntityType,
+		record.EntityID,
+		record.Before,
+		record.After,
+		record.RequestID,
+		record.IP,
+		record.PrevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Actor identifies who made the request of ctx: the key ID of its API token, the
+// operator running the admin CLI, or SystemActor outside of a request.
+func Actor(ctx context.Context) string {
+	if operator := logging.Operator(ctx); operator != "" {
+		return "operator:" + operator
+	}
+	if keyID := logging.KeyID(ctx); keyID != "" {
+		return "key:" + keyID
+	}
+	return SystemActor
+}
+
+func marshal(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.ErrInternal.WithMessage("could not encode audit entry").Wrap(err)
+	}
+	return data, nil
+}
@@ -0,0 +1,198 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/memory"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"testing"
+)
+
+func newService(store audit.Store, db *memory.DB) *audit.Service {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return audit.NewService(logger, store, db)
+}
+
+func record(t *testing.T, service *audit.Service, ctx context.Context, action, id string) {
+	t.Helper()
+	if err := service.Record(ctx, audit.Entry{
+		Action:     action,
+		EntityType: audit.EntityWallet,
+		EntityID:   id,
+		After:      map[string]int64{"balance": 100},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordChainsRecords(t *testing.T) {
+	db := memory.NewDB()
+	service := newService(memory.NewAudit(db), db)
+
+	ctx := logging.NewContext(context.Background(), "req-1")
+	logging.SetKeyID(ctx, "key-1")
+	logging.SetClientIP(ctx, "192.0.2.1")
+	record(t, service, ctx, audit.ActionWalletCreate, "w1")
+	record(t, service, context.Background(), audit.ActionWalletTransaction, "w1")
+
+	records, err := service.Find(context.Background(), models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	first, second := records[0], records[1]
+	if first.Actor != "key:key-1" || first.RequestID != "req-1" || first.IP != "192.0.2.1" {
+		t.Errorf("got actor %q, request %q and IP %q, want them taken from the context", first.Actor, first.RequestID, first.IP)
+	}
+	if second.Actor != audit.SystemActor {
+		t.Errorf("got actor %q outside a request, want %q", second.Actor, audit.SystemActor)
+	}
+	if first.Sequence != 1 || first.PrevHash != "" || second.Sequence != 2 || second.PrevHash != first.Hash {
+		t.Errorf("records are not chained: %+v, %+v", first, second)
+	}
+	if string(second.After) != `{"balance":100}` || second.Before != nil {
+		t.Errorf("got before %s and after %s", second.Before, second.After)
+	}
+
+	result, err := service.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 2 {
+		t.Errorf("got %+v, want a valid chain of 2 records", result)
+	}
+}
+
+func TestRecordRollsBackWithTransaction(t *testing.T) {
+	db := memory.NewDB()
+	service := newService(memory.NewAudit(db), db)
+
+	failure := errors.ErrInternal.WithMessage("change failed")
+	err := db.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		record(t, service, ctx, audit.ActionWalletCreate, "w1")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+
+	records, err := service.Find(context.Background(), models.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("got %d records after the rollback, want 0", len(records))
+	}
+}
+
+func TestSealChainsCommittedEntries(t *testing.T) {
+	db := memory.NewDB()
+	store := memory.NewAudit(db)
+	service := newService(store, db)
+
+	record(t, service, context.Background(), audit.ActionWalletCreate, "w1")
+	_ = db.WithinTransaction(context.Background(), func(ctx context.Context) error {
+		record(t, service, ctx, audit.ActionWalletCreate, "w2")
+		return errors.ErrInternal
+	})
+	record(t, service, context.Background(), audit.ActionWalletCreate, "w3")
+
+	if records, err := store.Find(context.Background(), models.AuditFilter{}); err != nil || len(records) != 0 {
+		t.Fatalf("got %d records and %v before sealing, want none", len(records), err)
+	}
+	if sealed, err := service.Seal(context.Background()); err != nil || sealed != 2 {
+		t.Fatalf("got %d sealed and %v, want the 2 committed entries", sealed, err)
+	}
+	if sealed, err := service.Seal(context.Background()); err != nil || sealed != 0 {
+		t.Fatalf("got %d sealed and %v on the second run, want 0", sealed, err)
+	}
+
+	result, err := service.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Checked != 2 {
+		t.Fatalf("got %+v, want a valid chain of 2 records", result)
+	}
+}
+
+// tampered alters the After value of one record as it is read back.
+type tampered struct {
+	audit.Store
+	sequence int64
+}
+
+func (s tampered) Find(ctx context.Context, filter models.AuditFilter) ([]*models.AuditRecord, error) {
+	records, err := s.Store.Find(ctx, filter)
+	for _, record := range records {
+		if record.Sequence == s.sequence {
+			record.After = json.RawMessage(`{"balance":1000000}`)
+		}
+	}
+	return records, err
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	db := memory.NewDB()
+	store := memory.NewAudit(db)
+	service := newService(store, db)
+	for _, id := range []string{"w1", "w2", "w3"} {
+		record(t, service, context.Background(), audit.ActionWalletCreate, id)
+	}
+
+	result, err := newService(tampered{store, 2}, db).Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenAt == nil || *result.BrokenAt != 2 || result.Checked != 1 {
+		t.Fatalf("got %+v, want the chain broken at record 2", result)
+	}
+}
+
+func TestFindFilters(t *testing.T) {
+	db := memory.NewDB()
+	service := newService(memory.NewAudit(db), db)
+	record(t, service, context.Background(), audit.ActionWalletCreate, "w1")
+	record(t, service, context.Background(), audit.ActionWalletCreate, "w2")
+	record(t, service, context.Background(), audit.ActionWalletTransaction, "w1")
+	record(t, service, context.Background(), audit.ActionWalletTransaction, "w1")
+
+	tests := []struct {
+		name   string
+		filter models.AuditFilter
+		want   []int64
+	}{
+		{"entity", models.AuditFilter{EntityID: "w1"}, []int64{1, 3, 4}},
+		{"action", models.AuditFilter{Action: audit.ActionWalletCreate}, []int64{1, 2}},
+		{"page", models.AuditFilter{EntityID: "w1", After: 1, Limit: 1}, []int64{3}},
+		{"actor", models.AuditFilter{Actor: "key:other"}, nil},
+	}
+	for _, tt := range tests {
+		records, err := service.Find(context.Background(), tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, record := range records {
+			got = append(got, record.Sequence)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got sequences %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got sequences %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"context"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+)
+
+type Config struct {
+	// AdminToken is accepted on the audit routes, next to admin API keys.
+	AdminToken string
+}
+
+// NewConfig extracts the audit settings from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{AdminToken: c.AdminToken}
+}
+
+// settings is what the audit log keeps of a configuration: the settings that apply without
+// a restart, with the tokens replaced by their key IDs.
+type settings struct {
+	TokenKeyID         string              `json:"token_key_id"`
+	AdminTokenKeyID    string              `json:"admin_token_key_id,omitempty"`
+	DiscountExpireTime int                 `json:"discount_expire_time"`
+	DiscountCodeLength int                 `json:"discount_code_length"`
+	Limits             config.LimitsConfig `json:"limits"`
+	Tiers              config.TiersConfig  `json:"tiers"`
+}
+
+func settingsOf(c *config.Config) *settings {
+	s := &settings{
+		TokenKeyID:         auth.KeyID(c.Token),
+		DiscountExpireTime: c.DiscountConfig.ExpireTime,
+		DiscountCodeLength: c.DiscountConfig.CodeLength,
+		Limits:             c.Limits,
+		Tiers:              c.Tiers,
+	}
+	if c.AdminToken != "" {
+		s.AdminTokenKeyID = auth.KeyID(c.AdminToken)
+	}
+	return s
+}
+
+// RecordReload records that the configuration was reloaded from previous to next.
+func RecordReload(ctx context.Context, recorder Recorder, previous, next *config.Config) error {
+	return recorder.Record(ctx, Entry{
+		Action:     ActionConfigReload,
+		EntityType: EntityConfig,
+		EntityID:   "config",
+		Before:     settingsOf(previous),
+		After:      settingsOf(next),
+	})
+}
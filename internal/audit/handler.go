@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"payment/api/models"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/openapi"
+	"strconv"
+	"time"
+)
+
+// Page sizes of the audit query endpoint.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the audit log to administrators.
+type Handler struct {
+	Service *Service
+	Logger  *logrus.Logger
+	Config  *config.Value[Config]
+	Keys    auth.Keys
+}
+
+// NewHandler initializes a new Handler for the audit log, which accepts the configured admin
+// token and the admin API keys of keys.
+func NewHandler(service *Service, logger *logrus.Logger, settings *config.Value[Config], keys auth.Keys) *Handler {
+	return &Handler{Service: service, Logger: logger, Config: settings, Keys: keys}
+}
+
+// RegisterRoutes registers the audit routes with the provided router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	protected := auth.AdminMiddleware(func() string { return h.Config.Load().AdminToken }, h.Keys)
+	adminRoutes := router.PathPrefix("/admin/audit").Subrouter()
+
+	openapi.Describe(adminRoutes.HandleFunc("", protected(h.findHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:     "List audit records",
+		Description: "Records are returned oldest first. Pass the sequence of the last record as after to fetch the next page.",
+		Tag:         "admin",
+		Admin:       true,
+		Query: []openapi.Parameter{
+			{Name: "actor", Description: "key:<key_id>, operator:<name> or system"},
+			{Name: "action", Description: "e.g. wallet.transaction"},
+			{Name: "entity_type", Enum: []string{EntityWallet, EntityDiscount, EntityConfig, EntityWebhook, EntityAPIKey}},
+			{Name: "entity_id"},
+			{Name: "request_id"},
+			{Name: "from", Format: "date-time"},
+			{Name: "to", Format: "date-time"},
+			{Name: "after", Type: "integer", Description: "only records with a greater sequence"},
+			{Name: "limit", Type: "integer", Description: "between 1 and " + strconv.Itoa(maxLimit), Format: "int32"},
+		},
+		Responses: map[int]interface{}{http.StatusOK: []models.AuditRecord{}},
+	})
+	openapi.Describe(adminRoutes.HandleFunc("/verify", protected(h.verifyHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Check the hash chain of the whole audit log",
+		Tag:       "admin",
+		Admin:     true,
+		Responses: map[int]interface{}{http.StatusOK: models.AuditVerification{}},
+	})
+}
+
+// findHandler returns the audit records matching the query parameters, oldest first.
+// Pass the sequence of the last record as after to fetch the next page.
+func (h *Handler) findHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	records, err := h.Service.Find(r.Context(), filter)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(records); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
+
+// verifyHandler checks the hash chain of the whole audit log.
+func (h *Handler) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.Service.Verify(r.Context())
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
+
+func parseFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		RequestID:  query.Get("request_id"),
+		Limit:      defaultLimit,
+	}
+
+	var err error
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, errors.ErrBadRequest.WithMessage(name + " must be an RFC 3339 time")
+			}
+		}
+	}
+	if value := query.Get("after"); value != "" {
+		if filter.After, err = strconv.ParseInt(value, 10, 64); err != nil || filter.After < 0 {
+			return filter, errors.ErrBadRequest.WithMessage("after must be a non-negative sequence")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxLimit {
+			return filter, errors.ErrBadRequest.WithMessage("limit must be between 1 and " + strconv.Itoa(maxLimit))
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+)
+
+// Store persists audit records. Records are only ever appended.
+type Store interface {
+	// Add keeps a change until it is sealed into the log.
+	Add(ctx context.Context, entry *models.AuditPending) error
+	// Pending returns up to limit changes that are not sealed yet, oldest first.
+	Pending(ctx context.Context, limit int) ([]*models.AuditPending, error)
+	// Last returns the newest record, or nil when the log is empty. It blocks other sealers
+	// until the surrounding transaction ends, so that no two records extend the same one.
+	Last(ctx context.Context) (*models.AuditRecord, error)
+	// Seal appends record to the log in place of the pending change with the given ID.
+	Seal(ctx context.Context, record *models.AuditRecord, pending int64) error
+	// Find returns the records matching filter ordered by sequence.
+	Find(ctx context.Context, filter models.AuditFilter) ([]*models.AuditRecord, error)
+}
+
+// lockKey identifies the transaction-level advisory lock that serializes the sealers. Only the
+// short transactions that chain pending changes take it; the transactions that record them do not.
+const lockKey int64 = 0x61756469746c6f67 // "auditlog"
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) Add(ctx context.Context, entry *models.AuditPending) error {
+	if err := s.db.Conn(ctx).Create(entry).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not write the audit log").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Pending(ctx context.Context, limit int) ([]*models.AuditPending, error) {
+	entries := make([]*models.AuditPending, 0)
+	if err := s.db.Conn(ctx).Order("id").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return entries, nil
+}
+
+func (s *store) Last(ctx context.Context) (*models.AuditRecord, error) {
+	conn := s.db.Conn(ctx)
+	if err := conn.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+		return nil, errors.ErrInternal.WithMessage("could not lock the audit log").Wrap(err)
+	}
+	var record models.AuditRecord
+	if err := conn.Order("sequence DESC").First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &record, nil
+}
+
+func (s *store) Seal(ctx context.Context, record *models.AuditRecord, pending int64) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Create(record).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not write the audit log").Wrap(err)
+	}
+	if err := conn.Delete(new(models.AuditPending), pending).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not seal the audit log").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Find(ctx context.Context, filter models.AuditFilter) ([]*models.AuditRecord, error) {
+	query := s.db.Conn(ctx).Where("sequence > ?", filter.After)
+	for column, value := range map[string]string{
+		"actor":       filter.Actor,
+		"action":      filter.Action,
+		"entity_type": filter.EntityType,
+		"entity_id":   filter.EntityID,
+		"request_id":  filter.RequestID,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	records := make([]*models.AuditRecord, 0)
+	if err := query.Order("sequence").Limit(filter.Limit).Find(&records).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return records, nil
+}
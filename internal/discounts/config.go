@@ -1,9 +1,26 @@
 package discounts
 
-import "time"
+import (
+	"payment/pkg/config"
+	"time"
+)
 
 type Config struct {
 	CreditExpiration time.Duration // Duration to expire a discount code
 	CodeLength       int           // Length of generated charge or voucher codes
 	AuthToken        string
+	QueueSize        int // Number of redemptions that may wait for the worker
 }
+
+// NewConfig extracts the discount settings from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{
+		CreditExpiration: time.Duration(c.DiscountConfig.ExpireTime) * time.Minute,
+		CodeLength:       c.DiscountConfig.CodeLength,
+		AuthToken:        c.Token,
+		QueueSize:        c.DiscountConfig.QueueSize,
+	}
+}
+
+// DefaultQueueSize is used when Config.QueueSize is not set.
+const DefaultQueueSize = 100
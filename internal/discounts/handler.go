@@ -1,96 +1,101 @@
 package discounts
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"payment/api/models"
+	"payment/internal/otp"
 	"payment/pkg/auth"
-	"payment/pkg/db"
+	"payment/pkg/config"
 	"payment/pkg/errors"
+	"payment/pkg/logging"
 	"payment/pkg/middleware"
+	"payment/pkg/openapi"
 	"payment/pkg/utils"
-	"time"
+)
+
+var (
+	errMissingCode  = errors.NewError(errors.CodeMissingParam, http.StatusBadRequest, "discount code is required")
+	errMissingPhone = errors.NewError(errors.CodeMissingParam, http.StatusBadRequest, "phone number is required")
 )
 
 type Handler struct {
-	discount    IDiscount
-	transaction IDiscountTransaction
-	service     Service
-	logger      *log.Logger
-	config      *Config
-	validator   *validator.Validate
+	discount  IDiscount
+	service   *Service
+	ownership *otp.Service
+	logger    *log.Logger
+	config    *config.Value[Config]
+	validator *validator.Validate
+	keys      auth.Keys
 }
 
-func NewHandler(config *Config, logger *log.Logger, db *db.DB, validate *validator.Validate) *Handler {
+// NewHandler creates the discount HTTP handler on top of the given service and discount repository.
+// Redemptions ask ownership for the proof of phone ownership when the configuration requires it.
+// The protected routes accept the configured token and the API keys of keys.
+func NewHandler(settings *config.Value[Config], logger *log.Logger, service *Service, discount IDiscount,
+	ownership *otp.Service, validate *validator.Validate, keys auth.Keys) *Handler {
 	handler := &Handler{
-		discount:    NewDiscountService(config, logger, db),
-		transaction: NewDiscountTransactionService(config, logger, db),
-		service:     NewService(config, db, logger),
-		logger:      logger,
-		config:      config,
-		validator:   validate,
+		discount:  discount,
+		service:   service,
+		ownership: ownership,
+		logger:    logger,
+		config:    settings,
+		validator: validate,
+		keys:      keys,
 	}
 	return handler
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	protected := auth.AuthMiddleware(&auth.Config{Token: h.config.AuthToken})
+	protected := auth.AuthMiddleware(func() string { return h.config.Load().AuthToken }, h.keys)
 	discountRoutes := router.PathPrefix("/discount").Subrouter()
-
-	discountRoutes.HandleFunc("", protected(h.createDiscount)).Methods(http.MethodPost)
-	discountRoutes.Handle("/usages", middleware.PhoneValidatorMiddleware(http.HandlerFunc(h.discountTransactions))).Methods(http.MethodGet)
-	discountRoutes.Handle("/apply", middleware.PhoneValidatorMiddleware(http.HandlerFunc(h.applyDiscount))).Methods(http.MethodGet)
-
+	code := openapi.Parameter{Name: "code", Description: "discount code", Required: true}
+	phone := openapi.Parameter{Name: "phone", Description: "phone number of the wallet", Required: true}
+
+	openapi.Describe(discountRoutes.HandleFunc("", protected(h.createDiscount)).Methods(http.MethodPost), openapi.Operation{
+		Summary:     "Create a discount",
+		Description: "The code and the expiration time are generated.",
+		Tag:         "discounts",
+		Secured:     true,
+		Request:     models.Discount{},
+		Responses:   map[int]interface{}{http.StatusCreated: models.Discount{}},
+	})
+	openapi.Describe(discountRoutes.Handle("/usages", middleware.PhoneValidatorMiddleware(http.HandlerFunc(h.discountTransactions))).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Get a discount and its redemptions",
+		Tag:       "discounts",
+		Query:     []openapi.Parameter{code, phone},
+		Responses: map[int]interface{}{http.StatusOK: models.Discount{}},
+	})
+	openapi.Describe(discountRoutes.Handle("/apply", middleware.PhoneValidatorMiddleware(http.HandlerFunc(h.applyDiscount))).Methods(http.MethodGet), openapi.Operation{
+		Summary:     "Redeem a discount",
+		Description: "The amount of the discount is deposited to the wallet of phone, which is created when it does not exist.",
+		Tag:         "discounts",
+		Query:       []openapi.Parameter{code, phone},
+		Headers: []openapi.Parameter{{
+			Name:        otp.TokenHeader,
+			Description: "ownership token of the phone number, when the configuration requires one",
+		}},
+		Responses: map[int]interface{}{http.StatusOK: models.DiscountResponse{}},
+	})
 }
 
 // createDiscount handles the creation of a new discount code.
 func (h *Handler) createDiscount(w http.ResponseWriter, r *http.Request) {
-	var discount *models.Discount
+	discount := &models.Discount{}
 	var err error
 
-	if err = json.NewDecoder(r.Body).Decode(&discount); err != nil {
-		h.logger.Error(err)
-		errors.Error(w, http.StatusBadRequest)
-		return
-	}
-
-	err = h.validator.Struct(discount)
-	if err != nil {
-		h.logger.Error(err.Error())
-		errors.Error(w, http.StatusBadRequest)
+	if err = utils.DecodeJSON(w, r, h.validator, discount); err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
-	if discount.Type == "" {
-		errors.Error(w, http.StatusBadRequest, "discount type is required")
-		return
-	}
-
-	switch discount.Type {
-	case models.Voucher, models.Charge:
-		break
-	default:
-		errors.Error(w, http.StatusBadRequest, "discount type is invalid")
-		return
-	}
-
-	discountCode, err := utils.GenerateDiscount(h.config.CodeLength)
-	if err != nil {
-		h.logger.Error(err)
-		errors.Error(w, http.StatusBadRequest)
-		return
-	}
-
-	discount.ExpirationTime = time.Now().Add(h.config.CreditExpiration)
-	discount.CreatedAt = time.Now()
-	discount.Code = discountCode
-
-	if discount, err = h.service.Create(r.Context(), discount); err != nil {
-		h.logger.Error(err)
-		errors.Error(w, http.StatusBadRequest)
+	if discount, err = h.service.Issue(r.Context(), discount); err != nil {
+		logging.FromContext(r.Context(), h.logger).Error(err)
+		errors.Respond(w, err)
 		return
 	} else {
 		w.Header().Set("Content-Type", "application/json")
@@ -103,13 +108,13 @@ func (h *Handler) createDiscount(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) discountTransactions(w http.ResponseWriter, r *http.Request) {
 	discountCode := r.URL.Query().Get("code")
 	if discountCode == "" {
-		http.Error(w, "discount code is required", http.StatusBadRequest)
+		errors.Respond(w, errMissingCode)
 		return
 	}
 
 	discount, err := h.discount.GetByCode(r.Context(), discountCode)
 	if err != nil {
-		errors.Error(w, http.StatusBadRequest)
+		errors.Respond(w, err)
 		return
 	}
 
@@ -126,11 +131,11 @@ func (h *Handler) applyDiscount(w http.ResponseWriter, r *http.Request) {
 	phoneNumber := r.URL.Query().Get("phone")
 
 	if discountCode == "" {
-		errors.Error(w, http.StatusBadRequest, "discount code is required")
+		errors.Respond(w, errMissingCode)
 		return
 	}
 	if phoneNumber == "" {
-		errors.Error(w, http.StatusBadRequest, "phone number is required")
+		errors.Respond(w, errMissingPhone)
 		return
 	}
 
@@ -139,9 +144,12 @@ func (h *Handler) applyDiscount(w http.ResponseWriter, r *http.Request) {
 		PhoneNum: phoneNumber,
 	}
 
-	discount, err := h.service.Apply(r.Context(), req)
+	token := r.Header.Get(otp.TokenHeader)
+	discount, err := h.service.Apply(r.Context(), req, func(ctx context.Context) error {
+		return h.ownership.Require(ctx, otp.PurposeDiscount, phoneNumber, token)
+	})
 	if err != nil {
-		errors.Error(w, http.StatusBadRequest, err.Error())
+		errors.Respond(w, err)
 		return
 	}
 
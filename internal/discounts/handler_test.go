@@ -0,0 +1,209 @@
+package discounts_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/otp"
+	"payment/internal/wallets"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/utils"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const token = "test-token"
+
+type fixture struct {
+	server  *httptest.Server
+	wallets wallets.IWallet
+	service *discounts.Service
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+
+	db := memory.NewDB()
+	auditor := audit.NewService(logger, memory.NewAudit(db), db)
+	outbox := events.NewOutbox(memory.NewOutbox(db))
+	walletService := wallets.NewWallet(logger, memory.NewWallets(db), memory.NewTransactions(db), db, auditor, outbox,
+		config.NewValue(&wallets.Config{AuthToken: token}))
+	discountRepository := memory.NewDiscounts(db)
+	settings := config.NewValue(&discounts.Config{CreditExpiration: time.Minute, CodeLength: 8, AuthToken: token})
+
+	service := discounts.NewService(settings, logger, db, discountRepository, discountRepository, walletService, auditor, outbox)
+	ownership := otp.NewService(logger, memory.NewOTP(db), db, otp.NewConsoleSender(io.Discard), config.NewValue(&otp.Config{}))
+	handler := discounts.NewHandler(settings, logger, service, discountRepository, ownership, validate, nil)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return &fixture{server: server, wallets: walletService, service: service}
+}
+
+func (f *fixture) do(t *testing.T, method, path, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+
+	resp, err := f.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func (f *fixture) createDiscount(t *testing.T, amount, usageLimit int64) string {
+	t.Helper()
+	resp, body := f.do(t, http.MethodPost, "/discount",
+		`{"usage_limit": `+strconv.FormatInt(usageLimit, 10)+`, "description": "Voucher for cup league", "amount": `+strconv.FormatInt(amount, 10)+`, "type": "voucher"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create discount: got %d %s", resp.StatusCode, body)
+	}
+	var discount models.Discount
+	if err := json.Unmarshal(body, &discount); err != nil {
+		t.Fatal(err)
+	}
+	if len(discount.Code) != 8 {
+		t.Fatalf("got code %q, want 8 characters", discount.Code)
+	}
+	return discount.Code
+}
+
+func errorCode(t *testing.T, body []byte) errors.Code {
+	t.Helper()
+	var message errors.ErrorMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatalf("invalid error body %q: %v", body, err)
+	}
+	return message.Code
+}
+
+func TestApplyChargesWallet(t *testing.T) {
+	f := newFixture(t)
+	code := f.createDiscount(t, 1000, 10)
+
+	resp, body := f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989121234567", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("apply: got %d %s", resp.StatusCode, body)
+	}
+
+	wallet, err := f.wallets.GetByPhone(context.Background(), "+989121234567")
+	if err != nil {
+		t.Fatalf("wallet was not created: %v", err)
+	}
+	if wallet.Amount != 1000 || len(wallet.Transactions) != 1 {
+		t.Fatalf("got balance %d with %d transactions, want 1000 with 1", wallet.Amount, len(wallet.Transactions))
+	}
+	if wallet.Tier != models.TierUnverified {
+		t.Fatalf("got tier %q, want wallets created by a redemption to start unverified", wallet.Tier)
+	}
+
+	resp, body = f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989121234567", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(t, body) != errors.CodeDiscountAlreadyUsed {
+		t.Fatalf("second apply: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = f.do(t, http.MethodGet, "/discount/usages?code="+code+"&phone=989121234567", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("usages: got %d %s", resp.StatusCode, body)
+	}
+	var discount models.Discount
+	if err = json.Unmarshal(body, &discount); err != nil {
+		t.Fatal(err)
+	}
+	if len(discount.Transactions) != 1 || discount.Transactions[0].PhoneNum != "+989121234567" {
+		t.Fatalf("unexpected usages %s", body)
+	}
+}
+
+func TestApplyUsageLimit(t *testing.T) {
+	f := newFixture(t)
+	code := f.createDiscount(t, 500, 1)
+
+	resp, body := f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989121234567", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("apply: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989127654321", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(t, body) != errors.CodeDiscountLimitReached {
+		t.Fatalf("apply over limit: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestApplyUnknownCode(t *testing.T) {
+	f := newFixture(t)
+
+	resp, body := f.do(t, http.MethodGet, "/discount/apply?code=UNKNOWN1&phone=989121234567", "")
+	if resp.StatusCode != http.StatusNotFound || errorCode(t, body) != errors.CodeDiscountNotFound {
+		t.Fatalf("apply unknown: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestApplyRespectsWalletStatus(t *testing.T) {
+	f := newFixture(t)
+	code := f.createDiscount(t, 100, 10)
+	ctx := context.Background()
+
+	for phone, status := range map[string]models.WalletStatus{
+		"+989121111111": models.WalletFrozen,
+		"+989122222222": models.WalletSuspended,
+	} {
+		if _, err := f.wallets.Create(ctx, &models.Wallet{Phone: phone}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.wallets.ChangeStatus(ctx, phone, status, "review"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, body := f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989121111111", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("apply to frozen wallet: got %d %s, want deposits to be allowed", resp.StatusCode, body)
+	}
+
+	resp, body = f.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989122222222", "")
+	if resp.StatusCode != http.StatusLocked || errorCode(t, body) != errors.CodeWalletSuspended {
+		t.Fatalf("apply to suspended wallet: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = f.do(t, http.MethodGet, "/discount/usages?code="+code+"&phone=989122222222", "")
+	var discount models.Discount
+	if err := json.Unmarshal(body, &discount); err != nil {
+		t.Fatal(err)
+	}
+	if len(discount.Transactions) != 1 {
+		t.Fatalf("got %d usages, want the rejected redemption rolled back", len(discount.Transactions))
+	}
+}
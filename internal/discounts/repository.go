@@ -4,8 +4,12 @@ import (
 	"context"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"payment/api/models"
 	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
 )
 
 // IDiscount defines the interface for the discount service, including methods for creating, retrieving, and checking discounts.
@@ -14,6 +18,9 @@ type IDiscount interface {
 	GetByCode(ctx context.Context, code string) (*models.Discount, error)
 	IsUsed(ctx context.Context, id uuid.UUID, phoneNumber string) (bool, error)
 	Count(ctx context.Context, id uuid.UUID) (int64, error)
+	// Lock holds a row lock on the discount until the surrounding transaction ends,
+	// serializing redemptions of the same code.
+	Lock(ctx context.Context, id uuid.UUID) error
 }
 
 // NewDiscountService creates a new instance of DiscountService implementing the IDiscount interface.
@@ -41,14 +48,14 @@ type DiscountService struct {
 }
 
 func (r *DiscountService) Create(ctx context.Context, discount *models.Discount) (*models.Discount, error) {
-	if err := r.db.WithContext(ctx).Save(discount).Error; err != nil {
-		r.logger.WithFields(log.Fields{
+	if err := r.db.Conn(ctx).Save(discount).Error; err != nil {
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"discount": discount,
 			"error":    err,
 		}).Error("failed to save discount")
 		return nil, err
 	}
-	r.logger.WithFields(log.Fields{
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 		"discount": discount,
 	}).Info("discount created")
 
@@ -58,22 +65,24 @@ func (r *DiscountService) Create(ctx context.Context, discount *models.Discount)
 func (r *DiscountService) GetByCode(ctx context.Context, code string) (*models.Discount, error) {
 	var discount *models.Discount
 	var err error
-	if err := r.db.
+	if err := r.db.Conn(ctx).
 		Model(new(models.Discount)).
-		WithContext(ctx).
 		Where("code = ?", code).
 		First(&discount).Error; err != nil {
-		r.logger.WithFields(log.Fields{
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"discount_code": code,
 			"error":         err,
 		}).Error("failed to find discount by Code")
 
-		return nil, err
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.ErrDiscountNotFound
+		}
+		return nil, errors.ErrInternal.Wrap(err)
 	}
 
 	discount.Transactions, err = r.List(ctx, discount.ID)
 	if err != nil {
-		r.logger.WithFields(log.Fields{
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"discount_id": discount.ID,
 			"error":       err,
 		}).Error("failed to list discount transactions")
@@ -84,9 +93,8 @@ func (r *DiscountService) GetByCode(ctx context.Context, code string) (*models.D
 
 func (r *DiscountService) IsUsed(ctx context.Context, id uuid.UUID, phoneNumber string) (bool, error) {
 	var count int64 = 0
-	if err := r.db.
+	if err := r.db.Conn(ctx).
 		Model(new(models.DiscountTransaction)).
-		WithContext(ctx).
 		Where("discount_id = ?", id).
 		Where("phone_num = ?", phoneNumber).
 		Count(&count).Error; err != nil {
@@ -97,9 +105,8 @@ func (r *DiscountService) IsUsed(ctx context.Context, id uuid.UUID, phoneNumber
 
 func (r *DiscountService) Count(ctx context.Context, id uuid.UUID) (int64, error) {
 	var count int64 = 0
-	if err := r.db.
+	if err := r.db.Conn(ctx).
 		Model(new(models.DiscountTransaction)).
-		WithContext(ctx).
 		Where("discount_id = ?", id).
 		Count(&count).Error; err != nil {
 		return 0, err
@@ -107,9 +114,22 @@ func (r *DiscountService) Count(ctx context.Context, id uuid.UUID) (int64, error
 	return count, nil
 }
 
+func (r *DiscountService) Lock(ctx context.Context, id uuid.UUID) error {
+	if err := r.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		First(new(models.Discount), "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.ErrDiscountNotFound
+		}
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
 func (r *DiscountService) Add(ctx context.Context, transaction *models.DiscountTransaction) (*models.DiscountTransaction, error) {
-	if err := r.db.WithContext(ctx).Save(transaction).Error; err != nil {
-		r.logger.WithFields(log.Fields{
+	if err := r.db.Conn(ctx).Save(transaction).Error; err != nil {
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"transaction_id": transaction.ID,
 			"discount_id":    transaction.DiscountID,
 			"wallet_id":      transaction.WalletID,
@@ -122,8 +142,8 @@ func (r *DiscountService) Add(ctx context.Context, transaction *models.DiscountT
 }
 
 func (r *DiscountService) Delete(ctx context.Context, id uuid.UUID) error {
-	if err := r.db.Unscoped().WithContext(ctx).Model(new(models.DiscountTransaction)).Delete(new(models.Status), id).Error; err != nil {
-		r.logger.WithFields(log.Fields{
+	if err := r.db.Conn(ctx).Unscoped().Delete(new(models.DiscountTransaction), "id = ?", id).Error; err != nil {
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"transaction_id": id,
 			"error":          err,
 		}).Error("failed to delete discount transaction")
@@ -134,12 +154,12 @@ func (r *DiscountService) Delete(ctx context.Context, id uuid.UUID) error {
 
 func (r *DiscountService) List(ctx context.Context, id uuid.UUID) ([]*models.DiscountTransaction, error) {
 	var transactions []*models.DiscountTransaction
-	if err := r.db.
+	if err := r.db.Conn(ctx).
 		Model(new(models.DiscountTransaction)).
-		WithContext(ctx).
 		Where("discount_id = ?", id).
+		Order("created_at").
 		Find(&transactions).Error; err != nil {
-		r.logger.WithFields(log.Fields{
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 			"discount_id": id,
 			"error":       err,
 		}).Error("failed to list discount transactions")
@@ -2,11 +2,20 @@ package discounts
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
 	"payment/internal/wallets"
+	"payment/pkg/config"
 	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/metrics"
+	"payment/pkg/tracing"
+	"payment/pkg/utils"
 	"time"
 )
 
@@ -15,21 +24,30 @@ type Service struct {
 	discountService     IDiscount
 	discountTransaction IDiscountTransaction
 	walletService       wallets.IWallet
-	worker              Worker
-	configs             *Config
+	worker              *Worker
+	transactor          db.Transactor
+	auditor             audit.Recorder
+	configs             *config.Value[Config]
 	logger              *log.Logger
 }
 
-// NewService initializes and returns a new Service instance.
-func NewService(config *Config, db *db.DB, log *log.Logger) Service {
-	discountService := NewDiscountService(config, log, db)
-	discountTransaction := NewDiscountTransactionService(config, log, db)
-	service := Service{
+// NewService initializes and returns a new Service instance using the given repositories and wallet service.
+// It starts the worker that charges wallets in the background. Created and redeemed discounts are recorded by auditor,
+// and redemptions are announced through outbox.
+func NewService(settings *config.Value[Config], log *log.Logger, transactor db.Transactor, discountService IDiscount,
+	discountTransaction IDiscountTransaction, walletService wallets.IWallet, auditor audit.Recorder, outbox events.Outbox) *Service {
+	queueSize := settings.Load().QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	service := &Service{
 		discountService:     discountService,
 		discountTransaction: discountTransaction,
-		walletService:       wallets.NewWallet(log, db),
-		worker:              NewWorker(log, db, discountService, discountTransaction),
-		configs:             config,
+		walletService:       walletService,
+		worker:              NewWorker(log, transactor, discountService, discountTransaction, walletService, auditor, outbox, queueSize),
+		transactor:          transactor,
+		auditor:             auditor,
+		configs:             settings,
 		logger:              log,
 	}
 
@@ -39,19 +57,116 @@ func NewService(config *Config, db *db.DB, log *log.Logger) Service {
 	return service
 }
 
+// CheckWorker reports whether the worker is running and has room for more redemptions.
+func (s *Service) CheckWorker(_ context.Context) (string, error) {
+	length, capacity := s.worker.Queue()
+	detail := fmt.Sprintf("%d/%d redemptions queued", length, capacity)
+	if !s.worker.Running() {
+		return detail, fmt.Errorf("discount worker is not running")
+	}
+	if length >= capacity {
+		return detail, fmt.Errorf("discount worker queue is saturated")
+	}
+	return detail, nil
+}
+
 func (s *Service) Create(ctx context.Context, discount *models.Discount) (*models.Discount, error) {
-	s.logger.WithFields(log.Fields{
-		"discount": discount,
+	ctx, span := tracing.Start(ctx, "discounts.Service.Create")
+	defer span.End()
+
+	var created *models.Discount
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if created, err = s.discountService.Create(ctx, discount); err != nil {
+			return err
+		}
+		state := *created
+		state.Transactions = nil
+		return s.auditor.Record(ctx, audit.Entry{
+			Action:     audit.ActionDiscountCreate,
+			EntityType: audit.EntityDiscount,
+			EntityID:   created.ID.String(),
+			After:      &state,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"discount_id": created.ID,
+		"code":        created.Code,
 	}).Info("discount created successfully")
+	return created, nil
+}
+
+// Issue checks the type of discount, gives it a new code and expiration time, and creates it.
+func (s *Service) Issue(ctx context.Context, discount *models.Discount) (*models.Discount, error) {
+	switch discount.Type {
+	case "":
+		return nil, errors.ErrInvalidDiscountType.WithMessage("discount type is required")
+	case models.Voucher, models.Charge:
+	default:
+		return nil, errors.ErrInvalidDiscountType
+	}
 
-	if tx, err := s.discountService.Create(ctx, discount); err != nil {
+	settings := s.configs.Load()
+	code, err := utils.GenerateDiscount(settings.CodeLength)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Error(err)
 		return nil, err
-	} else {
-		return tx, nil
 	}
+	discount.ExpirationTime = time.Now().Add(settings.CreditExpiration)
+	discount.CreatedAt = time.Now()
+	discount.Code = code
+	return s.Create(ctx, discount)
+}
+
+// Usages returns the discount with the given code and its redemptions.
+func (s *Service) Usages(ctx context.Context, code string) (*models.Discount, error) {
+	return s.discountService.GetByCode(ctx, code)
+}
+
+// Rerun redeems code for phoneNumber again, for a redemption that was lost, e.g. because the service
+// stopped while it was queued. It runs the allocation directly instead of through the worker and does not
+// check the expiration time, which the original request already passed. Allocations are all-or-nothing, so
+// a redemption that did complete is reported with redeemed unset and is never applied twice.
+func (s *Service) Rerun(ctx context.Context, code, phoneNumber string) (redeemed bool, err error) {
+	discount, err := s.discountService.GetByCode(ctx, code)
+	if err != nil {
+		return false, err
+	}
+	// Checked first, as a discount that reached its limit with this redemption reports the limit otherwise.
+	if used, err := s.discountService.IsUsed(ctx, discount.ID, phoneNumber); err != nil || used {
+		return false, err
+	}
+	if err = s.worker.Allocation(ctx, discount, phoneNumber, nil); err != nil {
+		if errors.Is(err, errors.ErrDiscountAlreadyUsed) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Guard runs in the transaction that redeems a discount, before the discount is redeemed. An error from
+// it cancels the redemption, and a redemption that fails rolls back what it did.
+type Guard func(ctx context.Context) error
+
+// Apply redeems a discount code for a phone number and records the outcome in the redemption metrics.
+// guard, if not nil, runs in the transaction of the redemption.
+func (s *Service) Apply(ctx context.Context, req *models.DiscountApplyRequest, guard Guard) (*models.Discount, error) {
+	ctx, span := tracing.Start(ctx, "discounts.Service.Apply", attribute.String("discount.code", req.Code))
+	discount, err := s.apply(ctx, req, guard)
+	outcome := redemptionOutcome(err)
+	span.SetAttributes(attribute.String("discount.outcome", outcome))
+	tracing.End(span, err)
+
+	metrics.ObserveRedemption(outcome)
+	return discount, err
 }
 
-func (s *Service) Apply(ctx context.Context, req *models.DiscountApplyRequest) (*models.Discount, error) {
+func (s *Service) apply(ctx context.Context, req *models.DiscountApplyRequest, guard Guard) (*models.Discount, error) {
 	var (
 		err      error
 		discount *models.Discount
@@ -69,13 +184,16 @@ func (s *Service) Apply(ctx context.Context, req *models.DiscountApplyRequest) (
 		return nil, err
 	}
 
-	timeout := time.Tick(s.configs.CreditExpiration)
-	workerResp := make(chan *Response)
+	timeout := time.Tick(s.configs.Load().CreditExpiration)
+	workerResp := make(chan *Response, 1)
 
+	metrics.WorkerEnqueued()
 	s.worker.dataChan <- &Seed{
 		ctx:         ctx,
+		enqueued:    time.Now(),
 		discount:    discount,
 		phoneNumber: req.PhoneNum,
+		guard:       guard,
 		respChan:    workerResp,
 	}
 
@@ -86,42 +204,70 @@ func (s *Service) Apply(ctx context.Context, req *models.DiscountApplyRequest) (
 		}
 		return nil, resp.Error
 	case <-ctx.Done():
-		return nil, err
+		return nil, errors.ErrTimeout.Wrap(ctx.Err())
 	case <-timeout:
-		return nil, err
+		return nil, errors.ErrTimeout.WithMessage("discount allocation timed out")
 	}
 }
 
 func (s *Service) IsUsed(ctx context.Context, discount *models.Discount, phoneNumber string) error {
+	return checkUsage(ctx, s.discountService, discount, phoneNumber)
+}
+
+// checkUsage rejects a redemption when the discount has reached its usage limit
+// or has already been redeemed by phoneNumber.
+func checkUsage(ctx context.Context, discountService IDiscount, discount *models.Discount, phoneNumber string) error {
 	var (
 		used       bool
 		usageCount int64
 		err        error
 	)
 
-	if usageCount, err = s.discountService.Count(ctx, discount.ID); err != nil {
+	if usageCount, err = discountService.Count(ctx, discount.ID); err != nil {
 		return err
 	}
 
 	if usageCount >= discount.UsageLimit {
-		return errors.New("usage limit exceed")
+		return errors.ErrDiscountLimitReached
 	}
 
-	if used, err = s.discountService.IsUsed(ctx, discount.ID, phoneNumber); err != nil {
+	if used, err = discountService.IsUsed(ctx, discount.ID, phoneNumber); err != nil {
 		return err
 	}
 	if used {
-		return errors.New("discount code used before")
+		return errors.ErrDiscountAlreadyUsed
 	}
 	return nil
 }
 
 func (s *Service) IsExpired(_ context.Context, discount *models.Discount) error {
 	if discount.ExpirationTime.Before(time.Now()) {
-		return errors.New("discount expired")
+		return errors.ErrDiscountExpired
 	}
 	if discount.CreatedAt.After(time.Now()) {
-		return errors.New("invalid discount")
+		return errors.ErrDiscountNotActive
 	}
 	return nil
 }
+
+// redemptionOutcome maps the result of Apply to the outcome label of the redemption metrics.
+func redemptionOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, errors.ErrDiscountExpired), errors.Is(err, errors.ErrDiscountNotActive):
+		return metrics.OutcomeExpired
+	case errors.Is(err, errors.ErrDiscountLimitReached):
+		return metrics.OutcomeLimit
+	case errors.Is(err, errors.ErrDiscountAlreadyUsed):
+		return metrics.OutcomeUsed
+	case errors.Is(err, errors.ErrDiscountNotFound):
+		return metrics.OutcomeNotFound
+	case errors.Is(err, errors.ErrTimeout):
+		return metrics.OutcomeTimeout
+	case errors.Is(err, errors.ErrWalletSuspended), errors.Is(err, errors.ErrWalletClosed):
+		return metrics.OutcomeBlocked
+	default:
+		return metrics.OutcomeError
+	}
+}
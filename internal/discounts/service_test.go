@@ -0,0 +1,51 @@
+package discounts_test
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"payment/api/models"
+	"testing"
+)
+
+func TestApplyTraceCrossesWorker(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	f := newFixture(t)
+	code := f.createDiscount(t, 100, 1)
+
+	if _, err := f.service.Apply(context.Background(), &models.DiscountApplyRequest{Code: code, PhoneNum: "+989121234567"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	apply, consume := spans["discounts.Service.Apply"], spans["discounts.Worker.Consume"]
+	if apply == nil || consume == nil {
+		t.Fatalf("missing apply or worker span in %v", spans)
+	}
+	if consume.Parent().SpanID() != apply.SpanContext().SpanID() {
+		t.Error("worker span is not a child of the apply span")
+	}
+	if spans["wallets.Service.Transaction"] == nil {
+		t.Error("missing wallet transaction span")
+	}
+}
+
+func TestCheckWorker(t *testing.T) {
+	f := newFixture(t)
+
+	detail, err := f.service.CheckWorker(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0/100 redemptions queued"; detail != want {
+		t.Errorf("got detail %q, want %q", detail, want)
+	}
+}
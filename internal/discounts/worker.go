@@ -2,11 +2,20 @@ package discounts
 
 import (
 	"context"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
 	"payment/internal/wallets"
 	"payment/pkg/db"
-	"strings"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/metrics"
+	"payment/pkg/tracing"
+	"sync/atomic"
+	"time"
 )
 
 type Worker struct {
@@ -14,13 +23,21 @@ type Worker struct {
 	DiscountService     IDiscount
 	DiscountTransaction IDiscountTransaction
 	WalletService       wallets.IWallet
+	transactor          db.Transactor
+	auditor             audit.Recorder
+	outbox              events.Outbox
 	logger              *log.Logger
+	running             atomic.Bool
 }
 
+// Seed is a redemption handed to the worker. ctx carries the trace of the request
+// that enqueued it, so the worker's spans belong to the same trace.
 type Seed struct {
 	ctx         context.Context
+	enqueued    time.Time
 	discount    *models.Discount
 	phoneNumber string
+	guard       Guard
 	respChan    chan *Response
 }
 
@@ -30,39 +47,61 @@ type Response struct {
 }
 
 // NewWorker creates a new Worker instance for charging wallet in the background.
-func NewWorker(logger *log.Logger, db *db.DB, discountService IDiscount, discountTransaction IDiscountTransaction) Worker {
-	return Worker{
+// Up to queueSize redemptions may wait for it before callers block.
+func NewWorker(logger *log.Logger, transactor db.Transactor, discountService IDiscount,
+	discountTransaction IDiscountTransaction, walletService wallets.IWallet, auditor audit.Recorder,
+	outbox events.Outbox, queueSize int) *Worker {
+	return &Worker{
 		logger:              logger,
 		DiscountService:     discountService,
 		DiscountTransaction: discountTransaction,
-		WalletService:       wallets.NewWallet(logger, db),
-		dataChan:            make(chan *Seed),
+		WalletService:       walletService,
+		transactor:          transactor,
+		auditor:             auditor,
+		outbox:              outbox,
+		dataChan:            make(chan *Seed, queueSize),
 	}
 }
 
+// Start consumes seeds in a background goroutine until the data channel is closed.
+// The response channel of a seed must be buffered, so that a caller that stopped
+// waiting never blocks the worker.
 func (w *Worker) Start() {
+	w.running.Store(true)
 	go func() {
+		defer w.running.Store(false)
 		for {
 			seed, ok := <-w.dataChan
 			if !ok {
 				break
 			}
+			metrics.WorkerDequeued()
+
+			start := time.Now()
 			response := w.Consume(seed)
-			select {
-			case <-seed.ctx.Done():
-				return
-			default:
-				seed.respChan <- response
-			}
+			metrics.ObserveWorker(time.Since(start))
+			seed.respChan <- response
 		}
 	}()
 }
 
+// Running reports whether the worker goroutine is consuming seeds.
+func (w *Worker) Running() bool {
+	return w.running.Load()
+}
+
+// Queue returns the number of seeds waiting for the worker and the capacity of the queue.
+func (w *Worker) Queue() (length, capacity int) {
+	return len(w.dataChan), cap(w.dataChan)
+}
+
 func (w *Worker) Consume(seed *Seed) *Response {
-	var (
-		err error
-	)
-	err = w.Allocation(seed.ctx, seed.discount, seed.phoneNumber)
+	ctx, span := tracing.Start(seed.ctx, "discounts.Worker.Consume",
+		attribute.String("discount.code", seed.discount.Code),
+		attribute.Int64("worker.queue_wait_ms", time.Since(seed.enqueued).Milliseconds()))
+
+	err := w.Allocation(ctx, seed.discount, seed.phoneNumber, seed.guard)
+	tracing.End(span, err)
 	if err != nil {
 		return &Response{
 			Error: err,
@@ -74,29 +113,73 @@ func (w *Worker) Consume(seed *Seed) *Response {
 	}
 }
 
-func (w *Worker) Allocation(ctx context.Context, discount *models.Discount, phoneNumber string) error {
-	var (
-		err                 error
-		wallet              *models.Wallet
-		discountTransaction *models.DiscountTransaction
-	)
-	if wallet, err = w.FetchWallet(ctx, phoneNumber); err != nil {
-		return err
-	}
+// Allocation redeems discount for phoneNumber in a single transaction. The discount row is locked
+// and the usage rules are checked again, so concurrent redemptions cannot exceed the usage limit
+// or redeem the same code twice for one phone number. guard, if not nil, runs first in the transaction.
+func (w *Worker) Allocation(ctx context.Context, discount *models.Discount, phoneNumber string, guard Guard) error {
+	return w.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var (
+			err                 error
+			wallet              *models.Wallet
+			discountTransaction *models.DiscountTransaction
+		)
+		if guard != nil {
+			if err = guard(ctx); err != nil {
+				return err
+			}
+		}
+		if err = w.DiscountService.Lock(ctx, discount.ID); err != nil {
+			return err
+		}
+		if err = checkUsage(ctx, w.DiscountService, discount, phoneNumber); err != nil {
+			return err
+		}
 
-	if discountTransaction, err = w.DiscountTransaction.Add(ctx, &models.DiscountTransaction{
-		DiscountID: discount.ID,
-		WalletID:   wallet.ID,
-		PhoneNum:   phoneNumber,
-	}); err != nil {
-		return err
-	}
+		if wallet, err = w.FetchWallet(ctx, phoneNumber); err != nil {
+			return err
+		}
 
-	if err = w.ChargeWallet(ctx, discount, wallet, discountTransaction); err != nil {
-		return err
-	}
+		if discountTransaction, err = w.DiscountTransaction.Add(ctx, &models.DiscountTransaction{
+			DiscountID: discount.ID,
+			WalletID:   wallet.ID,
+			PhoneNum:   phoneNumber,
+		}); err != nil {
+			return err
+		}
 
-	return nil
+		if err = w.ChargeWallet(ctx, discount, wallet, discountTransaction); err != nil {
+			return err
+		}
+		if err = w.auditor.Record(ctx, audit.Entry{
+			Action:     audit.ActionDiscountApply,
+			EntityType: audit.EntityDiscount,
+			EntityID:   discount.ID.String(),
+			After:      &redemption{ID: discountTransaction.ID, WalletID: wallet.ID, Amount: discount.Amount},
+		}); err != nil {
+			return err
+		}
+		return w.outbox.Add(ctx, events.Event{
+			Type:          events.DiscountRedeemed,
+			AggregateType: events.AggregateDiscount,
+			AggregateID:   discount.ID.String(),
+			Payload: &events.Redemption{
+				DiscountID: discount.ID,
+				Code:       discount.Code,
+				Type:       discount.Type,
+				WalletID:   wallet.ID,
+				Phone:      phoneNumber,
+				Amount:     discount.Amount,
+			},
+		})
+	})
+}
+
+// redemption is what the audit log keeps of a redeemed discount. The phone number is left
+// out, as the wallet ID identifies the wallet without outliving its anonymization.
+type redemption struct {
+	ID       uuid.UUID `json:"id"`
+	WalletID uuid.UUID `json:"wallet_id"`
+	Amount   int64     `json:"amount"`
 }
 
 func (w *Worker) FetchWallet(ctx context.Context, phoneNumber string) (*models.Wallet, error) {
@@ -105,8 +188,12 @@ func (w *Worker) FetchWallet(ctx context.Context, phoneNumber string) (*models.W
 		wallet *models.Wallet
 	)
 	if wallet, err = w.WalletService.GetByPhone(ctx, phoneNumber); err != nil {
-		if strings.Contains(err.Error(), "record not found") {
-			if wallet, err = w.WalletService.Create(ctx, &models.Wallet{Phone: phoneNumber, Amount: 0}); err != nil {
+		if errors.Is(err, errors.ErrWalletNotFound) {
+			// Wallets created by a redemption belong to nobody verified yet, so they start at the lowest tier.
+			if wallet, err = w.WalletService.Create(ctx, &models.Wallet{
+				Phone: phoneNumber,
+				Tier:  models.TierUnverified,
+			}); err != nil {
 				return nil, err
 			}
 		} else {
@@ -125,22 +212,16 @@ func (w *Worker) ChargeWallet(ctx context.Context, discount *models.Discount, wa
 	}
 
 	if err := w.WalletService.Transaction(ctx, wallet, transaction); err != nil {
-		w.logger.WithFields(log.Fields{
+		logging.FromContext(ctx, w.logger).WithFields(log.Fields{
 			"wallet_id":     wallet.ID,
 			"discount_code": discount.Code,
 		}).WithError(err).Error("failed to deposit wallet")
 
-		if rollbackErr := w.DiscountTransaction.Delete(ctx, discountTransaction.ID); rollbackErr != nil {
-			w.logger.WithFields(log.Fields{
-				"transaction_id": discountTransaction.ID,
-			}).WithError(rollbackErr).Error("failed to rollback usage")
-			return rollbackErr
-		}
-
+		// The usage recorded by Allocation is rolled back together with the deposit.
 		return err
 	}
 
-	w.logger.WithFields(log.Fields{
+	logging.FromContext(ctx, w.logger).WithFields(log.Fields{
 		"transaction_id": discountTransaction.ID,
 		"amount":         discount.Amount,
 		"type":           discount.Type,
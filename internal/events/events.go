@@ -0,0 +1,93 @@
+// Package events implements a transactional outbox. Services add domain events in the
+// transaction of the change they announce, and the Relay publishes them once committed,
+// so that an event is never lost nor sent for a change that was rolled back.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"time"
+)
+
+// Event types.
+const (
+	WalletCreated        = "wallet.created"
+	TransactionCompleted = "transaction.completed"
+	TransactionFailed    = "transaction.failed"
+	DiscountRedeemed     = "discount.redeemed"
+)
+
+// Aggregate types, the kind of entity an event belongs to.
+const (
+	AggregateWallet   = "wallet"
+	AggregateDiscount = "discount"
+)
+
+// Event is a domain event to be published. Payload is delivered as JSON.
+type Event struct {
+	Type          string
+	AggregateType string
+	AggregateID   string
+	Payload       interface{}
+}
+
+// Outbox adds events to the outbox. It joins the transaction of ctx, so an event is
+// committed or rolled back together with the change it announces.
+type Outbox interface {
+	Add(ctx context.Context, event Event) error
+}
+
+// NewOutbox creates an Outbox on top of store.
+func NewOutbox(store Store) Outbox {
+	return &outbox{store}
+}
+
+type outbox struct {
+	store Store
+}
+
+func (o *outbox) Add(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return errors.ErrInternal.WithMessage("could not encode %s event", event.Type).Wrap(err)
+	}
+	return o.store.Add(ctx, &models.OutboxEvent{
+		CreatedAt:     time.Now(),
+		Type:          event.Type,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Payload:       payload,
+	})
+}
+
+// Wallet is the payload of wallet.created.
+type Wallet struct {
+	WalletID uuid.UUID         `json:"wallet_id"`
+	Phone    string            `json:"phone"`
+	Tier     models.WalletTier `json:"tier"`
+}
+
+// Transaction is the payload of transaction.completed and transaction.failed. Balance is
+// the balance the transaction left, and is zero for failed transactions.
+type Transaction struct {
+	TransactionID uuid.UUID   `json:"transaction_id"`
+	WalletID      uuid.UUID   `json:"wallet_id"`
+	Phone         string      `json:"phone"`
+	Type          models.Type `json:"type"`
+	Amount        int64       `json:"amount"`
+	Description   string      `json:"description"`
+	Balance       int64       `json:"balance,omitempty"`
+}
+
+// Redemption is the payload of discount.redeemed.
+type Redemption struct {
+	DiscountID uuid.UUID           `json:"discount_id"`
+	Code       string              `json:"code"`
+	Type       models.DiscountType `json:"type"`
+	WalletID   uuid.UUID           `json:"wallet_id"`
+	Phone      string              `json:"phone"`
+	Amount     int64               `json:"amount"`
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"payment/api/models"
+	"payment/pkg/config"
+	"sync"
+	"time"
+)
+
+// Publisher delivers an event to the outside world. The relay retries an event until
+// Publish succeeds, so consumers must tolerate duplicates and use the event ID to skip them.
+type Publisher interface {
+	Publish(ctx context.Context, event *models.OutboxEvent) error
+}
+
+// Publishers selectable in the configuration.
+const (
+	PublisherNone    = "none"
+	PublisherLog     = "log"
+	PublisherFile    = "file"
+	PublisherWebhook = "webhook"
+)
+
+// NewPublisher creates the publisher selected by c. It returns nil when publishing is disabled.
+func NewPublisher(c config.EventsConfig, logger *log.Logger) (Publisher, error) {
+	switch c.Publisher {
+	case "", PublisherNone:
+		return nil, nil
+	case PublisherLog:
+		return NewLogPublisher(logger), nil
+	case PublisherFile:
+		return NewFilePublisher(c.File)
+	case PublisherWebhook:
+		return NewWebhookPublisher(c.WebhookURL, &http.Client{Timeout: 10 * time.Second}), nil
+	default:
+		return nil, fmt.Errorf("unknown events publisher %q", c.Publisher)
+	}
+}
+
+// Fanout publishes every event with each of publishers in turn and stops at the first failure.
+// The relay then retries the event with all of them, so each must tolerate duplicates.
+func Fanout(publishers ...Publisher) Publisher {
+	return fanout(publishers)
+}
+
+type fanout []Publisher
+
+func (f fanout) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	for _, publisher := range f {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogPublisher writes every event to the log.
+type LogPublisher struct {
+	logger *log.Logger
+}
+
+// NewLogPublisher creates a publisher that logs events with logger.
+func NewLogPublisher(logger *log.Logger) *LogPublisher {
+	return &LogPublisher{logger}
+}
+
+func (p *LogPublisher) Publish(_ context.Context, event *models.OutboxEvent) error {
+	p.logger.WithFields(log.Fields{
+		"section":        "events",
+		"event_id":       event.ID,
+		"event_type":     event.Type,
+		"aggregate_type": event.AggregateType,
+		"aggregate_id":   event.AggregateID,
+		"payload":        event.Payload,
+	}).Info("event published")
+	return nil
+}
+
+// FilePublisher appends every event to a file as one JSON object per line.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher opens path for appending, creating it when it does not exist.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+func (p *FilePublisher) Publish(_ context.Context, event *models.OutboxEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err = p.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+// Close closes the file.
+func (p *FilePublisher) Close() error {
+	return p.file.Close()
+}
+
+// Headers sent with every event delivered by WebhookPublisher.
+const (
+	EventIDHeader   = "X-Event-ID"
+	EventTypeHeader = "X-Event-Type"
+)
+
+// WebhookPublisher posts every event as JSON to a URL. Any status other than 2xx is a failure.
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookPublisher creates a publisher that posts events to url with client.
+func NewWebhookPublisher(url string, client *http.Client) *WebhookPublisher {
+	return &WebhookPublisher{url: url, client: client}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, event.ID.String())
+	req.Header.Set(EventTypeHeader, event.Type)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
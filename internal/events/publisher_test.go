@@ -0,0 +1,93 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"payment/api/models"
+	"payment/internal/events"
+	"testing"
+	"time"
+)
+
+func event() *models.OutboxEvent {
+	return &models.OutboxEvent{
+		ID:            uuid.New(),
+		CreatedAt:     time.Now(),
+		Type:          events.TransactionCompleted,
+		AggregateType: events.AggregateWallet,
+		AggregateID:   uuid.NewString(),
+		Payload:       json.RawMessage(`{"amount":100}`),
+		Attempts:      3,
+	}
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var received models.OutboxEvent
+	var header http.Header
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("invalid body %q: %v", body, err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	publisher := events.NewWebhookPublisher(server.URL, server.Client())
+	sent := event()
+	if err := publisher.Publish(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+	if received.ID != sent.ID || string(received.Payload) != `{"amount":100}` || received.Attempts != 0 {
+		t.Errorf("got %+v, want the event without its delivery state", received)
+	}
+	if header.Get(events.EventIDHeader) != sent.ID.String() || header.Get(events.EventTypeHeader) != sent.Type {
+		t.Errorf("got headers %v", header)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := publisher.Publish(context.Background(), event()); err == nil {
+		t.Error("expected an error for a 503 response")
+	}
+}
+
+func TestFilePublisherAppendsLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	for i := 0; i < 2; i++ {
+		publisher, err := events.NewFilePublisher(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = publisher.Publish(context.Background(), event()); err != nil {
+			t.Fatal(err)
+		}
+		if err = publisher.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		var published models.OutboxEvent
+		if err = json.Unmarshal(scanner.Bytes(), &published); err != nil || published.Type != events.TransactionCompleted {
+			t.Errorf("line %d: got %q (%v)", lines, scanner.Text(), err)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("got %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,180 @@
+package events
+
+import (
+	"context"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/pkg/db"
+	"time"
+)
+
+// DefaultBatch is the number of events published per transaction when none is configured.
+const DefaultBatch = 100
+
+// forwardLease is how long the events claimed for the external publisher are held back from
+// other relays. An event whose lease runs out while it is being sent may be forwarded twice;
+// receivers tell the copies apart by the event ID.
+const forwardLease = 5 * time.Minute
+
+// Relay publishes the events of the outbox in the order they were added, as far as one relay
+// goes: concurrent relays skip the events the others hold, and a transaction that commits after
+// a later one adds its events behind those already published, so the order is best effort. The
+// internal publisher and the external one each have their own cursor, so that an external
+// publisher that is down does not hold back the webhook deliveries and notifications.
+type Relay struct {
+	store         Store
+	transactor    db.Transactor
+	publisher     Publisher
+	external      Publisher
+	logger        *log.Logger
+	batch         int
+	keepPublished time.Duration
+}
+
+// NewRelay creates a relay that publishes up to batch events at a time with publisher on the
+// internal cursor, and with external on the external cursor unless it is nil. Published events
+// are deleted once they are older than keepPublished; zero keeps them.
+func NewRelay(logger *log.Logger, store Store, transactor db.Transactor, publisher, external Publisher,
+	batch int, keepPublished time.Duration) *Relay {
+	if batch <= 0 {
+		batch = DefaultBatch
+	}
+	return &Relay{
+		store:         store,
+		transactor:    transactor,
+		publisher:     publisher,
+		external:      external,
+		logger:        logger,
+		batch:         batch,
+		keepPublished: keepPublished,
+	}
+}
+
+// Run publishes pending events immediately and then every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := r.Publish(ctx); err != nil {
+			r.logger.WithError(err).WithField("published", n).Error("event relay failed")
+		}
+		if n, err := r.Forward(ctx); err != nil {
+			r.logger.WithError(err).WithField("forwarded", n).Error("event forwarding failed")
+		}
+		if r.keepPublished > 0 {
+			if _, err := r.store.DeletePublished(ctx, time.Now().Add(-r.keepPublished)); err != nil {
+				r.logger.WithError(err).Error("could not delete published events")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish publishes the pending events on the internal cursor and returns how many were
+// published. The internal publisher records what it is given in the database, so it runs in the
+// transaction that marks the events published. Publish stops at the first event that cannot be
+// published, so that this relay does not pass it by; that event is retried first on the next
+// call. Without an external publisher the events are marked forwarded at the same time.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	cursors := []Cursor{Internal}
+	if r.external == nil {
+		cursors = append(cursors, External)
+	}
+	total := 0
+	for {
+		n := 0
+		var failure error
+		err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			events, err := r.store.Pending(ctx, Internal, time.Now(), r.batch)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				if failure = r.publisher.Publish(ctx, event); failure != nil {
+					return r.store.MarkFailed(ctx, Internal, event.ID, failure.Error())
+				}
+				if err = r.store.MarkPublished(ctx, event.ID, time.Now(), cursors...); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if failure != nil {
+			return total, failure
+		}
+		if n < r.batch {
+			return total, nil
+		}
+	}
+}
+
+// Forward publishes the pending events on the external cursor like Publish, and returns how
+// many were forwarded. The external publisher sends the events out of the process, so they are
+// leased in a short transaction and sent outside of it, and each outcome is recorded on its own.
+// When an event fails, the rest of its batch is released to be retried after it.
+func (r *Relay) Forward(ctx context.Context) (int, error) {
+	if r.external == nil {
+		return 0, nil
+	}
+	total := 0
+	for {
+		events, err := r.lease(ctx, time.Now())
+		if err != nil {
+			return total, err
+		}
+		for i, event := range events {
+			if failure := r.external.Publish(ctx, event); failure != nil {
+				if err = r.store.MarkFailed(ctx, External, event.ID, failure.Error()); err != nil {
+					return total, err
+				}
+				if err = r.store.Lease(ctx, time.Time{}, ids(events[i:])...); err != nil {
+					return total, err
+				}
+				return total, failure
+			}
+			if err = r.store.MarkPublished(ctx, event.ID, time.Now(), External); err != nil {
+				return total, err
+			}
+			total++
+		}
+		if len(events) < r.batch {
+			return total, nil
+		}
+	}
+}
+
+// lease claims a batch of the events pending on the external cursor at now.
+func (r *Relay) lease(ctx context.Context, now time.Time) ([]*models.OutboxEvent, error) {
+	var events []*models.OutboxEvent
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if events, err = r.store.Pending(ctx, External, now, r.batch); err != nil {
+			return err
+		}
+		return r.store.Lease(ctx, now.Add(forwardLease), ids(events)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func ids(events []*models.OutboxEvent) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
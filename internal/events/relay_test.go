@@ -0,0 +1,185 @@
+package events_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"payment/api/models"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"testing"
+	"time"
+)
+
+// recorder collects the aggregate IDs of the events it publishes and fails for those in fail.
+type recorder struct {
+	published []string
+	fail      map[string]bool
+}
+
+func (r *recorder) Publish(_ context.Context, event *models.OutboxEvent) error {
+	if r.fail[event.AggregateID] {
+		return fmt.Errorf("receiver unavailable")
+	}
+	r.published = append(r.published, event.AggregateID)
+	return nil
+}
+
+func newRelay(db *memory.DB, publisher events.Publisher, batch int) *events.Relay {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return events.NewRelay(logger, memory.NewOutbox(db), db, publisher, nil, batch, time.Hour)
+}
+
+func add(t *testing.T, db *memory.DB, ids ...string) {
+	t.Helper()
+	outbox := events.NewOutbox(memory.NewOutbox(db))
+	for _, id := range ids {
+		if err := outbox.Add(context.Background(), events.Event{
+			Type:          events.WalletCreated,
+			AggregateType: events.AggregateWallet,
+			AggregateID:   id,
+			Payload:       map[string]string{"wallet_id": id},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRelayPublishesInOrder(t *testing.T) {
+	db := memory.NewDB()
+	add(t, db, "w1", "w2", "w3", "w4", "w5")
+	publisher := &recorder{}
+
+	n, err := newRelay(db, publisher, 2).Publish(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("got %d published (%v), want 5", n, err)
+	}
+	if fmt.Sprint(publisher.published) != "[w1 w2 w3 w4 w5]" {
+		t.Fatalf("got %v, want the events in the order they were added", publisher.published)
+	}
+
+	if n, err = newRelay(db, publisher, 2).Publish(context.Background()); err != nil || n != 0 {
+		t.Fatalf("got %d published (%v) on the second run, want 0", n, err)
+	}
+}
+
+func TestRelayStopsAtFailureAndRetries(t *testing.T) {
+	db := memory.NewDB()
+	add(t, db, "w1", "w2", "w3")
+	publisher := &recorder{fail: map[string]bool{"w2": true}}
+	relay := newRelay(db, publisher, 10)
+
+	n, err := relay.Publish(context.Background())
+	if err == nil || n != 1 {
+		t.Fatalf("got %d published (%v), want 1 and an error", n, err)
+	}
+	pending := memory.NewOutbox(db).All(context.Background())
+	if pending[1].Attempts != 1 || pending[1].LastError != "receiver unavailable" || pending[1].PublishedAt != nil {
+		t.Fatalf("got %+v, want the failure recorded on the event", pending[1])
+	}
+
+	publisher.fail = nil
+	if n, err = relay.Publish(context.Background()); err != nil || n != 2 {
+		t.Fatalf("got %d published (%v) after recovery, want 2", n, err)
+	}
+	if fmt.Sprint(publisher.published) != "[w1 w2 w3]" {
+		t.Fatalf("got %v, want every event once and in order", publisher.published)
+	}
+}
+
+func TestDeletePublished(t *testing.T) {
+	db := memory.NewDB()
+	add(t, db, "w1", "w2")
+	store := memory.NewOutbox(db)
+	if _, err := newRelay(db, &recorder{fail: map[string]bool{"w2": true}}, 10).Publish(context.Background()); err == nil {
+		t.Fatal("expected the second event to fail")
+	}
+
+	deleted, err := store.DeletePublished(context.Background(), time.Now().Add(time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("got %d deleted (%v), want 1", deleted, err)
+	}
+	if left := store.All(context.Background()); len(left) != 1 || left[0].AggregateID != "w2" {
+		t.Fatalf("got %+v, want only the unpublished event", left)
+	}
+}
+
+func TestExternalPublisherHasItsOwnCursor(t *testing.T) {
+	db := memory.NewDB()
+	add(t, db, "w1", "w2", "w3")
+	store := memory.NewOutbox(db)
+	internal := &recorder{}
+	external := &recorder{fail: map[string]bool{"w1": true}}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	relay := events.NewRelay(logger, store, db, internal, external, 10, time.Hour)
+
+	if n, err := relay.Forward(context.Background()); err == nil || n != 0 {
+		t.Fatalf("got %d forwarded (%v), want 0 and an error", n, err)
+	}
+	if n, err := relay.Publish(context.Background()); err != nil || n != 3 {
+		t.Fatalf("got %d published (%v), want every event despite the external failure", n, err)
+	}
+	if all := store.All(context.Background()); all[0].ForwardAttempts != 1 || all[0].ForwardError != "receiver unavailable" ||
+		all[0].ForwardedAt != nil || all[0].PublishedAt == nil {
+		t.Fatalf("got %+v, want the failure recorded on the external cursor only", all[0])
+	}
+	if deleted, err := store.DeletePublished(context.Background(), time.Now().Add(time.Minute)); err != nil || deleted != 0 {
+		t.Fatalf("got %d deleted (%v), want unforwarded events kept", deleted, err)
+	}
+
+	external.fail = nil
+	if n, err := relay.Forward(context.Background()); err != nil || n != 3 {
+		t.Fatalf("got %d forwarded (%v) after recovery, want 3", n, err)
+	}
+	if fmt.Sprint(external.published) != "[w1 w2 w3]" || fmt.Sprint(internal.published) != "[w1 w2 w3]" {
+		t.Fatalf("got %v and %v, want every event once and in order on both cursors", internal.published, external.published)
+	}
+	if deleted, err := store.DeletePublished(context.Background(), time.Now().Add(time.Minute)); err != nil || deleted != 3 {
+		t.Fatalf("got %d deleted (%v), want 3", deleted, err)
+	}
+}
+
+// during is a publisher that calls fn for every event before it succeeds.
+type during func(event *models.OutboxEvent)
+
+func (fn during) Publish(_ context.Context, event *models.OutboxEvent) error {
+	fn(event)
+	return nil
+}
+
+func TestForwardingIsLeasedAndSentOutsideTheTransaction(t *testing.T) {
+	db := memory.NewDB()
+	add(t, db, "w1", "w2")
+	store := memory.NewOutbox(db)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	other := events.NewRelay(logger, store, db, &recorder{}, &recorder{}, 10, time.Hour)
+
+	var forwarded []string
+	var again int
+	var err error
+	external := during(func(event *models.OutboxEvent) {
+		// No transaction is open, so the outbox can be read meanwhile, and the leased events
+		// are not pending for another relay.
+		if again, err = other.Forward(context.Background()); again != 0 || err != nil {
+			t.Errorf("got %d forwarded by another relay (%v) while sending %s, want 0", again, err, event.AggregateID)
+		}
+		forwarded = append(forwarded, event.AggregateID)
+	})
+	relay := events.NewRelay(logger, store, db, &recorder{}, external, 10, time.Hour)
+
+	if n, err := relay.Forward(context.Background()); err != nil || n != 2 {
+		t.Fatalf("got %d forwarded (%v), want 2", n, err)
+	}
+	if fmt.Sprint(forwarded) != "[w1 w2]" {
+		t.Fatalf("got %v, want both events in order", forwarded)
+	}
+	for _, event := range store.All(context.Background()) {
+		if event.ForwardedAt == nil || event.ForwardAttempts != 1 {
+			t.Fatalf("got %+v, want the event forwarded once", event)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+// Cursor is a position in the outbox. A relay publishes the events of each cursor in the order
+// they were added, and a cursor only waits for its own failed events.
+type Cursor int
+
+const (
+	// Internal is the cursor of the webhook deliveries and notifications, which are recorded
+	// in the database and do not depend on any receiver being reachable.
+	Internal Cursor = iota
+	// External is the cursor of the configured publisher.
+	External
+)
+
+// Store persists outbox events.
+type Store interface {
+	Add(ctx context.Context, event *models.OutboxEvent) error
+	// Pending returns up to limit events not yet published on cursor, oldest first, leaving out
+	// the events leased past now on the external cursor. They stay locked until the surrounding
+	// transaction ends, so that concurrent relays skip them.
+	Pending(ctx context.Context, cursor Cursor, now time.Time, limit int) ([]*models.OutboxEvent, error)
+	// Lease holds the events back from Pending on the external cursor until the given time. The
+	// zero time releases them.
+	Lease(ctx context.Context, until time.Time, ids ...uuid.UUID) error
+	// MarkPublished marks the event published on each of cursors and counts the attempt.
+	MarkPublished(ctx context.Context, id uuid.UUID, at time.Time, cursors ...Cursor) error
+	// MarkFailed counts a failed attempt to publish the event on cursor and keeps the reason.
+	MarkFailed(ctx context.Context, cursor Cursor, id uuid.UUID, reason string) error
+	// DeletePublished removes the events published on every cursor before the given time and
+	// returns how many.
+	DeletePublished(ctx context.Context, before time.Time) (int64, error)
+}
+
+// columns returns the columns holding the publication time, attempts and last error of cursor.
+func columns(cursor Cursor) (publishedAt, attempts, lastError string) {
+	if cursor == External {
+		return "forwarded_at", "forward_attempts", "forward_error"
+	}
+	return "published_at", "attempts", "last_error"
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) Add(ctx context.Context, event *models.OutboxEvent) error {
+	if err := s.db.Conn(ctx).Create(event).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not add %s event to the outbox", event.Type).Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Pending(ctx context.Context, cursor Cursor, now time.Time, limit int) ([]*models.OutboxEvent, error) {
+	publishedAt, _, _ := columns(cursor)
+	query := s.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where(publishedAt + " IS NULL")
+	if cursor == External {
+		query = query.Where("forward_leased_until IS NULL OR forward_leased_until <= ?", now)
+	}
+	events := make([]*models.OutboxEvent, 0)
+	err := query.
+		Order("created_at, id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return events, nil
+}
+
+func (s *store) Lease(ctx context.Context, until time.Time, ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var leasedUntil *time.Time
+	if !until.IsZero() {
+		leasedUntil = &until
+	}
+	err := s.db.Conn(ctx).Model(&models.OutboxEvent{}).Where("id IN ?", ids).
+		Update("forward_leased_until", leasedUntil).Error
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) MarkPublished(ctx context.Context, id uuid.UUID, at time.Time, cursors ...Cursor) error {
+	updates := make(map[string]interface{})
+	for _, cursor := range cursors {
+		publishedAt, attempts, _ := columns(cursor)
+		updates[publishedAt] = at
+		updates[attempts] = gorm.Expr(attempts + " + 1")
+	}
+	err := s.db.Conn(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).Updates(updates).Error
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) MarkFailed(ctx context.Context, cursor Cursor, id uuid.UUID, reason string) error {
+	_, attempts, lastError := columns(cursor)
+	err := s.db.Conn(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).
+		Updates(map[string]interface{}{lastError: reason, attempts: gorm.Expr(attempts + " + 1")}).Error
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	result := s.db.Conn(ctx).Where("published_at < ? AND forwarded_at < ?", before, before).Delete(&models.OutboxEvent{})
+	if result.Error != nil {
+		return 0, errors.ErrInternal.Wrap(result.Error)
+	}
+	return result.RowsAffected, nil
+}
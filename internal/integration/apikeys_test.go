@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"payment/api/models"
+	"testing"
+)
+
+func TestAPIKeysAuthenticateUntilRevoked(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		ctx := context.Background()
+		key, secret, err := a.apiKeys.Create(ctx, "partner", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.doAs(t, secret, http.MethodPost, "/wallet/register", `{"phone": "989121212121"}`).expect(t, http.StatusCreated)
+		var records []models.AuditRecord
+		a.admin(t, http.MethodGet, "/admin/audit?action=wallet.create", "").expect(t, http.StatusOK).decode(t, &records)
+		if len(records) != 1 || records[0].Actor != "key:"+key.KeyID {
+			t.Fatalf("got records %+v, want the wallet created by key %s", records, key.KeyID)
+		}
+
+		if _, err = a.apiKeys.Revoke(ctx, key.ID); err != nil {
+			t.Fatal(err)
+		}
+		a.doAs(t, secret, http.MethodGet, "/wallet/989121212121", "").expect(t, http.StatusUnauthorized)
+		// The configured token is not affected.
+		a.do(t, http.MethodGet, "/wallet/989121212121", "").expect(t, http.StatusOK)
+
+		if _, err = a.apiKeys.Revoke(ctx, key.ID); err == nil {
+			t.Error("revoking a revoked key succeeded")
+		}
+	})
+}
+
+func TestAdminRoutesNeedTheAdminCredential(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		ctx := context.Background()
+		_, client, err := a.apiKeys.Create(ctx, "partner", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, operator, err := a.apiKeys.Create(ctx, "back office", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989121313131"}`).expect(t, http.StatusCreated)
+
+		routes := []struct{ method, path, body string }{
+			{http.MethodPut, "/wallet/989121313131/status", `{"status": "frozen", "reason": "review"}`},
+			{http.MethodPut, "/wallet/989121313131/limits", `{"max_balance": 1}`},
+			{http.MethodPut, "/admin/wallets/989121313131/tier", `{"tier": "full", "reason": "verified"}`},
+			{http.MethodGet, "/admin/wallets/989121313131/tier/changes", ""},
+			{http.MethodGet, "/admin/audit", ""},
+			{http.MethodGet, "/admin/webhooks", ""},
+			{http.MethodPost, "/admin/webhooks", `{"url": "https://partner.example.com/hook", "event_types": ["wallet.created"]}`},
+		}
+		for _, route := range routes {
+			for _, key := range []string{token, client} {
+				if got := a.doAs(t, key, route.method, route.path, route.body).status; got != http.StatusUnauthorized {
+					t.Errorf("%s %s with a client credential: got %d, want %d", route.method, route.path, got, http.StatusUnauthorized)
+				}
+			}
+		}
+		// Admin keys are accepted next to the admin token, on both kinds of routes.
+		a.doAs(t, operator, http.MethodGet, "/admin/audit", "").expect(t, http.StatusOK)
+		a.doAs(t, operator, http.MethodGet, "/wallet/989121313131", "").expect(t, http.StatusOK)
+		a.admin(t, http.MethodPut, "/wallet/989121313131/status", `{"status": "frozen", "reason": "review"}`).expect(t, http.StatusOK)
+	})
+}
@@ -0,0 +1,309 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"payment/api/models"
+	"payment/internal/apikeys"
+	"payment/internal/audit"
+	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/notifications"
+	"payment/internal/otp"
+	"payment/internal/transactions"
+	"payment/internal/wallets"
+	"payment/internal/webhooks"
+	"payment/pkg/config"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/health"
+	"payment/pkg/metrics"
+	"payment/pkg/middleware"
+	"payment/pkg/migrations"
+	"payment/pkg/openapi"
+	"payment/pkg/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tokens the service is configured with: token for the wallet and discount routes, and
+// adminToken for the routes that manage the service.
+const (
+	token      = "integration-token"
+	adminToken = "integration-admin-token"
+)
+
+// dsnEnv names the environment variable holding the DSN of the Postgres test database.
+const dsnEnv = "PAYMENT_TEST_POSTGRES_DSN"
+
+// backend wires the repositories of one storage implementation.
+type backend struct {
+	transactor    db.Transactor
+	wallets       wallets.Store
+	transactions  transactions.ITransaction
+	discounts     discounts.IDiscount
+	usages        discounts.IDiscountTransaction
+	audit         audit.Store
+	outbox        events.Store
+	webhooks      webhooks.Store
+	apiKeys       apikeys.Store
+	otp           otp.Store
+	notifications notifications.Store
+}
+
+// app is a running instance of the HTTP API on top of a backend.
+type app struct {
+	server        *httptest.Server
+	router        *mux.Router
+	wallets       wallets.IWallet
+	walletConfig  *config.Value[wallets.Config]
+	discounts     *discounts.Service
+	audit         *audit.Service
+	webhooks      *webhooks.Service
+	apiKeys       *apikeys.Service
+	ownership     *otp.Service
+	otpConfig     *config.Value[otp.Config]
+	sms           *inbox
+	notifications *notifications.Service
+	backend       backend
+}
+
+// forEachBackend runs test once per available storage backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, a *app)) {
+	t.Run("memory", func(t *testing.T) {
+		database := memory.NewDB()
+		discountRepository := memory.NewDiscounts(database)
+		test(t, newApp(t, backend{
+			transactor:    database,
+			wallets:       memory.NewWallets(database),
+			transactions:  memory.NewTransactions(database),
+			discounts:     discountRepository,
+			usages:        discountRepository,
+			audit:         memory.NewAudit(database),
+			outbox:        memory.NewOutbox(database),
+			webhooks:      memory.NewWebhooks(database),
+			apiKeys:       memory.NewAPIKeys(database),
+			otp:           memory.NewOTP(database),
+			notifications: memory.NewNotifications(database),
+		}))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		database := postgres(t)
+		logger := discardLogger()
+		config := &discounts.Config{}
+		test(t, newApp(t, backend{
+			transactor:    database,
+			wallets:       wallets.NewStore(database),
+			transactions:  transactions.NewTransactionsService(logger, database),
+			discounts:     discounts.NewDiscountService(config, logger, database),
+			usages:        discounts.NewDiscountTransactionService(config, logger, database),
+			audit:         audit.NewStore(database),
+			outbox:        events.NewStore(database),
+			webhooks:      webhooks.NewStore(database),
+			apiKeys:       apikeys.NewStore(database),
+			otp:           otp.NewStore(database),
+			notifications: notifications.NewStore(database),
+		}))
+	})
+}
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+)
+
+// postgres connects to the test database, applies the migrations once per run and
+// empties every table. The test is skipped when no database is configured.
+func postgres(t testing.TB) *db.DB {
+	t.Helper()
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", dsnEnv)
+	}
+
+	database, err := db.New(dsn)
+	if err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+
+	migrateOnce.Do(func() {
+		var migrator *migrations.Migrator
+		if migrator, migrateErr = migrations.New(database, discardLogger()); migrateErr == nil {
+			migrateErr = migrator.Up(context.Background())
+		}
+	})
+	if migrateErr != nil {
+		t.Fatalf("migrations failed: %v", migrateErr)
+	}
+
+	var tables []string
+	if err = database.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) > 0 {
+		// The audit log refuses to be truncated; its triggers are lifted for the cleanup only.
+		err = database.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE audit_log DISABLE TRIGGER USER").Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+				return err
+			}
+			return tx.Exec("ALTER TABLE audit_log ENABLE TRIGGER USER").Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return database
+}
+
+func newApp(t *testing.T, b backend) *app {
+	t.Helper()
+	logger := discardLogger()
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+
+	auditService := audit.NewService(logger, b.audit, b.transactor)
+	outbox := events.NewOutbox(b.outbox)
+	walletConfig := config.NewValue(&wallets.Config{AuthToken: token, AdminToken: adminToken})
+	walletService := wallets.NewWallet(logger, b.wallets, b.transactions, b.transactor, auditService, outbox, walletConfig)
+	discountConfig := config.NewValue(&discounts.Config{CreditExpiration: time.Minute, CodeLength: 8, AuthToken: token})
+	discountService := discounts.NewService(discountConfig, logger, b.transactor, b.discounts, b.usages, walletService, auditService, outbox)
+	webhookService := webhooks.NewService(logger, b.webhooks, b.transactor, auditService, webhooks.Targets{Private: true})
+	apiKeyService := apikeys.NewService(logger, b.apiKeys, b.transactor, auditService)
+	otpConfig := config.NewValue(&otp.Config{CodeLength: 6, CodeTTL: time.Minute, MaxAttempts: 3,
+		ResendCooldown: time.Minute, TokenTTL: time.Minute, ClientLimit: 20, GlobalLimit: 100, LimitWindow: time.Hour,
+		Require: map[string]bool{}})
+	sms := &inbox{}
+	ownership := otp.NewService(logger, b.otp, b.transactor, sms, otpConfig)
+	notificationService := notifications.NewService(logger, b.notifications, b.wallets, b.transactor, auditService,
+		notifications.Settings{Channels: []models.NotificationChannel{models.NotificationSMS, models.NotificationEmail, models.NotificationWebhook}})
+
+	router := mux.NewRouter()
+	metrics.RegisterRoutes(router)
+	health.New(time.Second).RegisterRoutes(router, health.NewBuildInfo("payment", "test", ""))
+	openapi.Mount(router,
+		wallets.NewHandler(walletService, b.transactions, ownership, logger, validate, walletConfig, apiKeyService),
+		discounts.NewHandler(discountConfig, logger, discountService, b.discounts, ownership, validate, apiKeyService),
+		audit.NewHandler(auditService, logger, config.NewValue(&audit.Config{AdminToken: adminToken}), apiKeyService),
+		webhooks.NewHandler(webhookService, logger, validate, config.NewValue(&webhooks.Config{AdminToken: adminToken}), apiKeyService),
+		otp.NewHandler(ownership, logger, validate),
+		notifications.NewHandler(notificationService, logger, validate, config.NewValue(&notifications.Config{AuthToken: token}), apiKeyService))
+	openapi.RegisterRoutes(router, openapi.Info{Title: "payment", Version: "test"})
+
+	server := httptest.NewServer(middleware.RequestID(router))
+	t.Cleanup(server.Close)
+	return &app{server: server, router: router, wallets: walletService, walletConfig: walletConfig, discounts: discountService,
+		audit: auditService, webhooks: webhookService, apiKeys: apiKeyService, ownership: ownership, otpConfig: otpConfig,
+		sms: sms, notifications: notificationService, backend: b}
+}
+
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// response is a decoded HTTP response.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (r response) code(t *testing.T) errors.Code {
+	t.Helper()
+	var message errors.ErrorMessage
+	if err := json.Unmarshal(r.body, &message); err != nil {
+		t.Fatalf("invalid error body %q: %v", r.body, err)
+	}
+	return message.Code
+}
+
+func (r response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("invalid body %q: %v", r.body, err)
+	}
+}
+
+// do sends an authorized request. It is safe to call from multiple goroutines.
+func (a *app) do(t *testing.T, method, path, body string) response {
+	t.Helper()
+	return a.doAs(t, token, method, path, body)
+}
+
+// admin sends a request authorized by the admin token.
+func (a *app) admin(t *testing.T, method, path, body string) response {
+	t.Helper()
+	return a.doAs(t, adminToken, method, path, body)
+}
+
+// doAs sends a request authorized by key.
+func (a *app) doAs(t *testing.T, key, method, path, body string) response {
+	t.Helper()
+	return a.doWith(t, http.Header{"Authorization": {key}}, method, path, body)
+}
+
+// doWith sends a request with header, which must carry the authorization the route needs.
+func (a *app) doWith(t *testing.T, header http.Header, method, path, body string) response {
+	t.Helper()
+	req, err := http.NewRequest(method, a.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return response{}
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.server.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return response{}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return response{status: resp.StatusCode, header: resp.Header, body: data}
+}
+
+// verify raises the wallet of phone to the basic tier, which allows withdrawals.
+func (a *app) verify(t *testing.T, phone string) {
+	t.Helper()
+	a.admin(t, http.MethodPut, "/admin/wallets/"+phone+"/tier",
+		`{"tier": "basic", "reason": "identity verified"}`).expect(t, http.StatusOK)
+}
+
+// expect fails the test unless the response has the wanted status.
+func (r response) expect(t *testing.T, status int) response {
+	t.Helper()
+	if r.status != status {
+		t.Fatalf("got status %d, want %d: %s", r.status, status, r.body)
+	}
+	return r
+}
@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/auth"
+	"payment/pkg/db"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 500, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989128888888", "").expect(t, http.StatusOK)
+		a.verify(t, "989128888888")
+		a.do(t, http.MethodPut, "/wallet/989128888888",
+			`{"amount": 200, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+		// A rejected transaction changes nothing and leaves no record.
+		a.do(t, http.MethodPut, "/wallet/989128888888",
+			`{"amount": 1000, "description": "rent", "type": "withdrawal"}`).expect(t, http.StatusUnprocessableEntity)
+		a.do(t, http.MethodDelete, "/wallet/989128888888?settle=true", "").expect(t, http.StatusAccepted)
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989128888888")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []models.AuditRecord
+		a.admin(t, http.MethodGet, "/admin/audit?entity_type=wallet&entity_id="+wallet.ID.String(), "").
+			expect(t, http.StatusOK).decode(t, &records)
+
+		var actions []string
+		for _, record := range records {
+			actions = append(actions, record.Action)
+		}
+		want := []string{"wallet.create", "wallet.transaction", "wallet.tier", "wallet.transaction",
+			"wallet.transaction", "wallet.close"}
+		if len(actions) != len(want) {
+			t.Fatalf("got actions %v, want %v", actions, want)
+		}
+		for i := range want {
+			if actions[i] != want[i] {
+				t.Fatalf("got actions %v, want %v", actions, want)
+			}
+		}
+
+		last := records[len(records)-1]
+		if last.Actor != "key:"+auth.KeyID(token) || last.RequestID == "" || last.IP != "127.0.0.1" {
+			t.Errorf("got actor %q, request %q and IP %q", last.Actor, last.RequestID, last.IP)
+		}
+		var closed map[string]interface{}
+		if err = json.Unmarshal(last.After, &closed); err != nil || closed["status"] != "closed" {
+			t.Errorf("got after %s, want the closed wallet", last.After)
+		}
+
+		var byRequest []models.AuditRecord
+		a.admin(t, http.MethodGet, "/admin/audit?request_id="+last.RequestID, "").
+			expect(t, http.StatusOK).decode(t, &byRequest)
+		if len(byRequest) != 2 || byRequest[0].Action != "wallet.transaction" || byRequest[1].Action != "wallet.close" {
+			t.Errorf("got %+v for the request that closed the wallet, want the settlement and the closure", byRequest)
+		}
+
+		var discounts []models.AuditRecord
+		a.admin(t, http.MethodGet, "/admin/audit?entity_type=discount", "").
+			expect(t, http.StatusOK).decode(t, &discounts)
+		if len(discounts) != 2 || discounts[0].Action != "discount.create" || discounts[1].Action != "discount.apply" {
+			t.Errorf("got %+v, want the discount created and applied", discounts)
+		}
+
+		a.admin(t, http.MethodGet, "/admin/audit?limit=0", "").expect(t, http.StatusBadRequest)
+		a.admin(t, http.MethodGet, "/admin/audit?from=yesterday", "").expect(t, http.StatusBadRequest)
+
+		var verification models.AuditVerification
+		a.admin(t, http.MethodGet, "/admin/audit/verify", "").expect(t, http.StatusOK).decode(t, &verification)
+		if !verification.Valid || verification.Checked != 8 {
+			t.Errorf("got %+v, want a valid chain of 8 records", verification)
+		}
+
+		if database, ok := a.backend.transactor.(*db.DB); ok {
+			if err = database.Exec("UPDATE audit_log SET actor = 'someone else'").Error; err == nil {
+				t.Error("the audit log accepted an update")
+			}
+			if err = database.Exec("DELETE FROM audit_log").Error; err == nil {
+				t.Error("the audit log accepted a delete")
+			}
+		}
+	})
+}
+
+// BenchmarkAuditRecord records changes to distinct wallets in parallel on Postgres, then seals
+// them. Recording takes no lock, so records/s grows with -cpu; sealed/s is the rate at which the
+// single sealer chains them into the log.
+//
+//	PAYMENT_TEST_POSTGRES_DSN=... go test ./internal/integration/ -run '^$' -bench AuditRecord -cpu 1,8,32
+func BenchmarkAuditRecord(b *testing.B) {
+	database := postgres(b)
+	service := audit.NewService(discardLogger(), audit.NewStore(database), database)
+	var wallets atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		wallet := strconv.FormatInt(wallets.Add(1), 10)
+		for pb.Next() {
+			err := service.Record(context.Background(), audit.Entry{
+				Action:     audit.ActionWalletTransaction,
+				EntityType: audit.EntityWallet,
+				EntityID:   wallet,
+				After:      map[string]int64{"amount": 100},
+			})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "records/s")
+
+	b.StopTimer()
+	started := time.Now()
+	sealed, err := service.Seal(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(sealed)/time.Since(started).Seconds(), "sealed/s")
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/wallets"
+	"sync"
+	"testing"
+)
+
+// parallel sends n requests at the same time and returns their status codes.
+func parallel(t *testing.T, n int, request func(i int) response) []int {
+	t.Helper()
+	statuses := make([]int, n)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses[i] = request(i).status
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return statuses
+}
+
+func count(statuses []int, status int) int {
+	n := 0
+	for _, s := range statuses {
+		if s == status {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConcurrentWithdrawalsCannotDoubleSpend(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989128888888"}`).expect(t, http.StatusCreated)
+		a.verify(t, "989128888888")
+		a.do(t, http.MethodPut, "/wallet/989128888888",
+			`{"amount": 1000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+
+		statuses := parallel(t, 10, func(int) response {
+			return a.do(t, http.MethodPut, "/wallet/989128888888",
+				`{"amount": 300, "description": "withdrawal", "type": "withdrawal"}`)
+		})
+
+		if ok := count(statuses, http.StatusOK); ok != 3 {
+			t.Fatalf("got %d successful withdrawals, want 3: %v", ok, statuses)
+		}
+		if rejected := count(statuses, http.StatusUnprocessableEntity); rejected != 7 {
+			t.Fatalf("got %d rejected withdrawals, want 7: %v", rejected, statuses)
+		}
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989128888888")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Amount != 100 {
+			t.Fatalf("got balance %d, want 100", wallet.Amount)
+		}
+	})
+}
+
+func TestConcurrentDepositsAreNotLost(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989129999999"}`).expect(t, http.StatusCreated)
+
+		statuses := parallel(t, 20, func(int) response {
+			return a.do(t, http.MethodPut, "/wallet/989129999999",
+				`{"amount": 50, "description": "deposit", "type": "deposit"}`)
+		})
+		if ok := count(statuses, http.StatusOK); ok != 20 {
+			t.Fatalf("got %d successful deposits, want 20: %v", ok, statuses)
+		}
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989129999999")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Amount != 1000 || len(wallet.Transactions) != 20 {
+			t.Fatalf("got balance %d with %d transactions, want 1000 with 20", wallet.Amount, len(wallet.Transactions))
+		}
+	})
+}
+
+func TestConcurrentRedemptionsRespectUsageLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 100, 5)
+
+		statuses := parallel(t, 20, func(i int) response {
+			return a.do(t, http.MethodGet, fmt.Sprintf("/discount/apply?code=%s&phone=9891230000%02d", code, i), "")
+		})
+
+		if ok := count(statuses, http.StatusOK); ok != 5 {
+			t.Fatalf("got %d successful redemptions, want 5: %v", ok, statuses)
+		}
+		if rejected := count(statuses, http.StatusConflict); rejected != 15 {
+			t.Fatalf("got %d rejected redemptions, want 15: %v", rejected, statuses)
+		}
+
+		var discount discountBody
+		a.do(t, http.MethodGet, "/discount/usages?code="+code+"&phone=989123000000", "").
+			expect(t, http.StatusOK).decode(t, &discount)
+		if len(discount.Transactions) != 5 {
+			t.Fatalf("got %d usages, want 5", len(discount.Transactions))
+		}
+	})
+}
+
+func TestConcurrentRedemptionsBySamePhone(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 100, 50)
+
+		statuses := parallel(t, 10, func(int) response {
+			return a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989124000000", "")
+		})
+
+		if ok := count(statuses, http.StatusOK); ok != 1 {
+			t.Fatalf("got %d successful redemptions, want 1: %v", ok, statuses)
+		}
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989124000000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Amount != 100 {
+			t.Fatalf("got balance %d, want 100", wallet.Amount)
+		}
+	})
+}
+
+func TestConcurrentWithdrawalsRespectDailyLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.walletConfig.Store(&wallets.Config{AuthToken: token, AdminToken: adminToken, Limits: wallets.Limits{DailyWithdrawal: 1000}})
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989129999999"}`).expect(t, http.StatusCreated)
+		a.verify(t, "989129999999")
+		a.do(t, http.MethodPut, "/wallet/989129999999",
+			`{"amount": 10000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+
+		statuses := parallel(t, 10, func(int) response {
+			return a.do(t, http.MethodPut, "/wallet/989129999999",
+				`{"amount": 300, "description": "withdrawal", "type": "withdrawal"}`)
+		})
+		if ok := count(statuses, http.StatusOK); ok != 3 {
+			t.Fatalf("got %d successful withdrawals, want 3 within the daily limit: %v", ok, statuses)
+		}
+
+		var report models.WalletLimitsReport
+		a.do(t, http.MethodGet, "/wallet/989129999999/limits", "").expect(t, http.StatusOK).decode(t, &report)
+		for _, usage := range report.Limits {
+			if usage.Name == models.LimitDailyWithdrawal && (usage.Used != 900 || *usage.Remaining != 100) {
+				t.Fatalf("got daily withdrawal usage %+v, want 900 used and 100 remaining", usage)
+			}
+			if usage.Name == models.LimitDailyDeposit && (usage.Used != 10000 || usage.Limit != nil) {
+				t.Fatalf("got daily deposit usage %+v, want 10000 used and no limit", usage)
+			}
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"payment/pkg/errors"
+	"testing"
+)
+
+type discountBody struct {
+	Code         string `json:"code"`
+	Amount       int64  `json:"amount"`
+	UsageLimit   int64  `json:"usage_limit"`
+	Transactions []struct {
+		WalletID string `json:"wallet_id"`
+		Phone    string `json:"phone"`
+	} `json:"transactions"`
+}
+
+func (a *app) createDiscount(t *testing.T, amount, usageLimit int64) string {
+	t.Helper()
+	var discount discountBody
+	a.do(t, http.MethodPost, "/discount", fmt.Sprintf(
+		`{"usage_limit": %d, "description": "Voucher for cup league", "amount": %d, "type": "voucher"}`,
+		usageLimit, amount)).expect(t, http.StatusCreated).decode(t, &discount)
+	if len(discount.Code) != 8 {
+		t.Fatalf("got code %q, want 8 characters", discount.Code)
+	}
+	return discount.Code
+}
+
+func TestCreateDiscount(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.createDiscount(t, 1000, 10)
+
+		resp := a.do(t, http.MethodPost, "/discount",
+			`{"usage_limit": 10, "description": "Voucher", "amount": 10, "type": "coupon"}`).expect(t, http.StatusBadRequest)
+		if resp.code(t) != errors.CodeInvalidDiscountType {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeInvalidDiscountType)
+		}
+
+		resp = a.do(t, http.MethodPost, "/discount",
+			`{"usage_limit": 10, "description": "<script>", "amount": 10, "type": "voucher"}`).expect(t, http.StatusBadRequest)
+		if resp.code(t) != errors.CodeValidation {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeValidation)
+		}
+	})
+}
+
+func TestApplyDiscount(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 2500, 10)
+
+		// The first redemption creates the wallet.
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989126666666", "").expect(t, http.StatusOK)
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989126666666")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Amount != 2500 {
+			t.Fatalf("got balance %d, want 2500", wallet.Amount)
+		}
+
+		resp := a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989126666666", "").expect(t, http.StatusConflict)
+		if resp.code(t) != errors.CodeDiscountAlreadyUsed {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeDiscountAlreadyUsed)
+		}
+
+		// An existing wallet is charged instead of creating a new one.
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989127777777"}`).expect(t, http.StatusCreated)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989127777777", "").expect(t, http.StatusOK)
+		if wallet, err = a.wallets.GetByPhone(context.Background(), "+989127777777"); err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Amount != 2500 {
+			t.Fatalf("got balance %d, want 2500", wallet.Amount)
+		}
+	})
+}
+
+func TestApplyUnknownDiscount(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		resp := a.do(t, http.MethodGet, "/discount/apply?code=NOTFOUND&phone=989126666666", "").expect(t, http.StatusNotFound)
+		if resp.code(t) != errors.CodeDiscountNotFound {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeDiscountNotFound)
+		}
+
+		resp = a.do(t, http.MethodGet, "/discount/apply?code=NOTFOUND", "").expect(t, http.StatusBadRequest)
+		if resp.code(t) != errors.CodeInvalidPhone {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeInvalidPhone)
+		}
+	})
+}
+
+func TestDiscountUsages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 100, 10)
+		// Each number is written in another of the accepted forms.
+		phones := []string{"989121000001", "09121000002", "00989121000003"}
+		want := []string{"+989121000001", "+989121000002", "+989121000003"}
+		for _, phone := range phones {
+			a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone="+phone, "").expect(t, http.StatusOK)
+		}
+
+		var discount discountBody
+		a.do(t, http.MethodGet, "/discount/usages?code="+code+"&phone="+phones[0], "").
+			expect(t, http.StatusOK).decode(t, &discount)
+		if len(discount.Transactions) != len(phones) {
+			t.Fatalf("got %d usages, want %d", len(discount.Transactions), len(phones))
+		}
+		for i, usage := range discount.Transactions {
+			if usage.Phone != want[i] || usage.WalletID == "" {
+				t.Errorf("unexpected usage %d: %+v", i, usage)
+			}
+		}
+	})
+}
@@ -0,0 +1,8 @@
+// Package integration holds the end-to-end test suite for the wallet and discount flows.
+//
+// Every test runs against the in-memory backend. When PAYMENT_TEST_POSTGRES_DSN points to a
+// Postgres database (for example one started with docker-compose), the same tests also run
+// against it after the migrations have been applied; otherwise the Postgres variants are skipped.
+//
+//	PAYMENT_TEST_POSTGRES_DSN="host=localhost user=postgres password=password dbname=payment_test port=5432 sslmode=disable" go test ./internal/integration/
+package integration
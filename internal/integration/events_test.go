@@ -0,0 +1,74 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/events"
+	"testing"
+	"time"
+)
+
+// collector keeps the events published by the relay.
+type collector struct {
+	events []*models.OutboxEvent
+}
+
+func (c *collector) Publish(_ context.Context, event *models.OutboxEvent) error {
+	c.events = append(c.events, event)
+	return nil
+}
+
+func TestEventsAreWrittenWithChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 300, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989129999999", "").expect(t, http.StatusOK)
+		a.verify(t, "989129999999")
+		a.do(t, http.MethodPut, "/wallet/989129999999",
+			`{"amount": 100, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+		// A rejected transaction is rolled back with its event.
+		a.do(t, http.MethodPut, "/wallet/989129999999",
+			`{"amount": 1000, "description": "rent", "type": "withdrawal"}`).expect(t, http.StatusUnprocessableEntity)
+
+		publisher := &collector{}
+		relay := events.NewRelay(discardLogger(), a.backend.outbox, a.backend.transactor, publisher, nil, 2, time.Hour)
+		if n, err := relay.Publish(context.Background()); err != nil || n != 4 {
+			t.Fatalf("got %d published (%v), want 4", n, err)
+		}
+
+		var types []string
+		for _, event := range publisher.events {
+			types = append(types, event.Type)
+		}
+		want := []string{events.WalletCreated, events.TransactionCompleted, events.DiscountRedeemed, events.TransactionCompleted}
+		if len(types) != len(want) {
+			t.Fatalf("got events %v, want %v", types, want)
+		}
+		for i := range want {
+			if types[i] != want[i] {
+				t.Fatalf("got events %v, want %v", types, want)
+			}
+		}
+
+		var withdrawal events.Transaction
+		if err := json.Unmarshal(publisher.events[3].Payload, &withdrawal); err != nil {
+			t.Fatal(err)
+		}
+		if withdrawal.Type != models.Withdrawal || withdrawal.Amount != 100 || withdrawal.Balance != 200 ||
+			withdrawal.Phone != "+989129999999" {
+			t.Errorf("got payload %+v, want the withdrawal and the balance it left", withdrawal)
+		}
+		var redemption events.Redemption
+		if err := json.Unmarshal(publisher.events[2].Payload, &redemption); err != nil {
+			t.Fatal(err)
+		}
+		if redemption.Code != code || redemption.Amount != 300 || redemption.WalletID.String() != withdrawal.WalletID.String() {
+			t.Errorf("got payload %+v, want the redemption of %s", redemption, code)
+		}
+
+		if n, err := relay.Publish(context.Background()); err != nil || n != 0 {
+			t.Fatalf("got %d published (%v) on the second run, want 0", n, err)
+		}
+	})
+}
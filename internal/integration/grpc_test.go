@@ -0,0 +1,151 @@
+package integration
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"payment/api/paymentpb"
+	"payment/internal/rpc"
+	"payment/pkg/config"
+	"payment/pkg/utils"
+	"testing"
+)
+
+// grpcClients are the clients of a gRPC server running on top of the services of an app.
+type grpcClients struct {
+	wallets      paymentpb.WalletServiceClient
+	transactions paymentpb.TransactionServiceClient
+	discounts    paymentpb.DiscountServiceClient
+}
+
+func (a *app) grpc(t *testing.T) grpcClients {
+	t.Helper()
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer(discardLogger(), validate, config.NewValue(&rpc.Config{AuthToken: token}), a.apiKeys,
+		a.wallets, a.backend.transactions, a.discounts, a.ownership)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpcClients{
+		wallets:      paymentpb.NewWalletServiceClient(conn),
+		transactions: paymentpb.NewTransactionServiceClient(conn),
+		discounts:    paymentpb.NewDiscountServiceClient(conn),
+	}
+}
+
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+}
+
+// expectStatus fails the test unless err is a gRPC status with code and the given error code as its reason.
+func expectStatus(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("got %v, want %v", err, code)
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == reason {
+			return
+		}
+	}
+	t.Fatalf("got details %v, want reason %s", st.Details(), reason)
+}
+
+func TestGRPCMatchesREST(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		clients := a.grpc(t)
+		ctx := authorized()
+
+		_, err := clients.wallets.CreateWallet(context.Background(), &paymentpb.CreateWalletRequest{Phone: "989121111111"})
+		expectStatus(t, err, codes.Unauthenticated, "UNAUTHORIZED")
+		_, err = clients.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "phone"})
+		expectStatus(t, err, codes.InvalidArgument, "INVALID_PHONE")
+
+		wallet, err := clients.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "989121111111"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Tier != "unverified" || wallet.Status != "active" || wallet.Amount != 0 {
+			t.Fatalf("got %+v, want an empty unverified wallet", wallet)
+		}
+		_, err = clients.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "989121111111"})
+		expectStatus(t, err, codes.AlreadyExists, "WALLET_ALREADY_EXISTS")
+
+		// The discount endpoints are public, as in the REST API.
+		discount, err := clients.discounts.CreateDiscount(ctx, &paymentpb.CreateDiscountRequest{
+			Description: "Voucher for cup league", Amount: 500, UsageLimit: 10, Type: "voucher",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		applied, err := clients.discounts.ApplyDiscount(context.Background(),
+			&paymentpb.ApplyDiscountRequest{Code: discount.Code, Phone: "989121111111"})
+		if err != nil || applied.Total != 500 {
+			t.Fatalf("got %+v (%v), want 500 credited", applied, err)
+		}
+		_, err = clients.discounts.ApplyDiscount(context.Background(),
+			&paymentpb.ApplyDiscountRequest{Code: discount.Code, Phone: "989121111111"})
+		expectStatus(t, err, codes.FailedPrecondition, "DISCOUNT_ALREADY_USED")
+		usages, err := clients.discounts.ListDiscountUsages(context.Background(), &paymentpb.ListDiscountUsagesRequest{Code: discount.Code})
+		if err != nil || len(usages.Usages) != 1 || usages.Usages[0].WalletId != wallet.Id {
+			t.Fatalf("got %+v (%v), want one usage by the wallet", usages, err)
+		}
+
+		// Unverified wallets cannot withdraw, whichever API is used.
+		withdrawal := &paymentpb.TransactRequest{Phone: "989121111111", Type: "withdrawal", Amount: 100, Description: "groceries"}
+		_, err = clients.wallets.Transact(ctx, withdrawal)
+		expectStatus(t, err, codes.PermissionDenied, "TIER_NOT_ALLOWED")
+		a.verify(t, "989121111111")
+		transaction, err := clients.wallets.Transact(ctx, withdrawal)
+		if err != nil || transaction.Status != "completed" {
+			t.Fatalf("got %+v (%v), want a completed withdrawal", transaction, err)
+		}
+		_, err = clients.wallets.Transact(ctx, &paymentpb.TransactRequest{Phone: "989121111111", Type: "withdrawal", Amount: 1000, Description: "rent"})
+		expectStatus(t, err, codes.FailedPrecondition, "INSUFFICIENT_FUNDS")
+		_, err = clients.wallets.Transact(ctx, &paymentpb.TransactRequest{Phone: "989121111111", Type: "deposit", Amount: 10})
+		expectStatus(t, err, codes.InvalidArgument, "VALIDATION_FAILED")
+
+		found, err := clients.transactions.GetTransaction(ctx, &paymentpb.GetTransactionRequest{Id: transaction.Id})
+		if err != nil || found.Amount != 100 || found.Type != "withdrawal" {
+			t.Fatalf("got %+v (%v), want the withdrawal", found, err)
+		}
+		listed, err := clients.transactions.ListTransactions(ctx, &paymentpb.ListTransactionsRequest{Phone: "989121111111"})
+		if err != nil || len(listed.Transactions) != 2 {
+			t.Fatalf("got %+v (%v), want the credit and the withdrawal", listed, err)
+		}
+
+		var rest walletBody
+		a.do(t, http.MethodGet, "/wallet/989121111111", "").expect(t, http.StatusOK).decode(t, &rest)
+		if rest.Amount != 400 {
+			t.Fatalf("got a REST balance of %d, want 400", rest.Amount)
+		}
+
+		_, err = clients.wallets.DeleteWallet(ctx, &paymentpb.DeleteWalletRequest{Phone: "989121111111"})
+		expectStatus(t, err, codes.FailedPrecondition, "WALLET_NOT_EMPTY")
+		closed, err := clients.wallets.DeleteWallet(ctx, &paymentpb.DeleteWalletRequest{Phone: "989121111111", Settle: true})
+		if err != nil || closed.Status != "closed" || closed.Amount != 0 {
+			t.Fatalf("got %+v (%v), want the wallet closed and settled", closed, err)
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/events"
+	"payment/internal/notifications"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postbox is a notification channel that keeps the messages it is given. While failing is
+// set, it refuses them. It calls during, if set, before taking a message.
+type postbox struct {
+	mu       sync.Mutex
+	failing  bool
+	messages []*notifications.Message
+	during   func(message *notifications.Message)
+}
+
+func (p *postbox) Send(_ context.Context, message *notifications.Message) error {
+	if p.during != nil {
+		p.during(message)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing {
+		return fmt.Errorf("gateway unavailable")
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// notifier relays the events of a to its notification service and dispatches the
+// notifications through one postbox per channel.
+type notifier struct {
+	relay      *events.Relay
+	dispatcher *notifications.Dispatcher
+	boxes      map[models.NotificationChannel]*postbox
+}
+
+func (a *app) notifier(settings notifications.Settings) *notifier {
+	n := &notifier{
+		relay: events.NewRelay(discardLogger(), a.backend.outbox, a.backend.transactor, a.notifications, nil, 10, time.Hour),
+		boxes: map[models.NotificationChannel]*postbox{},
+	}
+	channels := map[models.NotificationChannel]notifications.Channel{}
+	for _, channel := range []models.NotificationChannel{models.NotificationSMS, models.NotificationEmail, models.NotificationWebhook} {
+		n.boxes[channel] = &postbox{}
+		channels[channel] = n.boxes[channel]
+	}
+	n.dispatcher = notifications.NewDispatcher(discardLogger(), a.backend.notifications, a.backend.wallets,
+		a.backend.transactor, channels, settings)
+	return n
+}
+
+// publish records the notifications of the pending events.
+func (n *notifier) publish(t *testing.T) {
+	t.Helper()
+	if _, err := n.relay.Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// send sends the notifications due at now.
+func (n *notifier) send(t *testing.T, now time.Time) {
+	t.Helper()
+	if _, err := n.dispatcher.Dispatch(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// notificationLog returns the notifications of the wallet of phone, newest first.
+func (a *app) notificationLog(t *testing.T, phone, query string) []models.Notification {
+	t.Helper()
+	var log []models.Notification
+	a.do(t, http.MethodGet, "/wallet/"+phone+"/notifications"+query, "").expect(t, http.StatusOK).decode(t, &log)
+	return log
+}
+
+func TestWithdrawalNotifications(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		n := a.notifier(notifications.Settings{})
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).expect(t, http.StatusCreated)
+		a.verify(t, "989122222222")
+		a.do(t, http.MethodPut, "/wallet/989122222222",
+			`{"amount": 1000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+		a.do(t, http.MethodPut, "/wallet/989122222222",
+			`{"amount": 300, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+		n.publish(t)
+		n.send(t, time.Now())
+
+		sms := n.boxes[models.NotificationSMS].messages
+		if len(sms) != 1 || sms[0].To != "+989122222222" || sms[0].EventType != events.TransactionCompleted ||
+			sms[0].Body != "300 was withdrawn from your wallet (groceries). Your balance is 700." {
+			t.Fatalf("got SMS %+v, want one about the withdrawal only", sms)
+		}
+		webhook := n.boxes[models.NotificationWebhook].messages
+		if len(webhook) != 1 || webhook[0].To != "" || webhook[0].WalletID != sms[0].WalletID {
+			t.Fatalf("got webhook messages %+v, want the withdrawal without a phone number", webhook)
+		}
+		if len(n.boxes[models.NotificationEmail].messages) != 0 {
+			t.Fatal("sent an email to a wallet without an address")
+		}
+
+		log := a.notificationLog(t, "09122222222", "")
+		if len(log) != 3 {
+			t.Fatalf("got %d notifications, want one per channel", len(log))
+		}
+		for _, notification := range log {
+			want := models.NotificationSent
+			if notification.Channel == models.NotificationEmail {
+				want = models.NotificationSkipped
+			}
+			if notification.Status != want || notification.Locale != notifications.LocaleEnglish {
+				t.Fatalf("got %s notification %+v, want it %s", notification.Channel, notification, want)
+			}
+		}
+		if skipped := a.notificationLog(t, "989122222222", "?status=skipped"); len(skipped) != 1 || skipped[0].LastError == "" {
+			t.Fatalf("got %+v, want the email skipped with its reason", skipped)
+		}
+		a.do(t, http.MethodGet, "/wallet/989122222222/notifications?status=lost", "").expect(t, http.StatusBadRequest)
+		a.do(t, http.MethodGet, "/wallet/989120000000/notifications", "").expect(t, http.StatusNotFound)
+
+		// The relay may publish an event again; it is only notified once.
+		payload, err := json.Marshal(&events.Transaction{WalletID: sms[0].WalletID, Type: models.Withdrawal, Amount: 300})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.backend.transactor.WithinTransaction(context.Background(), func(ctx context.Context) error {
+			return a.notifications.Publish(ctx, &models.OutboxEvent{ID: log[0].EventID, Type: events.TransactionCompleted, Payload: payload})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.notificationLog(t, "989122222222", ""); len(got) != 3 {
+			t.Fatalf("got %d notifications after publishing the withdrawal again, want 3", len(got))
+		}
+	})
+}
+
+func TestDiscountNotificationsFollowPreferences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		n := a.notifier(notifications.Settings{})
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989123333333"}`).expect(t, http.StatusCreated)
+
+		var preferences models.NotificationPreferences
+		a.do(t, http.MethodGet, "/wallet/989123333333/notifications/preferences", "").
+			expect(t, http.StatusOK).decode(t, &preferences)
+		if preferences.Locale != "" || len(preferences.OptOut) != 0 {
+			t.Fatalf("got %+v, want the defaults", preferences)
+		}
+		a.do(t, http.MethodPut, "/wallet/989123333333/notifications/preferences",
+			`{"locale": "de"}`).expect(t, http.StatusBadRequest)
+		a.do(t, http.MethodPut, "/wallet/989123333333/notifications/preferences",
+			`{"opt_out": ["pigeon"]}`).expect(t, http.StatusBadRequest)
+		a.do(t, http.MethodPut, "/wallet/09123333333/notifications/preferences",
+			`{"locale": "fa", "email": "owner@example.com", "opt_out": ["webhook", "webhook"]}`).
+			expect(t, http.StatusOK).decode(t, &preferences)
+		if preferences.Locale != "fa" || len(preferences.OptOut) != 1 {
+			t.Fatalf("got %+v, want the new preferences", preferences)
+		}
+
+		code := a.createDiscount(t, 500, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989123333333", "").expect(t, http.StatusOK)
+		n.publish(t)
+		n.send(t, time.Now())
+
+		email := n.boxes[models.NotificationEmail].messages
+		if len(email) != 1 || email[0].To != "owner@example.com" || email[0].Locale != notifications.LocalePersian ||
+			!strings.Contains(email[0].Body, code) || !strings.Contains(email[0].Body, "500") || email[0].Subject == "" {
+			t.Fatalf("got emails %+v, want the redemption in Persian", email)
+		}
+		if sms := n.boxes[models.NotificationSMS].messages; len(sms) != 1 || sms[0].Body != email[0].Body {
+			t.Fatalf("got SMS %+v, want the redemption once, not the deposit it made", sms)
+		}
+		if len(n.boxes[models.NotificationWebhook].messages) != 0 {
+			t.Fatal("sent a webhook message the owner opted out of")
+		}
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989123333333")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []models.AuditRecord
+		a.admin(t, http.MethodGet, "/admin/audit?entity_type=wallet&entity_id="+wallet.ID.String(), "").
+			expect(t, http.StatusOK).decode(t, &records)
+		var recorded *models.AuditRecord
+		for i := range records {
+			if records[i].Action == "wallet.notifications" {
+				recorded = &records[i]
+			}
+		}
+		if recorded == nil || strings.Contains(string(recorded.After), "example.com") {
+			t.Fatalf("got audit records %+v, want the change recorded without the address", records)
+		}
+	})
+}
+
+func TestNotificationRetries(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		n := a.notifier(notifications.Settings{MaxAttempts: 2, Backoff: time.Minute})
+		n.boxes[models.NotificationSMS].failing = true
+		code := a.createDiscount(t, 200, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989124444444", "").expect(t, http.StatusOK)
+
+		n.publish(t)
+		now := time.Now()
+		n.send(t, now)
+		pending := a.notificationLog(t, "989124444444", "?status=pending")
+		if len(pending) != 1 || pending[0].Channel != models.NotificationSMS || pending[0].Attempts != 1 ||
+			pending[0].LastError != "gateway unavailable" || pending[0].NextAttemptAt.Before(now.Add(time.Minute-time.Second)) {
+			t.Fatalf("got %+v, want the SMS scheduled again after the backoff", pending)
+		}
+
+		// Not due yet.
+		n.send(t, now.Add(time.Second))
+		if got := a.notificationLog(t, "989124444444", "?status=pending"); got[0].Attempts != 1 {
+			t.Fatalf("got %d attempts before the backoff elapsed, want 1", got[0].Attempts)
+		}
+
+		n.send(t, now.Add(2*time.Minute))
+		failed := a.notificationLog(t, "989124444444", "?status=failed")
+		if len(failed) != 1 || failed[0].Attempts != 2 {
+			t.Fatalf("got %+v, want the SMS failed after two attempts", failed)
+		}
+
+		// Opting out holds back the notifications not sent yet.
+		n.boxes[models.NotificationSMS].failing = false
+		code = a.createDiscount(t, 100, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989124444444", "").expect(t, http.StatusOK)
+		n.publish(t)
+		a.do(t, http.MethodPut, "/wallet/989124444444/notifications/preferences",
+			`{"opt_out": ["sms"]}`).expect(t, http.StatusOK)
+		n.send(t, now.Add(3*time.Minute))
+		if sms := n.boxes[models.NotificationSMS].messages; len(sms) != 0 {
+			t.Fatalf("got SMS %+v after opting out, want none", sms)
+		}
+		if skipped := a.notificationLog(t, "989124444444", "?status=skipped"); len(skipped) != 3 {
+			t.Fatalf("got %d skipped notifications, want the SMS and both emails to a wallet without an address", len(skipped))
+		}
+	})
+}
+
+func TestNotificationsAreSentOutsideTheTransaction(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		n := a.notifier(notifications.Settings{})
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989125555555"}`).expect(t, http.StatusCreated)
+		a.verify(t, "989125555555")
+		a.do(t, http.MethodPut, "/wallet/989125555555",
+			`{"amount": 1000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+		a.do(t, http.MethodPut, "/wallet/989125555555",
+			`{"amount": 300, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+		n.publish(t)
+		now := time.Now()
+
+		// The channel sends while no transaction is open, so the log can be read meanwhile, and
+		// the claimed notifications are not due for another dispatcher.
+		var during []models.Notification
+		again := -1
+		n.boxes[models.NotificationSMS].during = func(*notifications.Message) {
+			during = a.notificationLog(t, "989125555555", "?status=pending")
+			again, _ = n.dispatcher.Dispatch(context.Background(), now)
+		}
+		n.send(t, now)
+		if again != 0 {
+			t.Fatalf("got %d attempted while sending, want the notifications claimed", again)
+		}
+		for _, notification := range during {
+			if !notification.NextAttemptAt.After(now) {
+				t.Fatalf("got %+v while sending, want it leased", notification)
+			}
+		}
+		if sent := a.notificationLog(t, "989125555555", "?status=sent"); len(sent) != 2 {
+			t.Fatalf("got %+v, want the SMS and the webhook message sent", sent)
+		}
+	})
+}
+
+// unreadable is a notification store that cannot read the preferences of one wallet.
+type unreadable struct {
+	notifications.Store
+	wallet uuid.UUID
+}
+
+func (u unreadable) FindPreferences(ctx context.Context, walletID uuid.UUID) (*models.NotificationPreferences, error) {
+	if walletID == u.wallet {
+		return nil, fmt.Errorf("preferences unavailable")
+	}
+	return u.Store.FindPreferences(ctx, walletID)
+}
+
+func TestUnpreparedNotificationsDoNotHoldBackTheBatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		n := a.notifier(notifications.Settings{})
+		for _, phone := range []string{"989126666666", "989127777777"} {
+			a.do(t, http.MethodPost, "/wallet/register", `{"phone": "`+phone+`"}`).expect(t, http.StatusCreated)
+			a.verify(t, phone)
+			a.do(t, http.MethodPut, "/wallet/"+phone,
+				`{"amount": 1000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+			a.do(t, http.MethodPut, "/wallet/"+phone,
+				`{"amount": 300, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+		}
+		n.publish(t)
+		blocked := a.notificationLog(t, "989126666666", "")[0].WalletID
+		channels := map[models.NotificationChannel]notifications.Channel{}
+		for channel, box := range n.boxes {
+			channels[channel] = box
+		}
+		n.dispatcher = notifications.NewDispatcher(discardLogger(), unreadable{Store: a.backend.notifications, wallet: blocked},
+			a.backend.wallets, a.backend.transactor, channels, notifications.Settings{Backoff: time.Minute})
+		now := time.Now()
+		n.send(t, now)
+
+		if sms := n.boxes[models.NotificationSMS].messages; len(sms) != 1 || sms[0].To != "+989127777777" {
+			t.Fatalf("got SMS %+v, want the one to the other wallet", sms)
+		}
+		pending := a.notificationLog(t, "989126666666", "?status=pending")
+		if len(pending) != 2 {
+			t.Fatalf("got %+v, want the SMS and the webhook message pending", pending)
+		}
+		for _, notification := range pending {
+			if notification.Attempts != 1 || notification.LastError != "preferences unavailable" ||
+				!notification.NextAttemptAt.After(now) {
+				t.Fatalf("got %+v, want the failed lookup recorded and the notification retried later", notification)
+			}
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package integration
+
+import (
+	"net/http"
+	"payment/pkg/openapi"
+	"strings"
+	"testing"
+)
+
+// document is the part of the OpenAPI document the tests look at.
+type document struct {
+	OpenAPI string `json:"openapi"`
+	Paths   map[string]map[string]struct {
+		Deprecated bool `json:"deprecated"`
+	} `json:"paths"`
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		var spec document
+		a.do(t, http.MethodGet, "/openapi.json", "").expect(t, http.StatusOK).decode(t, &spec)
+		if !strings.HasPrefix(spec.OpenAPI, "3.") {
+			t.Fatalf("got version %q, want an OpenAPI 3 document", spec.OpenAPI)
+		}
+
+		routes, err := openapi.Routes(a.router)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, route := range routes {
+			operation, ok := spec.Paths[route.Path][strings.ToLower(route.Method)]
+			if !ok {
+				t.Errorf("%s %s is served but not documented", route.Method, route.Path)
+				continue
+			}
+			versioned := strings.HasPrefix(route.Path, openapi.Version+"/")
+			if _, moved := spec.Paths[openapi.Version+route.Path]; operation.Deprecated != (!versioned && moved) {
+				t.Errorf("%s %s: got deprecated %v", route.Method, route.Path, operation.Deprecated)
+			}
+		}
+		if _, ok := spec.Paths["/v1/wallet/{phoneNumber}"]["get"]; !ok {
+			t.Error("the versioned wallet route is not documented")
+		}
+	})
+}
+
+func TestUnversionedRoutesAreDeprecated(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		created := a.do(t, http.MethodPost, "/v1/wallet/register", `{"phone": "989121313131"}`).expect(t, http.StatusCreated)
+		if created.header.Get("Deprecation") != "" {
+			t.Error("a versioned route is marked deprecated")
+		}
+
+		legacy := a.do(t, http.MethodGet, "/wallet/989121313131", "").expect(t, http.StatusOK)
+		if legacy.header.Get("Deprecation") != "true" ||
+			legacy.header.Get("Link") != `</v1/wallet/989121313131>; rel="successor-version"` {
+			t.Errorf("got headers %v, want the deprecation and the successor", legacy.header)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"payment/api/models"
+	"payment/api/paymentpb"
+	"payment/internal/otp"
+	"payment/pkg/errors"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// inbox is an otp.SMSSender that keeps the messages sent to every phone number. While failing
+// is set, it refuses them. It calls during, if set, before taking a message.
+type inbox struct {
+	mu       sync.Mutex
+	failing  bool
+	messages map[string][]string
+	during   func(phone string)
+}
+
+func (i *inbox) Send(_ context.Context, phone, message string) error {
+	if i.during != nil {
+		i.during(phone)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.failing {
+		return fmt.Errorf("gateway unavailable")
+	}
+	if i.messages == nil {
+		i.messages = map[string][]string{}
+	}
+	i.messages[phone] = append(i.messages[phone], message)
+	return nil
+}
+
+var codePattern = regexp.MustCompile(`\d{4,10}`)
+
+// code returns the code in the last message sent to phone.
+func (i *inbox) code(t *testing.T, phone string) string {
+	t.Helper()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	messages := i.messages[phone]
+	if len(messages) == 0 {
+		t.Fatalf("no message sent to %s", phone)
+	}
+	return codePattern.FindString(messages[len(messages)-1])
+}
+
+// prove verifies phone with a one-time code and returns its ownership token for purpose.
+func (a *app) prove(t *testing.T, phone, purpose string) string {
+	t.Helper()
+	var challenge models.OTPChallenge
+	a.do(t, http.MethodPost, "/otp/request", `{"phone": "`+phone+`", "purpose": "`+purpose+`"}`).
+		expect(t, http.StatusAccepted).decode(t, &challenge)
+	var proof models.OwnershipProof
+	a.do(t, http.MethodPost, "/otp/verify", `{"phone": "`+phone+`", "code": "`+a.sms.code(t, challenge.Phone)+`"}`).
+		expect(t, http.StatusOK).decode(t, &proof)
+	return proof.Token
+}
+
+func TestOTPVerification(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		var challenge models.OTPChallenge
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "09121234567", "purpose": "registration"}`).
+			expect(t, http.StatusAccepted).decode(t, &challenge)
+		if challenge.Phone != "+989121234567" || challenge.Purpose != otp.PurposeRegistration || !challenge.ResendAt.After(challenge.ExpiresAt.Add(-time.Minute)) {
+			t.Fatalf("got challenge %+v, want the normalized number and its deadlines", challenge)
+		}
+		code := a.sms.code(t, challenge.Phone)
+		if len(code) != 6 {
+			t.Fatalf("got code %q, want 6 digits", code)
+		}
+
+		stored, err := a.backend.otp.Lock(context.Background(), challenge.Phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Hash == code || strings.Contains(stored.Hash, code) || stored.Salt == "" {
+			t.Fatalf("got stored code %+v, want only a salted hash of it", stored)
+		}
+
+		if got := a.do(t, http.MethodPost, "/otp/request", `{"phone": "+98 912 123 4567", "purpose": "registration"}`).
+			expect(t, http.StatusTooManyRequests).code(t); got != errors.CodeOTPCooldown {
+			t.Fatalf("got %s asking again within the cooldown, want %s", got, errors.CodeOTPCooldown)
+		}
+
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		if got := a.do(t, http.MethodPost, "/otp/verify", `{"phone": "09121234567", "code": "`+wrong+`"}`).
+			expect(t, http.StatusUnauthorized).code(t); got != errors.CodeOTPInvalid {
+			t.Fatalf("got %s for a wrong code, want %s", got, errors.CodeOTPInvalid)
+		}
+
+		var proof models.OwnershipProof
+		a.do(t, http.MethodPost, "/otp/verify", `{"phone": "989121234567", "code": "`+code+`"}`).
+			expect(t, http.StatusOK).decode(t, &proof)
+		if proof.Phone != challenge.Phone || proof.Purpose != otp.PurposeRegistration || !strings.HasPrefix(proof.Token, "own_") ||
+			proof.ExpiresAt.IsZero() {
+			t.Fatalf("got proof %+v, want a registration token for %s", proof, challenge.Phone)
+		}
+		if err = a.ownership.Use(context.Background(), otp.PurposeRegistration, challenge.Phone, proof.Token); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := a.do(t, http.MethodPost, "/otp/verify", `{"phone": "09121234567", "code": "`+code+`"}`).
+			expect(t, http.StatusUnauthorized).code(t); got != errors.CodeOTPInvalid {
+			t.Fatalf("got %s using a code twice, want %s", got, errors.CodeOTPInvalid)
+		}
+		a.do(t, http.MethodPost, "/otp/verify", `{"phone": "09121234567", "code": "12ab"}`).expect(t, http.StatusBadRequest)
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "09121234567"}`).expect(t, http.StatusBadRequest)
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "09121234567", "purpose": "login"}`).expect(t, http.StatusBadRequest)
+	})
+}
+
+func TestOTPAttemptLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "09121234567", "purpose": "discount"}`).expect(t, http.StatusAccepted)
+		code := a.sms.code(t, "+989121234567")
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		var failure struct {
+			Code errors.Code `json:"code"`
+			Data struct {
+				AttemptsLeft int `json:"attempts_left"`
+			} `json:"data"`
+		}
+		for left := 2; left >= 0; left-- {
+			a.do(t, http.MethodPost, "/otp/verify", `{"phone": "09121234567", "code": "`+wrong+`"}`).
+				expect(t, http.StatusUnauthorized).decode(t, &failure)
+			if failure.Code != errors.CodeOTPInvalid || failure.Data.AttemptsLeft != left {
+				t.Fatalf("got %+v, want %d attempts left", failure, left)
+			}
+		}
+		if got := a.do(t, http.MethodPost, "/otp/verify", `{"phone": "09121234567", "code": "`+code+`"}`).
+			expect(t, http.StatusTooManyRequests).code(t); got != errors.CodeOTPAttempts {
+			t.Fatalf("got %s for the right code after too many wrong ones, want %s", got, errors.CodeOTPAttempts)
+		}
+	})
+}
+
+func TestOwnershipRequired(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		code := a.createDiscount(t, 100, 10)
+		// Nothing is required until the configuration says so.
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989121111111"}`).expect(t, http.StatusCreated)
+
+		settings := *a.otpConfig.Load()
+		settings.Require = map[string]bool{otp.PurposeRegistration: true, otp.PurposeDiscount: true}
+		a.otpConfig.Store(&settings)
+
+		if got := a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).
+			expect(t, http.StatusForbidden).code(t); got != errors.CodeOwnershipRequired {
+			t.Fatalf("got %s registering without a token, want %s", got, errors.CodeOwnershipRequired)
+		}
+		other := a.prove(t, "989123333333", otp.PurposeRegistration)
+		header := http.Header{"Authorization": {token}, otp.TokenHeader: {other}}
+		a.doWith(t, header, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).expect(t, http.StatusForbidden)
+		header.Set(otp.TokenHeader, "own_forged")
+		a.doWith(t, header, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).expect(t, http.StatusForbidden)
+
+		header.Set(otp.TokenHeader, a.prove(t, "09122222222", otp.PurposeRegistration))
+		a.doWith(t, header, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).expect(t, http.StatusCreated)
+
+		// The registration token is used up, and would not do for a redemption anyway.
+		apply := "/discount/apply?code=" + code + "&phone=09122222222"
+		a.do(t, http.MethodGet, apply, "").expect(t, http.StatusForbidden)
+		a.doWith(t, header, http.MethodGet, apply, "").expect(t, http.StatusForbidden)
+		header.Set(otp.TokenHeader, a.prove(t, "09122222222", otp.PurposeDiscount))
+		a.doWith(t, header, http.MethodGet, apply, "").expect(t, http.StatusOK)
+
+		clients := a.grpc(t)
+		_, err := clients.wallets.CreateWallet(authorized(), &paymentpb.CreateWalletRequest{Phone: "989123333333"})
+		expectStatus(t, err, codes.PermissionDenied, string(errors.CodeOwnershipRequired))
+		ctx := metadata.AppendToOutgoingContext(authorized(), otp.TokenMetadata, other)
+		if _, err = clients.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "989123333333"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestFailedOperationsKeepTheToken(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989121111111"}`).expect(t, http.StatusCreated)
+		code := a.createDiscount(t, 100, 1)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989121111111", "").expect(t, http.StatusOK)
+
+		settings := *a.otpConfig.Load()
+		settings.Require = map[string]bool{otp.PurposeRegistration: true, otp.PurposeDiscount: true}
+		a.otpConfig.Store(&settings)
+
+		registration := a.prove(t, "989121111111", otp.PurposeRegistration)
+		header := http.Header{"Authorization": {token}, otp.TokenHeader: {registration}}
+		a.doWith(t, header, http.MethodPost, "/wallet/register", `{"phone": "989121111111"}`).expect(t, http.StatusConflict)
+		clients := a.grpc(t)
+		ctx := metadata.AppendToOutgoingContext(authorized(), otp.TokenMetadata, registration)
+		_, err := clients.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "989121111111"})
+		expectStatus(t, err, codes.AlreadyExists, string(errors.CodeWalletExists))
+
+		redemption := a.prove(t, "989122222222", otp.PurposeDiscount)
+		header.Set(otp.TokenHeader, redemption)
+		a.doWith(t, header, http.MethodGet, "/discount/apply?code="+code+"&phone=989122222222", "").expect(t, http.StatusConflict)
+
+		for _, token := range []struct{ purpose, phone, token string }{
+			{otp.PurposeRegistration, "+989121111111", registration},
+			{otp.PurposeDiscount, "+989122222222", redemption},
+		} {
+			if err = a.ownership.Use(context.Background(), token.purpose, token.phone, token.token); err != nil {
+				t.Fatalf("got %v using the %s token after the operation failed, want it kept", err, token.purpose)
+			}
+		}
+	})
+}
+
+func TestOTPRequestLimits(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		settings := *a.otpConfig.Load()
+		settings.ClientLimit, settings.GlobalLimit = 2, 3
+		a.otpConfig.Store(&settings)
+
+		request := func(phone string) response {
+			return a.do(t, http.MethodPost, "/otp/request", `{"phone": "`+phone+`", "purpose": "registration"}`)
+		}
+		request("989121111111").expect(t, http.StatusAccepted)
+		request("989122222222").expect(t, http.StatusAccepted)
+		if got := request("989123333333").expect(t, http.StatusTooManyRequests).code(t); got != errors.CodeOTPRateLimited {
+			t.Fatalf("got %s for the third number of one client, want %s", got, errors.CodeOTPRateLimited)
+		}
+
+		// Codes asked for by other clients count towards the global limit.
+		if _, err := a.ownership.Request(context.Background(), "+989124444444", otp.PurposeRegistration); err != nil {
+			t.Fatal(err)
+		}
+		settings.ClientLimit = 10
+		a.otpConfig.Store(&settings)
+		if got := request("989123333333").expect(t, http.StatusTooManyRequests).code(t); got != errors.CodeOTPRateLimited {
+			t.Fatalf("got %s past the global limit, want %s", got, errors.CodeOTPRateLimited)
+		}
+	})
+}
+
+func TestOTPIsSentOutsideTheTransaction(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		// While the message is sent, the code of the number can be read and other numbers get theirs.
+		a.sms.during = func(phone string) {
+			if phone != "+989121111111" {
+				return
+			}
+			a.sms.during = nil
+			a.do(t, http.MethodPost, "/otp/request", `{"phone": "989122222222", "purpose": "registration"}`).
+				expect(t, http.StatusAccepted)
+			a.do(t, http.MethodPost, "/otp/verify", `{"phone": "989121111111", "code": "000000"}`).
+				expect(t, http.StatusUnauthorized)
+		}
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "989121111111", "purpose": "registration"}`).
+			expect(t, http.StatusAccepted)
+
+		// A code that could not be sent is withdrawn, so another can be asked for at once.
+		a.sms.failing = true
+		a.do(t, http.MethodPost, "/otp/request", `{"phone": "989123333333", "purpose": "registration"}`).
+			expect(t, http.StatusInternalServerError)
+		a.sms.failing = false
+		a.prove(t, "989123333333", otp.PurposeRegistration)
+	})
+}
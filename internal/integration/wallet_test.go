@@ -0,0 +1,300 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/wallets"
+	"payment/pkg/errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type walletBody struct {
+	ID           string `json:"id"`
+	Phone        string `json:"phone"`
+	Amount       int64  `json:"amount"`
+	Transactions []struct {
+		Type   string `json:"type"`
+		Amount int64  `json:"amount"`
+		Status string `json:"status"`
+	} `json:"transactions"`
+}
+
+func TestRegisterWallet(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		var wallet walletBody
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989121111111"}`).
+			expect(t, http.StatusCreated).decode(t, &wallet)
+		if wallet.ID == "" || wallet.Phone != "+989121111111" {
+			t.Fatalf("unexpected wallet %+v", wallet)
+		}
+
+		// Every way of writing the number addresses the same wallet.
+		for _, number := range []string{"989121111111", "+989121111111", "00989121111111", "09121111111", "0912 111 1111"} {
+			resp := a.do(t, http.MethodPost, "/wallet/register", `{"phone": "`+number+`"}`).expect(t, http.StatusConflict)
+			if resp.code(t) != errors.CodeWalletExists {
+				t.Fatalf("got code %s for %s, want %s", resp.code(t), number, errors.CodeWalletExists)
+			}
+		}
+		a.do(t, http.MethodGet, "/wallet/09121111111", "").expect(t, http.StatusOK).decode(t, &wallet)
+		if wallet.Phone != "+989121111111" {
+			t.Fatalf("got wallet %+v, want the registered one", wallet)
+		}
+
+		resp := a.do(t, http.MethodPost, "/wallet/register", `{"phone": "02112345678"}`).expect(t, http.StatusBadRequest)
+		if resp.code(t) != errors.CodeInvalidPhone {
+			t.Fatalf("got code %s for a landline, want %s", resp.code(t), errors.CodeInvalidPhone)
+		}
+
+		resp = a.do(t, http.MethodPost, "/wallet/register", `{"phone": "+98-912"}`).expect(t, http.StatusBadRequest)
+		if resp.code(t) != errors.CodeInvalidPhone {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeInvalidPhone)
+		}
+	})
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989122222222"}`).expect(t, http.StatusCreated)
+		a.verify(t, "989122222222")
+
+		a.do(t, http.MethodPut, "/wallet/989122222222",
+			`{"amount": 10000, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+		a.do(t, http.MethodPut, "/wallet/989122222222",
+			`{"amount": 4000, "description": "groceries", "type": "withdrawal"}`).expect(t, http.StatusOK)
+
+		resp := a.do(t, http.MethodPut, "/wallet/989122222222",
+			`{"amount": 6001, "description": "rent", "type": "withdrawal"}`).expect(t, http.StatusUnprocessableEntity)
+		if resp.code(t) != errors.CodeInsufficientFunds {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeInsufficientFunds)
+		}
+
+		var wallet walletBody
+		a.do(t, http.MethodGet, "/wallet/989122222222", "").expect(t, http.StatusOK).decode(t, &wallet)
+		if wallet.Amount != 6000 {
+			t.Fatalf("got balance %d, want 6000", wallet.Amount)
+		}
+		if len(wallet.Transactions) != 2 {
+			t.Fatalf("got %d transactions, want 2", len(wallet.Transactions))
+		}
+		for _, transaction := range wallet.Transactions {
+			if transaction.Status != "completed" {
+				t.Errorf("transaction %+v is not completed", transaction)
+			}
+		}
+	})
+}
+
+func TestTransactionOnUnknownWallet(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		resp := a.do(t, http.MethodPut, "/wallet/989123333333",
+			`{"amount": 100, "description": "salary", "type": "deposit"}`).expect(t, http.StatusNotFound)
+		if resp.code(t) != errors.CodeWalletNotFound {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeWalletNotFound)
+		}
+	})
+}
+
+func TestCloseWalletKeepsLedger(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989124444444"}`).expect(t, http.StatusCreated)
+		a.do(t, http.MethodPut, "/wallet/989124444444",
+			`{"amount": 700, "description": "salary", "type": "deposit"}`).expect(t, http.StatusOK)
+
+		resp := a.do(t, http.MethodDelete, "/wallet/989124444444", "").expect(t, http.StatusConflict)
+		if resp.code(t) != errors.CodeWalletNotEmpty {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeWalletNotEmpty)
+		}
+		a.do(t, http.MethodDelete, "/wallet/989124444444?settle=true", "").expect(t, http.StatusAccepted)
+
+		wallet, err := a.wallets.GetByPhone(context.Background(), "+989124444444")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Status != models.WalletClosed || wallet.Amount != 0 || len(wallet.Transactions) != 2 {
+			t.Fatalf("got %s wallet with balance %d and %d transactions, want closed with 0 and 2",
+				wallet.Status, wallet.Amount, len(wallet.Transactions))
+		}
+
+		resp = a.do(t, http.MethodPut, "/wallet/989124444444",
+			`{"amount": 100, "description": "late deposit", "type": "deposit"}`).expect(t, http.StatusGone)
+		if resp.code(t) != errors.CodeWalletClosed {
+			t.Fatalf("got code %s, want %s", resp.code(t), errors.CodeWalletClosed)
+		}
+	})
+}
+
+func TestUnauthorized(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		req, err := http.NewRequest(http.MethodGet, a.server.URL+"/wallet/989125555555", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "wrong")
+		resp, err := a.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestRetentionAnonymizesClosedWallets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		ctx := context.Background()
+		code := a.createDiscount(t, 100, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989126666666", "").expect(t, http.StatusOK)
+		a.do(t, http.MethodPost, "/wallet/register", `{"phone": "989127777777"}`).expect(t, http.StatusCreated)
+		a.do(t, http.MethodDelete, "/wallet/989126666666?settle=true", "").expect(t, http.StatusAccepted)
+
+		closed, err := a.wallets.GetByPhone(ctx, "+989126666666")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		retention := wallets.NewRetention(discardLogger(), a.backend.wallets, a.backend.transactor, a.audit, 24*time.Hour)
+		if n, err := retention.Anonymize(ctx, time.Now()); err != nil || n != 0 {
+			t.Fatalf("got %d anonymized (%v) inside the retention period, want 0", n, err)
+		}
+		if n, err := retention.Anonymize(ctx, time.Now().Add(48*time.Hour)); err != nil || n != 1 {
+			t.Fatalf("got %d anonymized (%v) after the retention period, want 1", n, err)
+		}
+
+		if _, err = a.wallets.GetByPhone(ctx, "+989126666666"); !errors.Is(err, errors.ErrWalletNotFound) {
+			t.Fatalf("got %v looking up the old phone number, want %v", err, errors.ErrWalletNotFound)
+		}
+		wallet, err := a.wallets.GetByID(ctx, closed.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wallet.Phone != wallets.AnonymizedPhone(closed.ID) || wallet.AnonymizedAt == nil || len(wallet.Transactions) != 2 {
+			t.Fatalf("got phone %q with %d transactions, want it anonymized with the ledger kept",
+				wallet.Phone, len(wallet.Transactions))
+		}
+
+		var discount discountBody
+		a.do(t, http.MethodGet, "/discount/usages?code="+code+"&phone=989127777777", "").
+			expect(t, http.StatusOK).decode(t, &discount)
+		if len(discount.Transactions) != 1 || discount.Transactions[0].Phone != wallet.Phone {
+			t.Fatalf("got usages %+v, want the phone number anonymized", discount.Transactions)
+		}
+		a.do(t, http.MethodGet, "/wallet/989127777777", "").expect(t, http.StatusOK)
+	})
+}
+
+func TestPhoneMigrationMergesDuplicateWallets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		ctx := context.Background()
+		start := time.Now().Add(-time.Hour)
+		// Wallets registered before phone numbers were normalized.
+		legacy := []*models.Wallet{
+			{Phone: "09121111111"},
+			{Phone: "989121111111"},
+			{Phone: "+989122222222", Status: models.WalletClosed},
+			{Phone: "9122222222", Amount: 500},
+			{Phone: "989123333333", Status: models.WalletFrozen},
+			{Phone: "12345"},
+			{Phone: "+989124444444"},
+			{Phone: "09124444444"},
+		}
+		for i, wallet := range legacy {
+			wallet.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+			if wallet.Status == "" {
+				wallet.Status = models.WalletActive
+			}
+			if err := a.backend.wallets.Save(ctx, wallet); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i, amount := range map[int]int64{6: 100, 7: 250} {
+			if _, err := a.wallets.Adjust(ctx, legacy[i].ID, amount, "legacy balance"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		migration := wallets.NewPhoneMigration(discardLogger(), a.backend.wallets, a.backend.transactor, a.audit)
+		actions := func(changes []*wallets.PhoneChange) map[string]string {
+			got := map[string]string{}
+			for _, change := range changes {
+				got[change.Phone] = change.Action
+			}
+			return got
+		}
+		want := map[string]string{
+			"09121111111":  wallets.PhoneNormalized,
+			"989121111111": wallets.PhoneMerged,
+			"9122222222":   wallets.PhoneFlagged,
+			"989123333333": wallets.PhoneNormalized,
+			"12345":        wallets.PhoneInvalid,
+			"09124444444":  wallets.PhoneMerged,
+		}
+		changes, err := migration.Run(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := actions(changes); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got dry run %v, want %v", got, want)
+		}
+		if _, err = a.wallets.GetByPhone(ctx, "+989121111111"); !errors.Is(err, errors.ErrWalletNotFound) {
+			t.Fatalf("got %v, want the dry run to change nothing", err)
+		}
+
+		if changes, err = migration.Run(ctx, true); err != nil {
+			t.Fatal(err)
+		}
+		if got := actions(changes); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got changes %v, want %v", got, want)
+		}
+		kept, err := a.wallets.GetByPhone(ctx, "+989121111111")
+		if err != nil || kept.ID != legacy[0].ID {
+			t.Fatalf("got %v, %v, want the oldest wallet to keep the number", kept, err)
+		}
+		merged, err := a.wallets.GetByID(ctx, legacy[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.Status != models.WalletClosed || merged.DuplicateOf == nil || *merged.DuplicateOf != legacy[0].ID ||
+			merged.Phone != wallets.MergedPhone(merged.ID) {
+			t.Fatalf("got %+v, want the empty duplicate closed and merged", merged)
+		}
+		funded, err := a.wallets.GetByID(ctx, legacy[7].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if funded.Status != models.WalletClosed || funded.Amount != 0 || *funded.DuplicateOf != legacy[6].ID {
+			t.Fatalf("got %+v, want the funded duplicate emptied and closed", funded)
+		}
+		receiver, err := a.wallets.GetByPhone(ctx, "+989124444444")
+		if err != nil || receiver.ID != legacy[6].ID || receiver.Amount != 350 {
+			t.Fatalf("got %+v, %v, want the funds of the duplicate moved to the wallet that kept the number", receiver, err)
+		}
+		if history, err := a.backend.transactions.List(ctx, receiver.ID); err != nil || len(history) != 2 {
+			t.Fatalf("got %d transactions, %v, want the history of the duplicate moved too", len(history), err)
+		}
+		flagged, err := a.wallets.GetByID(ctx, legacy[3].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flagged.Status != models.WalletActive || flagged.Amount != 500 || flagged.DuplicateOf == nil ||
+			*flagged.DuplicateOf != legacy[2].ID {
+			t.Fatalf("got %+v, want the funded duplicate flagged and left alone", flagged)
+		}
+		if frozen, err := a.wallets.GetByPhone(ctx, "+989123333333"); err != nil || frozen.Status != models.WalletFrozen {
+			t.Fatalf("got %v, %v, want the frozen wallet normalized", frozen, err)
+		}
+
+		if changes, err = migration.Run(ctx, true); err != nil {
+			t.Fatal(err)
+		}
+		want = map[string]string{"9122222222": wallets.PhoneFlagged, "12345": wallets.PhoneInvalid}
+		if got := actions(changes); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v running again, want only what is left for an operator %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment/api/models"
+	"payment/internal/events"
+	"payment/internal/webhooks"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooksDeliverSignedEvents(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, a *app) {
+		var mu sync.Mutex
+		var secret string
+		var received []string
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			if err := webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+				t.Errorf("delivery of %s: %v", r.Header.Get(events.EventTypeHeader), err)
+			}
+			received = append(received, r.Header.Get(events.EventTypeHeader))
+		}))
+		defer receiver.Close()
+
+		a.admin(t, http.MethodPost, "/admin/webhooks",
+			`{"url": "`+receiver.URL+`", "event_types": ["wallet.created", "refund.issued"]}`).
+			expect(t, http.StatusBadRequest)
+		var subscription models.WebhookSubscription
+		a.admin(t, http.MethodPost, "/admin/webhooks",
+			`{"url": "`+receiver.URL+`", "event_types": ["wallet.created", "transaction.completed"]}`).
+			expect(t, http.StatusCreated).decode(t, &subscription)
+		mu.Lock()
+		secret = subscription.Secret
+		mu.Unlock()
+
+		// Redeeming a discount creates a wallet, deposits into it and redeems the code; the
+		// redemption is not subscribed to.
+		code := a.createDiscount(t, 300, 10)
+		a.do(t, http.MethodGet, "/discount/apply?code="+code+"&phone=989129999999", "").expect(t, http.StatusOK)
+
+		relay := events.NewRelay(discardLogger(), a.backend.outbox, a.backend.transactor, a.webhooks, nil, 10, time.Hour)
+		if n, err := relay.Publish(context.Background()); err != nil || n != 3 {
+			t.Fatalf("got %d published (%v), want 3", n, err)
+		}
+		deliverer := webhooks.NewDeliverer(discardLogger(), a.backend.webhooks, a.backend.transactor,
+			receiver.Client(), webhooks.Settings{})
+		if n, err := deliverer.Deliver(context.Background(), time.Now()); err != nil || n != 2 {
+			t.Fatalf("got %d attempted (%v), want 2", n, err)
+		}
+		if len(received) != 2 || received[0] != events.WalletCreated || received[1] != events.TransactionCompleted {
+			t.Fatalf("got %v, want the wallet creation and the deposit", received)
+		}
+
+		var deliveries []models.WebhookDelivery
+		a.admin(t, http.MethodGet, "/admin/webhooks/"+subscription.ID.String()+"/deliveries?status=succeeded", "").
+			expect(t, http.StatusOK).decode(t, &deliveries)
+		if len(deliveries) != 2 || deliveries[0].EventType != events.TransactionCompleted || deliveries[0].Attempts != 1 {
+			t.Fatalf("got %+v, want both deliveries, newest first", deliveries)
+		}
+
+		a.admin(t, http.MethodPost, "/admin/webhooks/deliveries/"+deliveries[1].ID.String()+"/redeliver", "").
+			expect(t, http.StatusAccepted)
+		if n, err := deliverer.Deliver(context.Background(), time.Now()); err != nil || n != 1 {
+			t.Fatalf("got %d attempted (%v) after redelivery, want 1", n, err)
+		}
+
+		a.admin(t, http.MethodDelete, "/admin/webhooks/"+subscription.ID.String(), "").expect(t, http.StatusNoContent)
+		var subscriptions []models.WebhookSubscription
+		a.admin(t, http.MethodGet, "/admin/webhooks", "").expect(t, http.StatusOK).decode(t, &subscriptions)
+		if len(subscriptions) != 1 || subscriptions[0].Active || subscriptions[0].Secret != "" {
+			t.Fatalf("got %+v, want the inactive subscription without its secret", subscriptions)
+		}
+		a.admin(t, http.MethodDelete, "/admin/webhooks/"+subscription.ID.String()+"x", "").expect(t, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+var errKeyNotFound = errors.ErrNotFound.WithMessage("api key not found")
+
+// APIKeys is an in-memory implementation of apikeys.Store.
+type APIKeys struct {
+	db *DB
+}
+
+// NewAPIKeys creates an API key store on top of db.
+func NewAPIKeys(db *DB) *APIKeys {
+	return &APIKeys{db}
+}
+
+func (s *APIKeys) Add(ctx context.Context, key *models.APIKey) error {
+	defer s.db.lock(ctx)()
+
+	if key.ID == uuid.Nil {
+		key.ID = uuid.New()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	for _, existing := range s.db.apiKeys {
+		if existing.KeyID == key.KeyID || existing.Hash == key.Hash {
+			return errors.ErrInternal.WithMessage("api key already exists")
+		}
+	}
+	s.db.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (s *APIKeys) List(ctx context.Context) ([]*models.APIKey, error) {
+	defer s.db.lock(ctx)()
+
+	keys := make([]*models.APIKey, 0, len(s.db.apiKeys))
+	for _, key := range s.db.apiKeys {
+		key := key
+		keys = append(keys, &key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *APIKeys) Find(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	defer s.db.lock(ctx)()
+
+	key, ok := s.db.apiKeys[id]
+	if !ok {
+		return nil, errKeyNotFound
+	}
+	return &key, nil
+}
+
+func (s *APIKeys) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	defer s.db.lock(ctx)()
+
+	for _, key := range s.db.apiKeys {
+		if key.Hash == hash {
+			return &key, nil
+		}
+	}
+	return nil, errKeyNotFound
+}
+
+func (s *APIKeys) Revoke(ctx context.Context, id uuid.UUID, at time.Time) error {
+	defer s.db.lock(ctx)()
+
+	key, ok := s.db.apiKeys[id]
+	if !ok {
+		return errKeyNotFound
+	}
+	key.RevokedAt = &at
+	s.db.apiKeys[id] = key
+	return nil
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"payment/api/models"
+)
+
+// Audit is an in-memory implementation of audit.Store.
+type Audit struct {
+	db *DB
+}
+
+// NewAudit creates an audit store on top of db.
+func NewAudit(db *DB) *Audit {
+	return &Audit{db}
+}
+
+func (s *Audit) Add(ctx context.Context, entry *models.AuditPending) error {
+	defer s.db.lock(ctx)()
+
+	s.db.auditSequence++
+	entry.ID = s.db.auditSequence
+	s.db.auditPending = append(s.db.auditPending, *entry)
+	return nil
+}
+
+func (s *Audit) Pending(ctx context.Context, limit int) ([]*models.AuditPending, error) {
+	defer s.db.lock(ctx)()
+
+	entries := make([]*models.AuditPending, 0)
+	for _, entry := range s.db.auditPending {
+		if len(entries) == limit {
+			break
+		}
+		entry := entry
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// Last returns the newest record. Sealers run in transactions, which already hold the database lock.
+func (s *Audit) Last(ctx context.Context) (*models.AuditRecord, error) {
+	defer s.db.lock(ctx)()
+
+	if len(s.db.auditLog) == 0 {
+		return nil, nil
+	}
+	record := s.db.auditLog[len(s.db.auditLog)-1]
+	return &record, nil
+}
+
+func (s *Audit) Seal(ctx context.Context, record *models.AuditRecord, pending int64) error {
+	defer s.db.lock(ctx)()
+
+	s.db.auditLog = append(s.db.auditLog, *record)
+	for i, entry := range s.db.auditPending {
+		if entry.ID == pending {
+			s.db.auditPending = append(s.db.auditPending[:i:i], s.db.auditPending[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Audit) Find(ctx context.Context, filter models.AuditFilter) ([]*models.AuditRecord, error) {
+	defer s.db.lock(ctx)()
+
+	records := make([]*models.AuditRecord, 0)
+	for _, record := range s.db.auditLog {
+		if !matches(record, filter) {
+			continue
+		}
+		if filter.Limit > 0 && len(records) == filter.Limit {
+			break
+		}
+		record := record
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+func matches(record models.AuditRecord, filter models.AuditFilter) bool {
+	equal := func(value, want string) bool { return want == "" || value == want }
+	return record.Sequence > filter.After &&
+		equal(record.Actor, filter.Actor) &&
+		equal(record.Action, filter.Action) &&
+		equal(record.EntityType, filter.EntityType) &&
+		equal(record.EntityID, filter.EntityID) &&
+		equal(record.RequestID, filter.RequestID) &&
+		(filter.From.IsZero() || !record.CreatedAt.Before(filter.From)) &&
+		(filter.To.IsZero() || record.CreatedAt.Before(filter.To))
+}
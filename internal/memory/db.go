@@ -0,0 +1,160 @@
+// Package memory provides in-memory implementations of the wallet, transaction, discount,
+// audit, outbox, webhook, API key, one-time code and notification repositories. It is meant
+// for tests and local experiments: nothing is persisted, and transactions are serialized
+// behind a single lock instead of row locks.
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	dbpkg "payment/pkg/db"
+	"sync"
+)
+
+type txKey struct{}
+
+// DB holds the in-memory tables shared by the repositories. It implements db.Transactor:
+// a transaction takes an exclusive lock on every table and restores a snapshot of them
+// when it fails, which gives the same all-or-nothing behaviour as a database transaction.
+type DB struct {
+	mu                      sync.Mutex
+	wallets                 map[uuid.UUID]models.Wallet
+	transactions            map[uuid.UUID]models.Transaction
+	discounts               map[uuid.UUID]models.Discount
+	discountTransactions    map[uuid.UUID]models.DiscountTransaction
+	walletLimits            map[uuid.UUID]models.WalletLimits
+	tierChanges             map[uuid.UUID]models.WalletTierChange
+	auditLog                []models.AuditRecord
+	auditPending            []models.AuditPending
+	outbox                  []models.OutboxEvent
+	subscriptions           map[uuid.UUID]models.WebhookSubscription
+	deliveries              map[uuid.UUID]models.WebhookDelivery
+	apiKeys                 map[uuid.UUID]models.APIKey
+	otpCodes                map[string]models.OTPCode
+	ownershipTokens         map[string]models.OwnershipToken
+	notificationPreferences map[uuid.UUID]models.NotificationPreferences
+	notifications           map[uuid.UUID]models.Notification
+	// auditSequence numbers the pending audit changes. Like a database sequence, it is not
+	// rolled back.
+	auditSequence int64
+}
+
+// NewDB creates an empty in-memory database.
+func NewDB() *DB {
+	return &DB{
+		wallets:                 make(map[uuid.UUID]models.Wallet),
+		transactions:            make(map[uuid.UUID]models.Transaction),
+		discounts:               make(map[uuid.UUID]models.Discount),
+		discountTransactions:    make(map[uuid.UUID]models.DiscountTransaction),
+		walletLimits:            make(map[uuid.UUID]models.WalletLimits),
+		tierChanges:             make(map[uuid.UUID]models.WalletTierChange),
+		subscriptions:           make(map[uuid.UUID]models.WebhookSubscription),
+		deliveries:              make(map[uuid.UUID]models.WebhookDelivery),
+		apiKeys:                 make(map[uuid.UUID]models.APIKey),
+		otpCodes:                make(map[string]models.OTPCode),
+		ownershipTokens:         make(map[string]models.OwnershipToken),
+		notificationPreferences: make(map[uuid.UUID]models.NotificationPreferences),
+		notifications:           make(map[uuid.UUID]models.Notification),
+	}
+}
+
+// WithinTransaction runs fn while holding the database lock. Changes made by fn are
+// rolled back when it returns an error. Nested calls join the outer transaction.
+func (db *DB) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+	inner, done := dbpkg.Deferred(ctx)
+	defer done()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	snapshot := db.snapshot()
+	if err := fn(context.WithValue(inner, txKey{}, db)); err != nil {
+		db.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+// lock acquires the database lock unless ctx already belongs to a transaction,
+// and returns the function that releases it.
+func (db *DB) lock(ctx context.Context) func() {
+	if ctx.Value(txKey{}) != nil {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+type snapshot struct {
+	wallets                 map[uuid.UUID]models.Wallet
+	transactions            map[uuid.UUID]models.Transaction
+	discounts               map[uuid.UUID]models.Discount
+	discountTransactions    map[uuid.UUID]models.DiscountTransaction
+	walletLimits            map[uuid.UUID]models.WalletLimits
+	tierChanges             map[uuid.UUID]models.WalletTierChange
+	auditLog                []models.AuditRecord
+	auditPending            []models.AuditPending
+	outbox                  []models.OutboxEvent
+	subscriptions           map[uuid.UUID]models.WebhookSubscription
+	deliveries              map[uuid.UUID]models.WebhookDelivery
+	apiKeys                 map[uuid.UUID]models.APIKey
+	otpCodes                map[string]models.OTPCode
+	ownershipTokens         map[string]models.OwnershipToken
+	notificationPreferences map[uuid.UUID]models.NotificationPreferences
+	notifications           map[uuid.UUID]models.Notification
+}
+
+// snapshot copies the tables. The audit log is only ever appended to, so its records are
+// shared; pending audit changes are removed when sealed and outbox events are updated in
+// place when published, so they are copied.
+func (db *DB) snapshot() snapshot {
+	return snapshot{
+		wallets:                 clone(db.wallets),
+		transactions:            clone(db.transactions),
+		discounts:               clone(db.discounts),
+		discountTransactions:    clone(db.discountTransactions),
+		walletLimits:            clone(db.walletLimits),
+		tierChanges:             clone(db.tierChanges),
+		auditLog:                db.auditLog[:len(db.auditLog):len(db.auditLog)],
+		auditPending:            append([]models.AuditPending(nil), db.auditPending...),
+		outbox:                  append([]models.OutboxEvent(nil), db.outbox...),
+		subscriptions:           clone(db.subscriptions),
+		deliveries:              clone(db.deliveries),
+		apiKeys:                 clone(db.apiKeys),
+		otpCodes:                clone(db.otpCodes),
+		ownershipTokens:         clone(db.ownershipTokens),
+		notificationPreferences: clone(db.notificationPreferences),
+		notifications:           clone(db.notifications),
+	}
+}
+
+func (db *DB) restore(s snapshot) {
+	db.wallets = s.wallets
+	db.transactions = s.transactions
+	db.discounts = s.discounts
+	db.discountTransactions = s.discountTransactions
+	db.walletLimits = s.walletLimits
+	db.tierChanges = s.tierChanges
+	db.auditLog = s.auditLog
+	db.auditPending = s.auditPending
+	db.outbox = s.outbox
+	db.subscriptions = s.subscriptions
+	db.deliveries = s.deliveries
+	db.apiKeys = s.apiKeys
+	db.otpCodes = s.otpCodes
+	db.ownershipTokens = s.ownershipTokens
+	db.notificationPreferences = s.notificationPreferences
+	db.notifications = s.notifications
+}
+
+func clone[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
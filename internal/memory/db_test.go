@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	stderrors "errors"
+	"payment/api/models"
+	dbpkg "payment/pkg/db"
+	"testing"
+)
+
+func TestWithinTransactionRollsBackOnError(t *testing.T) {
+	db := NewDB()
+	wallets := NewWallets(db)
+	ctx := context.Background()
+
+	wallet := &models.Wallet{Phone: "989120000001", Amount: 100}
+	if err := wallets.Save(ctx, wallet); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := stderrors.New("boom")
+	err := db.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := wallets.UpdateBalance(ctx, wallet.ID, 0); err != nil {
+			return err
+		}
+		if err := wallets.Save(ctx, &models.Wallet{Phone: "989120000002"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !stderrors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+
+	got, err := wallets.FindByID(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != 100 {
+		t.Errorf("balance was not rolled back: got %d, want 100", got.Amount)
+	}
+	if _, err = wallets.FindByPhone(ctx, "989120000002"); err == nil {
+		t.Error("wallet created in a failed transaction is still visible")
+	}
+}
+
+func TestWithinTransactionCommits(t *testing.T) {
+	db := NewDB()
+	wallets := NewWallets(db)
+	ctx := context.Background()
+
+	err := db.WithinTransaction(ctx, func(ctx context.Context) error {
+		return db.WithinTransaction(ctx, func(ctx context.Context) error {
+			return wallets.Save(ctx, &models.Wallet{Phone: "989120000003"})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wallets.FindByPhone(ctx, "989120000003"); err != nil {
+		t.Errorf("committed wallet not found: %v", err)
+	}
+}
+
+func TestAfterTransactionRunsOnceTheOuterTransactionEnds(t *testing.T) {
+	db := NewDB()
+	wallets := NewWallets(db)
+	ctx := context.Background()
+
+	failure := stderrors.New("boom")
+	var ran bool
+	err := db.WithinTransaction(ctx, func(ctx context.Context) error {
+		return db.WithinTransaction(ctx, func(ctx context.Context) error {
+			dbpkg.AfterTransaction(ctx, func(ctx context.Context) {
+				// The outer transaction has been rolled back and released the lock by now.
+				ran = wallets.Save(ctx, &models.Wallet{Phone: "989120000004"}) == nil
+			})
+			if ran {
+				t.Error("the function ran inside the transaction")
+			}
+			return failure
+		})
+	})
+	if !stderrors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+	if !ran {
+		t.Fatal("the function did not run after the rollback")
+	}
+	if _, err = wallets.FindByPhone(ctx, "989120000004"); err != nil {
+		t.Errorf("wallet saved after the rollback not found: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+// Discounts is an in-memory implementation of discounts.IDiscount and discounts.IDiscountTransaction.
+type Discounts struct {
+	db *DB
+}
+
+// NewDiscounts creates a discount repository on top of db.
+func NewDiscounts(db *DB) *Discounts {
+	return &Discounts{db}
+}
+
+func (s *Discounts) Create(ctx context.Context, discount *models.Discount) (*models.Discount, error) {
+	defer s.db.lock(ctx)()
+
+	for id, existing := range s.db.discounts {
+		if id != discount.ID && existing.Code == discount.Code {
+			return nil, errors.ErrBadRequest.WithMessage("discount code already exists")
+		}
+	}
+	if discount.ID == uuid.Nil {
+		discount.ID = uuid.New()
+	}
+	if discount.CreatedAt.IsZero() {
+		discount.CreatedAt = time.Now()
+	}
+
+	row := *discount
+	row.Transactions = nil
+	s.db.discounts[discount.ID] = row
+	return discount, nil
+}
+
+func (s *Discounts) GetByCode(ctx context.Context, code string) (*models.Discount, error) {
+	defer s.db.lock(ctx)()
+
+	for _, row := range s.db.discounts {
+		if row.Code == code {
+			row.Transactions = s.list(row.ID)
+			return &row, nil
+		}
+	}
+	return nil, errors.ErrDiscountNotFound
+}
+
+func (s *Discounts) IsUsed(ctx context.Context, id uuid.UUID, phoneNumber string) (bool, error) {
+	defer s.db.lock(ctx)()
+
+	for _, row := range s.db.discountTransactions {
+		if row.DiscountID == id && row.PhoneNum == phoneNumber {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Discounts) Count(ctx context.Context, id uuid.UUID) (int64, error) {
+	defer s.db.lock(ctx)()
+
+	return int64(len(s.list(id))), nil
+}
+
+// Lock checks that the discount exists. Transactions already hold the database lock.
+func (s *Discounts) Lock(ctx context.Context, id uuid.UUID) error {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.discounts[id]; !ok {
+		return errors.ErrDiscountNotFound
+	}
+	return nil
+}
+
+func (s *Discounts) List(ctx context.Context, id uuid.UUID) ([]*models.DiscountTransaction, error) {
+	defer s.db.lock(ctx)()
+
+	return s.list(id), nil
+}
+
+func (s *Discounts) Add(ctx context.Context, transaction *models.DiscountTransaction) (*models.DiscountTransaction, error) {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.discounts[transaction.DiscountID]; !ok {
+		return nil, errors.ErrDiscountNotFound
+	}
+	if transaction.ID == uuid.Nil {
+		transaction.ID = uuid.New()
+	}
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+	s.db.discountTransactions[transaction.ID] = *transaction
+	return transaction, nil
+}
+
+func (s *Discounts) Delete(ctx context.Context, id uuid.UUID) error {
+	defer s.db.lock(ctx)()
+
+	delete(s.db.discountTransactions, id)
+	return nil
+}
+
+// list returns the usages of a discount ordered by creation time. The caller must hold the lock.
+func (s *Discounts) list(id uuid.UUID) []*models.DiscountTransaction {
+	transactions := make([]*models.DiscountTransaction, 0)
+	for _, row := range s.db.discountTransactions {
+		if row.DiscountID == id {
+			row := row
+			transactions = append(transactions, &row)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+	return transactions
+}
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+var errNotificationNotFound = errors.ErrNotFound.WithMessage("notification not found")
+
+// Notifications is an in-memory implementation of notifications.Store.
+type Notifications struct {
+	db *DB
+}
+
+// NewNotifications creates a notification store on top of db.
+func NewNotifications(db *DB) *Notifications {
+	return &Notifications{db}
+}
+
+func (s *Notifications) FindPreferences(ctx context.Context, walletID uuid.UUID) (*models.NotificationPreferences, error) {
+	defer s.db.lock(ctx)()
+
+	preferences, ok := s.db.notificationPreferences[walletID]
+	if !ok {
+		return nil, nil
+	}
+	preferences.OptOut = append(models.NotificationChannels{}, preferences.OptOut...)
+	return &preferences, nil
+}
+
+func (s *Notifications) SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	defer s.db.lock(ctx)()
+
+	row := *preferences
+	row.OptOut = append(models.NotificationChannels{}, preferences.OptOut...)
+	s.db.notificationPreferences[preferences.WalletID] = row
+	return nil
+}
+
+func (s *Notifications) AddNotification(ctx context.Context, notification *models.Notification) error {
+	defer s.db.lock(ctx)()
+
+	for _, existing := range s.db.notifications {
+		if existing.EventID == notification.EventID && existing.WalletID == notification.WalletID &&
+			existing.Channel == notification.Channel {
+			return nil
+		}
+	}
+	if notification.ID == uuid.Nil {
+		notification.ID = uuid.New()
+	}
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = time.Now()
+	}
+	s.db.notifications[notification.ID] = *notification
+	return nil
+}
+
+// Due returns the pending notifications due at now. Dispatchers run in transactions, which
+// already hold the database lock.
+func (s *Notifications) Due(ctx context.Context, now time.Time, limit int) ([]*models.Notification, error) {
+	notifications := s.notifications(ctx, func(notification *models.Notification) bool {
+		return notification.Status == models.NotificationPending && !notification.NextAttemptAt.After(now)
+	})
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].NextAttemptAt.Before(notifications[j].NextAttemptAt)
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+func (s *Notifications) UpdateNotification(ctx context.Context, notification *models.Notification) error {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.notifications[notification.ID]; !ok {
+		return errNotificationNotFound
+	}
+	s.db.notifications[notification.ID] = *notification
+	return nil
+}
+
+func (s *Notifications) RecordAttempt(ctx context.Context, notification *models.Notification, lease uuid.UUID) (bool, error) {
+	defer s.db.lock(ctx)()
+
+	stored, ok := s.db.notifications[notification.ID]
+	if !ok {
+		return false, errNotificationNotFound
+	}
+	if stored.Lease == nil || *stored.Lease != lease {
+		return false, nil
+	}
+	stored.Status, stored.Attempts, stored.NextAttemptAt = notification.Status, notification.Attempts, notification.NextAttemptAt
+	stored.LastAttemptAt, stored.LastError, stored.SentAt = notification.LastAttemptAt, notification.LastError, notification.SentAt
+	stored.Lease = nil
+	s.db.notifications[notification.ID] = stored
+	return true, nil
+}
+
+func (s *Notifications) Notifications(ctx context.Context, walletID uuid.UUID, status models.NotificationStatus, limit int) ([]*models.Notification, error) {
+	notifications := s.notifications(ctx, func(notification *models.Notification) bool {
+		return notification.WalletID == walletID && (status == "" || notification.Status == status)
+	})
+	for i, j := 0, len(notifications)-1; i < j; i, j = i+1, j-1 {
+		notifications[i], notifications[j] = notifications[j], notifications[i]
+	}
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// notifications returns the notifications matching keep, oldest first.
+func (s *Notifications) notifications(ctx context.Context, keep func(*models.Notification) bool) []*models.Notification {
+	defer s.db.lock(ctx)()
+
+	notifications := make([]*models.Notification, 0)
+	for _, notification := range s.db.notifications {
+		notification := notification
+		if keep(&notification) {
+			notifications = append(notifications, &notification)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+	})
+	return notifications
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"time"
+)
+
+var (
+	errCodeNotFound  = errors.ErrNotFound.WithMessage("otp code not found")
+	errTokenNotFound = errors.ErrNotFound.WithMessage("ownership token not found")
+)
+
+// OTP is an in-memory implementation of otp.Store.
+type OTP struct {
+	db *DB
+}
+
+// NewOTP creates a one-time code store on top of db.
+func NewOTP(db *DB) *OTP {
+	return &OTP{db}
+}
+
+func (s *OTP) Lock(ctx context.Context, phone string) (*models.OTPCode, error) {
+	defer s.db.lock(ctx)()
+
+	code, ok := s.db.otpCodes[phone]
+	if !ok {
+		return nil, errCodeNotFound
+	}
+	return &code, nil
+}
+
+func (s *OTP) SaveCode(ctx context.Context, code *models.OTPCode) error {
+	defer s.db.lock(ctx)()
+
+	s.db.otpCodes[code.Phone] = *code
+	return nil
+}
+
+func (s *OTP) DeleteCode(ctx context.Context, phone string) error {
+	defer s.db.lock(ctx)()
+
+	delete(s.db.otpCodes, phone)
+	return nil
+}
+
+func (s *OTP) CountSent(ctx context.Context, since time.Time, clientIP string) (int64, error) {
+	defer s.db.lock(ctx)()
+
+	var count int64
+	for _, code := range s.db.otpCodes {
+		if !code.SentAt.Before(since) && (clientIP == "" || code.ClientIP == clientIP) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *OTP) AddToken(ctx context.Context, token *models.OwnershipToken) error {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.ownershipTokens[token.Hash]; ok {
+		return errors.ErrInternal.WithMessage("ownership token already exists")
+	}
+	s.db.ownershipTokens[token.Hash] = *token
+	return nil
+}
+
+func (s *OTP) ConsumeToken(ctx context.Context, hash, phone, purpose string, now time.Time) error {
+	defer s.db.lock(ctx)()
+
+	token, ok := s.db.ownershipTokens[hash]
+	if !ok || token.Phone != phone || token.Purpose != purpose || !now.Before(token.ExpiresAt) {
+		return errTokenNotFound
+	}
+	delete(s.db.ownershipTokens, hash)
+	return nil
+}
+
+func (s *OTP) DeleteExpired(ctx context.Context, now time.Time) error {
+	defer s.db.lock(ctx)()
+
+	for phone, code := range s.db.otpCodes {
+		if code.KeepUntil.Before(now) {
+			delete(s.db.otpCodes, phone)
+		}
+	}
+	for hash, token := range s.db.ownershipTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(s.db.ownershipTokens, hash)
+		}
+	}
+	return nil
+}
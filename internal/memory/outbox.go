@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/internal/events"
+	"payment/pkg/errors"
+	"time"
+)
+
+// Outbox is an in-memory implementation of events.Store.
+type Outbox struct {
+	db *DB
+}
+
+// NewOutbox creates an outbox store on top of db.
+func NewOutbox(db *DB) *Outbox {
+	return &Outbox{db}
+}
+
+func (s *Outbox) Add(ctx context.Context, event *models.OutboxEvent) error {
+	defer s.db.lock(ctx)()
+
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	s.db.outbox = append(s.db.outbox, *event)
+	return nil
+}
+
+// Pending returns the oldest events not yet published on cursor. Relays run in transactions,
+// which already hold the database lock.
+func (s *Outbox) Pending(ctx context.Context, cursor events.Cursor, now time.Time, limit int) ([]*models.OutboxEvent, error) {
+	defer s.db.lock(ctx)()
+
+	external := cursor == events.External
+	events := make([]*models.OutboxEvent, 0)
+	for _, event := range s.db.outbox {
+		if len(events) == limit {
+			break
+		}
+		leased := external && event.ForwardLeasedUntil != nil && event.ForwardLeasedUntil.After(now)
+		if *progress(&event, cursor).publishedAt == nil && !leased {
+			event := event
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+func (s *Outbox) Lease(ctx context.Context, until time.Time, ids ...uuid.UUID) error {
+	var leasedUntil *time.Time
+	if !until.IsZero() {
+		leasedUntil = &until
+	}
+	for _, id := range ids {
+		if err := s.update(ctx, id, func(event *models.OutboxEvent) { event.ForwardLeasedUntil = leasedUntil }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Outbox) MarkPublished(ctx context.Context, id uuid.UUID, at time.Time, cursors ...events.Cursor) error {
+	return s.update(ctx, id, func(event *models.OutboxEvent) {
+		for _, cursor := range cursors {
+			progress := progress(event, cursor)
+			*progress.publishedAt = &at
+			*progress.attempts++
+		}
+	})
+}
+
+func (s *Outbox) MarkFailed(ctx context.Context, cursor events.Cursor, id uuid.UUID, reason string) error {
+	return s.update(ctx, id, func(event *models.OutboxEvent) {
+		progress := progress(event, cursor)
+		*progress.lastError = reason
+		*progress.attempts++
+	})
+}
+
+func (s *Outbox) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	defer s.db.lock(ctx)()
+
+	kept := make([]models.OutboxEvent, 0, len(s.db.outbox))
+	for _, event := range s.db.outbox {
+		if event.PublishedAt == nil || !event.PublishedAt.Before(before) ||
+			event.ForwardedAt == nil || !event.ForwardedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	deleted := int64(len(s.db.outbox) - len(kept))
+	s.db.outbox = kept
+	return deleted, nil
+}
+
+// All returns every event in the outbox, published or not, oldest first.
+func (s *Outbox) All(ctx context.Context) []models.OutboxEvent {
+	defer s.db.lock(ctx)()
+
+	return append([]models.OutboxEvent(nil), s.db.outbox...)
+}
+
+func (s *Outbox) update(ctx context.Context, id uuid.UUID, fn func(event *models.OutboxEvent)) error {
+	defer s.db.lock(ctx)()
+
+	for i := range s.db.outbox {
+		if s.db.outbox[i].ID == id {
+			fn(&s.db.outbox[i])
+			return nil
+		}
+	}
+	return errors.ErrNotFound.WithMessage("event not found")
+}
+
+// cursorProgress points to the fields of an event that hold its progress on one cursor.
+type cursorProgress struct {
+	publishedAt **time.Time
+	attempts    *int
+	lastError   *string
+}
+
+func progress(event *models.OutboxEvent, cursor events.Cursor) cursorProgress {
+	if cursor == events.External {
+		return cursorProgress{&event.ForwardedAt, &event.ForwardAttempts, &event.ForwardError}
+	}
+	return cursorProgress{&event.PublishedAt, &event.Attempts, &event.LastError}
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+// Transactions is an in-memory implementation of transactions.ITransaction.
+type Transactions struct {
+	db *DB
+}
+
+// NewTransactions creates a transaction repository on top of db.
+func NewTransactions(db *DB) *Transactions {
+	return &Transactions{db}
+}
+
+func (s *Transactions) Create(ctx context.Context, transaction *models.Transaction) (*models.Transaction, error) {
+	if transaction.WalletID == uuid.Nil {
+		return nil, errors.ErrBadRequest.WithMessage("wallet ID is required")
+	}
+
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.wallets[transaction.WalletID]; !ok {
+		return nil, errors.ErrWalletNotFound
+	}
+	if transaction.ID == uuid.Nil {
+		transaction.ID = uuid.New()
+	}
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now()
+	}
+
+	row := *transaction
+	row.Wallet = models.Wallet{}
+	s.db.transactions[transaction.ID] = row
+	return transaction, nil
+}
+
+func (s *Transactions) GetByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.transactions[id]
+	if !ok {
+		return nil, errors.ErrNotFound.WithMessage("transaction not found")
+	}
+	return &row, nil
+}
+
+func (s *Transactions) ChangeStatus(ctx context.Context, id uuid.UUID, status models.Status) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.transactions[id]
+	if !ok {
+		return errors.ErrNotFound.WithMessage("transaction not found")
+	}
+	row.Status = status
+	s.db.transactions[id] = row
+	return nil
+}
+
+func (s *Transactions) List(ctx context.Context, id uuid.UUID) ([]*models.Transaction, error) {
+	defer s.db.lock(ctx)()
+
+	transactions := make([]*models.Transaction, 0)
+	for _, row := range s.db.transactions {
+		if row.WalletID == id {
+			row := row
+			transactions = append(transactions, &row)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt.Before(transactions[j].CreatedAt)
+	})
+	return transactions, nil
+}
+
+func (s *Transactions) Totals(ctx context.Context, walletID uuid.UUID, since time.Time) (*models.TransactionTotals, error) {
+	defer s.db.lock(ctx)()
+
+	totals := &models.TransactionTotals{}
+	for _, row := range s.db.transactions {
+		if row.WalletID != walletID || row.Status != models.Completed || row.CreatedAt.Before(since) {
+			continue
+		}
+		switch row.Type {
+		case models.Deposit:
+			totals.Deposits += row.Amount
+		case models.Withdrawal:
+			totals.Withdrawals += row.Amount
+		}
+		totals.Count++
+	}
+	return totals, nil
+}
@@ -0,0 +1,288 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+// Wallets is an in-memory implementation of wallets.Store.
+type Wallets struct {
+	db *DB
+}
+
+// NewWallets creates a wallet store on top of db.
+func NewWallets(db *DB) *Wallets {
+	return &Wallets{db}
+}
+
+func (s *Wallets) Save(ctx context.Context, wallet *models.Wallet) error {
+	defer s.db.lock(ctx)()
+
+	for id, existing := range s.db.wallets {
+		if id != wallet.ID && existing.Phone == wallet.Phone {
+			return errors.ErrWalletExists
+		}
+	}
+
+	now := time.Now()
+	if wallet.ID == uuid.Nil {
+		wallet.ID = uuid.New()
+	}
+	if wallet.CreatedAt.IsZero() {
+		wallet.CreatedAt = now
+	}
+	wallet.UpdatedAt = now
+
+	row := *wallet
+	row.Transactions = nil
+	s.db.wallets[wallet.ID] = row
+	return nil
+}
+
+func (s *Wallets) UpdateBalance(ctx context.Context, id uuid.UUID, amount int64) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	row.Amount = amount
+	row.UpdatedAt = time.Now()
+	s.db.wallets[id] = row
+	return nil
+}
+
+func (s *Wallets) UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string, at time.Time) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	row.Status = status
+	row.StatusReason = reason
+	row.StatusChangedAt = &at
+	row.UpdatedAt = time.Now()
+	s.db.wallets[id] = row
+	return nil
+}
+
+func (s *Wallets) ClosedBefore(ctx context.Context, before time.Time, limit int) ([]*models.Wallet, error) {
+	defer s.db.lock(ctx)()
+
+	var wallets []*models.Wallet
+	for _, row := range s.db.wallets {
+		if row.Status == models.WalletClosed && row.AnonymizedAt == nil &&
+			row.StatusChangedAt != nil && row.StatusChangedAt.Before(before) {
+			row := row
+			wallets = append(wallets, &row)
+		}
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].StatusChangedAt.Before(*wallets[j].StatusChangedAt)
+	})
+	if len(wallets) > limit {
+		wallets = wallets[:limit]
+	}
+	return wallets, nil
+}
+
+func (s *Wallets) Anonymize(ctx context.Context, id uuid.UUID, phone string, at time.Time) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	row.Phone = phone
+	row.AnonymizedAt = &at
+	s.db.wallets[id] = row
+	s.movePhone(id, phone)
+	delete(s.db.notificationPreferences, id)
+	return nil
+}
+
+func (s *Wallets) List(ctx context.Context, after uuid.UUID, limit int) ([]*models.Wallet, error) {
+	defer s.db.lock(ctx)()
+
+	var wallets []*models.Wallet
+	for _, row := range s.db.wallets {
+		if bytes.Compare(row.ID[:], after[:]) > 0 {
+			row := row
+			wallets = append(wallets, &row)
+		}
+	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return bytes.Compare(wallets[i].ID[:], wallets[j].ID[:]) < 0
+	})
+	if len(wallets) > limit {
+		wallets = wallets[:limit]
+	}
+	return wallets, nil
+}
+
+func (s *Wallets) UpdatePhone(ctx context.Context, id uuid.UUID, phone string) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	for _, other := range s.db.wallets {
+		if other.ID != id && other.Phone == phone {
+			return errors.ErrWalletExists
+		}
+	}
+	row.Phone = phone
+	s.db.wallets[id] = row
+	s.movePhone(id, phone)
+	return nil
+}
+
+func (s *Wallets) MarkDuplicate(ctx context.Context, id, of uuid.UUID, phone string) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	row.DuplicateOf = &of
+	s.db.wallets[id] = row
+	s.movePhone(id, phone)
+	return nil
+}
+
+func (s *Wallets) Merge(ctx context.Context, id, into uuid.UUID, number, phone string) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	for transactionID, transaction := range s.db.transactions {
+		if transaction.WalletID == id {
+			transaction.WalletID = into
+			s.db.transactions[transactionID] = transaction
+		}
+	}
+	for usageID, usage := range s.db.discountTransactions {
+		if usage.WalletID == id {
+			usage.WalletID, usage.PhoneNum = into, number
+			s.db.discountTransactions[usageID] = usage
+		}
+	}
+	for notificationID, notification := range s.db.notifications {
+		if notification.WalletID == id {
+			notification.WalletID = into
+			s.db.notifications[notificationID] = notification
+		}
+	}
+	row.DuplicateOf = &into
+	row.Phone = phone
+	s.db.wallets[id] = row
+	return nil
+}
+
+// movePhone sets the phone number of the discount usages of the wallet. The lock must be held.
+func (s *Wallets) movePhone(id uuid.UUID, phone string) {
+	for usageID, usage := range s.db.discountTransactions {
+		if usage.WalletID == id {
+			usage.PhoneNum = phone
+			s.db.discountTransactions[usageID] = usage
+		}
+	}
+}
+
+func (s *Wallets) FindByPhone(ctx context.Context, phone string) (*models.Wallet, error) {
+	defer s.db.lock(ctx)()
+
+	for _, row := range s.db.wallets {
+		if row.Phone == phone {
+			return &row, nil
+		}
+	}
+	return nil, errors.ErrWalletNotFound
+}
+
+func (s *Wallets) FindByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return nil, errors.ErrWalletNotFound
+	}
+	return &row, nil
+}
+
+// Lock returns the wallet. Transactions already hold the database lock, so no row lock is needed.
+func (s *Wallets) Lock(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	return s.FindByID(ctx, id)
+}
+
+func (s *Wallets) FindLimits(ctx context.Context, walletID uuid.UUID) (*models.WalletLimits, error) {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.walletLimits[walletID]
+	if !ok {
+		return nil, nil
+	}
+	return &row, nil
+}
+
+func (s *Wallets) SaveLimits(ctx context.Context, limits *models.WalletLimits) error {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.wallets[limits.WalletID]; !ok {
+		return errors.ErrWalletNotFound
+	}
+	limits.UpdatedAt = time.Now()
+	s.db.walletLimits[limits.WalletID] = *limits
+	return nil
+}
+
+func (s *Wallets) UpdateTier(ctx context.Context, id uuid.UUID, tier models.WalletTier) error {
+	defer s.db.lock(ctx)()
+
+	row, ok := s.db.wallets[id]
+	if !ok {
+		return errors.ErrWalletNotFound
+	}
+	row.Tier = tier
+	row.UpdatedAt = time.Now()
+	s.db.wallets[id] = row
+	return nil
+}
+
+func (s *Wallets) AddTierChange(ctx context.Context, change *models.WalletTierChange) error {
+	defer s.db.lock(ctx)()
+
+	if change.ID == uuid.Nil {
+		change.ID = uuid.New()
+	}
+	if change.CreatedAt.IsZero() {
+		change.CreatedAt = time.Now()
+	}
+	s.db.tierChanges[change.ID] = *change
+	return nil
+}
+
+func (s *Wallets) TierChanges(ctx context.Context, walletID uuid.UUID) ([]*models.WalletTierChange, error) {
+	defer s.db.lock(ctx)()
+
+	changes := make([]*models.WalletTierChange, 0)
+	for _, row := range s.db.tierChanges {
+		if row.WalletID == walletID {
+			row := row
+			changes = append(changes, &row)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].CreatedAt.Before(changes[j].CreatedAt)
+	})
+	return changes, nil
+}
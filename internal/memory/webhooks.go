@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"sort"
+	"time"
+)
+
+var (
+	errSubscriptionNotFound = errors.ErrNotFound.WithMessage("webhook subscription not found")
+	errDeliveryNotFound     = errors.ErrNotFound.WithMessage("webhook delivery not found")
+)
+
+// Webhooks is an in-memory implementation of webhooks.Store.
+type Webhooks struct {
+	db *DB
+}
+
+// NewWebhooks creates a webhook store on top of db.
+func NewWebhooks(db *DB) *Webhooks {
+	return &Webhooks{db}
+}
+
+func (s *Webhooks) AddSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	defer s.db.lock(ctx)()
+
+	if subscription.ID == uuid.Nil {
+		subscription.ID = uuid.New()
+	}
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = time.Now()
+	}
+	s.db.subscriptions[subscription.ID] = *subscription
+	return nil
+}
+
+func (s *Webhooks) Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	return s.subscriptions(ctx, func(*models.WebhookSubscription) bool { return true }), nil
+}
+
+func (s *Webhooks) FindSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	defer s.db.lock(ctx)()
+
+	subscription, ok := s.db.subscriptions[id]
+	if !ok {
+		return nil, errSubscriptionNotFound
+	}
+	return &subscription, nil
+}
+
+func (s *Webhooks) Deactivate(ctx context.Context, id uuid.UUID) error {
+	defer s.db.lock(ctx)()
+
+	subscription, ok := s.db.subscriptions[id]
+	if !ok {
+		return errSubscriptionNotFound
+	}
+	subscription.Active = false
+	s.db.subscriptions[id] = subscription
+	return nil
+}
+
+func (s *Webhooks) Subscribers(ctx context.Context, eventType string) ([]*models.WebhookSubscription, error) {
+	return s.subscriptions(ctx, func(subscription *models.WebhookSubscription) bool {
+		return subscription.Active && subscription.EventTypes.Contains(eventType)
+	}), nil
+}
+
+func (s *Webhooks) AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	defer s.db.lock(ctx)()
+
+	for _, existing := range s.db.deliveries {
+		if existing.SubscriptionID == delivery.SubscriptionID && existing.EventID == delivery.EventID {
+			return nil
+		}
+	}
+	if delivery.ID == uuid.Nil {
+		delivery.ID = uuid.New()
+	}
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now()
+	}
+	s.db.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+// Due returns the pending deliveries due at now. Deliverers run in transactions, which already
+// hold the database lock.
+func (s *Webhooks) Due(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	deliveries := s.deliveries(ctx, func(delivery *models.WebhookDelivery) bool {
+		return delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now)
+	})
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *Webhooks) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	defer s.db.lock(ctx)()
+
+	if _, ok := s.db.deliveries[delivery.ID]; !ok {
+		return errDeliveryNotFound
+	}
+	s.db.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *Webhooks) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, lease uuid.UUID) (bool, error) {
+	defer s.db.lock(ctx)()
+
+	stored, ok := s.db.deliveries[delivery.ID]
+	if !ok {
+		return false, errDeliveryNotFound
+	}
+	if stored.Lease == nil || *stored.Lease != lease {
+		return false, nil
+	}
+	stored.Status, stored.Attempts, stored.NextAttemptAt = delivery.Status, delivery.Attempts, delivery.NextAttemptAt
+	stored.LastAttemptAt, stored.LastStatusCode, stored.LastError = delivery.LastAttemptAt, delivery.LastStatusCode, delivery.LastError
+	stored.DeliveredAt, stored.Lease = delivery.DeliveredAt, nil
+	s.db.deliveries[delivery.ID] = stored
+	return true, nil
+}
+
+func (s *Webhooks) FindDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	defer s.db.lock(ctx)()
+
+	delivery, ok := s.db.deliveries[id]
+	if !ok {
+		return nil, errDeliveryNotFound
+	}
+	return &delivery, nil
+}
+
+func (s *Webhooks) Deliveries(ctx context.Context, subscriptionID uuid.UUID, status models.DeliveryStatus, limit int) ([]*models.WebhookDelivery, error) {
+	deliveries := s.deliveries(ctx, func(delivery *models.WebhookDelivery) bool {
+		return delivery.SubscriptionID == subscriptionID && (status == "" || delivery.Status == status)
+	})
+	for i, j := 0, len(deliveries)-1; i < j; i, j = i+1, j-1 {
+		deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+	}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// subscriptions returns the subscriptions matching keep, oldest first.
+func (s *Webhooks) subscriptions(ctx context.Context, keep func(*models.WebhookSubscription) bool) []*models.WebhookSubscription {
+	defer s.db.lock(ctx)()
+
+	subscriptions := make([]*models.WebhookSubscription, 0)
+	for _, subscription := range s.db.subscriptions {
+		subscription := subscription
+		if keep(&subscription) {
+			subscriptions = append(subscriptions, &subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions
+}
+
+// deliveries returns the deliveries matching keep, oldest first.
+func (s *Webhooks) deliveries(ctx context.Context, keep func(*models.WebhookDelivery) bool) []*models.WebhookDelivery {
+	defer s.db.lock(ctx)()
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for _, delivery := range s.db.deliveries {
+		delivery := delivery
+		if keep(&delivery) {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
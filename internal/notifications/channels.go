@@ -0,0 +1,166 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"payment/api/models"
+	"payment/internal/otp"
+	"payment/pkg/config"
+	"sync"
+	"time"
+)
+
+// Message is a notification ready to be sent. To is the phone number for SMS and the address
+// for email; webhook messages carry the wallet ID instead.
+type Message struct {
+	ID        uuid.UUID `json:"id"`
+	WalletID  uuid.UUID `json:"wallet_id"`
+	EventType string    `json:"event_type"`
+	Locale    string    `json:"locale"`
+	To        string    `json:"to,omitempty"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+}
+
+// Channel sends messages to wallet owners. A message whose Send fails is retried, so
+// channels must tolerate duplicates and can use the message ID to skip them.
+type Channel interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// NewChannels creates the channels enabled in c. SMS messages are sent by sms.
+func NewChannels(c config.NotificationsConfig, sms otp.SMSSender, logger *log.Logger) (map[models.NotificationChannel]Channel, error) {
+	channels := make(map[models.NotificationChannel]Channel)
+	if c.SMS {
+		channels[models.NotificationSMS] = NewSMSChannel(sms)
+	}
+	if c.Email {
+		if c.EmailFile == "" {
+			channels[models.NotificationEmail] = NewLogChannel(logger)
+		} else {
+			file, err := NewFileChannel(c.EmailFile)
+			if err != nil {
+				return nil, err
+			}
+			channels[models.NotificationEmail] = file
+		}
+	}
+	if c.WebhookURL != "" {
+		channels[models.NotificationWebhook] = NewWebhookChannel(c.WebhookURL, &http.Client{Timeout: 10 * time.Second})
+	}
+	return channels, nil
+}
+
+// SMSChannel sends the body of messages as SMS.
+type SMSChannel struct {
+	sender otp.SMSSender
+}
+
+// NewSMSChannel creates a channel that sends messages with sender.
+func NewSMSChannel(sender otp.SMSSender) *SMSChannel {
+	return &SMSChannel{sender}
+}
+
+func (c *SMSChannel) Send(ctx context.Context, message *Message) error {
+	return c.sender.Send(ctx, message.To, message.Body)
+}
+
+// LogChannel writes every message to the log instead of sending it, for local development.
+// The recipient is left out.
+type LogChannel struct {
+	logger *log.Logger
+}
+
+// NewLogChannel creates a channel that logs messages with logger.
+func NewLogChannel(logger *log.Logger) *LogChannel {
+	return &LogChannel{logger}
+}
+
+func (c *LogChannel) Send(_ context.Context, message *Message) error {
+	c.logger.WithFields(log.Fields{
+		"section":      "notifications",
+		"notification": message.ID,
+		"wallet_id":    message.WalletID,
+		"event_type":   message.EventType,
+		"subject":      message.Subject,
+		"body":         message.Body,
+	}).Info("notification sent")
+	return nil
+}
+
+// FileChannel appends every message to a file as one JSON object per line, for local
+// development and tests.
+type FileChannel struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileChannel opens path for appending, creating it when it does not exist.
+func NewFileChannel(path string) (*FileChannel, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileChannel{file: file}, nil
+}
+
+func (c *FileChannel) Send(_ context.Context, message *Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = c.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+// Close closes the file.
+func (c *FileChannel) Close() error {
+	return c.file.Close()
+}
+
+// WebhookChannel posts every message as JSON to a URL, such as a push notification gateway.
+// Any status other than 2xx is a failure.
+type WebhookChannel struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookChannel creates a channel that posts messages to url with client.
+func NewWebhookChannel(url string, client *http.Client) *WebhookChannel {
+	return &WebhookChannel{url: url, client: client}
+}
+
+func (c *WebhookChannel) Send(ctx context.Context, message *Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
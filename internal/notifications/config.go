@@ -0,0 +1,80 @@
+package notifications
+
+import (
+	"payment/api/models"
+	"payment/pkg/config"
+	"time"
+)
+
+// Defaults used for the settings that are not configured.
+const (
+	DefaultLocale      = "en"
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 30 * time.Second
+	DefaultBatch       = 50
+	DefaultLease       = 10 * time.Minute
+)
+
+type Config struct {
+	AuthToken string
+}
+
+// NewConfig extracts the notification settings that apply without a restart from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{AuthToken: c.Token}
+}
+
+// Settings select the channels messages are sent through and how failed messages are retried.
+type Settings struct {
+	// Channels are the enabled channels; every event is sent through each of them.
+	Channels      []models.NotificationChannel
+	DefaultLocale string
+	// MaxAttempts is the number of attempts after which a message has failed.
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt. It doubles with every further failure.
+	Backoff time.Duration
+	// Batch bounds how many messages are claimed at a time.
+	Batch int
+	// Lease is how long claimed messages are held back from other dispatchers while they are
+	// being sent.
+	Lease time.Duration
+}
+
+// NewSettings extracts the notification settings from the service configuration. Settings left
+// at zero take their default.
+func NewSettings(c *config.Config) Settings {
+	settings := Settings{
+		DefaultLocale: c.Notifications.DefaultLocale,
+		MaxAttempts:   c.Notifications.MaxAttempts,
+		Backoff:       c.Notifications.Backoff,
+	}
+	if c.Notifications.SMS {
+		settings.Channels = append(settings.Channels, models.NotificationSMS)
+	}
+	if c.Notifications.Email {
+		settings.Channels = append(settings.Channels, models.NotificationEmail)
+	}
+	if c.Notifications.WebhookURL != "" {
+		settings.Channels = append(settings.Channels, models.NotificationWebhook)
+	}
+	return settings
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.DefaultLocale == "" {
+		s.DefaultLocale = DefaultLocale
+	}
+	if s.MaxAttempts <= 0 {
+		s.MaxAttempts = DefaultMaxAttempts
+	}
+	if s.Backoff <= 0 {
+		s.Backoff = DefaultBackoff
+	}
+	if s.Batch <= 0 {
+		s.Batch = DefaultBatch
+	}
+	if s.Lease <= 0 {
+		s.Lease = DefaultLease
+	}
+	return s
+}
@@ -0,0 +1,202 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/wallets"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+// maxError bounds the length of the error kept on a notification.
+const maxError = 500
+
+// maxBackoff bounds the delay between two attempts.
+const maxBackoff = time.Hour
+
+// Dispatcher sends due notifications through their channels.
+type Dispatcher struct {
+	store      Store
+	wallets    wallets.Store
+	transactor db.Transactor
+	channels   map[models.NotificationChannel]Channel
+	logger     *log.Logger
+	settings   Settings
+}
+
+// NewDispatcher creates a dispatcher that sends notifications through channels, looking up
+// their recipients in walletStore. Settings left at zero take their default.
+func NewDispatcher(logger *log.Logger, store Store, walletStore wallets.Store, transactor db.Transactor,
+	channels map[models.NotificationChannel]Channel, settings Settings) *Dispatcher {
+	return &Dispatcher{
+		store:      store,
+		wallets:    walletStore,
+		transactor: transactor,
+		channels:   channels,
+		logger:     logger,
+		settings:   settings.withDefaults(),
+	}
+}
+
+// Run sends due notifications immediately and then every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := d.Dispatch(ctx, time.Now()); err != nil {
+			d.logger.WithError(err).WithField("attempted", n).Error("notification dispatch failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch attempts the notifications due at now and returns how many were attempted. A failed
+// attempt is not an error: the notification is scheduled again, or marked failed once it has
+// used all its attempts.
+//
+// Notifications are claimed in one transaction, and the outcome of each attempt is recorded on its
+// own right after it, so that no transaction is held open while channels send, and a message that
+// was sent is not sent again because a later one could not be recorded. A notification whose
+// outcome is never recorded, because the dispatcher stopped, is attempted again once its lease expires.
+func (d *Dispatcher) Dispatch(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		notifications, lease, err := d.claim(ctx, now)
+		if err != nil {
+			return total, err
+		}
+		for _, notification := range notifications {
+			d.attempt(ctx, notification, now)
+			if _, err = d.store.RecordAttempt(ctx, notification, lease); err != nil {
+				return total, err
+			}
+			total++
+		}
+		if len(notifications) < d.settings.Batch {
+			return total, nil
+		}
+	}
+}
+
+// claim leases a batch of the notifications due at now: it moves their next attempt past the lease
+// and marks them with the returned lease, under which their outcomes are recorded.
+func (d *Dispatcher) claim(ctx context.Context, now time.Time) ([]*models.Notification, uuid.UUID, error) {
+	var notifications []*models.Notification
+	lease := uuid.New()
+	err := d.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if notifications, err = d.store.Due(ctx, now, d.settings.Batch); err != nil {
+			return err
+		}
+		for _, notification := range notifications {
+			notification.NextAttemptAt, notification.Lease = now.Add(d.settings.Lease), &lease
+			if err = d.store.UpdateNotification(ctx, notification); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return notifications, lease, nil
+}
+
+// attempt sends notification and records the outcome on it. A notification that cannot be
+// prepared, because its wallet or preferences cannot be read, counts as a failed attempt like one
+// its channel refused, so that it is retried later without holding back the rest of the batch.
+func (d *Dispatcher) attempt(ctx context.Context, notification *models.Notification, now time.Time) {
+	message, reason, err := d.prepare(ctx, notification)
+	if reason != "" {
+		notification.Status, notification.LastError = models.NotificationSkipped, reason
+		return
+	}
+
+	notification.Attempts++
+	notification.LastAttemptAt = &now
+	if err == nil {
+		if channel, ok := d.channels[notification.Channel]; !ok {
+			err = fmt.Errorf("the %s channel is not enabled", notification.Channel)
+		} else {
+			err = channel.Send(ctx, message)
+		}
+	}
+	if err == nil {
+		notification.Status, notification.SentAt, notification.LastError = models.NotificationSent, &now, ""
+		return
+	}
+
+	notification.LastError = err.Error()
+	if len(notification.LastError) > maxError {
+		notification.LastError = notification.LastError[:maxError]
+	}
+	fields := log.Fields{
+		"section":      "notifications",
+		"notification": notification.ID,
+		"channel":      notification.Channel,
+		"event_id":     notification.EventID,
+		"attempts":     notification.Attempts,
+	}
+	// A wallet that does not exist will not appear on a later attempt.
+	if notification.Attempts >= d.settings.MaxAttempts || errors.Is(err, errors.ErrWalletNotFound) {
+		notification.Status = models.NotificationFailed
+		d.logger.WithFields(fields).WithError(err).Error("notification failed")
+		return
+	}
+	notification.NextAttemptAt = now.Add(d.backoff(notification.Attempts))
+	d.logger.WithFields(fields).WithError(err).Warn("notification failed, will retry")
+}
+
+// prepare addresses the message of notification to its recipient, or returns why it is skipped.
+// The preferences are read again, so that opting out also holds back the notifications not sent yet.
+func (d *Dispatcher) prepare(ctx context.Context, notification *models.Notification) (*Message, string, error) {
+	wallet, err := d.wallets.FindByID(ctx, notification.WalletID)
+	if err != nil {
+		return nil, "", err
+	}
+	if wallet.AnonymizedAt != nil {
+		return nil, reasonAnonymized, nil
+	}
+	preferences, err := d.store.FindPreferences(ctx, wallet.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if reason := skip(preferences, notification.Channel); reason != "" {
+		return nil, reason, nil
+	}
+
+	message := &Message{
+		ID:        notification.ID,
+		WalletID:  wallet.ID,
+		EventType: notification.EventType,
+		Locale:    notification.Locale,
+		Subject:   notification.Subject,
+		Body:      notification.Body,
+	}
+	switch notification.Channel {
+	case models.NotificationSMS:
+		message.To = wallet.Phone
+	case models.NotificationEmail:
+		message.To = preferences.Email
+	}
+	return message, "", nil
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.settings.Backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, max(maxBackoff, d.settings.Backoff))
+}
@@ -0,0 +1,152 @@
+package notifications
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"payment/api/models"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/openapi"
+	"payment/pkg/phone"
+	"payment/pkg/utils"
+	"strconv"
+)
+
+// Page sizes of the delivery log endpoint.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the notification preferences and delivery log of wallets.
+type Handler struct {
+	Service   *Service
+	Logger    *logrus.Logger
+	Validator *validator.Validate
+	Config    *config.Value[Config]
+	Keys      auth.Keys
+}
+
+// NewHandler initializes a new Handler for notifications, which accepts the configured token and
+// the API keys of keys.
+func NewHandler(service *Service, logger *logrus.Logger, validate *validator.Validate, settings *config.Value[Config],
+	keys auth.Keys) *Handler {
+	return &Handler{Service: service, Logger: logger, Validator: validate, Config: settings, Keys: keys}
+}
+
+// RegisterRoutes registers the notification routes with the provided router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	protected := auth.AuthMiddleware(func() string { return h.Config.Load().AuthToken }, h.Keys)
+	walletRoutes := router.PathPrefix("/wallet/{phoneNumber}/notifications").Subrouter()
+	preferences := map[int]interface{}{http.StatusOK: models.NotificationPreferences{}}
+
+	openapi.Describe(walletRoutes.HandleFunc("", protected(h.notificationsHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary: "List the notifications of a wallet and their delivery status, newest first",
+		Tag:     "notifications",
+		Secured: true,
+		Query: []openapi.Parameter{
+			{Name: "status", Enum: []string{string(models.NotificationPending), string(models.NotificationSent),
+				string(models.NotificationFailed), string(models.NotificationSkipped)}},
+			{Name: "limit", Type: "integer", Format: "int32", Description: "between 1 and " + strconv.Itoa(maxLimit)},
+		},
+		Responses: map[int]interface{}{http.StatusOK: []models.Notification{}},
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/preferences", protected(h.preferencesHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Get the notification preferences of a wallet",
+		Tag:       "notifications",
+		Secured:   true,
+		Responses: preferences,
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/preferences", protected(h.setPreferencesHandler)).Methods(http.MethodPut), openapi.Operation{
+		Summary:   "Replace the notification preferences of a wallet",
+		Tag:       "notifications",
+		Secured:   true,
+		Request:   models.NotificationPreferencesRequest{},
+		Responses: preferences,
+	})
+}
+
+// notificationsHandler returns the delivery log of a wallet, newest first, optionally filtered
+// by status.
+func (h *Handler) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	status := models.NotificationStatus(query.Get("status"))
+	switch status {
+	case "", models.NotificationPending, models.NotificationSent, models.NotificationFailed, models.NotificationSkipped:
+	default:
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("status must be pending, sent, failed or skipped"))
+		return
+	}
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxLimit {
+			errors.Respond(w, errors.ErrBadRequest.WithMessage("limit must be between 1 and "+strconv.Itoa(maxLimit)))
+			return
+		}
+	}
+
+	notifications, err := h.Service.Notifications(r.Context(), phoneNumber, status, limit)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, notifications)
+}
+
+// preferencesHandler returns the notification preferences of a wallet.
+func (h *Handler) preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	preferences, err := h.Service.Preferences(r.Context(), phoneNumber)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, preferences)
+}
+
+// setPreferencesHandler replaces the notification preferences of a wallet.
+func (h *Handler) setPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+	var request models.NotificationPreferencesRequest
+	if err = utils.DecodeJSON(w, r, h.Validator, &request); err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	preferences, err := h.Service.SetPreferences(r.Context(), phoneNumber, &request)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, preferences)
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,218 @@
+// Package notifications tells wallet owners about changes to their balance. The service is an
+// events.Publisher: the outbox relay hands it every event, and it renders the message about a
+// discount redemption or a withdrawal from the template of the event type in the owner's
+// locale, recording one notification per enabled channel. The Dispatcher then sends them and
+// retries those that fail. Owners can opt out of channels.
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
+	"payment/internal/wallets"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
+)
+
+// Reasons a notification is skipped.
+const (
+	reasonOptedOut   = "the owner opted out of the channel"
+	reasonNoEmail    = "the wallet has no email address"
+	reasonAnonymized = "the wallet is anonymized"
+)
+
+// Service records the notifications of published events and manages the preferences of wallets.
+type Service struct {
+	store      Store
+	wallets    wallets.Store
+	transactor db.Transactor
+	auditor    audit.Recorder
+	settings   Settings
+	logger     *log.Logger
+}
+
+// NewService creates the notification service on top of store, which finds the wallets of
+// events in walletStore. Changes to preferences are recorded by auditor.
+func NewService(logger *log.Logger, store Store, walletStore wallets.Store, transactor db.Transactor,
+	auditor audit.Recorder, settings Settings) *Service {
+	return &Service{
+		store:      store,
+		wallets:    walletStore,
+		transactor: transactor,
+		auditor:    auditor,
+		settings:   settings.withDefaults(),
+		logger:     logger,
+	}
+}
+
+// preferencesState is what the audit log keeps of the preferences of a wallet. The address is
+// left out, like phone numbers are.
+type preferencesState struct {
+	Locale   string                      `json:"locale,omitempty"`
+	HasEmail bool                        `json:"has_email"`
+	OptOut   models.NotificationChannels `json:"opt_out"`
+}
+
+func stateOf(preferences *models.NotificationPreferences) *preferencesState {
+	if preferences == nil {
+		return nil
+	}
+	return &preferencesState{Locale: preferences.Locale, HasEmail: preferences.Email != "", OptOut: preferences.OptOut}
+}
+
+// Preferences returns the preferences of the wallet of phone.
+func (s *Service) Preferences(ctx context.Context, phone string) (*models.NotificationPreferences, error) {
+	wallet, err := s.wallets.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := s.store.FindPreferences(ctx, wallet.ID)
+	if err != nil || preferences != nil {
+		return preferences, err
+	}
+	return &models.NotificationPreferences{WalletID: wallet.ID, OptOut: models.NotificationChannels{}}, nil
+}
+
+// SetPreferences replaces the preferences of the wallet of phone. Notifications already
+// recorded are skipped when they are sent through a channel the owner has since opted out of.
+func (s *Service) SetPreferences(ctx context.Context, phone string, request *models.NotificationPreferencesRequest) (*models.NotificationPreferences, error) {
+	preferences := &models.NotificationPreferences{
+		Locale:    request.Locale,
+		Email:     request.Email,
+		OptOut:    models.NotificationChannels{},
+		UpdatedAt: time.Now(),
+	}
+	for _, channel := range request.OptOut {
+		if !preferences.OptOut.Contains(channel) {
+			preferences.OptOut = append(preferences.OptOut, channel)
+		}
+	}
+
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		wallet, err := s.wallets.FindByPhone(ctx, phone)
+		if err != nil {
+			return err
+		}
+		previous, err := s.store.FindPreferences(ctx, wallet.ID)
+		if err != nil {
+			return err
+		}
+		preferences.WalletID = wallet.ID
+		if err = s.store.SavePreferences(ctx, preferences); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, audit.Entry{
+			Action:     audit.ActionWalletNotifications,
+			EntityType: audit.EntityWallet,
+			EntityID:   wallet.ID.String(),
+			Before:     stateOf(previous),
+			After:      stateOf(preferences),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+// Notifications returns the delivery log of the wallet of phone, newest first.
+func (s *Service) Notifications(ctx context.Context, phone string, status models.NotificationStatus, limit int) ([]*models.Notification, error) {
+	wallet, err := s.wallets.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Notifications(ctx, wallet.ID, status, limit)
+}
+
+// Publish records a notification of event through every enabled channel when the event credits
+// a wallet by a discount or debits it. It is called by the outbox relay in its transaction, and
+// may be called again for the same event.
+func (s *Service) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	if len(s.settings.Channels) == 0 {
+		return nil
+	}
+
+	var walletID uuid.UUID
+	var data interface{}
+	switch event.Type {
+	case events.DiscountRedeemed:
+		var payload events.Redemption
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return errors.ErrInternal.WithMessage("could not decode %s event", event.Type).Wrap(err)
+		}
+		walletID, data = payload.WalletID, &payload
+	case events.TransactionCompleted:
+		var payload events.Transaction
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return errors.ErrInternal.WithMessage("could not decode %s event", event.Type).Wrap(err)
+		}
+		// Deposits by discounts are announced by discount.redeemed; other deposits are not notified.
+		if payload.Type != models.Withdrawal {
+			return nil
+		}
+		walletID, data = payload.WalletID, &payload
+	default:
+		return nil
+	}
+
+	preferences, err := s.store.FindPreferences(ctx, walletID)
+	if err != nil {
+		return err
+	}
+	locale := s.settings.DefaultLocale
+	if preferences != nil && preferences.Locale != "" {
+		locale = preferences.Locale
+	}
+	locale, subject, body, err := render(event.Type, locale, s.settings.DefaultLocale, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, channel := range s.settings.Channels {
+		notification := &models.Notification{
+			EventID:       event.ID,
+			EventType:     event.Type,
+			WalletID:      walletID,
+			Channel:       channel,
+			Locale:        locale,
+			Subject:       subject,
+			Body:          body,
+			Status:        models.NotificationPending,
+			NextAttemptAt: now,
+		}
+		notification.CreatedAt = now
+		if reason := skip(preferences, channel); reason != "" {
+			notification.Status, notification.LastError = models.NotificationSkipped, reason
+		}
+		if err = s.store.AddNotification(ctx, notification); err != nil {
+			return err
+		}
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section":    "notifications",
+		"event_id":   event.ID,
+		"event_type": event.Type,
+		"wallet_id":  walletID,
+	}).Debug("notifications recorded")
+	return nil
+}
+
+// skip returns why a notification through channel is not sent to the wallet with preferences,
+// or an empty string when it is.
+func skip(preferences *models.NotificationPreferences, channel models.NotificationChannel) string {
+	if preferences != nil && preferences.OptOut.Contains(channel) {
+		return reasonOptedOut
+	}
+	if channel == models.NotificationEmail && (preferences == nil || preferences.Email == "") {
+		return reasonNoEmail
+	}
+	return ""
+}
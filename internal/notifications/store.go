@@ -0,0 +1,127 @@
+package notifications
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+// Store persists the notification preferences of wallets and the notifications sent to them.
+type Store interface {
+	// FindPreferences returns the preferences of the wallet, or nil when it has none.
+	FindPreferences(ctx context.Context, walletID uuid.UUID) (*models.NotificationPreferences, error)
+	// SavePreferences replaces the preferences of the wallet.
+	SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error
+	// AddNotification adds a notification unless the wallet already has one for the same event
+	// and channel.
+	AddNotification(ctx context.Context, notification *models.Notification) error
+	// Due returns up to limit pending notifications whose next attempt is due at now, oldest first.
+	// They stay locked until the surrounding transaction ends, so that concurrent dispatchers skip them.
+	Due(ctx context.Context, now time.Time, limit int) ([]*models.Notification, error)
+	UpdateNotification(ctx context.Context, notification *models.Notification) error
+	// RecordAttempt stores the outcome of an attempt made under lease, and clears the lease. It
+	// changes nothing and reports false when the notification is no longer held by lease.
+	RecordAttempt(ctx context.Context, notification *models.Notification, lease uuid.UUID) (bool, error)
+	// Notifications returns up to limit notifications of the wallet, newest first. An empty
+	// status matches every notification.
+	Notifications(ctx context.Context, walletID uuid.UUID, status models.NotificationStatus, limit int) ([]*models.Notification, error)
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) FindPreferences(ctx context.Context, walletID uuid.UUID) (*models.NotificationPreferences, error) {
+	var preferences models.NotificationPreferences
+	if err := s.db.Conn(ctx).Where("wallet_id = ?", walletID).First(&preferences).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &preferences, nil
+}
+
+func (s *store) SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	err := s.db.Conn(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "wallet_id"}}, UpdateAll: true}).
+		Create(preferences).Error
+	if err != nil {
+		return errors.ErrInternal.WithMessage("could not save notification preferences").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) AddNotification(ctx context.Context, notification *models.Notification) error {
+	err := s.db.Conn(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "event_id"}, {Name: "wallet_id"}, {Name: "channel"}},
+			DoNothing: true,
+		}).
+		Create(notification).Error
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Due(ctx context.Context, now time.Time, limit int) ([]*models.Notification, error) {
+	notifications := make([]*models.Notification, 0)
+	err := s.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", models.NotificationPending, now).
+		Order("next_attempt_at, created_at").
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return notifications, nil
+}
+
+func (s *store) UpdateNotification(ctx context.Context, notification *models.Notification) error {
+	if err := s.db.Conn(ctx).Save(notification).Error; err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) RecordAttempt(ctx context.Context, notification *models.Notification, lease uuid.UUID) (bool, error) {
+	result := s.db.Conn(ctx).Model(&models.Notification{}).
+		Where("id = ? AND lease = ?", notification.ID, lease).
+		Updates(map[string]interface{}{
+			"status":          notification.Status,
+			"attempts":        notification.Attempts,
+			"next_attempt_at": notification.NextAttemptAt,
+			"last_attempt_at": notification.LastAttemptAt,
+			"last_error":      notification.LastError,
+			"sent_at":         notification.SentAt,
+			"lease":           nil,
+		})
+	if result.Error != nil {
+		return false, errors.ErrInternal.Wrap(result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *store) Notifications(ctx context.Context, walletID uuid.UUID, status models.NotificationStatus, limit int) ([]*models.Notification, error) {
+	query := s.db.Conn(ctx).Where("wallet_id = ?", walletID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	notifications := make([]*models.Notification, 0)
+	if err := query.Order("created_at DESC").Limit(limit).Find(&notifications).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return notifications, nil
+}
@@ -0,0 +1,65 @@
+package notifications
+
+import (
+	"payment/internal/events"
+	"payment/pkg/errors"
+	"strings"
+	"text/template"
+)
+
+// Locales the templates are written in.
+const (
+	LocaleEnglish = "en"
+	LocalePersian = "fa"
+)
+
+// message is the template of the message about one event type in one locale. The subject is
+// used by the channels that have one, such as email.
+type message struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func parse(subject, body string) message {
+	return message{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// templates holds the message of every notified event type, by locale. transaction.completed
+// is only notified for withdrawals, and is rendered with an events.Transaction;
+// discount.redeemed is rendered with an events.Redemption.
+var templates = map[string]map[string]message{
+	events.TransactionCompleted: {
+		LocaleEnglish: parse("Your wallet was debited",
+			"{{.Amount}} was withdrawn from your wallet{{with .Description}} ({{.}}){{end}}. Your balance is {{.Balance}}."),
+		LocalePersian: parse("برداشت از کیف پول",
+			"مبلغ {{.Amount}} از کیف پول شما برداشت شد{{with .Description}} ({{.}}){{end}}. موجودی شما {{.Balance}} است."),
+	},
+	events.DiscountRedeemed: {
+		LocaleEnglish: parse("Your wallet was credited",
+			"Your wallet was credited with {{.Amount}} by the discount code {{.Code}}."),
+		LocalePersian: parse("واریز به کیف پول",
+			"مبلغ {{.Amount}} با کد تخفیف {{.Code}} به کیف پول شما واریز شد."),
+	},
+}
+
+// render returns the subject and body of the message about an event of eventType in locale,
+// falling back to fallback when there is no template in locale.
+func render(eventType, locale, fallback string, data interface{}) (string, string, string, error) {
+	localized, ok := templates[eventType][locale]
+	if !ok {
+		locale = fallback
+		localized = templates[eventType][locale]
+	}
+
+	var subject, body strings.Builder
+	if err := localized.subject.Execute(&subject, data); err != nil {
+		return "", "", "", errors.ErrInternal.WithMessage("could not render %s message", eventType).Wrap(err)
+	}
+	if err := localized.body.Execute(&body, data); err != nil {
+		return "", "", "", errors.ErrInternal.WithMessage("could not render %s message", eventType).Wrap(err)
+	}
+	return locale, subject.String(), body.String(), nil
+}
@@ -0,0 +1,33 @@
+package notifications
+
+import (
+	"payment/internal/events"
+	"strings"
+	"testing"
+)
+
+func TestEveryTemplateRendersInEveryLocale(t *testing.T) {
+	data := map[string]interface{}{
+		events.TransactionCompleted: &events.Transaction{Amount: 300, Description: "groceries", Balance: 700},
+		events.DiscountRedeemed:     &events.Redemption{Code: "WS9DE6CH", Amount: 500},
+	}
+	for eventType, localized := range templates {
+		for _, locale := range []string{LocaleEnglish, LocalePersian} {
+			if _, ok := localized[locale]; !ok {
+				t.Errorf("%s has no %s template", eventType, locale)
+				continue
+			}
+			got, subject, body, err := render(eventType, locale, DefaultLocale, data[eventType])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != locale || subject == "" || strings.Contains(body, "<no value>") {
+				t.Errorf("got %s message %q, %q in %s, want it rendered in %s", eventType, subject, body, got, locale)
+			}
+		}
+	}
+
+	if locale, _, _, err := render(events.DiscountRedeemed, "de", LocalePersian, data[events.DiscountRedeemed]); err != nil || locale != LocalePersian {
+		t.Fatalf("got locale %q (%v), want the fallback", locale, err)
+	}
+}
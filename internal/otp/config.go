@@ -0,0 +1,92 @@
+package otp
+
+import (
+	"payment/pkg/config"
+	"time"
+)
+
+// Defaults used for the settings that are not configured.
+const (
+	DefaultCodeLength     = 6
+	DefaultCodeTTL        = 5 * time.Minute
+	DefaultMaxAttempts    = 5
+	DefaultResendCooldown = time.Minute
+	DefaultTokenTTL       = 15 * time.Minute
+	DefaultLockout        = time.Hour
+	DefaultClientLimit    = 10
+	DefaultGlobalLimit    = 1000
+	DefaultLimitWindow    = time.Hour
+)
+
+// Operations that can require the proof of phone ownership.
+const (
+	PurposeRegistration = "registration"
+	PurposeDiscount     = "discount"
+)
+
+type Config struct {
+	CodeLength  int
+	CodeTTL     time.Duration
+	MaxAttempts int
+	// ResendCooldown is how long after a code was sent no other is sent to the same number.
+	ResendCooldown time.Duration
+	TokenTTL       time.Duration
+	// Lockout is how long wrong codes are remembered after the last one, and so how long a
+	// number that used up its attempts gets no new code.
+	Lockout time.Duration
+	// ClientLimit is how many codes are sent within LimitWindow to the numbers one client asks
+	// for, and GlobalLimit how many are sent in total.
+	ClientLimit int
+	GlobalLimit int
+	LimitWindow time.Duration
+	// Require lists the purposes that need an ownership token.
+	Require map[string]bool
+}
+
+// NewConfig extracts the one-time code settings from the service configuration. Settings
+// left at zero take their default.
+func NewConfig(c *config.Config) *Config {
+	settings := &Config{
+		CodeLength:     c.OTP.CodeLength,
+		CodeTTL:        c.OTP.CodeTTL,
+		MaxAttempts:    c.OTP.MaxAttempts,
+		ResendCooldown: c.OTP.ResendCooldown,
+		TokenTTL:       c.OTP.TokenTTL,
+		Lockout:        c.OTP.Lockout,
+		ClientLimit:    c.OTP.ClientLimit,
+		GlobalLimit:    c.OTP.GlobalLimit,
+		LimitWindow:    c.OTP.LimitWindow,
+		Require: map[string]bool{
+			PurposeRegistration: c.OTP.RequireForRegistration,
+			PurposeDiscount:     c.OTP.RequireForDiscounts,
+		},
+	}
+	if settings.CodeLength == 0 {
+		settings.CodeLength = DefaultCodeLength
+	}
+	if settings.CodeTTL == 0 {
+		settings.CodeTTL = DefaultCodeTTL
+	}
+	if settings.MaxAttempts == 0 {
+		settings.MaxAttempts = DefaultMaxAttempts
+	}
+	if settings.ResendCooldown == 0 {
+		settings.ResendCooldown = DefaultResendCooldown
+	}
+	if settings.TokenTTL == 0 {
+		settings.TokenTTL = DefaultTokenTTL
+	}
+	if settings.Lockout == 0 {
+		settings.Lockout = DefaultLockout
+	}
+	if settings.ClientLimit == 0 {
+		settings.ClientLimit = DefaultClientLimit
+	}
+	if settings.GlobalLimit == 0 {
+		settings.GlobalLimit = DefaultGlobalLimit
+	}
+	if settings.LimitWindow == 0 {
+		settings.LimitWindow = DefaultLimitWindow
+	}
+	return settings
+}
@@ -0,0 +1,101 @@
+package otp
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"payment/api/models"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/openapi"
+	"payment/pkg/phone"
+	"payment/pkg/utils"
+)
+
+// Handler serves the one-time code routes. They are public, like discount redemption, since
+// they are called by the owner of the phone number.
+type Handler struct {
+	service   *Service
+	logger    *log.Logger
+	validator *validator.Validate
+}
+
+// NewHandler creates the one-time code HTTP handler on top of service.
+func NewHandler(service *Service, logger *log.Logger, validate *validator.Validate) *Handler {
+	return &Handler{service: service, logger: logger, validator: validate}
+}
+
+// RegisterRoutes registers the one-time code routes with the provided router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	otpRoutes := router.PathPrefix("/otp").Subrouter()
+
+	openapi.Describe(otpRoutes.HandleFunc("/request", h.requestHandler).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Send a one-time code to a phone number",
+		Description: "A new code replaces the previous one, and can only be requested once the resend cooldown has passed. " +
+			"The code, and the token it is exchanged for, only prove ownership for the purpose of the request.",
+		Tag:       "otp",
+		Request:   models.OTPRequest{},
+		Responses: map[int]interface{}{http.StatusAccepted: models.OTPChallenge{}},
+	})
+	openapi.Describe(otpRoutes.HandleFunc("/verify", h.verifyHandler).Methods(http.MethodPost), openapi.Operation{
+		Summary: "Exchange a one-time code for an ownership token",
+		Description: "The token is sent in the " + TokenHeader + " header of a request that needs proof of " +
+			"phone ownership for its purpose, and is accepted once before it expires. The response is the only one that carries it.",
+		Tag:       "otp",
+		Request:   models.OTPVerifyRequest{},
+		Responses: map[int]interface{}{http.StatusOK: models.OwnershipProof{}},
+	})
+}
+
+// requestHandler sends a code to the phone number of the request.
+func (h *Handler) requestHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.OTPRequest
+	if err := utils.DecodeJSON(w, r, h.validator, &request); err != nil {
+		errors.Respond(w, err)
+		return
+	}
+	phoneNumber, err := phone.Normalize(request.Phone)
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	challenge, err := h.service.Request(r.Context(), phoneNumber, request.Purpose)
+	if err != nil {
+		logging.FromContext(r.Context(), h.logger).Error(err)
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusAccepted, challenge)
+}
+
+// verifyHandler checks a code and returns the ownership token of its phone number.
+func (h *Handler) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.OTPVerifyRequest
+	if err := utils.DecodeJSON(w, r, h.validator, &request); err != nil {
+		errors.Respond(w, err)
+		return
+	}
+	phoneNumber, err := phone.Normalize(request.Phone)
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	proof, err := h.service.Verify(r.Context(), phoneNumber, request.Code)
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, proof)
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,95 @@
+package otp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"payment/pkg/config"
+	"sync"
+	"time"
+)
+
+// SMSSender delivers a text message to a phone number in E.164 form.
+type SMSSender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+// Senders selectable in the configuration.
+const (
+	SenderConsole = "console"
+	SenderFile    = "file"
+)
+
+// NewSender creates the sender selected by c.
+func NewSender(c config.OTPConfig) (SMSSender, error) {
+	switch c.Sender {
+	case "", SenderConsole:
+		return NewConsoleSender(os.Stdout), nil
+	case SenderFile:
+		return NewFileSender(c.File)
+	default:
+		return nil, fmt.Errorf("unknown otp sender %q", c.Sender)
+	}
+}
+
+// ConsoleSender prints every message, for local development.
+type ConsoleSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewConsoleSender creates a sender that prints messages to w.
+func NewConsoleSender(w io.Writer) *ConsoleSender {
+	return &ConsoleSender{w: w}
+}
+
+func (s *ConsoleSender) Send(_ context.Context, phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "SMS to %s: %s\n", phone, message)
+	return err
+}
+
+// sms is a message as FileSender writes it.
+type sms struct {
+	SentAt  time.Time `json:"sent_at"`
+	Phone   string    `json:"phone"`
+	Message string    `json:"message"`
+}
+
+// FileSender appends every message to a file as one JSON object per line, for local
+// development and tests.
+type FileSender struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSender opens path for appending, creating it when it does not exist.
+func NewFileSender(path string) (*FileSender, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSender{file: file}, nil
+}
+
+func (s *FileSender) Send(_ context.Context, phone, message string) error {
+	line, err := json.Marshal(sms{SentAt: time.Now(), Phone: phone, Message: message})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file.
+func (s *FileSender) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,326 @@
+// Package otp proves that a client owns a phone number. A one-time code is sent to the number
+// by SMS; the client that sends it back receives a short-lived ownership token, which wallet
+// registration and discount redemption can be configured to require. Codes and tokens are bound
+// to one of these purposes, a token is accepted once, and codes and tokens are only stored as
+// hashes.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"payment/api/models"
+	"payment/pkg/config"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
+)
+
+// Clients pass the ownership token of the phone number a request names in this HTTP header,
+// or in the gRPC metadata key of the same name.
+const (
+	TokenHeader   = "X-Phone-Token"
+	TokenMetadata = "x-phone-token"
+)
+
+// tokenPrefix starts every ownership token, so that a leaked one is easy to recognise.
+const tokenPrefix = "own_"
+
+// Service sends and verifies one-time codes, and checks the ownership tokens they are
+// exchanged for. Phone numbers must be normalized by the caller.
+type Service struct {
+	store      Store
+	transactor db.Transactor
+	sender     SMSSender
+	settings   *config.Value[Config]
+	logger     *log.Logger
+	now        func() time.Time
+}
+
+// NewService creates the one-time code service, which sends codes with sender.
+func NewService(logger *log.Logger, store Store, transactor db.Transactor, sender SMSSender,
+	settings *config.Value[Config]) *Service {
+	return &Service{store: store, transactor: transactor, sender: sender, settings: settings, logger: logger, now: time.Now}
+}
+
+// cooldown is the data of ErrOTPCooldown.
+type cooldown struct {
+	ResendAt time.Time `json:"resend_at"`
+}
+
+// attempts is the data of ErrOTPInvalid after a wrong code.
+type attempts struct {
+	AttemptsLeft int `json:"attempts_left"`
+}
+
+// Request sends a new code for purpose to phone, which replaces the code sent before. It fails
+// with ErrOTPCooldown while the previous code is younger than the resend cooldown, with
+// ErrOTPAttempts while the number is locked out after too many wrong codes, and with
+// ErrOTPRateLimited once the client, or all clients together, were sent as many codes as the
+// limits allow. Wrong codes still count against the new code. The message is sent after the code
+// is stored; when it cannot be sent, the code is withdrawn.
+func (s *Service) Request(ctx context.Context, phone, purpose string) (*models.OTPChallenge, error) {
+	settings := s.settings.Load()
+	now := s.now()
+	client := logging.ClientIP(ctx)
+
+	var (
+		challenge *models.OTPChallenge
+		record    *models.OTPCode
+		sentAt    time.Time
+		message   string
+	)
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.store.DeleteExpired(ctx, now); err != nil {
+			return err
+		}
+		var failures int
+		var keepUntil time.Time
+		previous, err := s.store.Lock(ctx, phone)
+		switch {
+		case err == nil:
+			if previous.Attempts >= settings.MaxAttempts {
+				return errors.ErrOTPAttempts.WithData(&cooldown{ResendAt: previous.KeepUntil})
+			}
+			if resendAt := previous.SentAt.Add(settings.ResendCooldown); now.Before(resendAt) {
+				return errors.ErrOTPCooldown.WithData(&cooldown{ResendAt: resendAt})
+			}
+			failures, keepUntil, sentAt = previous.Attempts, previous.KeepUntil, previous.SentAt
+		case !errors.Is(err, errors.ErrNotFound):
+			return err
+		}
+		if err = s.limit(ctx, settings, client, now); err != nil {
+			return err
+		}
+
+		code, err := generate(settings.CodeLength)
+		if err != nil {
+			return err
+		}
+		salt, err := random(16)
+		if err != nil {
+			return err
+		}
+		record = &models.OTPCode{
+			Phone:     phone,
+			Hash:      hashCode(salt, phone, code),
+			Salt:      salt,
+			Purpose:   purpose,
+			Attempts:  failures,
+			SentAt:    now,
+			ExpiresAt: now.Add(settings.CodeTTL),
+			ClientIP:  client,
+		}
+		// The code is kept for the limit window, so that it is counted for as long.
+		record.KeepUntil = later(keepUntil, later(record.ExpiresAt, now.Add(settings.LimitWindow)))
+		if err = s.store.SaveCode(ctx, record); err != nil {
+			return err
+		}
+		challenge = &models.OTPChallenge{Phone: phone, Purpose: purpose, ExpiresAt: record.ExpiresAt,
+			ResendAt: now.Add(settings.ResendCooldown)}
+		message = fmt.Sprintf("Your verification code is %s. It expires in %s.", code, settings.CodeTTL)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section": "otp",
+		"phone":   logging.MaskPhone(phone),
+		"purpose": purpose,
+	})
+	if err = s.sender.Send(ctx, phone, message); err != nil {
+		if err := s.withdraw(ctx, record, sentAt); err != nil {
+			entry.WithError(err).Error("could not withdraw the otp code")
+		}
+		return nil, errors.ErrInternal.WithMessage("could not send the code").Wrap(err)
+	}
+	entry.Info("otp code sent")
+	return challenge, nil
+}
+
+// limit fails with ErrOTPRateLimited when client, or all clients together, were sent as many
+// codes within the limit window as the settings allow.
+func (s *Service) limit(ctx context.Context, settings *Config, client string, now time.Time) error {
+	since := now.Add(-settings.LimitWindow)
+	if client != "" {
+		sent, err := s.store.CountSent(ctx, since, client)
+		if err != nil {
+			return err
+		}
+		if sent >= int64(settings.ClientLimit) {
+			return errors.ErrOTPRateLimited
+		}
+	}
+	sent, err := s.store.CountSent(ctx, since, "")
+	if err != nil {
+		return err
+	}
+	if sent >= int64(settings.GlobalLimit) {
+		return errors.ErrOTPRateLimited
+	}
+	return nil
+}
+
+// withdraw expires record, whose message could not be sent, and moves its sent time back to
+// sentAt, that of the code it replaced, so that another code can be asked for at once and the
+// unsent one is not counted against the limits. Wrong codes stay counted. Nothing changes when
+// the code was replaced meanwhile.
+func (s *Service) withdraw(ctx context.Context, record *models.OTPCode, sentAt time.Time) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		current, err := s.store.Lock(ctx, record.Phone)
+		if errors.Is(err, errors.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current.Hash != record.Hash {
+			return nil
+		}
+		current.SentAt, current.ExpiresAt = sentAt, record.SentAt
+		return s.store.SaveCode(ctx, current)
+	})
+}
+
+// Verify exchanges the code sent to phone for an ownership token for the purpose of the code.
+// A code is used once; after too many wrong codes it fails with ErrOTPAttempts, and the number
+// gets no new code until the lockout has passed.
+func (s *Service) Verify(ctx context.Context, phone, code string) (*models.OwnershipProof, error) {
+	settings := s.settings.Load()
+	now := s.now()
+
+	var proof *models.OwnershipProof
+	var failure error
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		record, err := s.store.Lock(ctx, phone)
+		if errors.Is(err, errors.ErrNotFound) {
+			failure = errors.ErrOTPInvalid
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !now.Before(record.ExpiresAt) {
+			failure = errors.ErrOTPInvalid
+			return nil
+		}
+		if record.Attempts >= settings.MaxAttempts {
+			failure = errors.ErrOTPAttempts
+			return nil
+		}
+		if subtle.ConstantTimeCompare([]byte(hashCode(record.Salt, phone, code)), []byte(record.Hash)) != 1 {
+			// The attempt is counted even though the verification fails, so it must commit.
+			record.Attempts++
+			record.KeepUntil = later(record.KeepUntil, now.Add(settings.Lockout))
+			failure = errors.ErrOTPInvalid.WithData(&attempts{AttemptsLeft: settings.MaxAttempts - record.Attempts})
+			return s.store.SaveCode(ctx, record)
+		}
+
+		if err = s.store.DeleteCode(ctx, phone); err != nil {
+			return err
+		}
+		secret, err := random(32)
+		if err != nil {
+			return err
+		}
+		proof = &models.OwnershipProof{Phone: phone, Purpose: record.Purpose, Token: tokenPrefix + secret,
+			ExpiresAt: now.Add(settings.TokenTTL)}
+		return s.store.AddToken(ctx, &models.OwnershipToken{Hash: hashToken(proof.Token), Phone: phone,
+			Purpose: proof.Purpose, ExpiresAt: proof.ExpiresAt})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section": "otp",
+		"phone":   logging.MaskPhone(phone),
+	})
+	if failure != nil {
+		entry.WithError(failure).Warn("otp verification failed")
+		return nil, failure
+	}
+	entry.Info("otp code verified")
+	return proof, nil
+}
+
+// Require uses the ownership token of phone when the configuration requires one for purpose,
+// one of the Purpose constants.
+func (s *Service) Require(ctx context.Context, purpose, phone, token string) error {
+	if !s.settings.Load().Require[purpose] {
+		return nil
+	}
+	return s.Use(ctx, purpose, phone, token)
+}
+
+// Within runs operation in one transaction with Require, so that the ownership token is only used
+// up when operation succeeds.
+func (s *Service) Within(ctx context.Context, purpose, phone, token string, operation func(ctx context.Context) error) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.Require(ctx, purpose, phone, token); err != nil {
+			return err
+		}
+		return operation(ctx)
+	})
+}
+
+// Use fails with ErrOwnershipRequired unless token was issued for purpose and phone, has not
+// expired and has not been used; otherwise the token is used up.
+func (s *Service) Use(ctx context.Context, purpose, phone, token string) error {
+	if token == "" {
+		return errors.ErrOwnershipRequired.WithMessage("verify the phone number with a one-time code and send the token in %s", TokenHeader)
+	}
+	err := s.store.ConsumeToken(ctx, hashToken(token), phone, purpose, s.now())
+	if errors.Is(err, errors.ErrNotFound) {
+		return errors.ErrOwnershipRequired.WithMessage("ownership token is invalid, expired, used or for another operation")
+	}
+	return err
+}
+
+// later returns the later of a and b.
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// generate returns a random code of n decimal digits.
+func generate(n int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", errors.ErrInternal.Wrap(err)
+	}
+	return fmt.Sprintf("%0*d", n, v), nil
+}
+
+// random returns n random bytes in hex.
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.ErrInternal.Wrap(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashCode returns the hex SHA-256 of a code sent to phone, salted so that equal codes do
+// not hash alike.
+func hashCode(salt, phone, code string) string {
+	sum := sha256.Sum256([]byte(salt + ":" + phone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashToken returns the hex SHA-256 of an ownership token, which is what the store keeps of it.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package otp
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"io"
+	"payment/internal/memory"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// lastMessage is an SMSSender that keeps the last message it sent.
+type lastMessage struct {
+	message string
+}
+
+func (l *lastMessage) Send(_ context.Context, _, message string) error {
+	l.message = message
+	return nil
+}
+
+func newTestService(t *testing.T, clock *time.Time) (*Service, *lastMessage) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := memory.NewDB()
+	sender := &lastMessage{}
+	service := NewService(logger, memory.NewOTP(db), db, sender, config.NewValue(NewConfig(&config.Config{})))
+	service.now = func() time.Time { return *clock }
+	return service, sender
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	service, sender := newTestService(t, &clock)
+	const phone = "+989121234567"
+
+	if _, err := service.Request(ctx, phone, PurposeRegistration); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(DefaultCodeTTL)
+	code := regexp.MustCompile(`\d+`).FindString(sender.message)
+	if _, err := service.Verify(ctx, phone, code); !errors.Is(err, errors.ErrOTPInvalid) {
+		t.Fatalf("got %v verifying an expired code, want %v", err, errors.ErrOTPInvalid)
+	}
+
+	// The expired code no longer holds back a new one.
+	if _, err := service.Request(ctx, phone, PurposeRegistration); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := service.Verify(ctx, phone, regexp.MustCompile(`\d+`).FindString(sender.message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = proof.ExpiresAt
+	if err = service.Use(ctx, PurposeRegistration, phone, proof.Token); !errors.Is(err, errors.ErrOwnershipRequired) {
+		t.Fatalf("got %v using an expired token, want %v", err, errors.ErrOwnershipRequired)
+	}
+}
+
+func TestTokensAreBoundToTheirPurposeAndUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	service, sender := newTestService(t, &clock)
+	const phone = "+989121234567"
+
+	if _, err := service.Request(ctx, phone, PurposeDiscount); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := service.Verify(ctx, phone, regexp.MustCompile(`\d+`).FindString(sender.message))
+	if err != nil || proof.Purpose != PurposeDiscount {
+		t.Fatalf("got %+v (%v), want a discount token", proof, err)
+	}
+	if err = service.Use(ctx, PurposeRegistration, phone, proof.Token); !errors.Is(err, errors.ErrOwnershipRequired) {
+		t.Fatalf("got %v registering with a discount token, want %v", err, errors.ErrOwnershipRequired)
+	}
+	if err = service.Use(ctx, PurposeDiscount, "+989121111111", proof.Token); !errors.Is(err, errors.ErrOwnershipRequired) {
+		t.Fatalf("got %v using the token of another number, want %v", err, errors.ErrOwnershipRequired)
+	}
+	if err = service.Use(ctx, PurposeDiscount, phone, proof.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err = service.Use(ctx, PurposeDiscount, phone, proof.Token); !errors.Is(err, errors.ErrOwnershipRequired) {
+		t.Fatalf("got %v using a token twice, want %v", err, errors.ErrOwnershipRequired)
+	}
+}
+
+func TestWrongCodesCountAcrossResends(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	service, sender := newTestService(t, &clock)
+	const phone = "+989121234567"
+
+	for i := 0; i < DefaultMaxAttempts; i++ {
+		if _, err := service.Request(ctx, phone, PurposeRegistration); err != nil {
+			t.Fatalf("resend %d: %v", i, err)
+		}
+		wrong := "000000"
+		if regexp.MustCompile(`\d+`).FindString(sender.message) == wrong {
+			wrong = "111111"
+		}
+		if _, err := service.Verify(ctx, phone, wrong); !errors.Is(err, errors.ErrOTPInvalid) {
+			t.Fatalf("got %v for a wrong code, want %v", err, errors.ErrOTPInvalid)
+		}
+		clock = clock.Add(DefaultResendCooldown)
+	}
+
+	if _, err := service.Request(ctx, phone, PurposeRegistration); !errors.Is(err, errors.ErrOTPAttempts) {
+		t.Fatalf("got %v asking for a code after %d wrong ones, want %v", err, DefaultMaxAttempts, errors.ErrOTPAttempts)
+	}
+	clock = clock.Add(DefaultLockout)
+	if _, err := service.Request(ctx, phone, PurposeRegistration); err != nil {
+		t.Fatalf("got %v after the lockout, want a new code", err)
+	}
+	if _, err := service.Verify(ctx, phone, regexp.MustCompile(`\d+`).FindString(sender.message)); err != nil {
+		t.Fatal(err)
+	}
+}
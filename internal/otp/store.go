@@ -0,0 +1,117 @@
+package otp
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+var (
+	errCodeNotFound  = errors.ErrNotFound.WithMessage("otp code not found")
+	errTokenNotFound = errors.ErrNotFound.WithMessage("ownership token not found")
+)
+
+// Store persists one-time codes and ownership tokens.
+type Store interface {
+	// Lock returns the code last sent to phone and holds a row lock on it until the surrounding
+	// transaction ends.
+	Lock(ctx context.Context, phone string) (*models.OTPCode, error)
+	// SaveCode stores code, replacing the one sent to the same phone before.
+	SaveCode(ctx context.Context, code *models.OTPCode) error
+	DeleteCode(ctx context.Context, phone string) error
+	// CountSent returns how many of the codes kept were sent at or after since, only counting
+	// those asked for by clientIP unless it is empty.
+	CountSent(ctx context.Context, since time.Time, clientIP string) (int64, error)
+	AddToken(ctx context.Context, token *models.OwnershipToken) error
+	// ConsumeToken deletes the token with hash if it was issued for phone and purpose and has
+	// not expired at now. It fails with ErrNotFound when there is no such token.
+	ConsumeToken(ctx context.Context, hash, phone, purpose string, now time.Time) error
+	// DeleteExpired removes the codes kept until before now, and the tokens that expired.
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) Lock(ctx context.Context, phone string) (*models.OTPCode, error) {
+	var code models.OTPCode
+	if err := s.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("phone = ?", phone).
+		First(&code).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errCodeNotFound
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &code, nil
+}
+
+func (s *store) SaveCode(ctx context.Context, code *models.OTPCode) error {
+	if err := s.db.Conn(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "phone"}}, UpdateAll: true}).
+		Create(code).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not save otp code").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) DeleteCode(ctx context.Context, phone string) error {
+	if err := s.db.Conn(ctx).Where("phone = ?", phone).Delete(new(models.OTPCode)).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not delete otp code").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) CountSent(ctx context.Context, since time.Time, clientIP string) (int64, error) {
+	query := s.db.Conn(ctx).Model(new(models.OTPCode)).Where("sent_at >= ?", since)
+	if clientIP != "" {
+		query = query.Where("client_ip = ?", clientIP)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, errors.ErrInternal.WithMessage("could not count otp codes").Wrap(err)
+	}
+	return count, nil
+}
+
+func (s *store) AddToken(ctx context.Context, token *models.OwnershipToken) error {
+	if err := s.db.Conn(ctx).Create(token).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not save ownership token").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) ConsumeToken(ctx context.Context, hash, phone, purpose string, now time.Time) error {
+	result := s.db.Conn(ctx).
+		Where("hash = ? AND phone = ? AND purpose = ? AND expires_at > ?", hash, phone, purpose, now).
+		Delete(new(models.OwnershipToken))
+	if result.Error != nil {
+		return errors.ErrInternal.WithMessage("could not use ownership token").Wrap(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errTokenNotFound
+	}
+	return nil
+}
+
+func (s *store) DeleteExpired(ctx context.Context, now time.Time) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Where("keep_until < ?", now).Delete(new(models.OTPCode)).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not delete expired otp codes").Wrap(err)
+	}
+	if err := conn.Where("expires_at < ?", now).Delete(new(models.OwnershipToken)).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not delete expired ownership tokens").Wrap(err)
+	}
+	return nil
+}
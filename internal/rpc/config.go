@@ -0,0 +1,14 @@
+package rpc
+
+import (
+	"payment/pkg/config"
+)
+
+type Config struct {
+	AuthToken string
+}
+
+// NewConfig extracts the gRPC settings that apply without a restart from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{AuthToken: c.Token}
+}
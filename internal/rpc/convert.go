@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"payment/api/models"
+	"payment/api/paymentpb"
+	"time"
+)
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func walletMessage(wallet *models.Wallet) *paymentpb.Wallet {
+	return &paymentpb.Wallet{
+		Id:           wallet.ID.String(),
+		CreatedAt:    timestamp(wallet.CreatedAt),
+		Phone:        wallet.Phone,
+		Amount:       wallet.Amount,
+		Status:       string(wallet.Status),
+		StatusReason: wallet.StatusReason,
+		Tier:         string(wallet.Tier),
+		Transactions: transactionMessages(wallet.Transactions),
+	}
+}
+
+func transactionMessage(transaction *models.Transaction) *paymentpb.Transaction {
+	return &paymentpb.Transaction{
+		Id:          transaction.ID.String(),
+		CreatedAt:   timestamp(transaction.CreatedAt),
+		Type:        string(transaction.Type),
+		Amount:      transaction.Amount,
+		Status:      string(transaction.Status),
+		Description: transaction.Description,
+	}
+}
+
+func transactionMessages(transactions []*models.Transaction) []*paymentpb.Transaction {
+	messages := make([]*paymentpb.Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		messages = append(messages, transactionMessage(transaction))
+	}
+	return messages
+}
+
+func discountMessage(discount *models.Discount) *paymentpb.Discount {
+	message := &paymentpb.Discount{
+		Id:             discount.ID.String(),
+		CreatedAt:      timestamp(discount.CreatedAt),
+		Code:           discount.Code,
+		Description:    discount.Description,
+		Amount:         discount.Amount,
+		UsageLimit:     discount.UsageLimit,
+		ExpirationTime: timestamp(discount.ExpirationTime),
+		Type:           string(discount.Type),
+	}
+	for _, usage := range discount.Transactions {
+		message.Usages = append(message.Usages, &paymentpb.DiscountUsage{
+			Id:        usage.ID.String(),
+			CreatedAt: timestamp(usage.CreatedAt),
+			WalletId:  usage.WalletID.String(),
+			Phone:     usage.PhoneNum,
+		})
+	}
+	return message
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"payment/api/models"
+	"payment/api/paymentpb"
+	"payment/internal/discounts"
+	"payment/internal/otp"
+	"payment/pkg/errors"
+	"payment/pkg/phone"
+)
+
+var errMissingCode = errors.NewError(errors.CodeMissingParam, http.StatusBadRequest, "discount code is required")
+
+type discountServer struct {
+	paymentpb.UnimplementedDiscountServiceServer
+	discounts *discounts.Service
+	ownership *otp.Service
+	validator *validator.Validate
+}
+
+func (s *discountServer) CreateDiscount(ctx context.Context, req *paymentpb.CreateDiscountRequest) (*paymentpb.Discount, error) {
+	discount := &models.Discount{
+		Description: req.Description,
+		Amount:      req.Amount,
+		UsageLimit:  req.UsageLimit,
+		Type:        models.DiscountType(req.Type),
+	}
+	if err := s.validator.Struct(discount); err != nil {
+		return nil, fail(errors.Validation(err))
+	}
+	discount, err := s.discounts.Issue(ctx, discount)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return discountMessage(discount), nil
+}
+
+func (s *discountServer) ApplyDiscount(ctx context.Context, req *paymentpb.ApplyDiscountRequest) (*paymentpb.ApplyDiscountResponse, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if req.Code == "" {
+		return nil, fail(errMissingCode)
+	}
+	token := ownershipToken(ctx)
+	discount, err := s.discounts.Apply(ctx, &models.DiscountApplyRequest{Code: req.Code, PhoneNum: number},
+		func(ctx context.Context) error {
+			return s.ownership.Require(ctx, otp.PurposeDiscount, number, token)
+		})
+	if err != nil {
+		return nil, fail(err)
+	}
+	return &paymentpb.ApplyDiscountResponse{
+		Code:        req.Code,
+		Description: discount.Description,
+		Total:       discount.Amount,
+		Type:        string(discount.Type),
+	}, nil
+}
+
+func (s *discountServer) ListDiscountUsages(ctx context.Context, req *paymentpb.ListDiscountUsagesRequest) (*paymentpb.Discount, error) {
+	if req.Code == "" {
+		return nil, fail(errMissingCode)
+	}
+	discount, err := s.discounts.Usages(ctx, req.Code)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return discountMessage(discount), nil
+}
@@ -0,0 +1,59 @@
+// Package rpc serves the gRPC API defined in api/paymentpb. It is a thin layer over the same
+// services as the REST handlers: requests are checked as the handlers check them, and domain
+// errors are converted with errors.GRPCStatus.
+package rpc
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"payment/api/paymentpb"
+	"payment/internal/discounts"
+	"payment/internal/otp"
+	"payment/internal/transactions"
+	"payment/internal/wallets"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+	"payment/pkg/metrics"
+	"payment/pkg/middleware"
+	"payment/pkg/tracing"
+)
+
+// publicMethods do not require a token, like their REST counterparts.
+var publicMethods = []string{
+	paymentpb.DiscountService_ApplyDiscount_FullMethodName,
+	paymentpb.DiscountService_ListDiscountUsages_FullMethodName,
+}
+
+// NewServer creates a gRPC server exposing the wallet, transaction and discount services.
+// The token is read from settings on every call, and the API keys of keys are accepted too.
+// Registration and redemption ask ownership for the proof of phone ownership when the
+// configuration requires it.
+func NewServer(logger *log.Logger, validate *validator.Validate, settings *config.Value[Config], keys auth.Keys,
+	walletService wallets.IWallet, transactionService transactions.ITransaction, discountService *discounts.Service,
+	ownership *otp.Service) *grpc.Server {
+	// Panics are recovered first, so that none escapes; the interceptors after it still log,
+	// count and trace a call that panics, as an internal error.
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		middleware.UnaryRecover(logger),
+		middleware.UnaryRequestID,
+		tracing.UnaryInterceptor,
+		middleware.UnaryAccessLog(logger),
+		metrics.UnaryInterceptor,
+		auth.UnaryInterceptor(func() string { return settings.Load().AuthToken }, keys, publicMethods...),
+	))
+	paymentpb.RegisterWalletServiceServer(server, &walletServer{wallets: walletService, ownership: ownership, validator: validate, logger: logger})
+	paymentpb.RegisterTransactionServiceServer(server, &transactionServer{wallets: walletService, transactions: transactionService})
+	paymentpb.RegisterDiscountServiceServer(server, &discountServer{discounts: discountService, ownership: ownership, validator: validate})
+	return server
+}
+
+// ownershipToken returns the ownership token in the metadata of the call, or an empty string.
+func ownershipToken(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, otp.TokenMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,332 @@
+package rpc
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"payment/api/models"
+	"payment/api/paymentpb"
+	"payment/internal/audit"
+	"payment/internal/discounts"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/otp"
+	"payment/internal/wallets"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/utils"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// inbox is an SMSSender that keeps the last message sent to each number.
+type inbox map[string]string
+
+func (i inbox) Send(_ context.Context, phone, message string) error {
+	i[phone] = message
+	return nil
+}
+
+type fixture struct {
+	wallets      *walletServer
+	transactions *transactionServer
+	discounts    *discountServer
+	ownership    *otp.Service
+	inbox        inbox
+}
+
+func newValidator(t *testing.T) *validator.Validate {
+	t.Helper()
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+	return validate
+}
+
+// newFixture creates the servers on in-memory stores. Registration and redemption need an
+// ownership token, so that the metadata carrying it is exercised.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	validate := newValidator(t)
+
+	db := memory.NewDB()
+	auditor := audit.NewService(logger, memory.NewAudit(db), db)
+	outbox := events.NewOutbox(memory.NewOutbox(db))
+	transactionService := memory.NewTransactions(db)
+	walletService := wallets.NewWallet(logger, memory.NewWallets(db), transactionService, db, auditor, outbox,
+		config.NewValue(&wallets.Config{}))
+	discountRepository := memory.NewDiscounts(db)
+	discountService := discounts.NewService(config.NewValue(&discounts.Config{CreditExpiration: time.Minute, CodeLength: 8}),
+		logger, db, discountRepository, discountRepository, walletService, auditor, outbox)
+
+	settings := otp.NewConfig(&config.Config{})
+	settings.Require = map[string]bool{otp.PurposeRegistration: true, otp.PurposeDiscount: true}
+	messages := inbox{}
+	ownership := otp.NewService(logger, memory.NewOTP(db), db, messages, config.NewValue(settings))
+
+	return &fixture{
+		wallets:      &walletServer{wallets: walletService, ownership: ownership, validator: validate, logger: logger},
+		transactions: &transactionServer{wallets: walletService, transactions: transactionService},
+		discounts:    &discountServer{discounts: discountService, ownership: ownership, validator: validate},
+		ownership:    ownership,
+		inbox:        messages,
+	}
+}
+
+// prove returns a context carrying an ownership token for purpose and phone in its metadata.
+func (f *fixture) prove(t *testing.T, purpose, phone string) context.Context {
+	t.Helper()
+	if _, err := f.ownership.Request(context.Background(), phone, purpose); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := f.ownership.Verify(context.Background(), phone, regexp.MustCompile(`\d+`).FindString(f.inbox[phone]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(otp.TokenMetadata, proof.Token))
+}
+
+// expectStatus fails the test unless err is a gRPC status with code and the given error code as its reason.
+func expectStatus(t *testing.T, err error, code codes.Code, reason errors.Code) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("got %v, want %v", err, code)
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == string(reason) {
+			return
+		}
+	}
+	t.Fatalf("got details %v, want reason %s", st.Details(), reason)
+}
+
+func TestFail(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason errors.Code
+	}{
+		{"bad request", errors.ErrInvalidPhone, codes.InvalidArgument, errors.CodeInvalidPhone},
+		{"not found", errors.ErrWalletNotFound, codes.NotFound, errors.CodeWalletNotFound},
+		{"wallet exists", errors.ErrWalletExists, codes.AlreadyExists, errors.CodeWalletExists},
+		{"forbidden", errors.ErrOwnershipRequired, codes.PermissionDenied, errors.CodeOwnershipRequired},
+		{"unprocessable", errors.ErrInsufficientFunds, codes.FailedPrecondition, errors.CodeInsufficientFunds},
+		{"rate limited", errors.ErrOTPRateLimited, codes.ResourceExhausted, errors.CodeOTPRateLimited},
+		{"timeout", errors.ErrTimeout, codes.DeadlineExceeded, errors.CodeTimeout},
+		{"wrapped", errors.ErrWalletNotFound.Wrap(io.ErrUnexpectedEOF), codes.NotFound, errors.CodeWalletNotFound},
+		{"unknown", stderrors.New("connection reset"), codes.Internal, errors.CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, fail(tt.err), tt.code, tt.reason)
+		})
+	}
+}
+
+func TestFailKeepsFieldViolations(t *testing.T) {
+	err := fail(errors.Validation(newValidator(t).Struct(&models.Transaction{})))
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, detail := range status.Convert(err).Details() {
+		if request, ok := detail.(*errdetails.BadRequest); ok {
+			violations = request.FieldViolations
+		}
+	}
+	if status.Code(err) != codes.InvalidArgument || len(violations) == 0 {
+		t.Fatalf("got %v with violations %v, want an invalid argument naming the fields", err, violations)
+	}
+}
+
+func TestOwnershipToken(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"no metadata", context.Background(), ""},
+		{"other keys", metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "token")), ""},
+		{"token", metadata.NewIncomingContext(context.Background(), metadata.Pairs(otp.TokenMetadata, "pt_1")), "pt_1"},
+		{"first of several", metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(otp.TokenMetadata, "pt_1", otp.TokenMetadata, "pt_2")), "pt_1"},
+		{"outgoing only", metadata.AppendToOutgoingContext(context.Background(), otp.TokenMetadata, "pt_1"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ownershipToken(tt.ctx); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateWallet(t *testing.T) {
+	f := newFixture(t)
+	const phone = "+989121234567"
+
+	_, err := f.wallets.CreateWallet(context.Background(), &paymentpb.CreateWalletRequest{Phone: "phone"})
+	expectStatus(t, err, codes.InvalidArgument, errors.CodeInvalidPhone)
+	_, err = f.wallets.CreateWallet(context.Background(), &paymentpb.CreateWalletRequest{Phone: phone})
+	expectStatus(t, err, codes.PermissionDenied, errors.CodeOwnershipRequired)
+
+	ctx := f.prove(t, otp.PurposeRegistration, phone)
+	_, err = f.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: "+989127654321"})
+	expectStatus(t, err, codes.PermissionDenied, errors.CodeOwnershipRequired)
+	wallet, err := f.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: phone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Phone != phone || wallet.Tier != string(models.TierUnverified) || wallet.Status != string(models.WalletActive) {
+		t.Fatalf("got %+v, want an active unverified wallet for %s", wallet, phone)
+	}
+	_, err = f.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: phone})
+	expectStatus(t, err, codes.PermissionDenied, errors.CodeOwnershipRequired)
+
+	// A failed registration leaves the token to be used again.
+	ctx = f.prove(t, otp.PurposeRegistration, phone)
+	_, err = f.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: phone})
+	expectStatus(t, err, codes.AlreadyExists, errors.CodeWalletExists)
+	_, err = f.wallets.CreateWallet(ctx, &paymentpb.CreateWalletRequest{Phone: phone})
+	expectStatus(t, err, codes.AlreadyExists, errors.CodeWalletExists)
+}
+
+func TestWalletCalls(t *testing.T) {
+	f := newFixture(t)
+	const phone = "+989121234567"
+	if _, err := f.wallets.CreateWallet(f.prove(t, otp.PurposeRegistration, phone),
+		&paymentpb.CreateWalletRequest{Phone: phone}); err != nil {
+		t.Fatal(err)
+	}
+	deposit := &paymentpb.TransactRequest{Phone: phone, Type: string(models.Deposit), Amount: 500, Description: "salary"}
+	transaction, err := f.wallets.Transact(context.Background(), deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		call   func(ctx context.Context) error
+		code   codes.Code
+		reason errors.Code
+	}{
+		{"get with an invalid phone", func(ctx context.Context) error {
+			_, err := f.wallets.GetWallet(ctx, &paymentpb.GetWalletRequest{Phone: "phone"})
+			return err
+		}, codes.InvalidArgument, errors.CodeInvalidPhone},
+		{"get an unknown wallet", func(ctx context.Context) error {
+			_, err := f.wallets.GetWallet(ctx, &paymentpb.GetWalletRequest{Phone: "+989127654321"})
+			return err
+		}, codes.NotFound, errors.CodeWalletNotFound},
+		{"transact on an unknown wallet", func(ctx context.Context) error {
+			_, err := f.wallets.Transact(ctx, &paymentpb.TransactRequest{Phone: "+989127654321", Type: string(models.Deposit),
+				Amount: 500, Description: "salary"})
+			return err
+		}, codes.NotFound, errors.CodeWalletNotFound},
+		{"transact without a description", func(ctx context.Context) error {
+			_, err := f.wallets.Transact(ctx, &paymentpb.TransactRequest{Phone: phone, Type: string(models.Deposit), Amount: 500})
+			return err
+		}, codes.InvalidArgument, errors.CodeValidation},
+		{"withdraw from an unverified wallet", func(ctx context.Context) error {
+			_, err := f.wallets.Transact(ctx, &paymentpb.TransactRequest{Phone: phone, Type: string(models.Withdrawal),
+				Amount: 100, Description: "groceries"})
+			return err
+		}, codes.PermissionDenied, errors.CodeTierNotAllowed},
+		{"close a wallet with a balance", func(ctx context.Context) error {
+			_, err := f.wallets.DeleteWallet(ctx, &paymentpb.DeleteWalletRequest{Phone: phone})
+			return err
+		}, codes.FailedPrecondition, errors.CodeWalletNotEmpty},
+		{"get a transaction with an invalid ID", func(ctx context.Context) error {
+			_, err := f.transactions.GetTransaction(ctx, &paymentpb.GetTransactionRequest{Id: "transaction"})
+			return err
+		}, codes.InvalidArgument, errors.CodeBadRequest},
+		{"list the transactions of an unknown wallet", func(ctx context.Context) error {
+			_, err := f.transactions.ListTransactions(ctx, &paymentpb.ListTransactionsRequest{Phone: "+989127654321"})
+			return err
+		}, codes.NotFound, errors.CodeWalletNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, tt.call(context.Background()), tt.code, tt.reason)
+		})
+	}
+
+	found, err := f.transactions.GetTransaction(context.Background(), &paymentpb.GetTransactionRequest{Id: transaction.Id})
+	if err != nil || found.Amount != 500 || found.Type != string(models.Deposit) {
+		t.Fatalf("got %+v (%v), want the deposit", found, err)
+	}
+	listed, err := f.transactions.ListTransactions(context.Background(), &paymentpb.ListTransactionsRequest{Phone: phone})
+	if err != nil || len(listed.Transactions) != 1 || listed.Transactions[0].Id != transaction.Id {
+		t.Fatalf("got %+v (%v), want the deposit listed", listed, err)
+	}
+	closed, err := f.wallets.DeleteWallet(context.Background(), &paymentpb.DeleteWalletRequest{Phone: phone, Settle: true})
+	if err != nil || closed.Status != string(models.WalletClosed) || closed.Amount != 0 {
+		t.Fatalf("got %+v (%v), want a closed, settled wallet", closed, err)
+	}
+}
+
+func TestDiscountCalls(t *testing.T) {
+	f := newFixture(t)
+	const phone = "+989121234567"
+
+	tests := []struct {
+		name    string
+		request *paymentpb.CreateDiscountRequest
+		code    codes.Code
+		reason  errors.Code
+	}{
+		{"without a description", &paymentpb.CreateDiscountRequest{Amount: 500, UsageLimit: 10, Type: string(models.Voucher)},
+			codes.InvalidArgument, errors.CodeValidation},
+		{"without a type", &paymentpb.CreateDiscountRequest{Description: "Voucher for cup league", Amount: 500, UsageLimit: 10},
+			codes.InvalidArgument, errors.CodeInvalidDiscountType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.discounts.CreateDiscount(context.Background(), tt.request)
+			expectStatus(t, err, tt.code, tt.reason)
+		})
+	}
+
+	discount, err := f.discounts.CreateDiscount(context.Background(), &paymentpb.CreateDiscountRequest{
+		Description: "Voucher for cup league", Amount: 500, UsageLimit: 10, Type: string(models.Voucher),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := &paymentpb.ApplyDiscountRequest{Code: discount.Code, Phone: phone}
+
+	_, err = f.discounts.ApplyDiscount(context.Background(), &paymentpb.ApplyDiscountRequest{Phone: phone})
+	expectStatus(t, err, codes.InvalidArgument, errors.CodeMissingParam)
+	_, err = f.discounts.ApplyDiscount(context.Background(), &paymentpb.ApplyDiscountRequest{Code: discount.Code, Phone: "phone"})
+	expectStatus(t, err, codes.InvalidArgument, errors.CodeInvalidPhone)
+	_, err = f.discounts.ApplyDiscount(context.Background(), apply)
+	expectStatus(t, err, codes.PermissionDenied, errors.CodeOwnershipRequired)
+	// A token proves ownership for its purpose only.
+	_, err = f.discounts.ApplyDiscount(f.prove(t, otp.PurposeRegistration, phone), apply)
+	expectStatus(t, err, codes.PermissionDenied, errors.CodeOwnershipRequired)
+
+	applied, err := f.discounts.ApplyDiscount(f.prove(t, otp.PurposeDiscount, phone), apply)
+	if err != nil || applied.Total != 500 || applied.Code != discount.Code {
+		t.Fatalf("got %+v (%v), want 500 credited", applied, err)
+	}
+
+	_, err = f.discounts.ListDiscountUsages(context.Background(), &paymentpb.ListDiscountUsagesRequest{})
+	expectStatus(t, err, codes.InvalidArgument, errors.CodeMissingParam)
+	_, err = f.discounts.ListDiscountUsages(context.Background(), &paymentpb.ListDiscountUsagesRequest{Code: "unknown"})
+	expectStatus(t, err, codes.NotFound, errors.CodeDiscountNotFound)
+	usages, err := f.discounts.ListDiscountUsages(context.Background(), &paymentpb.ListDiscountUsagesRequest{Code: discount.Code})
+	if err != nil || len(usages.Usages) != 1 || usages.Usages[0].Phone != phone {
+		t.Fatalf("got %+v (%v), want one usage by %s", usages, err, phone)
+	}
+}
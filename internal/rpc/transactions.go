@@ -0,0 +1,41 @@
+package rpc
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/paymentpb"
+	"payment/internal/transactions"
+	"payment/internal/wallets"
+	"payment/pkg/errors"
+	"payment/pkg/phone"
+)
+
+type transactionServer struct {
+	paymentpb.UnimplementedTransactionServiceServer
+	wallets      wallets.IWallet
+	transactions transactions.ITransaction
+}
+
+func (s *transactionServer) GetTransaction(ctx context.Context, req *paymentpb.GetTransactionRequest) (*paymentpb.Transaction, error) {
+	id, err := uuid.Parse(req.Id)
+	if err != nil {
+		return nil, fail(errors.ErrBadRequest.WithMessage("invalid transaction ID"))
+	}
+	transaction, err := s.transactions.GetByID(ctx, id)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return transactionMessage(transaction), nil
+}
+
+func (s *transactionServer) ListTransactions(ctx context.Context, req *paymentpb.ListTransactionsRequest) (*paymentpb.ListTransactionsResponse, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+	wallet, err := s.wallets.GetByPhone(ctx, number)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return &paymentpb.ListTransactionsResponse{Transactions: transactionMessages(wallet.Transactions)}, nil
+}
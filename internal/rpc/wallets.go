@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/api/paymentpb"
+	"payment/internal/otp"
+	"payment/internal/wallets"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/phone"
+)
+
+type walletServer struct {
+	paymentpb.UnimplementedWalletServiceServer
+	wallets   wallets.IWallet
+	ownership *otp.Service
+	validator *validator.Validate
+	logger    *log.Logger
+}
+
+func (s *walletServer) CreateWallet(ctx context.Context, req *paymentpb.CreateWalletRequest) (*paymentpb.Wallet, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	var wallet *models.Wallet
+	err = s.ownership.Within(ctx, otp.PurposeRegistration, number, ownershipToken(ctx), func(ctx context.Context) error {
+		if model, _ := s.wallets.GetByPhone(ctx, number); model != nil {
+			return errors.ErrWalletExists
+		}
+		// The tier is only raised after verification, through the admin endpoint.
+		wallet, err = s.wallets.Create(ctx, &models.Wallet{Phone: number, Tier: models.TierUnverified})
+		return err
+	})
+	if err != nil {
+		return nil, fail(err)
+	}
+	return walletMessage(wallet), nil
+}
+
+func (s *walletServer) GetWallet(ctx context.Context, req *paymentpb.GetWalletRequest) (*paymentpb.Wallet, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+	wallet, err := s.wallets.GetByPhone(ctx, number)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return walletMessage(wallet), nil
+}
+
+func (s *walletServer) Transact(ctx context.Context, req *paymentpb.TransactRequest) (*paymentpb.Transaction, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+	wallet, err := s.wallets.GetByPhone(ctx, number)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	transaction := &models.Transaction{
+		WalletID:    wallet.ID,
+		Type:        models.Type(req.Type),
+		Amount:      req.Amount,
+		Description: req.Description,
+	}
+	if err = s.validator.Struct(transaction); err != nil {
+		return nil, fail(errors.Validation(err))
+	}
+	if err = s.wallets.Transaction(ctx, wallet, transaction); err != nil {
+		logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+			"type":      "transaction",
+			"wallet_id": wallet.ID,
+			"error":     err,
+		}).Error("Transaction failed")
+		return nil, fail(err)
+	}
+	return transactionMessage(transaction), nil
+}
+
+func (s *walletServer) DeleteWallet(ctx context.Context, req *paymentpb.DeleteWalletRequest) (*paymentpb.Wallet, error) {
+	number, err := phone.Normalize(req.Phone)
+	if err != nil {
+		return nil, fail(err)
+	}
+	wallet, err := s.wallets.Close(ctx, number, req.Settle)
+	if err != nil {
+		return nil, fail(err)
+	}
+	return walletMessage(wallet), nil
+}
+
+// fail converts err into the gRPC status returned to the client.
+func fail(err error) error {
+	return errors.GRPCStatus(err).Err()
+}
@@ -2,11 +2,14 @@ package transactions
 
 import (
 	"context"
-	"errors"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 	"payment/api/models"
 	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
 )
 
 type ITransaction interface {
@@ -14,6 +17,8 @@ type ITransaction interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error)
 	ChangeStatus(ctx context.Context, id uuid.UUID, status models.Status) error
 	List(ctx context.Context, id uuid.UUID) ([]*models.Transaction, error)
+	// Totals sums the completed transactions of the wallet created at or after since.
+	Totals(ctx context.Context, walletID uuid.UUID, since time.Time) (*models.TransactionTotals, error)
 }
 
 type Service struct {
@@ -29,18 +34,11 @@ func NewTransactionsService(logger *log.Logger, db *db.DB) ITransaction {
 }
 
 func (s *Service) Create(ctx context.Context, transaction *models.Transaction) (*models.Transaction, error) {
-	tx := s.db.Begin()
 	if transaction.WalletID == uuid.Nil {
-		return nil, errors.New("wallet ID is required")
+		return nil, errors.ErrBadRequest.WithMessage("wallet ID is required")
 	}
-	if err := tx.WithContext(ctx).Create(transaction).Error; err != nil {
-		s.logger.Error(err)
-		tx.Rollback()
-		return nil, err
-	}
-	if err := tx.Commit().Error; err != nil {
-		s.logger.Error(err)
-		tx.Rollback()
+	if err := s.db.Conn(ctx).Create(transaction).Error; err != nil {
+		logging.FromContext(ctx, s.logger).Error(err)
 		return nil, err
 	}
 	return transaction, nil
@@ -48,19 +46,22 @@ func (s *Service) Create(ctx context.Context, transaction *models.Transaction) (
 
 func (s *Service) GetByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
 	transaction := &models.Transaction{}
-	if err := s.db.WithContext(ctx).First(transaction, id).Error; err != nil {
-		s.logger.Error(err)
-		return nil, err
+	if err := s.db.Conn(ctx).First(transaction, "id = ?", id).Error; err != nil {
+		logging.FromContext(ctx, s.logger).Error(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.ErrNotFound.WithMessage("transaction not found")
+		}
+		return nil, errors.ErrInternal.Wrap(err)
 	}
 	return transaction, nil
 }
 
-func (s *Service) ChangeStatus(_ context.Context, id uuid.UUID, status models.Status) error {
-	if err := s.db.
+func (s *Service) ChangeStatus(ctx context.Context, id uuid.UUID, status models.Status) error {
+	if err := s.db.Conn(ctx).
 		Model(new(models.Transaction)).
 		Where("id = ?", id).
 		Update("status", status).Error; err != nil {
-		s.logger.Error(err)
+		logging.FromContext(ctx, s.logger).Error(err)
 		return err
 	}
 	return nil
@@ -68,13 +69,28 @@ func (s *Service) ChangeStatus(_ context.Context, id uuid.UUID, status models.St
 
 func (s *Service) List(ctx context.Context, id uuid.UUID) ([]*models.Transaction, error) {
 	transactions := make([]*models.Transaction, 0)
-	if err := s.db.
+	if err := s.db.Conn(ctx).
 		Model(new(models.Transaction)).
-		WithContext(ctx).
 		Where("wallet_id = ?", id).
+		Order("created_at").
 		Find(&transactions).Error; err != nil {
-		s.logger.Error(err)
+		logging.FromContext(ctx, s.logger).Error(err)
 		return nil, err
 	}
 	return transactions, nil
 }
+
+func (s *Service) Totals(ctx context.Context, walletID uuid.UUID, since time.Time) (*models.TransactionTotals, error) {
+	totals := &models.TransactionTotals{}
+	if err := s.db.Conn(ctx).
+		Model(new(models.Transaction)).
+		Select(`COALESCE(SUM(amount) FILTER (WHERE type = ?), 0) AS deposits,
+			COALESCE(SUM(amount) FILTER (WHERE type = ?), 0) AS withdrawals,
+			COUNT(*) AS count`, models.Deposit, models.Withdrawal).
+		Where("wallet_id = ? AND status = ? AND created_at >= ?", walletID, models.Completed, since).
+		Scan(totals).Error; err != nil {
+		logging.FromContext(ctx, s.logger).Error(err)
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return totals, nil
+}
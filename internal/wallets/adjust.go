@@ -0,0 +1,67 @@
+package wallets
+
+import (
+	"context"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/tracing"
+)
+
+// adjustmentDescription marks the transactions of manual adjustments in the ledger.
+const adjustmentDescription = "manual adjustment"
+
+// adjustment is what the audit log keeps of a manual adjustment, next to the transaction it made.
+type adjustment struct {
+	TransactionID uuid.UUID `json:"transaction_id"`
+	Amount        int64     `json:"amount"`
+	Reason        string    `json:"reason"`
+}
+
+// Adjust corrects the balance of the wallet with the given ID by amount: a deposit when it is positive,
+// a withdrawal when it is negative. Adjustments follow the status rules but neither the tier nor
+// the limits, which are meant for customers, and reason is kept in the audit log.
+func (r *WalletService) Adjust(ctx context.Context, id uuid.UUID, amount int64, reason string) (*models.Transaction, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.Adjust")
+	defer span.End()
+
+	switch {
+	case amount == 0:
+		return nil, errors.ErrBadRequest.WithMessage("adjustment amount must not be zero")
+	case reason == "":
+		return nil, errors.ErrBadRequest.WithMessage("a reason is required for an adjustment")
+	}
+
+	transaction := &models.Transaction{Type: models.Deposit, Amount: amount, Description: adjustmentDescription}
+	if amount < 0 {
+		transaction.Type, transaction.Amount = models.Withdrawal, -amount
+	}
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		wallet, err := r.store.FindByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		transaction.WalletID = wallet.ID
+		if err = r.transact(ctx, wallet, transaction, false); err != nil {
+			return err
+		}
+		return record(ctx, r.auditor, audit.ActionWalletAdjust, wallet.ID, nil,
+			&adjustment{TransactionID: transaction.ID, Amount: amount, Reason: reason})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+		"section":     "wallet",
+		"mode":        "adjust",
+		"wallet":      transaction.WalletID,
+		"transaction": transaction.ID,
+		"amount":      amount,
+		"reason":      reason,
+	}).Info("wallet adjusted")
+	return transaction, nil
+}
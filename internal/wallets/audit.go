@@ -0,0 +1,39 @@
+package wallets
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"payment/api/models"
+	"payment/internal/audit"
+)
+
+// walletState is what the audit log keeps of a wallet. The phone number is left out,
+// so that anonymizing a closed wallet leaves nothing identifying in the log.
+type walletState struct {
+	Balance int64               `json:"balance"`
+	Status  models.WalletStatus `json:"status"`
+	Tier    models.WalletTier   `json:"tier"`
+}
+
+func stateOf(wallet *models.Wallet) *walletState {
+	return &walletState{Balance: wallet.Amount, Status: wallet.Status, Tier: wallet.Tier}
+}
+
+// transactionState is what the audit log keeps of a transaction and the balance it left.
+type transactionState struct {
+	ID      uuid.UUID   `json:"id"`
+	Type    models.Type `json:"type"`
+	Amount  int64       `json:"amount"`
+	Balance int64       `json:"balance"`
+}
+
+// record appends a change of wallet to the audit log, in the transaction of ctx.
+func record(ctx context.Context, auditor audit.Recorder, action string, wallet uuid.UUID, before, after interface{}) error {
+	return auditor.Record(ctx, audit.Entry{
+		Action:     action,
+		EntityType: audit.EntityWallet,
+		EntityID:   wallet.String(),
+		Before:     before,
+		After:      after,
+	})
+}
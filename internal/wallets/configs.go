@@ -1,5 +1,30 @@
 package wallets
 
+import (
+	"payment/api/models"
+	"payment/pkg/config"
+)
+
 type Config struct {
 	AuthToken string
+	// AdminToken is accepted on the routes that change statuses, limits and tiers instead of
+	// AuthToken; those routes only accept admin API keys when it is empty.
+	AdminToken string
+	Limits     Limits
+	// Tiers narrows Limits for the wallets of each verification tier.
+	Tiers map[models.WalletTier]Limits
+}
+
+// NewConfig extracts the wallet settings from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{
+		AuthToken:  c.Token,
+		AdminToken: c.AdminToken,
+		Limits:     Limits(c.Limits),
+		Tiers: map[models.WalletTier]Limits{
+			models.TierUnverified: Limits(c.Tiers.Unverified),
+			models.TierBasic:      Limits(c.Tiers.Basic),
+			models.TierFull:       Limits(c.Tiers.Full),
+		},
+	}
 }
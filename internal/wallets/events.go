@@ -0,0 +1,28 @@
+package wallets
+
+import (
+	"payment/api/models"
+	"payment/internal/events"
+)
+
+// transactionEvent announces transaction on wallet. The balance is only reported once the
+// transaction has completed.
+func transactionEvent(eventType string, wallet *models.Wallet, transaction *models.Transaction) events.Event {
+	payload := &events.Transaction{
+		TransactionID: transaction.ID,
+		WalletID:      wallet.ID,
+		Phone:         wallet.Phone,
+		Type:          transaction.Type,
+		Amount:        transaction.Amount,
+		Description:   transaction.Description,
+	}
+	if transaction.Status == models.Completed {
+		payload.Balance = wallet.Amount
+	}
+	return events.Event{
+		Type:          eventType,
+		AggregateType: events.AggregateWallet,
+		AggregateID:   wallet.ID.String(),
+		Payload:       payload,
+	}
+}
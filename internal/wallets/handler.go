@@ -3,79 +3,162 @@ package wallets
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"payment/api/models"
+	"payment/internal/otp"
 	"payment/internal/transactions"
 	"payment/pkg/auth"
-	"payment/pkg/db"
+	"payment/pkg/config"
 	"payment/pkg/errors"
+	"payment/pkg/openapi"
+	"payment/pkg/phone"
 	"payment/pkg/utils"
+	"strconv"
 )
 
+// ownershipHeader documents the ownership token that registration can be configured to require.
+var ownershipHeader = openapi.Parameter{
+	Name:        otp.TokenHeader,
+	Description: "ownership token of the phone number, when the configuration requires one",
+}
+
 // RegisterRoutes registers the routes for wallet-related operations with the provided router.
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	protected := auth.AuthMiddleware(&auth.Config{Token: h.Config.AuthToken})
+	protected := auth.AuthMiddleware(func() string { return h.Config.Load().AuthToken }, h.Keys)
+	admin := auth.AdminMiddleware(func() string { return h.Config.Load().AdminToken }, h.Keys)
 	walletRoutes := router.PathPrefix("/wallet").Subrouter()
-
-	walletRoutes.HandleFunc("/register", protected(h.createWalletHandler)).Methods(http.MethodPost)
-	walletRoutes.HandleFunc("/{phoneNumber}", protected(h.transactionHandler)).Methods(http.MethodPut)
-	walletRoutes.HandleFunc("/{phoneNumber}", protected(h.deleteWalletHandler)).Methods(http.MethodDelete)
-	walletRoutes.HandleFunc("/{phoneNumber}", protected(h.returnByPhoneNumber)).Methods(http.MethodGet)
+	wallet := map[int]interface{}{http.StatusOK: models.Wallet{}}
+	limits := map[int]interface{}{http.StatusOK: models.WalletLimitsReport{}}
+
+	openapi.Describe(walletRoutes.HandleFunc("/register", protected(h.createWalletHandler)).Methods(http.MethodPost), openapi.Operation{
+		Summary:   "Create a wallet",
+		Tag:       "wallets",
+		Secured:   true,
+		Headers:   []openapi.Parameter{ownershipHeader},
+		Request:   models.WalletRequest{},
+		Responses: map[int]interface{}{http.StatusCreated: models.Wallet{}},
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}", protected(h.transactionHandler)).Methods(http.MethodPut), openapi.Operation{
+		Summary:   "Deposit to or withdraw from a wallet",
+		Tag:       "wallets",
+		Secured:   true,
+		Request:   models.NewTransaction{},
+		Responses: map[int]interface{}{http.StatusOK: models.NewTransaction{}},
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}", protected(h.deleteWalletHandler)).Methods(http.MethodDelete), openapi.Operation{
+		Summary: "Close a wallet",
+		Tag:     "wallets",
+		Secured: true,
+		Description: "The transactions are kept. A wallet that still holds funds is only closed with settle=true, " +
+			"which pays out the balance first.",
+		Query:     []openapi.Parameter{{Name: "settle", Type: "boolean", Description: "pay out the remaining balance"}},
+		Responses: map[int]interface{}{http.StatusAccepted: models.Wallet{}},
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}", protected(h.returnByPhoneNumber)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Get a wallet",
+		Tag:       "wallets",
+		Secured:   true,
+		Responses: wallet,
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}/status", admin(h.changeStatusHandler)).Methods(http.MethodPut), openapi.Operation{
+		Summary:   "Freeze, suspend, reactivate or close a wallet",
+		Tag:       "wallets",
+		Admin:     true,
+		Request:   models.WalletStatusRequest{},
+		Responses: wallet,
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}/limits", protected(h.limitsHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Get the usage of a wallet against its limits",
+		Tag:       "wallets",
+		Secured:   true,
+		Responses: limits,
+	})
+	openapi.Describe(walletRoutes.HandleFunc("/{phoneNumber}/limits", admin(h.setLimitsHandler)).Methods(http.MethodPut), openapi.Operation{
+		Summary:   "Replace the limit overrides of a wallet",
+		Tag:       "wallets",
+		Admin:     true,
+		Request:   models.WalletLimits{},
+		Responses: limits,
+	})
+
+	adminRoutes := router.PathPrefix("/admin/wallets").Subrouter()
+	openapi.Describe(adminRoutes.HandleFunc("/{phoneNumber}/tier", admin(h.changeTierHandler)).Methods(http.MethodPut), openapi.Operation{
+		Summary:   "Move a wallet to another verification tier",
+		Tag:       "admin",
+		Admin:     true,
+		Request:   models.WalletTierRequest{},
+		Responses: wallet,
+	})
+	openapi.Describe(adminRoutes.HandleFunc("/{phoneNumber}/tier/changes", admin(h.tierChangesHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "List the tier changes of a wallet",
+		Tag:       "admin",
+		Admin:     true,
+		Responses: map[int]interface{}{http.StatusOK: []models.WalletTierChange{}},
+	})
 }
 
 // Handler is a struct that holds the services and logger needed for handling wallet and transaction-related requests.
 type Handler struct {
 	WalletService      IWallet
 	TransactionService transactions.ITransaction
+	Ownership          *otp.Service
 	Logger             *logrus.Logger
 	Validator          *validator.Validate
-	Config             *Config
+	Config             *config.Value[Config]
+	Keys               auth.Keys
 }
 
-// NewHandler initializes a new Handler with the provided database connection and logger.
-// It sets up the WalletService and TransactionService with their respective dependencies.
-func NewHandler(db *db.DB, logger *logrus.Logger, validate *validator.Validate, config *Config) *Handler {
+// NewHandler initializes a new Handler with the provided wallet and transaction services and logger.
+// Registration asks ownership for the proof of phone ownership when the configuration requires it.
+// The routes accept the configured token and the API keys of keys; the routes that change
+// statuses, limits and tiers only accept the admin token and admin keys.
+func NewHandler(walletService IWallet, transactionService transactions.ITransaction, ownership *otp.Service,
+	logger *logrus.Logger, validate *validator.Validate, settings *config.Value[Config], keys auth.Keys) *Handler {
 	handler := &Handler{
 		Logger:             logger,
-		TransactionService: transactions.NewTransactionsService(logger, db),
-		WalletService:      NewWallet(logger, db),
+		TransactionService: transactionService,
+		Ownership:          ownership,
+		WalletService:      walletService,
 		Validator:          validate,
-		Config:             config,
+		Config:             settings,
+		Keys:               keys,
 	}
 	return handler
 }
 
 // createWalletHandler handles the creation of a new wallet.
 func (h *Handler) createWalletHandler(w http.ResponseWriter, r *http.Request) {
-	var wallet *models.Wallet
-	ctx := context.Background()
+	var request models.WalletRequest
+	ctx := r.Context()
 
-	err := json.NewDecoder(r.Body).Decode(&wallet)
-	if err != nil {
-		h.Logger.Error(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := utils.DecodeJSON(w, r, h.Validator, &request); err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
-	if !utils.CellphoneValidator(wallet.Phone) {
-		errors.Error(w, http.StatusBadRequest, "invalid phone")
-		return
-	}
-
-	if model, _ := h.WalletService.GetByPhone(ctx, wallet.Phone); model != nil {
-		errors.Error(w, http.StatusConflict, "wallet already exist")
+	phoneNumber, err := phone.Normalize(request.Phone)
+	if err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
-	wallet, err = h.WalletService.Create(ctx, wallet)
-	w.Header().Set("Content-Type", "application/json")
+	var wallet *models.Wallet
+	err = h.Ownership.Within(ctx, otp.PurposeRegistration, phoneNumber, r.Header.Get(otp.TokenHeader), func(ctx context.Context) error {
+		if model, _ := h.WalletService.GetByPhone(ctx, phoneNumber); model != nil {
+			return errors.ErrWalletExists
+		}
+		// The tier is only raised after verification, through the admin endpoint.
+		wallet, err = h.WalletService.Create(ctx, &models.Wallet{Phone: phoneNumber, Tier: models.TierUnverified})
+		if err != nil {
+			h.Logger.Error(err.Error())
+		}
+		return err
+	})
 	if err != nil {
-		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusInternalServerError, "wallet creation failed")
+		errors.Respond(w, err)
 		return
 	}
 
@@ -89,22 +172,27 @@ func (h *Handler) createWalletHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// deleteWalletHandler handles the deletion of a wallet by phone number.
+// deleteWalletHandler closes a wallet by phone number. Its transactions are kept. A wallet that
+// still holds funds is only closed when the settle query parameter is true.
 func (h *Handler) deleteWalletHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	var phoneNumber = vars["phoneNumber"]
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
 
-	if !utils.CellphoneValidator(phoneNumber) {
-		errors.Error(w, http.StatusBadRequest, "invalid phone")
+	settle, err := strconv.ParseBool(r.URL.Query().Get("settle"))
+	if err != nil && r.URL.Query().Has("settle") {
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("settle must be true or false"))
 		return
 	}
 
-	ctx := context.Background()
-	wallet, err := h.WalletService.Delete(ctx, phoneNumber)
+	ctx := r.Context()
+	wallet, err := h.WalletService.Close(ctx, phoneNumber, settle)
 
 	if err != nil {
 		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusInternalServerError, "failed to delete wallet")
+		errors.Respond(w, err)
 		return
 	}
 
@@ -120,25 +208,16 @@ func (h *Handler) deleteWalletHandler(w http.ResponseWriter, r *http.Request) {
 
 // transactionHandler handles wallet transactions (withdrawals and deposits).
 func (h *Handler) transactionHandler(w http.ResponseWriter, r *http.Request) {
-	var transaction *models.NewTransaction
-	vars := mux.Vars(r)
-	var phoneNumber = vars["phoneNumber"]
-
-	if !utils.CellphoneValidator(phoneNumber) {
-		errors.Error(w, http.StatusBadRequest, "invalid phone")
-		return
-	}
-
-	ctx := context.Background()
-	err := json.NewDecoder(r.Body).Decode(&transaction)
+	var transaction models.NewTransaction
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
 	if err != nil {
-		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusBadRequest)
+		errors.Respond(w, err)
 		return
 	}
 
-	if phoneNumber == "" {
-		errors.Error(w, http.StatusBadRequest, "wallet phone number is required")
+	ctx := r.Context()
+	if err := utils.DecodeJSON(w, r, h.Validator, &transaction); err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
@@ -146,7 +225,7 @@ func (h *Handler) transactionHandler(w http.ResponseWriter, r *http.Request) {
 	wallet, err := h.WalletService.GetByPhone(ctx, phoneNumber)
 	if err != nil {
 		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusInternalServerError, "wallet not found")
+		errors.Respond(w, err)
 		return
 	}
 
@@ -158,70 +237,149 @@ func (h *Handler) transactionHandler(w http.ResponseWriter, r *http.Request) {
 		Description: transaction.Description,
 	}
 
-	err = h.Validator.Struct(tx)
+	err = h.WalletService.Transaction(ctx, wallet, tx)
+	if err != nil {
+		h.Logger.WithFields(logrus.Fields{
+			"type":             "transaction",
+			"wallet_id":        wallet.ID,
+			"transaction_id":   tx.ID,
+			"transaction_type": tx.Type,
+			"amount":           tx.Amount,
+			"error":            err,
+		}).Error("Transaction failed")
+
+		errors.Respond(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(transaction); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
+
+// returnByPhoneNumber handles retrieving a wallet by phone number.
+func (h *Handler) returnByPhoneNumber(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	wallet, err := h.WalletService.GetByPhone(ctx, phoneNumber)
 	if err != nil {
 		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusBadRequest)
+		errors.Respond(w, err)
 		return
 	}
 
-	switch transaction.Type {
-	case models.Withdrawal:
-		transaction.Type = models.Withdrawal
-		if wallet.Amount < transaction.Amount {
-			h.Logger.WithFields(logrus.Fields{
-				"type":            "transaction",
-				"wallet_id":       wallet.ID,
-				"current_amount":  wallet.Amount,
-				"required_amount": transaction.Amount,
-			}).Error("Insufficient funds")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(wallet); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
+
+// changeStatusHandler freezes, suspends, reactivates or closes a wallet.
+func (h *Handler) changeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.WalletStatusRequest
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
 
-			errors.Error(w, http.StatusBadRequest, fmt.Sprintf("insufficient funds: wallet %s balance is %d, but %d is required", wallet.Phone, wallet.Amount, transaction.Amount))
-			return
-		}
-	case models.Deposit:
-		transaction.Type = models.Deposit
-	default:
-		errors.Error(w, http.StatusBadRequest, "unknown transaction type")
+	if err := utils.DecodeJSON(w, r, h.Validator, &request); err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
-	err = h.WalletService.Transaction(ctx, wallet, tx)
+	wallet, err := h.WalletService.ChangeStatus(r.Context(), phoneNumber, request.Status, request.Reason)
 	if err != nil {
-		h.Logger.WithFields(logrus.Fields{
-			"type":        "transaction",
-			"wallet_id":   wallet.ID,
-			"transaction": tx,
-			"error":       err,
-		}).Error("Transaction failed")
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
 
-		errors.Error(w, http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(wallet); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
+
+// limitsHandler returns the usage of a wallet against its transaction limits.
+func (h *Handler) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	report, err := h.WalletService.Limits(r.Context(), phoneNumber)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(report); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
 
-	if err = json.NewEncoder(w).Encode(transaction); err != nil {
+// setLimitsHandler replaces the limit overrides of a wallet. Limits left out of the
+// body fall back to the global configuration.
+func (h *Handler) setLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	var overrides models.WalletLimits
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	if err := utils.DecodeJSON(w, r, h.Validator, &overrides); err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	report, err := h.WalletService.SetLimits(r.Context(), phoneNumber, &overrides)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(report); err != nil {
 		errors.Error(w, http.StatusInternalServerError)
 	}
 }
 
-// returnByPhoneNumber handles retrieving a wallet by phone number.
-func (h *Handler) returnByPhoneNumber(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	phoneNumber := vars["phoneNumber"]
+// changeTierHandler moves a wallet to another verification tier once its owner has been verified.
+func (h *Handler) changeTierHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.WalletTierRequest
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
 
-	if !utils.CellphoneValidator(phoneNumber) {
-		errors.Error(w, http.StatusBadRequest, "invalid phone")
+	if err := utils.DecodeJSON(w, r, h.Validator, &request); err != nil {
+		errors.Respond(w, err)
 		return
 	}
 
-	ctx := context.Background()
-	wallet, err := h.WalletService.GetByPhone(ctx, phoneNumber)
+	wallet, err := h.WalletService.ChangeTier(r.Context(), phoneNumber, request.Tier, request.Reason)
 	if err != nil {
 		h.Logger.Error(err.Error())
-		errors.Error(w, http.StatusNotFound, "wallet not found")
+		errors.Respond(w, err)
 		return
 	}
 
@@ -231,3 +389,25 @@ func (h *Handler) returnByPhoneNumber(w http.ResponseWriter, r *http.Request) {
 		errors.Error(w, http.StatusInternalServerError)
 	}
 }
+
+// tierChangesHandler returns the audit trail of the tier changes of a wallet.
+func (h *Handler) tierChangesHandler(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := phone.Normalize(mux.Vars(r)["phoneNumber"])
+	if err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	changes, err := h.WalletService.TierChanges(r.Context(), phoneNumber)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(changes); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,496 @@
+package wallets_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/otp"
+	"payment/internal/wallets"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/middleware"
+	"payment/pkg/utils"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	token      = "test-token"
+	adminToken = "test-admin-token"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	return newServerWithConfig(t, wallets.Config{})
+}
+
+func newServerWithConfig(t *testing.T, settings wallets.Config) *httptest.Server {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	validate := validator.New()
+	validate.RegisterTagNameFunc(utils.JSONTagName)
+	if err := validate.RegisterValidation("description", utils.DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+
+	db := memory.NewDB()
+	transactionService := memory.NewTransactions(db)
+	settings.AuthToken, settings.AdminToken = token, adminToken
+	auditor := audit.NewService(logger, memory.NewAudit(db), db)
+	walletService := wallets.NewWallet(logger, memory.NewWallets(db), transactionService, db, auditor,
+		events.NewOutbox(memory.NewOutbox(db)), config.NewValue(&settings))
+	ownership := otp.NewService(logger, memory.NewOTP(db), db, otp.NewConsoleSender(io.Discard), config.NewValue(&otp.Config{}))
+	handler := wallets.NewHandler(walletService, transactionService, ownership, logger, validate, config.NewValue(&settings), nil)
+
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	server := httptest.NewServer(middleware.RequestID(router))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// do sends a request with the token the route needs: the admin token for the routes that change
+// statuses, limits and tiers, and the API token otherwise.
+func do(t *testing.T, server *httptest.Server, method, path, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := token
+	if strings.HasPrefix(path, "/admin/") ||
+		method == http.MethodPut && (strings.HasSuffix(path, "/status") || strings.HasSuffix(path, "/limits")) {
+		key = adminToken
+	}
+	req.Header.Set("Authorization", key)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func errorCode(t *testing.T, body []byte) errors.Code {
+	t.Helper()
+	var message errors.ErrorMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatalf("invalid error body %q: %v", body, err)
+	}
+	return message.Code
+}
+
+// verify raises the wallet of phone to the basic tier, which allows withdrawals.
+func verify(t *testing.T, server *httptest.Server, phone string) {
+	t.Helper()
+	resp, body := do(t, server, http.MethodPut, "/admin/wallets/"+phone+"/tier",
+		`{"tier": "basic", "reason": "identity verified"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("verify: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestWalletLifecycle(t *testing.T) {
+	server := newServer(t)
+
+	resp, body := do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	if resp.StatusCode != http.StatusConflict || errorCode(t, body) != errors.CodeWalletExists {
+		t.Fatalf("duplicate register: got %d %s", resp.StatusCode, body)
+	}
+	verify(t, server, "989121234567")
+
+	resp, body = do(t, server, http.MethodPut, "/wallet/989121234567",
+		`{"amount": 5000, "description": "salary", "type": "deposit"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("deposit: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodPut, "/wallet/989121234567",
+		`{"amount": 7000, "description": "rent", "type": "withdrawal"}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity || errorCode(t, body) != errors.CodeInsufficientFunds {
+		t.Fatalf("overdraft: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodPut, "/wallet/989121234567",
+		`{"amount": 2000, "description": "groceries", "type": "withdrawal"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("withdrawal: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodGet, "/wallet/989121234567", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get: got %d %s", resp.StatusCode, body)
+	}
+	var wallet struct {
+		Amount       int64             `json:"amount"`
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	if err := json.Unmarshal(body, &wallet); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Amount != 3000 || len(wallet.Transactions) != 2 {
+		t.Fatalf("got balance %d with %d transactions, want 3000 with 2", wallet.Amount, len(wallet.Transactions))
+	}
+
+	resp, body = do(t, server, http.MethodDelete, "/wallet/989121234567", "")
+	if resp.StatusCode != http.StatusConflict || errorCode(t, body) != errors.CodeWalletNotEmpty {
+		t.Fatalf("close with balance: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodDelete, "/wallet/989121234567?settle=true", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("close with settlement: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodGet, "/wallet/989121234567", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get closed: got %d %s", resp.StatusCode, body)
+	}
+	var closed struct {
+		Status       string            `json:"status"`
+		Amount       int64             `json:"amount"`
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	if err := json.Unmarshal(body, &closed); err != nil {
+		t.Fatal(err)
+	}
+	if closed.Status != "closed" || closed.Amount != 0 || len(closed.Transactions) != 3 {
+		t.Fatalf("got %s, want a closed, settled wallet that keeps its ledger", body)
+	}
+
+	resp, body = do(t, server, http.MethodDelete, "/wallet/989121234567", "")
+	if resp.StatusCode != http.StatusGone || errorCode(t, body) != errors.CodeWalletClosed {
+		t.Fatalf("close twice: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestTransactionValidation(t *testing.T) {
+	server := newServer(t)
+	do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+
+	resp, body := do(t, server, http.MethodPut, "/wallet/989121234567", `{"amount": 10, "type": "deposit"}`)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != errors.CodeValidation {
+		t.Fatalf("missing description: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodPut, "/wallet/989121234567",
+		`{"amount": 10, "description": "gift", "type": "refund"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"field":"type","rule":"oneof"`) {
+		t.Fatalf("unknown type: got %d %s", resp.StatusCode, body)
+	}
+
+	for _, amount := range []string{"0", "-100"} {
+		resp, body = do(t, server, http.MethodPut, "/wallet/989121234567",
+			`{"amount": `+amount+`, "description": "gift", "type": "deposit"}`)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"field":"amount","rule":"gt"`) {
+			t.Fatalf("amount %s: got %d %s", amount, resp.StatusCode, body)
+		}
+	}
+
+	resp, body = do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234568", "amount": 1000000}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"field":"amount","rule":"unknown"`) {
+		t.Fatalf("register with a balance: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = do(t, server, http.MethodPut, "/wallet/not-a-phone", `{}`)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != errors.CodeInvalidPhone {
+		t.Fatalf("invalid phone: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestWalletStatus(t *testing.T) {
+	server := newServer(t)
+	const path = "/wallet/989121234567"
+	deposit := `{"amount": 1000, "description": "salary", "type": "deposit"}`
+	withdrawal := `{"amount": 100, "description": "rent", "type": "withdrawal"}`
+
+	do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	verify(t, server, "989121234567")
+	do(t, server, http.MethodPut, path, deposit)
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   errors.Code
+	}{
+		{"freeze", http.MethodPut, path + "/status", `{"status": "frozen", "reason": "chargeback dispute"}`, http.StatusOK, ""},
+		{"frozen deposit", http.MethodPut, path, deposit, http.StatusOK, ""},
+		{"frozen withdrawal", http.MethodPut, path, withdrawal, http.StatusLocked, errors.CodeWalletFrozen},
+		{"suspend", http.MethodPut, path + "/status", `{"status": "suspended", "reason": "fraud review"}`, http.StatusOK, ""},
+		{"suspended deposit", http.MethodPut, path, deposit, http.StatusLocked, errors.CodeWalletSuspended},
+		{"close with balance", http.MethodPut, path + "/status", `{"status": "closed", "reason": "customer request"}`, http.StatusConflict, errors.CodeWalletNotEmpty},
+		{"unknown status", http.MethodPut, path + "/status", `{"status": "deleted", "reason": "x"}`, http.StatusBadRequest, errors.CodeValidation},
+		{"missing reason", http.MethodPut, path + "/status", `{"status": "active"}`, http.StatusBadRequest, errors.CodeValidation},
+		{"reactivate", http.MethodPut, path + "/status", `{"status": "active", "reason": "review cleared"}`, http.StatusOK, ""},
+		{"empty wallet", http.MethodPut, path, `{"amount": 2000, "description": "payout", "type": "withdrawal"}`, http.StatusOK, ""},
+		{"close", http.MethodPut, path + "/status", `{"status": "closed", "reason": "customer request"}`, http.StatusOK, ""},
+		{"closed deposit", http.MethodPut, path, deposit, http.StatusGone, errors.CodeWalletClosed},
+		{"reopen", http.MethodPut, path + "/status", `{"status": "active", "reason": "mistake"}`, http.StatusGone, errors.CodeWalletClosed},
+	}
+	for _, step := range steps {
+		resp, body := do(t, server, step.method, step.path, step.body)
+		if resp.StatusCode != step.status {
+			t.Fatalf("%s: got %d %s, want %d", step.name, resp.StatusCode, body, step.status)
+		}
+		if step.code != "" && errorCode(t, body) != step.code {
+			t.Fatalf("%s: got %s, want code %s", step.name, body, step.code)
+		}
+	}
+
+	_, body := do(t, server, http.MethodGet, path, "")
+	var wallet struct {
+		Status          string  `json:"status"`
+		StatusReason    string  `json:"status_reason"`
+		StatusChangedAt *string `json:"status_changed_at"`
+	}
+	if err := json.Unmarshal(body, &wallet); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Status != "closed" || wallet.StatusReason != "customer request" || wallet.StatusChangedAt == nil {
+		t.Fatalf("got %s", body)
+	}
+}
+
+func TestWalletLimits(t *testing.T) {
+	server := newServerWithConfig(t, wallets.Config{Limits: wallets.Limits{
+		SingleWithdrawal:   1000,
+		DailyDeposit:       5000,
+		MaxBalance:         4000,
+		HourlyTransactions: 4,
+	}})
+	const path = "/wallet/989121234567"
+	transaction := func(amount int, kind string) string {
+		return `{"amount": ` + strconv.Itoa(amount) + `, "description": "test", "type": "` + kind + `"}`
+	}
+	do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	verify(t, server, "989121234567")
+
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		status    int
+		limit     string
+		remaining int64
+	}{
+		{"deposit", http.MethodPut, path, transaction(3000, "deposit"), http.StatusOK, "", 0},
+		{"large withdrawal", http.MethodPut, path, transaction(1500, "withdrawal"), http.StatusUnprocessableEntity, "single_withdrawal", 1000},
+		{"over max balance", http.MethodPut, path, transaction(2000, "deposit"), http.StatusUnprocessableEntity, "max_balance", 1000},
+		{"negative override", http.MethodPut, path + "/limits", `{"max_balance": -1}`, http.StatusBadRequest, "", 0},
+		{"lift max balance", http.MethodPut, path + "/limits", `{"max_balance": 0}`, http.StatusOK, "", 0},
+		{"deposit up to daily cap", http.MethodPut, path, transaction(2000, "deposit"), http.StatusOK, "", 0},
+		{"over daily deposit", http.MethodPut, path, transaction(1, "deposit"), http.StatusUnprocessableEntity, "daily_deposit", 0},
+		{"withdrawal", http.MethodPut, path, transaction(500, "withdrawal"), http.StatusOK, "", 0},
+		{"withdrawal", http.MethodPut, path, transaction(500, "withdrawal"), http.StatusOK, "", 0},
+		{"too many transactions", http.MethodPut, path, transaction(500, "withdrawal"), http.StatusUnprocessableEntity, "hourly_transactions", 0},
+	}
+	for _, step := range steps {
+		resp, body := do(t, server, step.method, step.path, step.body)
+		if resp.StatusCode != step.status {
+			t.Fatalf("%s: got %d %s, want %d", step.name, resp.StatusCode, body, step.status)
+		}
+		if step.limit == "" {
+			continue
+		}
+		var message struct {
+			Code errors.Code          `json:"code"`
+			Data models.LimitExceeded `json:"data"`
+		}
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Code != errors.CodeLimitExceeded || string(message.Data.Name) != step.limit ||
+			message.Data.Remaining == nil || *message.Data.Remaining != step.remaining {
+			t.Fatalf("%s: got %s, want %s exceeded with %d remaining", step.name, body, step.limit, step.remaining)
+		}
+	}
+
+	_, body := do(t, server, http.MethodGet, path+"/limits", "")
+	var report models.WalletLimitsReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	usage := make(map[models.LimitName]models.LimitUsage)
+	for _, u := range report.Limits {
+		usage[u.Name] = u
+	}
+	if daily := usage[models.LimitDailyDeposit]; daily.Used != 5000 || *daily.Remaining != 0 || daily.ResetsAt == nil {
+		t.Fatalf("got daily deposit usage %+v, want 5000 used", daily)
+	}
+	if balance := usage[models.LimitMaxBalance]; balance.Used != 4000 || balance.Limit != nil {
+		t.Fatalf("got balance usage %+v, want the limit lifted by the override", balance)
+	}
+	if hourly := usage[models.LimitHourlyTransactions]; hourly.Used != 4 {
+		t.Fatalf("got %d transactions this hour, want 4", hourly.Used)
+	}
+
+	resp, body := do(t, server, http.MethodDelete, path+"?settle=true", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("settlement above the withdrawal limit: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestWalletTiers(t *testing.T) {
+	server := newServerWithConfig(t, wallets.Config{
+		Limits: wallets.Limits{MaxBalance: 3000},
+		Tiers: map[models.WalletTier]wallets.Limits{
+			models.TierUnverified: {MaxBalance: 1000},
+			models.TierBasic:      {MaxBalance: 5000},
+		},
+	})
+	const path = "/wallet/989121234567"
+	deposit := func(amount string) string {
+		return `{"amount": ` + amount + `, "description": "salary", "type": "deposit"}`
+	}
+	withdrawal := `{"amount": 500, "description": "rent", "type": "withdrawal"}`
+
+	resp, body := do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567", "tier": "full"}`)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != errors.CodeValidation {
+		t.Fatalf("register with a tier: got %d %s", resp.StatusCode, body)
+	}
+	resp, body = do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	var wallet models.Wallet
+	if err := json.Unmarshal(body, &wallet); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: got %d %s", resp.StatusCode, body)
+	}
+	if wallet.Tier != models.TierUnverified {
+		t.Fatalf("got tier %q, want new wallets to start unverified", wallet.Tier)
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   errors.Code
+	}{
+		{"unverified withdrawal", http.MethodPut, path, withdrawal, http.StatusForbidden, errors.CodeTierNotAllowed},
+		{"unverified deposit", http.MethodPut, path, deposit("1000"), http.StatusOK, ""},
+		{"over unverified balance", http.MethodPut, path, deposit("1"), http.StatusUnprocessableEntity, errors.CodeLimitExceeded},
+		{"unknown tier", http.MethodPut, "/admin/wallets/989121234567/tier", `{"tier": "gold", "reason": "x"}`, http.StatusBadRequest, errors.CodeValidation},
+		{"missing reason", http.MethodPut, "/admin/wallets/989121234567/tier", `{"tier": "basic"}`, http.StatusBadRequest, errors.CodeValidation},
+		{"verify", http.MethodPut, "/admin/wallets/989121234567/tier", `{"tier": "basic", "reason": "passport checked"}`, http.StatusOK, ""},
+		{"basic deposit", http.MethodPut, path, deposit("2000"), http.StatusOK, ""},
+		{"over global balance", http.MethodPut, path, deposit("1"), http.StatusUnprocessableEntity, errors.CodeLimitExceeded},
+		{"basic withdrawal", http.MethodPut, path, withdrawal, http.StatusOK, ""},
+	}
+	for _, step := range steps {
+		resp, body := do(t, server, step.method, step.path, step.body)
+		if resp.StatusCode != step.status {
+			t.Fatalf("%s: got %d %s, want %d", step.name, resp.StatusCode, body, step.status)
+		}
+		if step.code != "" && errorCode(t, body) != step.code {
+			t.Fatalf("%s: got %s, want code %s", step.name, body, step.code)
+		}
+	}
+
+	_, body = do(t, server, http.MethodGet, "/admin/wallets/989121234567/tier/changes", "")
+	var changes []models.WalletTierChange
+	if err := json.Unmarshal(body, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].From != models.TierUnverified || changes[0].To != models.TierBasic ||
+		changes[0].Reason != "passport checked" || changes[0].Actor != auth.KeyID(adminToken) {
+		t.Fatalf("got tier changes %s, want one audited change to basic", body)
+	}
+}
+
+func TestCloseUnverifiedWallet(t *testing.T) {
+	server := newServerWithConfig(t, wallets.Config{Limits: wallets.Limits{SingleWithdrawal: 100}})
+	const path = "/wallet/989121234567"
+	do(t, server, http.MethodPost, "/wallet/register", `{"phone": "989121234567"}`)
+	do(t, server, http.MethodPut, path, `{"amount": 800, "description": "salary", "type": "deposit"}`)
+
+	resp, body := do(t, server, http.MethodPut, path, `{"amount": 50, "description": "rent", "type": "withdrawal"}`)
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, body) != errors.CodeTierNotAllowed {
+		t.Fatalf("unverified withdrawal: got %d %s", resp.StatusCode, body)
+	}
+	resp, body = do(t, server, http.MethodDelete, path+"?settle=true", "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("close with settlement: got %d %s", resp.StatusCode, body)
+	}
+
+	_, body = do(t, server, http.MethodGet, path, "")
+	var wallet models.Wallet
+	if err := json.Unmarshal(body, &wallet); err != nil {
+		t.Fatal(err)
+	}
+	if wallet.Status != models.WalletClosed || wallet.Tier != models.TierUnverified || wallet.Amount != 0 {
+		t.Fatalf("got %s, want the unverified wallet settled and closed", body)
+	}
+}
+
+// unsettled is a ledger that cannot complete transactions.
+type unsettled struct {
+	*memory.Transactions
+}
+
+func (unsettled) ChangeStatus(context.Context, uuid.UUID, models.Status) error {
+	return errors.ErrInternal.WithMessage("ledger unavailable")
+}
+
+func TestFailedSettlementsAndAdjustmentsAreKept(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := memory.NewDB()
+	ledger := memory.NewTransactions(db)
+	store := memory.NewWallets(db)
+	walletService := wallets.NewWallet(logger, store, unsettled{ledger}, db, audit.NewService(logger, memory.NewAudit(db), db),
+		events.NewOutbox(memory.NewOutbox(db)), config.NewValue(&wallets.Config{}))
+	ctx := context.Background()
+
+	wallet := &models.Wallet{Phone: "989121234567", Amount: 500, Tier: models.TierUnverified}
+	if err := store.Save(ctx, wallet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walletService.Adjust(ctx, wallet.ID, -100, "chargeback"); !errors.Is(err, errors.ErrTransactionFailed) {
+		t.Fatalf("adjust: got %v, want %v", err, errors.ErrTransactionFailed)
+	}
+	if _, err := walletService.Close(ctx, wallet.Phone, true); !errors.Is(err, errors.ErrTransactionFailed) {
+		t.Fatalf("close: got %v, want %v", err, errors.ErrTransactionFailed)
+	}
+
+	transactions, err := ledger.List(ctx, wallet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, transaction := range transactions {
+		if transaction.Status != models.Failed {
+			t.Errorf("got a %s transaction %q, want only failed ones", transaction.Status, transaction.Description)
+		}
+		descriptions = append(descriptions, transaction.Description)
+	}
+	if len(descriptions) != 2 {
+		t.Fatalf("got transactions %q, want the failed adjustment and settlement", descriptions)
+	}
+}
@@ -0,0 +1,217 @@
+package wallets
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/tracing"
+	"time"
+)
+
+// Limits caps the transactions of a wallet. A zero limit is not enforced.
+type Limits struct {
+	SingleWithdrawal   int64
+	DailyWithdrawal    int64
+	MonthlyWithdrawal  int64
+	DailyDeposit       int64
+	MonthlyDeposit     int64
+	MaxBalance         int64
+	HourlyTransactions int64
+}
+
+// override returns the limits with the overrides of a single wallet applied.
+func (l Limits) override(overrides *models.WalletLimits) Limits {
+	if overrides == nil {
+		return l
+	}
+	set := func(limit *int64, value *int64) {
+		if value != nil {
+			*limit = *value
+		}
+	}
+	set(&l.SingleWithdrawal, overrides.SingleWithdrawal)
+	set(&l.DailyWithdrawal, overrides.DailyWithdrawal)
+	set(&l.MonthlyWithdrawal, overrides.MonthlyWithdrawal)
+	set(&l.DailyDeposit, overrides.DailyDeposit)
+	set(&l.MonthlyDeposit, overrides.MonthlyDeposit)
+	set(&l.MaxBalance, overrides.MaxBalance)
+	set(&l.HourlyTransactions, overrides.HourlyTransactions)
+	return l
+}
+
+// effectiveLimits returns the limits of wallet: the global limits narrowed by those of its
+// tier, then replaced by its own overrides, which are returned as well.
+func (r *WalletService) effectiveLimits(ctx context.Context, wallet *models.Wallet) (Limits, *models.WalletLimits, error) {
+	overrides, err := r.store.FindLimits(ctx, wallet.ID)
+	if err != nil {
+		return Limits{}, nil, err
+	}
+	settings := r.config.Load()
+	return settings.Limits.tighten(settings.Tiers[wallet.Tier]).override(overrides), overrides, nil
+}
+
+// periods holds the start of the windows that limits are counted over. Daily and monthly
+// limits follow the calendar in the time zone of now; the hourly count is a sliding window.
+type periods struct {
+	hour, day, month time.Time
+}
+
+func periodsAt(now time.Time) periods {
+	year, month, day := now.Date()
+	return periods{
+		hour:  now.Add(-time.Hour),
+		day:   time.Date(year, month, day, 0, 0, 0, 0, now.Location()),
+		month: time.Date(year, month, 1, 0, 0, 0, 0, now.Location()),
+	}
+}
+
+// usage reports the completed transactions and the balance of wallet against limits.
+func (r *WalletService) usage(ctx context.Context, wallet *models.Wallet, limits Limits, now time.Time) ([]models.LimitUsage, error) {
+	p := periodsAt(now)
+	hour, err := r.transaction.Totals(ctx, wallet.ID, p.hour)
+	if err != nil {
+		return nil, err
+	}
+	day, err := r.transaction.Totals(ctx, wallet.ID, p.day)
+	if err != nil {
+		return nil, err
+	}
+	month, err := r.transaction.Totals(ctx, wallet.ID, p.month)
+	if err != nil {
+		return nil, err
+	}
+
+	nextDay, nextMonth := p.day.AddDate(0, 0, 1), p.month.AddDate(0, 1, 0)
+	return []models.LimitUsage{
+		newUsage(models.LimitSingleWithdrawal, limits.SingleWithdrawal, 0, nil),
+		newUsage(models.LimitDailyWithdrawal, limits.DailyWithdrawal, day.Withdrawals, &nextDay),
+		newUsage(models.LimitMonthlyWithdrawal, limits.MonthlyWithdrawal, month.Withdrawals, &nextMonth),
+		newUsage(models.LimitDailyDeposit, limits.DailyDeposit, day.Deposits, &nextDay),
+		newUsage(models.LimitMonthlyDeposit, limits.MonthlyDeposit, month.Deposits, &nextMonth),
+		newUsage(models.LimitMaxBalance, limits.MaxBalance, wallet.Amount, nil),
+		newUsage(models.LimitHourlyTransactions, limits.HourlyTransactions, hour.Count, nil),
+	}, nil
+}
+
+func newUsage(name models.LimitName, limit, used int64, resetsAt *time.Time) models.LimitUsage {
+	usage := models.LimitUsage{Name: name, Used: used, ResetsAt: resetsAt}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		usage.Limit, usage.Remaining = &limit, &remaining
+	}
+	return usage
+}
+
+// requested returns how much transaction adds to the usage counted by the named limit.
+func requested(name models.LimitName, transaction *models.Transaction) int64 {
+	switch name {
+	case models.LimitSingleWithdrawal, models.LimitDailyWithdrawal, models.LimitMonthlyWithdrawal:
+		if transaction.Type == models.Withdrawal {
+			return transaction.Amount
+		}
+	case models.LimitDailyDeposit, models.LimitMonthlyDeposit, models.LimitMaxBalance:
+		if transaction.Type == models.Deposit {
+			return transaction.Amount
+		}
+	case models.LimitHourlyTransactions:
+		return 1
+	}
+	return 0
+}
+
+// checkLimits rejects transaction when it would take wallet over one of its limits. It runs
+// under the wallet row lock, so concurrent transactions of the same wallet are counted.
+func (r *WalletService) checkLimits(ctx context.Context, wallet *models.Wallet, transaction *models.Transaction) error {
+	limits, _, err := r.effectiveLimits(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if limits == (Limits{}) {
+		return nil
+	}
+
+	usages, err := r.usage(ctx, wallet, limits, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		amount := requested(usage.Name, transaction)
+		if usage.Remaining == nil || amount <= *usage.Remaining {
+			continue
+		}
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+			"type":      "transaction",
+			"wallet_id": wallet.ID,
+			"limit":     usage.Name,
+			"used":      usage.Used,
+			"requested": amount,
+		}).Warn("transaction limit exceeded")
+
+		return errors.ErrLimitExceeded.
+			WithMessage("%s limit of %d exceeded: %d remaining, %d requested",
+				usage.Name, *usage.Limit, *usage.Remaining, amount).
+			WithData(models.LimitExceeded{LimitUsage: usage, Requested: amount})
+	}
+	return nil
+}
+
+func (r *WalletService) Limits(ctx context.Context, phone string) (*models.WalletLimitsReport, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.Limits")
+	defer span.End()
+
+	wallet, err := r.store.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	limits, overrides, err := r.effectiveLimits(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+	usages, err := r.usage(ctx, wallet, limits, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &models.WalletLimitsReport{WalletID: wallet.ID, Overrides: overrides, Limits: usages}, nil
+}
+
+func (r *WalletService) SetLimits(ctx context.Context, phone string, overrides *models.WalletLimits) (*models.WalletLimitsReport, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.SetLimits")
+	defer span.End()
+
+	wallet, err := r.store.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	overrides.WalletID = wallet.ID
+	err = r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.store.Lock(ctx, wallet.ID); err != nil {
+			return err
+		}
+		previous, err := r.store.FindLimits(ctx, wallet.ID)
+		if err != nil {
+			return err
+		}
+		if err = r.store.SaveLimits(ctx, overrides); err != nil {
+			return err
+		}
+		var before interface{}
+		if previous != nil {
+			before = previous
+		}
+		return record(ctx, r.auditor, audit.ActionWalletLimits, wallet.ID, before, overrides)
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
+		return nil, err
+	}
+
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+		"section": "wallet",
+		"mode":    "limits",
+		"wallet":  wallet.ID,
+	}).Info("wallet limits changed")
+	return r.Limits(ctx, phone)
+}
@@ -0,0 +1,313 @@
+package wallets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/db"
+	"payment/pkg/logging"
+	"payment/pkg/phone"
+	"sort"
+	"time"
+)
+
+// phoneBatch bounds how many wallets are read at once.
+const phoneBatch = 500
+
+// What a PhoneMigration does, or would do, to a wallet.
+const (
+	// PhoneNormalized means the phone number of the wallet is rewritten in E.164 form.
+	PhoneNormalized = "normalized"
+	// PhoneMerged means the wallet is a duplicate whose balance, transactions, discount usages
+	// and notifications move to the wallet that keeps the number, and which is then closed.
+	PhoneMerged = "merged"
+	// PhoneFlagged means the wallet is a duplicate that is frozen or suspended, or holds funds
+	// the wallet that keeps the number cannot take. It is marked as a duplicate but otherwise
+	// left for an operator to settle.
+	PhoneFlagged = "flagged"
+	// PhoneInvalid means the phone number of the wallet is not a valid mobile number.
+	PhoneInvalid = "invalid"
+)
+
+// PhoneChange describes what happens to one wallet when phone numbers are normalized.
+type PhoneChange struct {
+	WalletID uuid.UUID           `json:"wallet_id"`
+	Action   string              `json:"action"`
+	Phone    string              `json:"phone"`
+	Number   string              `json:"number,omitempty"`
+	Into     *uuid.UUID          `json:"into,omitempty"`
+	Balance  int64               `json:"balance"`
+	Status   models.WalletStatus `json:"status"`
+}
+
+// PhoneMigration rewrites the phone numbers stored before they were normalized to E.164, once
+// after upgrading. Wallets registered under several forms of one number are merged into the
+// wallet that keeps the number unless they need an operator, and flagged otherwise.
+type PhoneMigration struct {
+	store      Store
+	transactor db.Transactor
+	auditor    audit.Recorder
+	logger     *log.Logger
+}
+
+// NewPhoneMigration creates the migration of the phone numbers of the wallets in store.
+func NewPhoneMigration(logger *log.Logger, store Store, transactor db.Transactor, auditor audit.Recorder) *PhoneMigration {
+	return &PhoneMigration{store: store, transactor: transactor, auditor: auditor, logger: logger}
+}
+
+// phoneState is what the audit log keeps of a normalized or merged wallet, and of the wallet
+// a duplicate was merged into. The numbers are left out, like in walletState.
+type phoneState struct {
+	Normalized  bool                `json:"normalized,omitempty"`
+	DuplicateOf *uuid.UUID          `json:"duplicate_of,omitempty"`
+	Merged      *uuid.UUID          `json:"merged,omitempty"`
+	Balance     int64               `json:"balance"`
+	Status      models.WalletStatus `json:"status"`
+}
+
+// phoneEntry is an audit entry of the migration of one number.
+type phoneEntry struct {
+	wallet        uuid.UUID
+	before, after *phoneState
+}
+
+// MergedPhone returns the placeholder that replaces the phone number of the merged wallet id,
+// which gives up its number to the wallet it was merged into. It is unique per wallet, fits the
+// phone column and never passes phone validation.
+func MergedPhone(id uuid.UUID) string {
+	sum := sha256.Sum256(id[:])
+	return "merged-" + hex.EncodeToString(sum[:])[:13]
+}
+
+// Run returns the changes the phone numbers of the wallets need and makes them when apply is
+// true. Running it again only reports the flagged wallets, which it leaves as they are.
+func (m *PhoneMigration) Run(ctx context.Context, apply bool) ([]*PhoneChange, error) {
+	groups, changes, err := m.group(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]string, 0, len(groups))
+	for number := range groups {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+	for _, number := range numbers {
+		planned := plan(number, groups[number])
+		if apply && len(planned) > 0 {
+			if err = m.apply(ctx, planned); err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, planned...)
+	}
+
+	if apply {
+		logging.FromContext(ctx, m.logger).WithFields(log.Fields{
+			"section": "wallet",
+			"mode":    "phones",
+			"changes": len(changes),
+		}).Info("normalized wallet phone numbers")
+	}
+	return changes, nil
+}
+
+// group reads every wallet and groups them by normalized phone number. Wallets whose number
+// does not normalize are reported as invalid; anonymized wallets, and those merged by an
+// earlier run, are skipped.
+func (m *PhoneMigration) group(ctx context.Context) (map[string][]*models.Wallet, []*PhoneChange, error) {
+	groups := map[string][]*models.Wallet{}
+	var invalid []*PhoneChange
+	after := uuid.Nil
+	for {
+		wallets, err := m.store.List(ctx, after, phoneBatch)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, wallet := range wallets {
+			if wallet.AnonymizedAt != nil || wallet.Phone == MergedPhone(wallet.ID) {
+				continue
+			}
+			number, err := phone.Normalize(wallet.Phone)
+			if err != nil {
+				invalid = append(invalid, changeOf(wallet, PhoneInvalid, ""))
+				continue
+			}
+			groups[number] = append(groups[number], wallet)
+		}
+		if len(wallets) < phoneBatch {
+			return groups, invalid, nil
+		}
+		after = wallets[len(wallets)-1].ID
+	}
+}
+
+// plan returns the changes the wallets sharing number need. The wallet already stored under
+// number keeps it; otherwise the oldest wallet that is not closed does, or the oldest of all.
+func plan(number string, wallets []*models.Wallet) []*PhoneChange {
+	sort.SliceStable(wallets, func(i, j int) bool { return wallets[i].CreatedAt.Before(wallets[j].CreatedAt) })
+	keeper := wallets[0]
+	for _, wallet := range wallets {
+		if wallet.Phone == number {
+			keeper = wallet
+			break
+		}
+		if keeper.Status == models.WalletClosed && wallet.Status != models.WalletClosed {
+			keeper = wallet
+		}
+	}
+
+	var changes []*PhoneChange
+	if keeper.Phone != number {
+		changes = append(changes, changeOf(keeper, PhoneNormalized, number))
+	}
+	for _, wallet := range wallets {
+		if wallet == keeper {
+			continue
+		}
+		action := PhoneFlagged
+		if mergeable(wallet, keeper) {
+			action = PhoneMerged
+		}
+		change := changeOf(wallet, action, number)
+		change.Into = &keeper.ID
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// mergeable reports whether the duplicate wallet can be merged into keeper: it is neither frozen
+// nor suspended, and its funds, if any, go to an active wallet.
+func mergeable(wallet, keeper *models.Wallet) bool {
+	return (wallet.Status == models.WalletActive || wallet.Status == models.WalletClosed) &&
+		(wallet.Amount == 0 || keeper.Status == models.WalletActive)
+}
+
+func changeOf(wallet *models.Wallet, action, number string) *PhoneChange {
+	return &PhoneChange{
+		WalletID: wallet.ID,
+		Action:   action,
+		Phone:    wallet.Phone,
+		Number:   number,
+		Balance:  wallet.Amount,
+		Status:   wallet.Status,
+	}
+}
+
+// apply makes the changes of one number in a transaction, so that a number is either fully
+// migrated or not at all. Every wallet involved is locked first, in order of ID, and the audit
+// entries are recorded last, so that the migration does not hold the audit log while it waits
+// for a wallet that a customer transaction holds.
+func (m *PhoneMigration) apply(ctx context.Context, changes []*PhoneChange) error {
+	return m.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		wallets, err := m.lock(ctx, changes)
+		if err != nil {
+			return err
+		}
+		var entries []phoneEntry
+		for _, change := range changes {
+			switch change.Action {
+			case PhoneNormalized:
+				entries, err = m.normalize(ctx, change, wallets[change.WalletID], entries)
+			case PhoneMerged, PhoneFlagged:
+				entries, err = m.markDuplicate(ctx, change, wallets[change.WalletID], wallets[*change.Into], entries)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if err = record(ctx, m.auditor, audit.ActionWalletPhone, entry.wallet, entry.before, entry.after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lock locks the wallets changes touch, in order of ID.
+func (m *PhoneMigration) lock(ctx context.Context, changes []*PhoneChange) (map[uuid.UUID]*models.Wallet, error) {
+	var ids []uuid.UUID
+	wallets := map[uuid.UUID]*models.Wallet{}
+	for _, change := range changes {
+		for _, id := range []*uuid.UUID{&change.WalletID, change.Into} {
+			if id == nil {
+				continue
+			}
+			if _, ok := wallets[*id]; !ok {
+				wallets[*id] = nil
+				ids = append(ids, *id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	for _, id := range ids {
+		wallet, err := m.store.Lock(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		wallets[id] = wallet
+	}
+	return wallets, nil
+}
+
+func (m *PhoneMigration) normalize(ctx context.Context, change *PhoneChange, wallet *models.Wallet,
+	entries []phoneEntry) ([]phoneEntry, error) {
+	if err := m.store.UpdatePhone(ctx, wallet.ID, change.Number); err != nil {
+		return nil, err
+	}
+	return append(entries, phoneEntry{wallet: wallet.ID,
+		after: &phoneState{Normalized: true, Balance: wallet.Amount, Status: wallet.Status}}), nil
+}
+
+// markDuplicate flags wallet as a duplicate of keeper, or merges it into keeper: its balance is
+// added to that of keeper, its history moves to keeper, and it is closed.
+func (m *PhoneMigration) markDuplicate(ctx context.Context, change *PhoneChange, wallet, keeper *models.Wallet,
+	entries []phoneEntry) ([]phoneEntry, error) {
+	if change.Action == PhoneMerged && !mergeable(wallet, keeper) {
+		// The wallets changed since the plan was made.
+		change.Action = PhoneFlagged
+	}
+	before := &phoneState{DuplicateOf: wallet.DuplicateOf, Balance: wallet.Amount, Status: wallet.Status}
+	after := &phoneState{DuplicateOf: &keeper.ID, Balance: wallet.Amount, Status: wallet.Status}
+	if change.Action == PhoneFlagged {
+		if wallet.DuplicateOf != nil {
+			// Flagged by an earlier run.
+			return entries, nil
+		}
+		if err := m.store.MarkDuplicate(ctx, wallet.ID, keeper.ID, change.Number); err != nil {
+			return nil, err
+		}
+		return append(entries, phoneEntry{wallet: wallet.ID, before: before, after: after}), nil
+	}
+
+	if wallet.Amount != 0 {
+		received := &phoneState{Balance: keeper.Amount, Status: keeper.Status}
+		keeper.Amount += wallet.Amount
+		if err := m.store.UpdateBalance(ctx, keeper.ID, keeper.Amount); err != nil {
+			return nil, err
+		}
+		if err := m.store.UpdateBalance(ctx, wallet.ID, 0); err != nil {
+			return nil, err
+		}
+		entries = append(entries, phoneEntry{wallet: keeper.ID, before: received,
+			after: &phoneState{Merged: &wallet.ID, Balance: keeper.Amount, Status: keeper.Status}})
+		after.Balance = 0
+	}
+	if err := m.store.Merge(ctx, wallet.ID, keeper.ID, change.Number, MergedPhone(wallet.ID)); err != nil {
+		return nil, err
+	}
+	if wallet.Status != models.WalletClosed {
+		reason := "merged into wallet " + keeper.ID.String()
+		if err := m.store.UpdateStatus(ctx, wallet.ID, models.WalletClosed, reason, time.Now()); err != nil {
+			return nil, err
+		}
+		after.Status = models.WalletClosed
+	}
+	return append(entries, phoneEntry{wallet: wallet.ID, before: before, after: after}), nil
+}
@@ -2,112 +2,210 @@ package wallets
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
 	"payment/internal/transactions"
+	"payment/pkg/config"
 	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/metrics"
+	"payment/pkg/tracing"
 )
 
 type IWallet interface {
 	Create(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error)
 	Update(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error)
-	Delete(ctx context.Context, phone string) (*models.Wallet, error)
+	// Close closes the wallet of phone and keeps its ledger. A remaining balance is paid out
+	// by a settlement withdrawal when settle is set; otherwise closing is refused.
+	Close(ctx context.Context, phone string, settle bool) (*models.Wallet, error)
 	Transaction(ctx context.Context, wallet *models.Wallet, transaction *models.Transaction) error
 	GetByPhone(ctx context.Context, number string) (*models.Wallet, error)
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	// ChangeStatus moves the wallet of phone to status, recording why.
+	ChangeStatus(ctx context.Context, phone string, status models.WalletStatus, reason string) (*models.Wallet, error)
+	// ChangeStatusByID is ChangeStatus for the wallet with the given ID, which also reaches
+	// wallets whose number no longer leads to them, such as flagged duplicates.
+	ChangeStatusByID(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string) (*models.Wallet, error)
+	// Limits reports the usage of the wallet of phone against its effective limits.
+	Limits(ctx context.Context, phone string) (*models.WalletLimitsReport, error)
+	// SetLimits replaces the limit overrides of the wallet of phone.
+	SetLimits(ctx context.Context, phone string, overrides *models.WalletLimits) (*models.WalletLimitsReport, error)
+	// ChangeTier moves the wallet of phone to another verification tier, recording why.
+	ChangeTier(ctx context.Context, phone string, tier models.WalletTier, reason string) (*models.Wallet, error)
+	// TierChanges returns the tier changes of the wallet of phone, oldest first.
+	TierChanges(ctx context.Context, phone string) ([]*models.WalletTierChange, error)
+	// Adjust corrects the balance of the wallet with the given ID by a signed amount, recording why.
+	Adjust(ctx context.Context, id uuid.UUID, amount int64, reason string) (*models.Transaction, error)
 }
 
 type WalletService struct {
 	transaction transactions.ITransaction
+	store       Store
+	transactor  db.Transactor
+	auditor     audit.Recorder
+	outbox      events.Outbox
+	config      *config.Value[Config]
 	logger      *log.Logger
-	db          *db.DB
 }
 
-func NewWallet(logger *log.Logger, db *db.DB) IWallet {
-	return &WalletService{transactions.NewTransactionsService(logger, db), logger, db}
+// NewWallet creates the wallet service on top of the given wallet store and transaction repository.
+// Balance changes run through transactor so that a transaction record and the balance it moves are committed together.
+// Every change is recorded by auditor, and announced through outbox, in the same transaction.
+// The transaction limits are read from settings on every transaction.
+func NewWallet(logger *log.Logger, store Store, transaction transactions.ITransaction, transactor db.Transactor,
+	auditor audit.Recorder, outbox events.Outbox, settings *config.Value[Config]) IWallet {
+	return &WalletService{transaction, store, transactor, auditor, outbox, settings, logger}
 }
 
 func (r *WalletService) Create(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.Create")
+	defer span.End()
 
-	if err := r.db.WithContext(ctx).Save(wallet).Error; err != nil {
-		r.logger.Error(err)
-		return nil, err
+	if wallet.Status == "" {
+		wallet.Status = models.WalletActive
 	}
-	r.logger.WithFields(log.Fields{
-		"section": "wallet",
-		"mode":    "insert",
-		"type":    "database",
-		"wallet":  wallet.ID,
-	}).Info("wallet created")
-	return wallet, nil
-}
-
-func (r *WalletService) Delete(ctx context.Context, phone string) (*models.Wallet, error) {
-	var wallet *models.Wallet
-
-	wallet, err := r.GetByPhone(ctx, phone)
-	if err != nil {
-		r.logger.Error(err)
-		return nil, err
+	if wallet.Tier == "" {
+		wallet.Tier = models.TierUnverified
 	}
 
-	if err = r.db.Unscoped().WithContext(ctx).Delete(&wallet).Error; err != nil {
-		r.logger.Error(err)
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := r.store.Save(ctx, wallet); err != nil {
+			return err
+		}
+		if err := record(ctx, r.auditor, audit.ActionWalletCreate, wallet.ID, nil, stateOf(wallet)); err != nil {
+			return err
+		}
+		return r.outbox.Add(ctx, events.Event{
+			Type:          events.WalletCreated,
+			AggregateType: events.AggregateWallet,
+			AggregateID:   wallet.ID.String(),
+			Payload:       &events.Wallet{WalletID: wallet.ID, Phone: wallet.Phone, Tier: wallet.Tier},
+		})
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
 		return nil, err
 	}
-
-	r.logger.WithFields(log.Fields{
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 		"section": "wallet",
-		"mode":    "delete",
+		"mode":    "insert",
 		"type":    "database",
 		"wallet":  wallet.ID,
-	}).Info("wallet deleted")
-
+	}).Info("wallet created")
 	return wallet, nil
 }
 
 func (r *WalletService) Update(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error) {
-	var err error
-	if err = r.db.Model(&wallet).WithContext(ctx).
-		Where("id = ?", wallet.ID).
-		Update("amount", wallet.Amount).Error; err != nil {
-		r.logger.Error(err)
-		return nil, errors.New("could not update wallet balance")
+	if err := r.store.UpdateBalance(ctx, wallet.ID, wallet.Amount); err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
+		return nil, err
 	}
 	return wallet, nil
 }
 
+// Transaction applies a deposit or withdrawal to the wallet. The balance is re-read under a
+// row lock, so concurrent withdrawals cannot spend the same funds twice, and the transaction
+// is checked against the limits of the wallet. On success wallet is updated with the new balance.
 func (r *WalletService) Transaction(ctx context.Context, wallet *models.Wallet, transaction *models.Transaction) error {
-	var err error
-	transaction.Status = models.Pending
+	return r.transact(ctx, wallet, transaction, true)
+}
 
-	switch transaction.Type {
-	case models.Deposit:
-		wallet.Amount += transaction.Amount
-	case models.Withdrawal:
-		wallet.Amount -= transaction.Amount
-	}
+// transact implements Transaction. The tier and the limits only apply to customer transactions:
+// the settlement on closure must pay out the whole balance, and operators must be able to
+// correct any wallet.
+func (r *WalletService) transact(ctx context.Context, wallet *models.Wallet, transaction *models.Transaction, customer bool) (err error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.Transaction",
+		attribute.String("wallet.id", wallet.ID.String()),
+		attribute.String("transaction.type", string(transaction.Type)),
+		attribute.Int64("transaction.amount", transaction.Amount))
+	defer func() { tracing.End(span, err) }()
 
-	if transaction, err = r.transaction.Create(ctx, transaction); err != nil {
-		r.logger.Error(err)
-		return fmt.Errorf("could not create Transaction: %w", err)
-	}
-	if _, err = r.Update(ctx, wallet); err != nil {
-		if err = r.transaction.ChangeStatus(ctx, transaction.ID, models.Failed); err != nil {
-			return fmt.Errorf("could not update transaction status: %w", err)
+	err = r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		current, err := r.store.Lock(ctx, wallet.ID)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("could not update wallet balance: %w", err)
-	}
+		if err = allowTransaction(current, transaction.Type); err != nil {
+			return err
+		}
+		if customer {
+			if err = allowTier(current, transaction.Type); err != nil {
+				return err
+			}
+			if err = r.checkLimits(ctx, current, transaction); err != nil {
+				return err
+			}
+		}
+		before := stateOf(current)
 
-	if err = r.transaction.ChangeStatus(ctx, transaction.ID, models.Completed); err != nil {
-		r.logger.Error(err)
-		return fmt.Errorf("could not update Transaction status: %w", err)
+		switch transaction.Type {
+		case models.Deposit:
+			current.Amount += transaction.Amount
+		case models.Withdrawal:
+			if current.Amount < transaction.Amount {
+				logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+					"type":            "transaction",
+					"wallet_id":       current.ID,
+					"current_amount":  current.Amount,
+					"required_amount": transaction.Amount,
+				}).Error("Insufficient funds")
+
+				return errors.ErrInsufficientFunds.WithMessage(
+					"insufficient funds: wallet %s balance is %d, but %d is required",
+					current.Phone, current.Amount, transaction.Amount)
+			}
+			current.Amount -= transaction.Amount
+		default:
+			return errors.ErrInvalidTransactionType
+		}
+
+		transaction.Status = models.Pending
+		if _, err = r.transaction.Create(ctx, transaction); err != nil {
+			logging.FromContext(ctx, r.logger).Error(err)
+			return errors.ErrTransactionFailed.WithMessage("could not create transaction").Wrap(err)
+		}
+		if _, err = r.Update(ctx, current); err != nil {
+			return errors.ErrTransactionFailed.WithMessage("could not update wallet balance").Wrap(err)
+		}
+		if err = r.transaction.ChangeStatus(ctx, transaction.ID, models.Completed); err != nil {
+			logging.FromContext(ctx, r.logger).Error(err)
+			return errors.ErrTransactionFailed.WithMessage("could not update transaction status").Wrap(err)
+		}
+
+		transaction.Status = models.Completed
+		wallet.Amount = current.Amount
+		if err = record(ctx, r.auditor, audit.ActionWalletTransaction, current.ID, before, &transactionState{
+			ID:      transaction.ID,
+			Type:    transaction.Type,
+			Amount:  transaction.Amount,
+			Balance: current.Amount,
+		}); err != nil {
+			return errors.ErrTransactionFailed.WithMessage("could not audit transaction").Wrap(err)
+		}
+		if err = r.outbox.Add(ctx, transactionEvent(events.TransactionCompleted, current, transaction)); err != nil {
+			return errors.ErrTransactionFailed.WithMessage("could not announce transaction").Wrap(err)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errors.ErrTransactionFailed) {
+			metrics.ObserveTransaction(string(transaction.Type), metrics.ResultFailed, transaction.Amount)
+			// Close, Adjust and discount redemptions run transact inside a transaction of their own,
+			// which the failure rolls back as well, so the record is kept once that one has ended.
+			db.AfterTransaction(ctx, func(ctx context.Context) { r.recordFailure(ctx, wallet, transaction) })
+		} else {
+			metrics.ObserveTransaction(string(transaction.Type), metrics.ResultRejected, transaction.Amount)
+		}
+		return err
 	}
+	metrics.ObserveTransaction(string(transaction.Type), metrics.ResultCompleted, transaction.Amount)
 
-	r.logger.WithFields(log.Fields{
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
 		"section": "transaction",
 		"mode":    "insert",
 		"type":    "database",
@@ -121,16 +219,38 @@ func (r *WalletService) Transaction(ctx context.Context, wallet *models.Wallet,
 	return nil
 }
 
+// recordFailure keeps a failed transaction record, and announces it. It is called with a context
+// outside of the unit of work that failed, which has been rolled back by then.
+func (r *WalletService) recordFailure(ctx context.Context, wallet *models.Wallet, transaction *models.Transaction) {
+	transaction.ID = uuid.Nil
+	transaction.Status = models.Failed
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.transaction.Create(ctx, transaction); err != nil {
+			return err
+		}
+		return r.outbox.Add(ctx, transactionEvent(events.TransactionFailed, wallet, transaction))
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+			"section":   "transaction",
+			"wallet_id": transaction.WalletID,
+		}).WithError(err).Error("could not record failed transaction")
+	}
+}
+
 func (r *WalletService) GetByPhone(ctx context.Context, number string) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.GetByPhone")
+	defer span.End()
+
 	var wallet *models.Wallet
 	var err error
 
-	if err = r.db.WithContext(ctx).First(&wallet, "phone = ?", number).Error; err != nil {
-		r.logger.Error(err)
+	if wallet, err = r.store.FindByPhone(ctx, number); err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
 		return nil, err
 	}
 	if wallet.Transactions, err = r.transaction.List(ctx, wallet.ID); err != nil {
-		r.logger.Error(err)
+		logging.FromContext(ctx, r.logger).Error(err)
 		return nil, err
 	}
 
@@ -141,12 +261,12 @@ func (r *WalletService) GetByID(ctx context.Context, id uuid.UUID) (*models.Wall
 	var wallet *models.Wallet
 	var err error
 
-	if err = r.db.WithContext(ctx).First(&wallet, "id = ?", id).Error; err != nil {
-		r.logger.Error(err)
+	if wallet, err = r.store.FindByID(ctx, id); err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
 		return nil, err
 	}
 	if wallet.Transactions, err = r.transaction.List(ctx, wallet.ID); err != nil {
-		r.logger.Error(err)
+		logging.FromContext(ctx, r.logger).Error(err)
 		return nil, err
 	}
 
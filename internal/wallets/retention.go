@@ -0,0 +1,97 @@
+package wallets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/internal/audit"
+	"payment/pkg/db"
+	"time"
+)
+
+// retentionBatch bounds how many wallets are anonymized in one transaction.
+const retentionBatch = 100
+
+// Retention anonymizes the phone numbers of wallets that have been closed for longer than
+// the retention period. The wallets and their ledgers are kept for audits.
+type Retention struct {
+	store      Store
+	transactor db.Transactor
+	auditor    audit.Recorder
+	logger     *log.Logger
+	after      time.Duration
+}
+
+// NewRetention creates the retention job for wallets closed longer than after.
+func NewRetention(logger *log.Logger, store Store, transactor db.Transactor, auditor audit.Recorder,
+	after time.Duration) *Retention {
+	return &Retention{store: store, transactor: transactor, auditor: auditor, logger: logger, after: after}
+}
+
+// anonymization is what the audit log keeps of an anonymized wallet.
+type anonymization struct {
+	AnonymizedAt time.Time `json:"anonymized_at"`
+}
+
+// AnonymizedPhone returns the placeholder that replaces the phone number of wallet id.
+// It is unique per wallet, fits the phone column and never passes phone validation.
+func AnonymizedPhone(id uuid.UUID) string {
+	sum := sha256.Sum256(id[:])
+	return "anon-" + hex.EncodeToString(sum[:])[:15]
+}
+
+// Run anonymizes eligible wallets immediately and then every interval until ctx is done.
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := r.Anonymize(ctx, time.Now()); err != nil {
+			r.logger.WithError(err).Error("wallet retention failed")
+		} else if n > 0 {
+			r.logger.WithField("wallets", n).Info("anonymized closed wallets")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Anonymize replaces the phone numbers of the wallets closed before now minus the retention
+// period and returns how many were anonymized.
+func (r *Retention) Anonymize(ctx context.Context, now time.Time) (int, error) {
+	before := now.Add(-r.after)
+	total := 0
+	for {
+		n := 0
+		err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			wallets, err := r.store.ClosedBefore(ctx, before, retentionBatch)
+			if err != nil {
+				return err
+			}
+			for _, wallet := range wallets {
+				if err = r.store.Anonymize(ctx, wallet.ID, AnonymizedPhone(wallet.ID), now); err != nil {
+					return err
+				}
+				if err = record(ctx, r.auditor, audit.ActionWalletAnonymize, wallet.ID, nil,
+					&anonymization{AnonymizedAt: now}); err != nil {
+					return err
+				}
+			}
+			n = len(wallets)
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < retentionBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package wallets
+
+import (
+	"context"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/tracing"
+	"time"
+)
+
+// allowTransaction rejects a balance change that the status of wallet does not permit.
+// Frozen wallets still accept deposits, so that incoming funds are not lost.
+func allowTransaction(wallet *models.Wallet, transactionType models.Type) error {
+	switch wallet.Status {
+	case models.WalletFrozen:
+		if transactionType != models.Deposit {
+			return errors.ErrWalletFrozen
+		}
+	case models.WalletSuspended:
+		return errors.ErrWalletSuspended
+	case models.WalletClosed:
+		return errors.ErrWalletClosed
+	}
+	return nil
+}
+
+// allowStatusChange rejects changes out of the closed state, which is final, and
+// closing a wallet that still holds funds.
+func allowStatusChange(wallet *models.Wallet, status models.WalletStatus) error {
+	switch {
+	case wallet.Status == models.WalletClosed:
+		return errors.ErrWalletClosed.WithMessage("wallet is closed, its status can no longer change")
+	case status == models.WalletClosed && wallet.Amount != 0:
+		return errors.ErrWalletNotEmpty.WithMessage("wallet still holds %d and cannot be closed", wallet.Amount)
+	}
+	switch status {
+	case models.WalletActive, models.WalletFrozen, models.WalletSuspended, models.WalletClosed:
+		return nil
+	default:
+		return errors.ErrInvalidWalletStatus.WithMessage("unknown wallet status %q", status)
+	}
+}
+
+// Reasons recorded when a wallet is closed through Close.
+const (
+	closeReason       = "closed by request"
+	settlementPayment = "settlement on wallet closure"
+)
+
+func (r *WalletService) Close(ctx context.Context, phone string, settle bool) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.Close")
+	defer span.End()
+
+	var closed *models.Wallet
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		found, err := r.store.FindByPhone(ctx, phone)
+		if err != nil {
+			return err
+		}
+		wallet, err := r.store.Lock(ctx, found.ID)
+		if err != nil {
+			return err
+		}
+		if wallet.Status == models.WalletClosed {
+			return errors.ErrWalletClosed
+		}
+
+		if wallet.Amount != 0 {
+			if !settle {
+				return errors.ErrWalletNotEmpty.WithMessage(
+					"wallet still holds %d, withdraw it or close with settle=true", wallet.Amount)
+			}
+			// The withdrawal follows the status rules, so frozen or suspended funds cannot be paid out,
+			// but not the tier or the limits, which would otherwise keep an unverified wallet or one
+			// with a large balance open.
+			if err = r.transact(ctx, wallet, &models.Transaction{
+				WalletID:    wallet.ID,
+				Type:        models.Withdrawal,
+				Amount:      wallet.Amount,
+				Description: settlementPayment,
+			}, false); err != nil {
+				return err
+			}
+		}
+
+		closed, err = r.ChangeStatus(ctx, phone, models.WalletClosed, closeReason)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(ctx, r.logger).Error(err)
+		return nil, err
+	}
+	return closed, nil
+}
+
+func (r *WalletService) ChangeStatus(ctx context.Context, phone string, status models.WalletStatus, reason string) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.ChangeStatus")
+	defer span.End()
+
+	wallet, err := r.store.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	return r.changeStatus(ctx, wallet.ID, status, reason)
+}
+
+func (r *WalletService) ChangeStatusByID(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.ChangeStatusByID")
+	defer span.End()
+	return r.changeStatus(ctx, id, status, reason)
+}
+
+func (r *WalletService) changeStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string) (*models.Wallet, error) {
+	var wallet *models.Wallet
+	var previous models.WalletStatus
+	err := r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		current, err := r.store.Lock(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = allowStatusChange(current, status); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err = r.store.UpdateStatus(ctx, current.ID, status, reason, now); err != nil {
+			return err
+		}
+		previous = current.Status
+		before := stateOf(current)
+		wallet = current
+		wallet.Status, wallet.StatusReason, wallet.StatusChangedAt = status, reason, &now
+
+		action := audit.ActionWalletStatus
+		if status == models.WalletClosed {
+			action = audit.ActionWalletClose
+		}
+		return record(ctx, r.auditor, action, wallet.ID, before, stateOf(wallet))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+		"section": "wallet",
+		"mode":    "status",
+		"wallet":  wallet.ID,
+		"from":    previous,
+		"to":      status,
+		"reason":  reason,
+	}).Info("wallet status changed")
+	return wallet, nil
+}
@@ -0,0 +1,270 @@
+package wallets
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+// Store persists wallets. WalletService implements the wallet rules on top of it,
+// so the same rules apply whichever backend is plugged in.
+type Store interface {
+	Save(ctx context.Context, wallet *models.Wallet) error
+	UpdateBalance(ctx context.Context, id uuid.UUID, amount int64) error
+	UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string, at time.Time) error
+	// ClosedBefore returns up to limit closed wallets whose phone number has not been
+	// anonymized yet and that were closed before the given time.
+	ClosedBefore(ctx context.Context, before time.Time, limit int) ([]*models.Wallet, error)
+	// Anonymize replaces the phone number of the wallet, and of its discount usages, with phone,
+	// and deletes its notification preferences, which hold its email address.
+	Anonymize(ctx context.Context, id uuid.UUID, phone string, at time.Time) error
+	// List returns up to limit wallets whose id sorts after the given one, in order of id.
+	List(ctx context.Context, after uuid.UUID, limit int) ([]*models.Wallet, error)
+	// UpdatePhone replaces the phone number of the wallet, and of its discount usages, with phone.
+	UpdatePhone(ctx context.Context, id uuid.UUID, phone string) error
+	// MarkDuplicate records the wallet as a duplicate of the wallet of, and moves its discount
+	// usages to phone, the number the wallet of keeps.
+	MarkDuplicate(ctx context.Context, id, of uuid.UUID, phone string) error
+	// Merge moves the transactions, discount usages and notifications of the wallet to the wallet
+	// into, whose number is number, records the wallet as a duplicate of into and replaces its
+	// phone number with phone. Balances are left to the caller.
+	Merge(ctx context.Context, id, into uuid.UUID, number, phone string) error
+	FindByPhone(ctx context.Context, phone string) (*models.Wallet, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	// Lock returns the wallet and holds a row lock on it until the surrounding transaction ends.
+	Lock(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+	// FindLimits returns the limit overrides of the wallet, or nil when it has none.
+	FindLimits(ctx context.Context, walletID uuid.UUID) (*models.WalletLimits, error)
+	// SaveLimits replaces the limit overrides of the wallet.
+	SaveLimits(ctx context.Context, limits *models.WalletLimits) error
+	UpdateTier(ctx context.Context, id uuid.UUID, tier models.WalletTier) error
+	AddTierChange(ctx context.Context, change *models.WalletTierChange) error
+	// TierChanges returns the tier changes of the wallet, oldest first.
+	TierChanges(ctx context.Context, walletID uuid.UUID) ([]*models.WalletTierChange, error)
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) Save(ctx context.Context, wallet *models.Wallet) error {
+	if err := s.db.Conn(ctx).Save(wallet).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errors.ErrWalletExists
+		}
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) UpdateBalance(ctx context.Context, id uuid.UUID, amount int64) error {
+	if err := s.db.Conn(ctx).Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Update("amount", amount).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not update wallet balance").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) UpdateStatus(ctx context.Context, id uuid.UUID, status models.WalletStatus, reason string, at time.Time) error {
+	if err := s.db.Conn(ctx).Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":            status,
+			"status_reason":     reason,
+			"status_changed_at": at,
+		}).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not update wallet status").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) ClosedBefore(ctx context.Context, before time.Time, limit int) ([]*models.Wallet, error) {
+	var wallets []*models.Wallet
+	if err := s.db.Conn(ctx).
+		Where("status = ? AND anonymized_at IS NULL AND status_changed_at < ?", models.WalletClosed, before).
+		Order("status_changed_at").
+		Limit(limit).
+		Find(&wallets).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return wallets, nil
+}
+
+func (s *store) Anonymize(ctx context.Context, id uuid.UUID, phone string, at time.Time) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"phone": phone, "anonymized_at": at}).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not anonymize wallet").Wrap(err)
+	}
+	if err := conn.Model(new(models.DiscountTransaction)).
+		Where("wallet_id = ?", id).
+		Update("phone_num", phone).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not anonymize discount usages").Wrap(err)
+	}
+	if err := conn.Where("wallet_id = ?", id).Delete(new(models.NotificationPreferences)).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not delete notification preferences").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) List(ctx context.Context, after uuid.UUID, limit int) ([]*models.Wallet, error) {
+	var wallets []*models.Wallet
+	if err := s.db.Conn(ctx).
+		Where("id > ?", after).
+		Order("id").
+		Limit(limit).
+		Find(&wallets).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return wallets, nil
+}
+
+func (s *store) UpdatePhone(ctx context.Context, id uuid.UUID, phone string) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Update("phone", phone).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return errors.ErrWalletExists
+		}
+		return errors.ErrInternal.WithMessage("could not update wallet phone").Wrap(err)
+	}
+	if err := conn.Model(new(models.DiscountTransaction)).
+		Where("wallet_id = ?", id).
+		Update("phone_num", phone).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not update discount usages").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) MarkDuplicate(ctx context.Context, id, of uuid.UUID, phone string) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Update("duplicate_of", of).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not mark duplicate wallet").Wrap(err)
+	}
+	if err := conn.Model(new(models.DiscountTransaction)).
+		Where("wallet_id = ?", id).
+		Update("phone_num", phone).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not update discount usages").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Merge(ctx context.Context, id, into uuid.UUID, number, phone string) error {
+	conn := s.db.Conn(ctx)
+	if err := conn.Model(new(models.Transaction)).
+		Where("wallet_id = ?", id).
+		Update("wallet_id", into).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not move transactions").Wrap(err)
+	}
+	if err := conn.Model(new(models.DiscountTransaction)).
+		Where("wallet_id = ?", id).
+		Updates(map[string]interface{}{"wallet_id": into, "phone_num": number}).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not move discount usages").Wrap(err)
+	}
+	if err := conn.Model(new(models.Notification)).
+		Where("wallet_id = ?", id).
+		Update("wallet_id", into).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not move notifications").Wrap(err)
+	}
+	if err := conn.Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"duplicate_of": into, "phone": phone}).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not merge wallet").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) FindByPhone(ctx context.Context, phone string) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := s.db.Conn(ctx).First(&wallet, "phone = ?", phone).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &wallet, nil
+}
+
+func (s *store) FindByID(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := s.db.Conn(ctx).First(&wallet, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &wallet, nil
+}
+
+func (s *store) Lock(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	var wallet models.Wallet
+	if err := s.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&wallet, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &wallet, nil
+}
+
+func (s *store) FindLimits(ctx context.Context, walletID uuid.UUID) (*models.WalletLimits, error) {
+	var limits models.WalletLimits
+	if err := s.db.Conn(ctx).First(&limits, "wallet_id = ?", walletID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &limits, nil
+}
+
+func (s *store) SaveLimits(ctx context.Context, limits *models.WalletLimits) error {
+	if err := s.db.Conn(ctx).Save(limits).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not save wallet limits").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) UpdateTier(ctx context.Context, id uuid.UUID, tier models.WalletTier) error {
+	if err := s.db.Conn(ctx).Model(new(models.Wallet)).
+		Where("id = ?", id).
+		Update("tier", tier).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not update wallet tier").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) AddTierChange(ctx context.Context, change *models.WalletTierChange) error {
+	if err := s.db.Conn(ctx).Create(change).Error; err != nil {
+		return errors.ErrInternal.WithMessage("could not record tier change").Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) TierChanges(ctx context.Context, walletID uuid.UUID) ([]*models.WalletTierChange, error) {
+	changes := make([]*models.WalletTierChange, 0)
+	if err := s.db.Conn(ctx).
+		Where("wallet_id = ?", walletID).
+		Order("created_at").
+		Find(&changes).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return changes, nil
+}
+
+// notFound translates a missing record into ErrWalletNotFound and any other
+// database failure into ErrInternal.
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.ErrWalletNotFound
+	}
+	return errors.ErrInternal.Wrap(err)
+}
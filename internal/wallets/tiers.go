@@ -0,0 +1,105 @@
+package wallets
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"payment/pkg/tracing"
+	"slices"
+)
+
+// tierOperations lists the transaction types each tier allows. Discount credits are
+// deposits, so every tier can receive them.
+var tierOperations = map[models.WalletTier][]models.Type{
+	models.TierUnverified: {models.Deposit},
+	models.TierBasic:      {models.Deposit, models.Withdrawal},
+	models.TierFull:       {models.Deposit, models.Withdrawal},
+}
+
+// allowTier rejects a balance change that the tier of wallet does not permit.
+func allowTier(wallet *models.Wallet, transactionType models.Type) error {
+	if transactionType != models.Deposit && transactionType != models.Withdrawal {
+		// Unknown types are rejected as such by Transaction.
+		return nil
+	}
+	if !slices.Contains(tierOperations[wallet.Tier], transactionType) {
+		return errors.ErrTierNotAllowed.WithMessage("%s wallets cannot make a %s, verify the owner first",
+			wallet.Tier, transactionType)
+	}
+	return nil
+}
+
+// tighten returns the stricter of each pair of limits, where zero is unlimited.
+func (l Limits) tighten(other Limits) Limits {
+	stricter := func(limit *int64, value int64) {
+		if value > 0 && (*limit == 0 || value < *limit) {
+			*limit = value
+		}
+	}
+	stricter(&l.SingleWithdrawal, other.SingleWithdrawal)
+	stricter(&l.DailyWithdrawal, other.DailyWithdrawal)
+	stricter(&l.MonthlyWithdrawal, other.MonthlyWithdrawal)
+	stricter(&l.DailyDeposit, other.DailyDeposit)
+	stricter(&l.MonthlyDeposit, other.MonthlyDeposit)
+	stricter(&l.MaxBalance, other.MaxBalance)
+	stricter(&l.HourlyTransactions, other.HourlyTransactions)
+	return l
+}
+
+// ChangeTier moves the wallet of phone to tier and records the change, with the key ID
+// of the caller, in the same transaction.
+func (r *WalletService) ChangeTier(ctx context.Context, phone string, tier models.WalletTier, reason string) (*models.Wallet, error) {
+	ctx, span := tracing.Start(ctx, "wallets.Service.ChangeTier")
+	defer span.End()
+
+	wallet, err := r.store.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &models.WalletTierChange{WalletID: wallet.ID, To: tier, Reason: reason, Actor: logging.KeyID(ctx)}
+	err = r.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		current, err := r.store.Lock(ctx, wallet.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status == models.WalletClosed {
+			return errors.ErrWalletClosed.WithMessage("wallet is closed, its tier can no longer change")
+		}
+		if err = r.store.UpdateTier(ctx, current.ID, tier); err != nil {
+			return err
+		}
+		change.From = current.Tier
+		if err = r.store.AddTierChange(ctx, change); err != nil {
+			return err
+		}
+		before := stateOf(current)
+		wallet = current
+		wallet.Tier = tier
+		return record(ctx, r.auditor, audit.ActionWalletTier, wallet.ID, before, stateOf(wallet))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, r.logger).WithFields(log.Fields{
+		"section": "wallet",
+		"mode":    "tier",
+		"wallet":  wallet.ID,
+		"from":    change.From,
+		"to":      tier,
+		"reason":  reason,
+	}).Info("wallet tier changed")
+	return wallet, nil
+}
+
+func (r *WalletService) TierChanges(ctx context.Context, phone string) ([]*models.WalletTierChange, error) {
+	wallet, err := r.store.FindByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	return r.store.TierChanges(ctx, wallet.ID)
+}
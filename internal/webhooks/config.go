@@ -0,0 +1,24 @@
+package webhooks
+
+import (
+	"payment/pkg/config"
+)
+
+type Config struct {
+	// AdminToken is accepted on the webhook routes, next to admin API keys.
+	AdminToken string
+}
+
+// NewConfig extracts the webhook settings that apply without a restart from the service configuration.
+func NewConfig(c *config.Config) *Config {
+	return &Config{AdminToken: c.AdminToken}
+}
+
+// NewSettings extracts the retry settings of the deliverer from the service configuration.
+func NewSettings(c *config.Config) Settings {
+	return Settings{
+		MaxAttempts: c.Webhooks.MaxAttempts,
+		Backoff:     c.Webhooks.Backoff,
+		MaxBackoff:  c.Webhooks.MaxBackoff,
+	}
+}
@@ -0,0 +1,233 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"payment/api/models"
+	"payment/internal/events"
+	"payment/pkg/db"
+	"time"
+)
+
+// Settings control how deliveries are retried.
+type Settings struct {
+	// MaxAttempts is the number of attempts after which a delivery is dead.
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt. It doubles with every further
+	// failure, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Batch bounds how many deliveries are claimed at a time.
+	Batch int
+	// Lease is how long claimed deliveries are held back from other deliverers while they
+	// are being sent. It defaults to long enough for every request of a batch to time out.
+	Lease time.Duration
+}
+
+// Defaults used for the settings that are not configured.
+const (
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = 10 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultBatch       = 50
+)
+
+// maxError bounds the length of the error kept on a delivery.
+const maxError = 500
+
+// Deliverer sends due deliveries to their subscriptions.
+type Deliverer struct {
+	store      Store
+	transactor db.Transactor
+	client     *http.Client
+	logger     *log.Logger
+	settings   Settings
+}
+
+// NewDeliverer creates a deliverer that sends deliveries with client. Settings left at zero
+// take their default.
+func NewDeliverer(logger *log.Logger, store Store, transactor db.Transactor, client *http.Client, settings Settings) *Deliverer {
+	if settings.MaxAttempts <= 0 {
+		settings.MaxAttempts = DefaultMaxAttempts
+	}
+	if settings.Backoff <= 0 {
+		settings.Backoff = DefaultBackoff
+	}
+	if settings.MaxBackoff < settings.Backoff {
+		settings.MaxBackoff = max(DefaultMaxBackoff, settings.Backoff)
+	}
+	if settings.Batch <= 0 {
+		settings.Batch = DefaultBatch
+	}
+	if settings.Lease <= 0 {
+		settings.Lease = time.Duration(settings.Batch)*client.Timeout + time.Minute
+	}
+	return &Deliverer{store: store, transactor: transactor, client: client, logger: logger, settings: settings}
+}
+
+// Run sends due deliveries immediately and then every interval until ctx is done.
+func (d *Deliverer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := d.Deliver(ctx, time.Now()); err != nil {
+			d.logger.WithError(err).WithField("attempted", n).Error("webhook delivery failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver attempts the deliveries due at now and returns how many were attempted. A failed
+// attempt is not an error: the delivery is scheduled again, or marked dead once it has used
+// all its attempts.
+//
+// Deliveries are claimed in one transaction, and the outcome of each attempt is recorded on its own
+// right after it, so that no transaction is held open while receivers answer. A delivery whose
+// outcome is never recorded, because the deliverer stopped, is attempted again once its lease expires.
+func (d *Deliverer) Deliver(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		claims, lease, err := d.claim(ctx, now)
+		if err != nil {
+			return total, err
+		}
+		for _, claim := range claims {
+			d.attempt(ctx, claim.subscription, claim.delivery, now)
+			recorded, err := d.store.RecordAttempt(ctx, claim.delivery, lease)
+			if err != nil {
+				return total, err
+			}
+			if !recorded {
+				d.logger.WithFields(log.Fields{
+					"section":  "webhooks",
+					"delivery": claim.delivery.ID,
+				}).Info("webhook delivery was redelivered while it was sent, its outcome is dropped")
+			}
+			total++
+		}
+		if len(claims) < d.settings.Batch {
+			return total, nil
+		}
+	}
+}
+
+// claim is a delivery leased for an attempt, with its subscription.
+type claim struct {
+	delivery     *models.WebhookDelivery
+	subscription *models.WebhookSubscription
+}
+
+// claim leases a batch of the deliveries due at now: it moves their next attempt past the lease
+// and marks them with the returned lease, under which their outcomes are recorded.
+func (d *Deliverer) claim(ctx context.Context, now time.Time) ([]claim, uuid.UUID, error) {
+	var claims []claim
+	lease := uuid.New()
+	err := d.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		deliveries, err := d.store.Due(ctx, now, d.settings.Batch)
+		if err != nil {
+			return err
+		}
+		claims = make([]claim, 0, len(deliveries))
+		subscriptions := make(map[uuid.UUID]*models.WebhookSubscription)
+		for _, delivery := range deliveries {
+			subscription, ok := subscriptions[delivery.SubscriptionID]
+			if !ok {
+				if subscription, err = d.store.FindSubscription(ctx, delivery.SubscriptionID); err != nil {
+					return err
+				}
+				subscriptions[subscription.ID] = subscription
+			}
+			delivery.NextAttemptAt, delivery.Lease = now.Add(d.settings.Lease), &lease
+			if err = d.store.UpdateDelivery(ctx, delivery); err != nil {
+				return err
+			}
+			claims = append(claims, claim{delivery: delivery, subscription: subscription})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return claims, lease, nil
+}
+
+// attempt sends delivery and records the outcome on it.
+func (d *Deliverer) attempt(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery, now time.Time) {
+	if !subscription.Active {
+		delivery.Status, delivery.LastError = models.DeliveryDead, "the subscription is no longer active"
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	status, err := d.send(ctx, subscription, delivery, now)
+	delivery.LastStatusCode = status
+	if err == nil {
+		delivery.Status, delivery.DeliveredAt, delivery.LastError = models.DeliverySucceeded, &now, ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > maxError {
+		delivery.LastError = delivery.LastError[:maxError]
+	}
+	fields := log.Fields{
+		"section":      "webhooks",
+		"delivery":     delivery.ID,
+		"subscription": subscription.ID,
+		"event_id":     delivery.EventID,
+		"attempts":     delivery.Attempts,
+	}
+	if delivery.Attempts >= d.settings.MaxAttempts {
+		delivery.Status = models.DeliveryDead
+		d.logger.WithFields(fields).WithError(err).Error("webhook delivery is dead")
+		return
+	}
+	delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	d.logger.WithFields(fields).WithError(err).Warn("webhook delivery failed, will retry")
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Deliverer) backoff(attempts int) time.Duration {
+	delay := d.settings.Backoff
+	for i := 1; i < attempts && delay < d.settings.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.settings.MaxBackoff)
+}
+
+// send posts the body of delivery and returns the status of the response. Any status other
+// than 2xx is an error.
+func (d *Deliverer) send(ctx context.Context, subscription *models.WebhookSubscription, delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(events.EventIDHeader, delivery.EventID.String())
+	req.Header.Set(events.EventTypeHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, now, delivery.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,279 @@
+package webhooks_test
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/internal/events"
+	"payment/internal/memory"
+	"payment/internal/webhooks"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint that verifies signatures and answers with status. It calls
+// during, if set, before answering.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []string
+	invalid  []error
+	during   func()
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := webhooks.Verify(r.secret, req.Header.Get(webhooks.SignatureHeader), body, time.Now(), 24*time.Hour); err != nil {
+		r.invalid = append(r.invalid, err)
+	}
+	r.received = append(r.received, req.Header.Get(events.EventIDHeader))
+	if r.during != nil {
+		r.during()
+	}
+	w.WriteHeader(r.status)
+}
+
+type fixture struct {
+	db        *memory.DB
+	service   *webhooks.Service
+	deliverer *webhooks.Deliverer
+	receiver  *receiver
+	url       string
+}
+
+func newFixture(t *testing.T, status int) *fixture {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := memory.NewDB()
+	store := memory.NewWebhooks(db)
+	receiver := &receiver{secret: "0123456789abcdef", status: status}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return &fixture{
+		db:      db,
+		service: webhooks.NewService(logger, store, db, audit.NewService(logger, memory.NewAudit(db), db), webhooks.Targets{Private: true}),
+		deliverer: webhooks.NewDeliverer(logger, store, db, server.Client(), webhooks.Settings{
+			MaxAttempts: 3,
+			Backoff:     time.Minute,
+			MaxBackoff:  90 * time.Second,
+		}),
+		receiver: receiver,
+		url:      server.URL,
+	}
+}
+
+func (f *fixture) subscribe(t *testing.T, eventTypes ...string) *models.WebhookSubscription {
+	t.Helper()
+	subscription, err := f.service.Subscribe(context.Background(), &models.WebhookSubscriptionRequest{
+		URL:        f.url,
+		EventTypes: eventTypes,
+		Secret:     f.receiver.secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subscription
+}
+
+func (f *fixture) publish(t *testing.T, eventType string) *models.OutboxEvent {
+	t.Helper()
+	event := &models.OutboxEvent{
+		ID:            uuid.New(),
+		CreatedAt:     time.Now(),
+		Type:          eventType,
+		AggregateType: events.AggregateWallet,
+		AggregateID:   uuid.NewString(),
+		Payload:       []byte(`{}`),
+	}
+	if err := f.service.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func (f *fixture) deliveries(t *testing.T, subscription *models.WebhookSubscription) []*models.WebhookDelivery {
+	t.Helper()
+	deliveries, err := f.service.Deliveries(context.Background(), subscription.ID, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func TestDeliverSignsAndSendsMatchingEvents(t *testing.T) {
+	f := newFixture(t, http.StatusNoContent)
+	subscription := f.subscribe(t, events.WalletCreated)
+	event := f.publish(t, events.WalletCreated)
+	f.publish(t, events.TransactionCompleted)
+	// The relay may publish an event again; it is only delivered once.
+	if err := f.service.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := f.deliverer.Deliver(context.Background(), time.Now()); err != nil || n != 1 {
+		t.Fatalf("got %d attempted (%v), want 1", n, err)
+	}
+	if len(f.receiver.received) != 1 || f.receiver.received[0] != event.ID.String() || len(f.receiver.invalid) != 0 {
+		t.Fatalf("got %v (invalid signatures %v), want event %s once", f.receiver.received, f.receiver.invalid, event.ID)
+	}
+	deliveries := f.deliveries(t, subscription)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySucceeded || deliveries[0].LastStatusCode != http.StatusNoContent {
+		t.Fatalf("got %+v, want one succeeded delivery", deliveries)
+	}
+	if n, _ := f.deliverer.Deliver(context.Background(), time.Now().Add(time.Hour)); n != 0 {
+		t.Fatalf("got %d attempted after success, want 0", n)
+	}
+}
+
+func TestDeliveriesAreLeasedWhileSent(t *testing.T) {
+	f := newFixture(t, http.StatusOK)
+	subscription := f.subscribe(t, events.WalletCreated)
+	f.publish(t, events.WalletCreated)
+	now := time.Now()
+
+	// The receiver answers while no transaction is open, so the store can be read meanwhile,
+	// and the claimed delivery is not due for anyone else.
+	var (
+		during []*models.WebhookDelivery
+		again  int
+		err    error
+	)
+	f.receiver.during = func() {
+		if during, err = f.service.Deliveries(context.Background(), subscription.ID, "", 100); err == nil {
+			again, err = f.deliverer.Deliver(context.Background(), now)
+		}
+	}
+	if n, err := f.deliverer.Deliver(context.Background(), now); err != nil || n != 1 {
+		t.Fatalf("got %d attempted (%v), want 1", n, err)
+	}
+	if err != nil || again != 0 {
+		t.Fatalf("got %d attempted while sending (%v), want the delivery claimed", again, err)
+	}
+	if len(during) != 1 || during[0].Status != models.DeliveryPending || !during[0].NextAttemptAt.After(now) {
+		t.Fatalf("got %+v while sending, want the delivery leased", during)
+	}
+	if deliveries := f.deliveries(t, subscription); deliveries[0].Status != models.DeliverySucceeded {
+		t.Fatalf("got %+v, want the outcome recorded", deliveries[0])
+	}
+}
+
+func TestRedeliveryDuringAnAttemptIsKept(t *testing.T) {
+	f := newFixture(t, http.StatusServiceUnavailable)
+	subscription := f.subscribe(t, events.WalletCreated)
+	f.publish(t, events.WalletCreated)
+	ctx := context.Background()
+
+	var err error
+	f.receiver.during = func() {
+		_, err = f.service.Redeliver(ctx, f.deliveries(t, subscription)[0].ID)
+	}
+	if n, err := f.deliverer.Deliver(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("got %d attempted (%v), want 1", n, err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := f.deliveries(t, subscription)[0]
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 0 || delivery.LastAttemptAt != nil {
+		t.Fatalf("got %+v, want the redelivery to replace the outcome of the failed attempt", delivery)
+	}
+}
+
+func TestDeliverRetriesWithBackoffUntilDead(t *testing.T) {
+	f := newFixture(t, http.StatusServiceUnavailable)
+	subscription := f.subscribe(t, events.WalletCreated)
+	f.publish(t, events.WalletCreated)
+	ctx, now := context.Background(), time.Now()
+
+	if n, _ := f.deliverer.Deliver(ctx, now); n != 1 {
+		t.Fatalf("got %d attempted, want 1", n)
+	}
+	delivery := f.deliveries(t, subscription)[0]
+	if delivery.Status != models.DeliveryPending || delivery.Attempts != 1 || !delivery.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("got %+v, want a retry in a minute", delivery)
+	}
+	if n, _ := f.deliverer.Deliver(ctx, now.Add(30*time.Second)); n != 0 {
+		t.Fatalf("got %d attempted before the backoff elapsed, want 0", n)
+	}
+
+	now = now.Add(time.Minute)
+	f.deliverer.Deliver(ctx, now)
+	// The backoff doubles, but not beyond the maximum.
+	if delivery = f.deliveries(t, subscription)[0]; !delivery.NextAttemptAt.Equal(now.Add(90 * time.Second)) {
+		t.Fatalf("got next attempt at %v, want the maximum backoff", delivery.NextAttemptAt.Sub(now))
+	}
+
+	f.deliverer.Deliver(ctx, now.Add(90*time.Second))
+	delivery = f.deliveries(t, subscription)[0]
+	if delivery.Status != models.DeliveryDead || delivery.Attempts != 3 || delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %+v, want a dead delivery after 3 attempts", delivery)
+	}
+	if n, _ := f.deliverer.Deliver(ctx, now.Add(24*time.Hour)); n != 0 {
+		t.Fatalf("got %d attempted on a dead delivery, want 0", n)
+	}
+
+	// A redelivery gets a fresh set of attempts.
+	f.receiver.status = http.StatusOK
+	if _, err := f.service.Redeliver(ctx, delivery.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := f.deliverer.Deliver(ctx, time.Now()); n != 1 {
+		t.Fatalf("got %d attempted after redelivery, want 1", n)
+	}
+	if delivery = f.deliveries(t, subscription)[0]; delivery.Status != models.DeliverySucceeded || delivery.Attempts != 1 {
+		t.Fatalf("got %+v, want the redelivery to succeed", delivery)
+	}
+	if len(f.receiver.received) != 4 || len(f.receiver.invalid) != 0 {
+		t.Fatalf("got %d requests (invalid signatures %v), want 4 signed ones", len(f.receiver.received), f.receiver.invalid)
+	}
+}
+
+func TestUnsubscribedDeliveriesAreDropped(t *testing.T) {
+	f := newFixture(t, http.StatusOK)
+	subscription := f.subscribe(t, events.WalletCreated)
+	f.publish(t, events.WalletCreated)
+	ctx := context.Background()
+
+	if err := f.service.Unsubscribe(ctx, subscription.ID); err != nil {
+		t.Fatal(err)
+	}
+	f.publish(t, events.WalletCreated)
+	f.deliverer.Deliver(ctx, time.Now())
+
+	deliveries := f.deliveries(t, subscription)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliveryDead || len(f.receiver.received) != 0 {
+		t.Fatalf("got %+v and %d requests, want the pending delivery dead and nothing sent", deliveries, len(f.receiver.received))
+	}
+	if _, err := f.service.Redeliver(ctx, deliveries[0].ID); err == nil {
+		t.Fatal("got a redelivery to an inactive subscription")
+	}
+}
+
+func TestSubscribeGeneratesSecretAndHidesIt(t *testing.T) {
+	f := newFixture(t, http.StatusOK)
+	subscription, err := f.service.Subscribe(context.Background(), &models.WebhookSubscriptionRequest{
+		URL:        f.url,
+		EventTypes: []string{events.WalletCreated},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscription.Secret) != 64 {
+		t.Fatalf("got secret %q, want 32 random bytes in hex", subscription.Secret)
+	}
+
+	subscriptions, err := f.service.Subscriptions(context.Background())
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].Secret != "" {
+		t.Fatalf("got %+v (%v), want the subscription without its secret", subscriptions, err)
+	}
+}
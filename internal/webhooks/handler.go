@@ -0,0 +1,184 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"payment/api/models"
+	"payment/pkg/auth"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"payment/pkg/openapi"
+	"payment/pkg/utils"
+	"strconv"
+)
+
+// Page sizes of the delivery log endpoint.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the webhook subscriptions and their delivery logs to administrators.
+type Handler struct {
+	Service   *Service
+	Logger    *logrus.Logger
+	Validator *validator.Validate
+	Config    *config.Value[Config]
+	Keys      auth.Keys
+}
+
+// NewHandler initializes a new Handler for webhook subscriptions, which accepts the configured admin
+// token and the admin API keys of keys.
+func NewHandler(service *Service, logger *logrus.Logger, validate *validator.Validate, settings *config.Value[Config],
+	keys auth.Keys) *Handler {
+	return &Handler{Service: service, Logger: logger, Validator: validate, Config: settings, Keys: keys}
+}
+
+// RegisterRoutes registers the webhook routes with the provided router.
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	protected := auth.AdminMiddleware(func() string { return h.Config.Load().AdminToken }, h.Keys)
+	adminRoutes := router.PathPrefix("/admin/webhooks").Subrouter()
+
+	openapi.Describe(adminRoutes.HandleFunc("", protected(h.subscribeHandler)).Methods(http.MethodPost), openapi.Operation{
+		Summary:     "Subscribe to events",
+		Description: "The response is the only one that carries the secret deliveries are signed with.",
+		Tag:         "webhooks",
+		Admin:       true,
+		Request:     models.WebhookSubscriptionRequest{},
+		Responses:   map[int]interface{}{http.StatusCreated: models.WebhookSubscription{}},
+	})
+	openapi.Describe(adminRoutes.HandleFunc("", protected(h.subscriptionsHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "List subscriptions, without their secrets",
+		Tag:       "webhooks",
+		Admin:     true,
+		Responses: map[int]interface{}{http.StatusOK: []models.WebhookSubscription{}},
+	})
+	openapi.Describe(adminRoutes.HandleFunc("/deliveries/{id}/redeliver", protected(h.redeliverHandler)).Methods(http.MethodPost), openapi.Operation{
+		Summary:   "Send a delivery again, whatever its status",
+		Tag:       "webhooks",
+		Admin:     true,
+		Responses: map[int]interface{}{http.StatusAccepted: models.WebhookDelivery{}},
+	})
+	openapi.Describe(adminRoutes.HandleFunc("/{id}", protected(h.unsubscribeHandler)).Methods(http.MethodDelete), openapi.Operation{
+		Summary:   "Deactivate a subscription",
+		Tag:       "webhooks",
+		Admin:     true,
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	})
+	openapi.Describe(adminRoutes.HandleFunc("/{id}/deliveries", protected(h.deliveriesHandler)).Methods(http.MethodGet), openapi.Operation{
+		Summary: "List the deliveries of a subscription, newest first",
+		Tag:     "webhooks",
+		Admin:   true,
+		Query: []openapi.Parameter{
+			{Name: "status", Enum: []string{string(models.DeliveryPending), string(models.DeliverySucceeded), string(models.DeliveryDead)}},
+			{Name: "limit", Type: "integer", Format: "int32", Description: "between 1 and " + strconv.Itoa(maxLimit)},
+		},
+		Responses: map[int]interface{}{http.StatusOK: []models.WebhookDelivery{}},
+	})
+}
+
+// subscribeHandler creates a subscription. The response is the only one that carries its secret.
+func (h *Handler) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.WebhookSubscriptionRequest
+	if err := utils.DecodeJSON(w, r, h.Validator, &request); err != nil {
+		errors.Respond(w, err)
+		return
+	}
+
+	subscription, err := h.Service.Subscribe(r.Context(), &request)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusCreated, subscription)
+}
+
+// subscriptionsHandler lists every subscription, without secrets.
+func (h *Handler) subscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.Service.Subscriptions(r.Context())
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, subscriptions)
+}
+
+// unsubscribeHandler deactivates a subscription. Its delivery log is kept.
+func (h *Handler) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("invalid subscription ID"))
+		return
+	}
+	if err = h.Service.Unsubscribe(r.Context(), id); err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliveriesHandler returns the delivery log of a subscription, newest first, optionally
+// filtered by status.
+func (h *Handler) deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("invalid subscription ID"))
+		return
+	}
+
+	query := r.URL.Query()
+	status := models.DeliveryStatus(query.Get("status"))
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryDead:
+	default:
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("status must be pending, succeeded or dead"))
+		return
+	}
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxLimit {
+			errors.Respond(w, errors.ErrBadRequest.WithMessage("limit must be between 1 and "+strconv.Itoa(maxLimit)))
+			return
+		}
+	}
+
+	deliveries, err := h.Service.Deliveries(r.Context(), id, status, limit)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusOK, deliveries)
+}
+
+// redeliverHandler schedules a delivery to be sent again, whatever its status.
+func (h *Handler) redeliverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		errors.Respond(w, errors.ErrBadRequest.WithMessage("invalid delivery ID"))
+		return
+	}
+
+	delivery, err := h.Service.Redeliver(r.Context(), id)
+	if err != nil {
+		h.Logger.Error(err.Error())
+		errors.Respond(w, err)
+		return
+	}
+	respond(w, http.StatusAccepted, delivery)
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		errors.Error(w, http.StatusInternalServerError)
+	}
+}
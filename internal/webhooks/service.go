@@ -0,0 +1,198 @@
+// Package webhooks delivers domain events to the URLs partners subscribe. The service is an
+// events.Publisher: the outbox relay hands it every event, and it records one delivery per
+// matching subscription. The Deliverer then sends the deliveries, signed with the secret of
+// the subscription, and retries them with exponential backoff until they succeed or run out
+// of attempts.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"payment/api/models"
+	"payment/internal/audit"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"time"
+)
+
+// Service manages subscriptions and records the deliveries of published events.
+type Service struct {
+	store      Store
+	transactor db.Transactor
+	auditor    audit.Recorder
+	targets    Targets
+	logger     *log.Logger
+}
+
+// NewService creates the webhook service on top of store. Changes to subscriptions and
+// redeliveries are recorded by auditor, and only URLs allowed by targets can be subscribed.
+func NewService(logger *log.Logger, store Store, transactor db.Transactor, auditor audit.Recorder, targets Targets) *Service {
+	return &Service{store: store, transactor: transactor, auditor: auditor, targets: targets, logger: logger}
+}
+
+// subscriptionState is what the audit log keeps of a subscription. The secret is left out.
+type subscriptionState struct {
+	URL        string            `json:"url"`
+	EventTypes models.EventTypes `json:"event_types"`
+	Active     bool              `json:"active"`
+}
+
+func stateOf(subscription *models.WebhookSubscription) *subscriptionState {
+	return &subscriptionState{URL: subscription.URL, EventTypes: subscription.EventTypes, Active: subscription.Active}
+}
+
+// Subscribe creates a subscription. A secret is generated when the request has none; the
+// returned subscription is the only place it is shown.
+func (s *Service) Subscribe(ctx context.Context, request *models.WebhookSubscriptionRequest) (*models.WebhookSubscription, error) {
+	if err := s.targets.Check(request.URL); err != nil {
+		return nil, err
+	}
+	secret := request.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, errors.ErrInternal.Wrap(err)
+		}
+		secret = hex.EncodeToString(key)
+	}
+
+	subscription := &models.WebhookSubscription{
+		URL:        request.URL,
+		EventTypes: request.EventTypes,
+		Secret:     secret,
+		Active:     true,
+	}
+	subscription.CreatedAt = time.Now()
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.store.AddSubscription(ctx, subscription); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionWebhookSubscribe, subscription.ID, nil, stateOf(subscription))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx, s.logger).WithFields(log.Fields{
+		"section":      "webhooks",
+		"subscription": subscription.ID,
+		"event_types":  subscription.EventTypes,
+	}).Info("webhook subscription created")
+	return subscription, nil
+}
+
+// Subscriptions returns every subscription without its secret.
+func (s *Service) Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	subscriptions, err := s.store.Subscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	return subscriptions, nil
+}
+
+// Unsubscribe deactivates a subscription. Its deliveries are kept, and pending ones are dropped
+// when they are next attempted.
+func (s *Service) Unsubscribe(ctx context.Context, id uuid.UUID) error {
+	return s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		subscription, err := s.store.FindSubscription(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = s.store.Deactivate(ctx, id); err != nil {
+			return err
+		}
+		before := stateOf(subscription)
+		subscription.Active = false
+		return s.record(ctx, audit.ActionWebhookUnsubscribe, id, before, stateOf(subscription))
+	})
+}
+
+// Deliveries returns the delivery log of a subscription, newest first.
+func (s *Service) Deliveries(ctx context.Context, subscriptionID uuid.UUID, status models.DeliveryStatus, limit int) ([]*models.WebhookDelivery, error) {
+	if _, err := s.store.FindSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+	return s.store.Deliveries(ctx, subscriptionID, status, limit)
+}
+
+// Redeliver schedules a delivery to be sent again right away, with a fresh set of attempts.
+// Dead deliveries are only sent again this way.
+func (s *Service) Redeliver(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery *models.WebhookDelivery
+	err := s.transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if delivery, err = s.store.FindDelivery(ctx, id); err != nil {
+			return err
+		}
+		subscription, err := s.store.FindSubscription(ctx, delivery.SubscriptionID)
+		if err != nil {
+			return err
+		}
+		if !subscription.Active {
+			return errors.ErrBadRequest.WithMessage("webhook subscription %s is no longer active", subscription.ID)
+		}
+
+		previous := delivery.Status
+		// Clearing the lease drops the outcome of an attempt still in flight.
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt = models.DeliveryPending, 0, time.Now()
+		delivery.Lease = nil
+		if err = s.store.UpdateDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionWebhookRedeliver, subscription.ID,
+			map[string]interface{}{"delivery_id": delivery.ID, "status": previous},
+			map[string]interface{}{"delivery_id": delivery.ID, "status": delivery.Status})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Publish records a delivery of event for every active subscription to its type. It is
+// called by the outbox relay in its transaction, and may be called again for the same event.
+func (s *Service) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	subscriptions, err := s.store.Subscribers(ctx, event.Type)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		delivery := &models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Body:           body,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		}
+		delivery.CreatedAt = now
+		if err = s.store.AddDelivery(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, action string, subscription uuid.UUID, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Entry{
+		Action:     action,
+		EntityType: audit.EntityWebhook,
+		EntityID:   subscription.String(),
+		Before:     before,
+		After:      after,
+	})
+}
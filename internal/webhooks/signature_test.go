@@ -0,0 +1,29 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign("secret", now, body)
+	if header[:13] != "t=1700000000," {
+		t.Fatalf("got %q, want the timestamp first", header)
+	}
+
+	if err := Verify("secret", header, body, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Fatalf("got %v, want a valid signature", err)
+	}
+	for name, err := range map[string]error{
+		"wrong secret": Verify("other", header, body, now, 5*time.Minute),
+		"changed body": Verify("secret", header, []byte(`{"id":"2"}`), now, 5*time.Minute),
+		"too old":      Verify("secret", header, body, now.Add(10*time.Minute), 5*time.Minute),
+		"malformed":    Verify("secret", "v1=abc", body, now, 5*time.Minute),
+	} {
+		if err == nil {
+			t.Errorf("%s: got a valid signature", name)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"payment/api/models"
+	"payment/pkg/db"
+	"payment/pkg/errors"
+	"time"
+)
+
+var (
+	errSubscriptionNotFound = errors.ErrNotFound.WithMessage("webhook subscription not found")
+	errDeliveryNotFound     = errors.ErrNotFound.WithMessage("webhook delivery not found")
+)
+
+// Store persists webhook subscriptions and their deliveries.
+type Store interface {
+	AddSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	// Subscriptions returns every subscription, oldest first.
+	Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error)
+	FindSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error)
+	Deactivate(ctx context.Context, id uuid.UUID) error
+	// Subscribers returns the active subscriptions to eventType.
+	Subscribers(ctx context.Context, eventType string) ([]*models.WebhookSubscription, error)
+	// AddDelivery adds a delivery unless the subscription already has one for the same event.
+	AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// Due returns up to limit pending deliveries whose next attempt is due at now, oldest first.
+	// They stay locked until the surrounding transaction ends, so that concurrent deliverers skip them.
+	Due(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// RecordAttempt stores the outcome of an attempt made under lease, and clears the lease. It
+	// changes nothing and reports false when the delivery is no longer held by lease, because it
+	// was redelivered or claimed again meanwhile.
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, lease uuid.UUID) (bool, error)
+	FindDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error)
+	// Deliveries returns up to limit deliveries of the subscription, newest first. An empty
+	// status matches every delivery.
+	Deliveries(ctx context.Context, subscriptionID uuid.UUID, status models.DeliveryStatus, limit int) ([]*models.WebhookDelivery, error)
+}
+
+// NewStore creates a Store backed by Postgres.
+func NewStore(db *db.DB) Store {
+	return &store{db}
+}
+
+type store struct {
+	db *db.DB
+}
+
+func (s *store) AddSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	if err := s.db.Conn(ctx).Create(subscription).Error; err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	subscriptions := make([]*models.WebhookSubscription, 0)
+	if err := s.db.Conn(ctx).Order("created_at").Find(&subscriptions).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return subscriptions, nil
+}
+
+func (s *store) FindSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	if err := s.db.Conn(ctx).Where("id = ?", id).First(&subscription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errSubscriptionNotFound
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &subscription, nil
+}
+
+func (s *store) Deactivate(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Conn(ctx).Model(&models.WebhookSubscription{}).Where("id = ?", id).Update("active", false)
+	if result.Error != nil {
+		return errors.ErrInternal.Wrap(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errSubscriptionNotFound
+	}
+	return nil
+}
+
+func (s *store) Subscribers(ctx context.Context, eventType string) ([]*models.WebhookSubscription, error) {
+	match, err := json.Marshal([]string{eventType})
+	if err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	subscriptions := make([]*models.WebhookSubscription, 0)
+	err = s.db.Conn(ctx).
+		Where("active AND event_types @> ?::jsonb", string(match)).
+		Order("created_at").
+		Find(&subscriptions).Error
+	if err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return subscriptions, nil
+}
+
+func (s *store) AddDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	err := s.db.Conn(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "subscription_id"}, {Name: "event_id"}}, DoNothing: true}).
+		Create(delivery).Error
+	if err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) Due(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	deliveries := make([]*models.WebhookDelivery, 0)
+	err := s.db.Conn(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at, created_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return deliveries, nil
+}
+
+func (s *store) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := s.db.Conn(ctx).Save(delivery).Error; err != nil {
+		return errors.ErrInternal.Wrap(err)
+	}
+	return nil
+}
+
+func (s *store) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, lease uuid.UUID) (bool, error) {
+	result := s.db.Conn(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND lease = ?", delivery.ID, lease).
+		Updates(map[string]interface{}{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"last_attempt_at":  delivery.LastAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"delivered_at":     delivery.DeliveredAt,
+			"lease":            nil,
+		})
+	if result.Error != nil {
+		return false, errors.ErrInternal.Wrap(result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *store) FindDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := s.db.Conn(ctx).Where("id = ?", id).First(&delivery).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errDeliveryNotFound
+		}
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return &delivery, nil
+}
+
+func (s *store) Deliveries(ctx context.Context, subscriptionID uuid.UUID, status models.DeliveryStatus, limit int) ([]*models.WebhookDelivery, error) {
+	query := s.db.Conn(ctx).Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	deliveries := make([]*models.WebhookDelivery, 0)
+	if err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, errors.ErrInternal.Wrap(err)
+	}
+	return deliveries, nil
+}
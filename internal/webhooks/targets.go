@@ -0,0 +1,113 @@
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"payment/pkg/config"
+	"payment/pkg/errors"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Targets restricts where webhooks are sent. Any client with an admin credential can subscribe
+// a URL, so without it the service could be made to send requests into its own network.
+type Targets struct {
+	// Hosts lists the allowed host names; a name starting with a dot also allows its
+	// subdomains. Any host is allowed when it is empty.
+	Hosts []string
+	// Private allows hosts that are, or resolve to, loopback, private, link-local or other
+	// non-public addresses.
+	Private bool
+}
+
+// NewTargets extracts the allowed webhook targets from the service configuration.
+func NewTargets(c *config.Config) Targets {
+	return Targets{Hosts: c.Webhooks.AllowedHosts, Private: c.Webhooks.AllowPrivate}
+}
+
+// Check rejects a URL that is not an http or https URL of an allowed host. Host names are
+// resolved when a delivery is sent, by the client returned by Client.
+func (t Targets) Check(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.ErrBadRequest.WithMessage("webhook url %q is not an http or https URL", raw)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if !t.allowed(host) {
+		return errors.ErrBadRequest.WithMessage("webhooks cannot be sent to %s", host)
+	}
+	if t.Private {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.ErrBadRequest.WithMessage("webhooks cannot be sent to %s", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !public(ip) {
+		return errors.ErrBadRequest.WithMessage("webhooks cannot be sent to the non-public address %s", host)
+	}
+	return nil
+}
+
+func (t Targets) allowed(host string) bool {
+	if len(t.Hosts) == 0 {
+		return true
+	}
+	for _, allowed := range t.Hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client returns an HTTP client that checks every request it sends, redirects included, and
+// refuses to connect to a non-public address unless private addresses are allowed. Checking
+// the address that is dialled, rather than the one a name resolved to earlier, also covers
+// names that resolve differently by the time the delivery is sent.
+func (t Targets) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !t.Private {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return errors.ErrBadRequest.WithMessage("webhooks cannot be sent to the non-public address %s", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: &checked{targets: t, next: transport}}
+}
+
+// checked is a RoundTripper that checks the URL of every request against targets.
+type checked struct {
+	targets Targets
+	next    http.RoundTripper
+}
+
+func (c *checked) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := c.targets.Check(req.URL.String()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return c.next.RoundTrip(req)
+}
+
+// public reports whether ip is a public unicast address.
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routed on the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
@@ -0,0 +1,61 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTargetsCheck(t *testing.T) {
+	for _, c := range []struct {
+		targets Targets
+		url     string
+		allowed bool
+	}{
+		{Targets{}, "https://hooks.example.com/payment", true},
+		{Targets{}, "ftp://hooks.example.com/payment", false},
+		{Targets{}, "hooks.example.com/payment", false},
+		{Targets{}, "http://localhost:8080/hook", false},
+		{Targets{}, "http://api.localhost/hook", false},
+		{Targets{}, "http://127.0.0.1/hook", false},
+		{Targets{}, "http://10.0.0.7/hook", false},
+		{Targets{}, "http://169.254.169.254/latest/meta-data", false},
+		{Targets{}, "http://[::1]/hook", false},
+		{Targets{}, "http://100.64.0.1/hook", false},
+		{Targets{}, "http://93.184.216.34/hook", true},
+		{Targets{Private: true}, "http://127.0.0.1/hook", true},
+		{Targets{Hosts: []string{"hooks.example.com"}}, "https://HOOKS.example.com./a", true},
+		{Targets{Hosts: []string{"hooks.example.com"}}, "https://api.example.com/a", false},
+		{Targets{Hosts: []string{".example.com"}}, "https://api.example.com/a", true},
+		{Targets{Hosts: []string{".example.com"}}, "https://example.com.evil.org/a", false},
+	} {
+		if err := c.targets.Check(c.url); (err == nil) != c.allowed {
+			t.Errorf("%+v: got %v for %s, want allowed %t", c.targets, err, c.url, c.allowed)
+		}
+	}
+}
+
+func TestTargetsClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost/elsewhere", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// The test server listens on a loopback address, which stands in for one a public
+	// name resolves to.
+	if _, err := (Targets{}).Client(time.Second).Get(server.URL); err == nil {
+		t.Fatal("got a response from a loopback address, want it refused")
+	}
+	client := Targets{Private: true}.Client(time.Second)
+	if response, err := client.Get(server.URL); err != nil {
+		t.Fatalf("got %v, want private addresses allowed", err)
+	} else {
+		response.Body.Close()
+	}
+	if _, err := (Targets{Hosts: []string{"127.0.0.1"}, Private: true}).Client(time.Second).Get(server.URL + "/redirect"); err == nil {
+		t.Fatal("followed a redirect to a host that is not allowed")
+	}
+}
@@ -1,57 +1,111 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"payment/api/models"
+	"os"
 	"payment/pkg/config"
 	"payment/pkg/db"
+	"payment/pkg/migrations"
+	"strconv"
+	"text/tabwriter"
+	"time"
 )
 
+const usage = `Usage: migrate [--config path] <command> [argument]
+
+Commands:
+  up            apply all pending migrations (default)
+  down [n]      roll back the last n migrations (default 1)
+  status        list applied and pending migrations
+  to <version>  migrate up or down to the given version (0 rolls back everything)
+`
+
 func main() {
 	fmt.Println("Payment Service migration tool")
 	logger := log.New()
 	logger.SetFormatter(&log.TextFormatter{})
 
-	configuration, err := config.LoadConfig("configs/config.yaml")
+	configFilePath := flag.String("config", "configs/config.yaml", "Path to the YAML configuration file")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	command := flag.Arg(0)
+	if command == "" {
+		command = "up"
+	}
+
+	configuration, err := config.LoadConfig(*configFilePath)
 	if err != nil {
 		logger.Fatal(err)
 	}
 	logger.Info("Configuration loaded")
 
-	dsn := db.LoadDSN(configuration)
-	database, err := db.New(dsn)
+	database, err := db.Connect(context.Background(), configuration, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
 	logger.Println("Connected to database")
 
-	// Create enum types
-	err = db.CreateEnumType(database, "discount_type", []string{"voucher", "charge"})
+	migrator, err := migrations.New(database, logger)
 	if err != nil {
-		logger.Info(err)
+		logger.Fatal(err)
 	}
-	err = db.CreateEnumType(database, "transaction_type", []string{"withdrawal", "deposit"})
-	if err != nil {
-		logger.Info(err)
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			if steps, err = strconv.Atoi(flag.Arg(1)); err != nil || steps < 1 {
+				logger.Fatalf("invalid number of steps %q", flag.Arg(1))
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "to":
+		if flag.NArg() < 2 {
+			logger.Fatal("missing target version")
+		}
+		var version int64
+		if version, err = strconv.ParseInt(flag.Arg(1), 10, 64); err != nil {
+			logger.Fatalf("invalid version %q", flag.Arg(1))
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	err = db.CreateEnumType(database, "transaction_status", []string{"pending", "completed", "failed"})
 	if err != nil {
-		logger.Info(err)
+		logger.Fatal(err)
 	}
+	logger.Infof("%s completed", command)
+}
 
-	// Migrate database models
-	err = db.AutoMigrate(database,
-		models.Wallet{},
-		models.Transaction{},
-		models.Discount{},
-		models.DiscountTransaction{},
-	)
-
+// printStatus writes the state of every known migration as a table.
+func printStatus(ctx context.Context, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status(ctx)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
 
-	logger.Info("Database models migrated")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return w.Flush()
 }
@@ -1,28 +1,98 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"net/http"
 	"payment/pkg/errors"
+	"payment/pkg/logging"
 )
 
-type Config struct {
-	Token string
+// Keys checks tokens against the API keys issued by operators.
+type Keys interface {
+	// Authenticate reports whether token is an API key that has not been revoked and, when admin
+	// is set, that it has the admin scope.
+	Authenticate(ctx context.Context, token string, admin bool) (bool, error)
+}
+
+// authenticate checks token against the configured token first, and then against the API keys
+// of keys, when there are any. With admin set, only keys with the admin scope are accepted.
+func authenticate(ctx context.Context, token, configured string, keys Keys, admin bool) error {
+	if configured != "" && subtle.ConstantTimeCompare([]byte(token), []byte(configured)) == 1 {
+		return nil
+	}
+	if keys != nil {
+		ok, err := keys.Authenticate(ctx, token, admin)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.ErrUnauthorized.WithMessage("invalid token")
 }
 
 // AuthMiddleware returns a middleware function that wraps an http.HandlerFunc.
-func AuthMiddleware(config *Config) func(http.HandlerFunc) http.HandlerFunc {
+// token is called for every request, so that a reloaded token takes effect immediately.
+// The API keys of keys are accepted too; a nil keys only accepts the token.
+func AuthMiddleware(token func() string, keys Keys) func(http.HandlerFunc) http.HandlerFunc {
+	return middleware(token, keys, false)
+}
+
+// AdminMiddleware is AuthMiddleware for the routes that manage the service rather than wallets:
+// token is the admin token, and only API keys with the admin scope are accepted.
+func AdminMiddleware(token func() string, keys Keys) func(http.HandlerFunc) http.HandlerFunc {
+	return middleware(token, keys, true)
+}
+
+func middleware(token func() string, keys Keys, admin bool) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			tokenString := r.Header.Get("Authorization")
 			if len(tokenString) == 0 {
-				errors.Error(w, http.StatusUnauthorized, "token not found in header")
+				errors.Respond(w, errors.ErrUnauthorized.WithMessage("token not found in header"))
 				return
 			}
-			if tokenString != config.Token {
-				errors.Error(w, http.StatusUnauthorized, "invalid token")
+			if err := authenticate(r.Context(), tokenString, token(), keys, admin); err != nil {
+				errors.Respond(w, err)
 				return
 			}
+			logging.SetKeyID(r.Context(), KeyID(tokenString))
 			next(w, r)
 		}
 	}
 }
+
+// KeyID returns a stable identifier of token that can be logged without revealing the token itself.
+func KeyID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// UnaryInterceptor is the gRPC counterpart of AuthMiddleware. It checks the token sent in the
+// authorization metadata of every call, except for the full method names listed in public.
+func UnaryInterceptor(token func() string, keys Keys, public ...string) grpc.UnaryServerInterceptor {
+	open := make(map[string]bool, len(public))
+	for _, method := range public {
+		open[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if open[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		values := metadata.ValueFromIncomingContext(ctx, "authorization")
+		if len(values) == 0 || values[0] == "" {
+			return nil, errors.GRPCStatus(errors.ErrUnauthorized.WithMessage("token not found in metadata")).Err()
+		}
+		if err := authenticate(ctx, values[0], token(), keys, false); err != nil {
+			return nil, errors.GRPCStatus(err).Err()
+		}
+		logging.SetKeyID(ctx, KeyID(values[0]))
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileSuffix marks a variable that names a file holding the value, e.g. a Docker or Kubernetes secret.
+const fileSuffix = "_FILE"
+
+// applyEnv overrides the fields of config that carry an env tag. A NAME_FILE variable
+// takes precedence over NAME. It returns one error per override that could not be applied.
+// The env tag of a nested struct is a prefix for the tags of its fields, so that a struct
+// used in several places, such as LimitsConfig, gets a variable per place.
+func applyEnv(config *Config, lookupEnv func(string) (string, bool)) []error {
+	return applyEnvTo(reflect.ValueOf(config).Elem(), "", lookupEnv)
+}
+
+func applyEnvTo(value reflect.Value, prefix string, lookupEnv func(string) (string, bool)) []error {
+	var problems []error
+	for i := 0; i < value.NumField(); i++ {
+		field, fieldType := value.Field(i), value.Type().Field(i)
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, applyEnvTo(field, prefix+fieldType.Tag.Get("env"), lookupEnv)...)
+			continue
+		}
+		name := fieldType.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		name = prefix + name
+
+		raw, source, ok, err := lookup(name, lookupEnv)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err = set(field, raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", source, err))
+		}
+	}
+	return problems
+}
+
+// lookup returns the value of name, read from the file named by NAME_FILE when that is set.
+// source names where the value came from, for error messages.
+func lookup(name string, lookupEnv func(string) (string, bool)) (raw, source string, ok bool, err error) {
+	if path, found := lookupEnv(name + fileSuffix); found {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s%s: %w", name, fileSuffix, err)
+		}
+		// Secret files usually end with a newline that is not part of the value.
+		return strings.TrimRight(string(data), "\r\n"), name + fileSuffix, true, nil
+	}
+	raw, ok = lookupEnv(name)
+	return raw, name, ok, nil
+}
+
+func set(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		// Lists are separated by commas.
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
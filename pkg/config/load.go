@@ -1,32 +1,178 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"time"
 )
 
+// PostgresConfig describes the database connection. URL, when set, is used instead of the
+// separate connection fields; the TLS, session and pool settings apply to both.
 type PostgresConfig struct {
-	Host     string `yaml:"POSTGRES_HOST"`
-	User     string `yaml:"POSTGRES_USER"`
-	Password string `yaml:"POSTGRES_PASSWORD"`
-	Database string `yaml:"POSTGRES_DB"`
-	Port     string `yaml:"POSTGRES_PORT"`
-	Timezone string `yaml:"TIMEZONE"`
+	URL      string `yaml:"POSTGRES_URL" env:"PAYMENT_POSTGRES_URL"`
+	Host     string `yaml:"POSTGRES_HOST" env:"PAYMENT_POSTGRES_HOST"`
+	User     string `yaml:"POSTGRES_USER" env:"PAYMENT_POSTGRES_USER"`
+	Password string `yaml:"POSTGRES_PASSWORD" env:"PAYMENT_POSTGRES_PASSWORD"`
+	Database string `yaml:"POSTGRES_DB" env:"PAYMENT_POSTGRES_DB"`
+	Port     string `yaml:"POSTGRES_PORT" env:"PAYMENT_POSTGRES_PORT"`
+	Timezone string `yaml:"TIMEZONE" env:"PAYMENT_POSTGRES_TIMEZONE"`
+
+	SSLMode     string `yaml:"POSTGRES_SSLMODE" env:"PAYMENT_POSTGRES_SSLMODE"`
+	SSLRootCert string `yaml:"POSTGRES_SSLROOTCERT" env:"PAYMENT_POSTGRES_SSLROOTCERT"`
+	SSLCert     string `yaml:"POSTGRES_SSLCERT" env:"PAYMENT_POSTGRES_SSLCERT"`
+	SSLKey      string `yaml:"POSTGRES_SSLKEY" env:"PAYMENT_POSTGRES_SSLKEY"`
+
+	ApplicationName  string        `yaml:"APPLICATION_NAME" env:"PAYMENT_POSTGRES_APPLICATION_NAME"`
+	StatementTimeout time.Duration `yaml:"STATEMENT_TIMEOUT" env:"PAYMENT_POSTGRES_STATEMENT_TIMEOUT"`
+
+	MaxOpenConns    int           `yaml:"MAX_OPEN_CONNS" env:"PAYMENT_POSTGRES_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"MAX_IDLE_CONNS" env:"PAYMENT_POSTGRES_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"CONN_MAX_LIFETIME" env:"PAYMENT_POSTGRES_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"CONN_MAX_IDLE_TIME" env:"PAYMENT_POSTGRES_CONN_MAX_IDLE_TIME"`
+
+	// ConnectTimeout bounds how long startup keeps retrying while the database is unavailable.
+	ConnectTimeout time.Duration `yaml:"CONNECT_TIMEOUT" env:"PAYMENT_POSTGRES_CONNECT_TIMEOUT"`
 }
 
 type DiscountConfig struct {
-	ExpireTime int `yaml:"expire_time"`
-	CodeLength int `yaml:"code_length"`
+	ExpireTime int `yaml:"expire_time" env:"PAYMENT_DISCOUNT_EXPIRE_TIME"`
+	CodeLength int `yaml:"code_length" env:"PAYMENT_DISCOUNT_CODE_LENGTH"`
+	QueueSize  int `yaml:"queue_size" env:"PAYMENT_DISCOUNT_QUEUE_SIZE"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported.
+// Exporter is one of "none", "stdout" or "otlp"; an empty value disables tracing.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"PAYMENT_TRACING_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" env:"PAYMENT_TRACING_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" env:"PAYMENT_TRACING_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"PAYMENT_TRACING_SAMPLE_RATIO"`
+	ServiceName string  `yaml:"service_name" env:"PAYMENT_TRACING_SERVICE_NAME"`
+}
+
+// RetentionConfig controls how long closed wallets keep their phone numbers.
+// A zero AnonymizeAfter disables anonymization.
+type RetentionConfig struct {
+	AnonymizeAfter time.Duration `yaml:"anonymize_after" env:"PAYMENT_RETENTION_ANONYMIZE_AFTER"`
+	Interval       time.Duration `yaml:"interval" env:"PAYMENT_RETENTION_INTERVAL"`
+}
+
+// EventsConfig selects how the domain events of the outbox are published. Publisher is one of
+// "none", "log", "file" or "webhook"; an empty value leaves the events in the outbox.
+type EventsConfig struct {
+	Publisher     string        `yaml:"publisher" env:"PAYMENT_EVENTS_PUBLISHER"`
+	File          string        `yaml:"file" env:"PAYMENT_EVENTS_FILE"`
+	WebhookURL    string        `yaml:"webhook_url" env:"PAYMENT_EVENTS_WEBHOOK_URL"`
+	Interval      time.Duration `yaml:"interval" env:"PAYMENT_EVENTS_INTERVAL"`
+	BatchSize     int           `yaml:"batch_size" env:"PAYMENT_EVENTS_BATCH_SIZE"`
+	KeepPublished time.Duration `yaml:"keep_published" env:"PAYMENT_EVENTS_KEEP_PUBLISHED"`
+}
+
+// WebhooksConfig controls the delivery of events to webhook subscriptions. A failed delivery
+// is retried after Backoff, doubling up to MaxBackoff, until MaxAttempts have been made.
+type WebhooksConfig struct {
+	MaxAttempts int           `yaml:"max_attempts" env:"PAYMENT_WEBHOOKS_MAX_ATTEMPTS"`
+	Backoff     time.Duration `yaml:"backoff" env:"PAYMENT_WEBHOOKS_BACKOFF"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" env:"PAYMENT_WEBHOOKS_MAX_BACKOFF"`
+	Timeout     time.Duration `yaml:"timeout" env:"PAYMENT_WEBHOOKS_TIMEOUT"`
+	Interval    time.Duration `yaml:"interval" env:"PAYMENT_WEBHOOKS_INTERVAL"`
+	// AllowedHosts restricts the hosts subscriptions may target; a name starting with a dot
+	// also allows its subdomains. Any public host is allowed when it is empty.
+	AllowedHosts []string `yaml:"allowed_hosts" env:"PAYMENT_WEBHOOKS_ALLOWED_HOSTS"`
+	// AllowPrivate allows loopback, private and link-local addresses, for development.
+	AllowPrivate bool `yaml:"allow_private" env:"PAYMENT_WEBHOOKS_ALLOW_PRIVATE"`
+}
+
+// LimitsConfig holds transaction limits. Amounts are in the wallet currency; zero means unlimited.
+// Its env tags are relative to the env tag of the field that holds it.
+type LimitsConfig struct {
+	SingleWithdrawal   int64 `yaml:"single_withdrawal" env:"SINGLE_WITHDRAWAL"`
+	DailyWithdrawal    int64 `yaml:"daily_withdrawal" env:"DAILY_WITHDRAWAL"`
+	MonthlyWithdrawal  int64 `yaml:"monthly_withdrawal" env:"MONTHLY_WITHDRAWAL"`
+	DailyDeposit       int64 `yaml:"daily_deposit" env:"DAILY_DEPOSIT"`
+	MonthlyDeposit     int64 `yaml:"monthly_deposit" env:"MONTHLY_DEPOSIT"`
+	MaxBalance         int64 `yaml:"max_balance" env:"MAX_BALANCE"`
+	HourlyTransactions int64 `yaml:"hourly_transactions" env:"HOURLY_TRANSACTIONS"`
+}
+
+// TiersConfig holds the limits of each verification tier. They apply on top of the
+// global limits, the stricter of the two winning.
+type TiersConfig struct {
+	Unverified LimitsConfig `yaml:"unverified" env:"UNVERIFIED_"`
+	Basic      LimitsConfig `yaml:"basic" env:"BASIC_"`
+	Full       LimitsConfig `yaml:"full" env:"FULL_"`
+}
+
+// PhoneConfig selects how phone numbers written without a country code are read.
+// DefaultRegion is an ISO 3166-1 alpha-2 code; IR when it is empty.
+type PhoneConfig struct {
+	DefaultRegion string `yaml:"default_region" env:"PAYMENT_PHONE_DEFAULT_REGION"`
+}
+
+// OTPConfig controls the one-time codes that prove a client owns a phone number. Sender is
+// "console" or "file"; an empty value prints codes to the console. Durations and counts left
+// at zero take their default.
+type OTPConfig struct {
+	Sender                 string        `yaml:"sender" env:"PAYMENT_OTP_SENDER"`
+	File                   string        `yaml:"file" env:"PAYMENT_OTP_FILE"`
+	CodeLength             int           `yaml:"code_length" env:"PAYMENT_OTP_CODE_LENGTH"`
+	CodeTTL                time.Duration `yaml:"code_ttl" env:"PAYMENT_OTP_CODE_TTL"`
+	MaxAttempts            int           `yaml:"max_attempts" env:"PAYMENT_OTP_MAX_ATTEMPTS"`
+	ResendCooldown         time.Duration `yaml:"resend_cooldown" env:"PAYMENT_OTP_RESEND_COOLDOWN"`
+	TokenTTL               time.Duration `yaml:"token_ttl" env:"PAYMENT_OTP_TOKEN_TTL"`
+	Lockout                time.Duration `yaml:"lockout" env:"PAYMENT_OTP_LOCKOUT"`
+	ClientLimit            int           `yaml:"client_limit" env:"PAYMENT_OTP_CLIENT_LIMIT"`
+	GlobalLimit            int           `yaml:"global_limit" env:"PAYMENT_OTP_GLOBAL_LIMIT"`
+	LimitWindow            time.Duration `yaml:"limit_window" env:"PAYMENT_OTP_LIMIT_WINDOW"`
+	RequireForRegistration bool          `yaml:"require_for_registration" env:"PAYMENT_OTP_REQUIRE_FOR_REGISTRATION"`
+	RequireForDiscounts    bool          `yaml:"require_for_discounts" env:"PAYMENT_OTP_REQUIRE_FOR_DISCOUNTS"`
+}
+
+// NotificationsConfig controls the messages sent to wallet owners when a discount credits their
+// wallet or a withdrawal debits it. Each channel is used when it is enabled: SMS messages go
+// through the sender of the one-time codes, emails are written to EmailFile or logged when it
+// is empty, and webhook messages are posted to WebhookURL. Counts and durations left at zero
+// take their default.
+type NotificationsConfig struct {
+	SMS           bool          `yaml:"sms" env:"PAYMENT_NOTIFICATIONS_SMS"`
+	Email         bool          `yaml:"email" env:"PAYMENT_NOTIFICATIONS_EMAIL"`
+	EmailFile     string        `yaml:"email_file" env:"PAYMENT_NOTIFICATIONS_EMAIL_FILE"`
+	WebhookURL    string        `yaml:"webhook_url" env:"PAYMENT_NOTIFICATIONS_WEBHOOK_URL"`
+	DefaultLocale string        `yaml:"default_locale" env:"PAYMENT_NOTIFICATIONS_DEFAULT_LOCALE"`
+	MaxAttempts   int           `yaml:"max_attempts" env:"PAYMENT_NOTIFICATIONS_MAX_ATTEMPTS"`
+	Backoff       time.Duration `yaml:"backoff" env:"PAYMENT_NOTIFICATIONS_BACKOFF"`
+	Interval      time.Duration `yaml:"interval" env:"PAYMENT_NOTIFICATIONS_INTERVAL"`
 }
 
 type Config struct {
-	ServerPort     int            `yaml:"port"`
-	Token          string         `yaml:"token"`
-	DiscountConfig DiscountConfig `yaml:"discount"`
-	PostgresConfig PostgresConfig `yaml:"postgres"`
+	ServerPort int `yaml:"port" env:"PAYMENT_PORT"`
+	// GRPCPort is the port of the gRPC API; zero disables it.
+	GRPCPort int    `yaml:"grpc_port" env:"PAYMENT_GRPC_PORT"`
+	Token    string `yaml:"token" env:"PAYMENT_TOKEN"`
+	// AdminToken is accepted on the routes that manage the service instead of Token. Without it,
+	// those routes only accept API keys with the admin scope.
+	AdminToken     string              `yaml:"admin_token" env:"PAYMENT_ADMIN_TOKEN"`
+	DiscountConfig DiscountConfig      `yaml:"discount"`
+	PostgresConfig PostgresConfig      `yaml:"postgres"`
+	TracingConfig  TracingConfig       `yaml:"tracing"`
+	Retention      RetentionConfig     `yaml:"retention"`
+	Events         EventsConfig        `yaml:"events"`
+	Webhooks       WebhooksConfig      `yaml:"webhooks"`
+	Limits         LimitsConfig        `yaml:"limits" env:"PAYMENT_LIMITS_"`
+	Tiers          TiersConfig         `yaml:"tiers" env:"PAYMENT_TIERS_"`
+	Phone          PhoneConfig         `yaml:"phone"`
+	OTP            OTPConfig           `yaml:"otp"`
+	Notifications  NotificationsConfig `yaml:"notifications"`
 }
 
+// LoadConfig reads the YAML file at path and applies the environment overrides on top of it.
+// Every field can be set by the variable named in its env tag, or read from the file named by
+// the same variable with a _FILE suffix, which is meant for secrets such as PAYMENT_TOKEN_FILE.
+// Unknown keys, malformed overrides and invalid values are all reported in a single error.
 func LoadConfig(path string) (config *Config, err error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -38,10 +184,31 @@ func LoadConfig(path string) (config *Config, err error) {
 	if err != nil {
 		return nil, err
 	}
-	config = &Config{}
-	err = yaml.Unmarshal(data, config)
-	if err != nil {
-		return nil, err
+	return Parse(data, os.LookupEnv)
+}
+
+// Parse decodes a YAML configuration, applies the overrides found by lookupEnv and validates the result.
+func Parse(data []byte, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := &Config{}
+	var problems []error
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		// Unknown keys and mistyped values do not stop decoding, so the rest can still be checked.
+		for _, message := range typeErr.Errors {
+			problems = append(problems, fmt.Errorf("yaml: %s", message))
+		}
+	}
+
+	problems = append(problems, applyEnv(config, lookupEnv)...)
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(problems...))
 	}
 	return config, nil
 }
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sample = `
+port: 8080
+discount:
+  expire_time: 5
+  code_length: 8
+postgres:
+  POSTGRES_HOST: "postgres"
+  POSTGRES_USER: "postgres"
+  POSTGRES_DB: "payment"
+  POSTGRES_PORT: 5432
+`
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestParseAppliesOverrides(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Parse([]byte(sample), env(map[string]string{
+		"PAYMENT_TOKEN":                   "token",
+		"PAYMENT_PORT":                    "9090",
+		"PAYMENT_POSTGRES_PASSWORD":       "ignored",
+		"PAYMENT_POSTGRES_PASSWORD_FILE":  secret,
+		"PAYMENT_TRACING_INSECURE":        "true",
+		"PAYMENT_LIMITS_MAX_BALANCE":      "5000000000",
+		"PAYMENT_TIERS_BASIC_MAX_BALANCE": "1000",
+		"PAYMENT_WEBHOOKS_ALLOWED_HOSTS":  "hooks.example.com, .partner.example.org,",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Token != "token" || config.ServerPort != 9090 || !config.TracingConfig.Insecure {
+		t.Errorf("environment overrides not applied: %+v", config)
+	}
+	if config.PostgresConfig.Password != "s3cret" {
+		t.Errorf("got password %q, want it read from the _FILE variable", config.PostgresConfig.Password)
+	}
+	if config.Limits.MaxBalance != 5000000000 {
+		t.Errorf("got max balance %d, want the 64-bit override", config.Limits.MaxBalance)
+	}
+	if config.Tiers.Basic.MaxBalance != 1000 || config.Tiers.Full.MaxBalance != 0 {
+		t.Errorf("got tier limits %+v, want the prefixed override applied to the basic tier only", config.Tiers)
+	}
+	if hosts := config.Webhooks.AllowedHosts; len(hosts) != 2 || hosts[1] != ".partner.example.org" {
+		t.Errorf("got allowed hosts %q, want the comma-separated list", hosts)
+	}
+	if config.PostgresConfig.Host != "postgres" {
+		t.Errorf("got host %q, want the YAML value", config.PostgresConfig.Host)
+	}
+}
+
+func TestParseReportsAllProblems(t *testing.T) {
+	data := strings.Replace(sample, "code_length: 8", "code_length: 4\n  expires: 5", 1)
+	_, err := Parse([]byte(data), env(map[string]string{
+		"PAYMENT_PORT":                         "http",
+		"PAYMENT_GRPC_PORT":                    "-1",
+		"PAYMENT_TOKEN_FILE":                   filepath.Join(t.TempDir(), "missing"),
+		"PAYMENT_TRACING_EXPORTER":             "jaeger",
+		"PAYMENT_POSTGRES_PORT":                "70000",
+		"PAYMENT_DISCOUNT_QUEUE_SIZE":          "-1",
+		"PAYMENT_LIMITS_DAILY_DEPOSIT":         "-5",
+		"PAYMENT_EVENTS_PUBLISHER":             "webhook",
+		"PAYMENT_EVENTS_WEBHOOK_URL":           "ftp://example.com",
+		"PAYMENT_WEBHOOKS_MAX_BACKOFF":         "1s",
+		"PAYMENT_WEBHOOKS_BACKOFF":             "1m",
+		"PAYMENT_WEBHOOKS_ALLOWED_HOSTS":       "https://hooks.example.com",
+		"PAYMENT_PHONE_DEFAULT_REGION":         "XX",
+		"PAYMENT_OTP_SENDER":                   "file",
+		"PAYMENT_OTP_CODE_LENGTH":              "3",
+		"PAYMENT_NOTIFICATIONS_DEFAULT_LOCALE": "de",
+	}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"field expires not found",
+		"PAYMENT_PORT: \"http\" is not an integer",
+		"grpc_port: -1",
+		"PAYMENT_TOKEN_FILE",
+		"token: is required",
+		"discount.code_length: 4",
+		"discount.queue_size: -1",
+		"postgres.POSTGRES_PORT: \"70000\"",
+		"tracing.exporter: \"jaeger\"",
+		"limits.daily_deposit: -5",
+		"events.webhook_url: \"ftp://example.com\"",
+		"webhooks.max_backoff: must not be shorter",
+		"webhooks.allowed_hosts: \"https://hooks.example.com\"",
+		"phone.default_region: \"XX\"",
+		"otp.file: is required",
+		"otp.code_length: 3",
+		"notifications.default_locale: \"de\"",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestAdminTokenMustDifferFromToken(t *testing.T) {
+	_, err := Parse([]byte(sample), env(map[string]string{"PAYMENT_TOKEN": "token", "PAYMENT_ADMIN_TOKEN": "token"}))
+	if err == nil || !strings.Contains(err.Error(), "admin_token: must differ from token") {
+		t.Fatalf("got %v, want the shared token rejected", err)
+	}
+}
+
+func TestSampleConfigIsValid(t *testing.T) {
+	data, err := os.ReadFile("../../configs/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Parse(data, env(map[string]string{"PAYMENT_TOKEN": "token", "PAYMENT_POSTGRES_PASSWORD": "password"})); err != nil {
+		t.Fatal(err)
+	}
+}
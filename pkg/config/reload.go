@@ -0,0 +1,179 @@
+package config
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reloader re-reads the configuration file on SIGHUP or when the file changes, and passes
+// every valid configuration to the registered listeners. An invalid file is rejected and
+// the previous configuration stays in place.
+//
+// Settings that are only read at startup, such as the server port, the database connection,
+// tracing, retention and the worker queue size, are kept at their current values; a change to them is
+// logged as requiring a restart.
+type Reloader struct {
+	path      string
+	logger    *log.Logger
+	current   *Value[Config]
+	mu        sync.Mutex
+	modTime   time.Time
+	listeners []func(*Config)
+}
+
+// NewReloader creates a Reloader for the file at path, starting from initial.
+func NewReloader(path string, initial *Config, logger *log.Logger) *Reloader {
+	r := &Reloader{path: path, logger: logger, current: NewValue(initial)}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to be called with every configuration that is applied.
+// Listeners must be registered before Watch is started.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload reads the file and applies it when it is valid.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := LoadConfig(r.path)
+	if err != nil {
+		r.logger.WithError(err).Error("configuration reload rejected, keeping the current configuration")
+		return err
+	}
+
+	previous := r.current.Load()
+	if ignored := keepStructural(previous, next); len(ignored) > 0 {
+		r.logger.WithField("settings", ignored).Warn("configuration changes that require a restart were not applied")
+	}
+	r.current.Store(next)
+	for _, listener := range r.listeners {
+		listener(next)
+	}
+	r.logger.WithField("changed", changed(previous, next)).Info("configuration reloaded")
+	return nil
+}
+
+// Watch reloads the configuration on SIGHUP, and when the modification time of the file
+// changes, checked every interval. It returns when ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.logger.Info("SIGHUP received, reloading configuration")
+			_ = r.Reload()
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+			r.modTime = info.ModTime()
+			r.logger.WithField("path", r.path).Info("configuration file changed, reloading")
+			_ = r.Reload()
+		}
+	}
+}
+
+// keepStructural copies the settings that cannot change at runtime from previous into next,
+// and returns the names of those that differed.
+func keepStructural(previous, next *Config) []string {
+	var ignored []string
+	if next.ServerPort != previous.ServerPort {
+		ignored = append(ignored, "port")
+		next.ServerPort = previous.ServerPort
+	}
+	if next.GRPCPort != previous.GRPCPort {
+		ignored = append(ignored, "grpc_port")
+		next.GRPCPort = previous.GRPCPort
+	}
+	if next.PostgresConfig != previous.PostgresConfig {
+		ignored = append(ignored, "postgres")
+		next.PostgresConfig = previous.PostgresConfig
+	}
+	if next.TracingConfig != previous.TracingConfig {
+		ignored = append(ignored, "tracing")
+		next.TracingConfig = previous.TracingConfig
+	}
+	if next.Retention != previous.Retention {
+		ignored = append(ignored, "retention")
+		next.Retention = previous.Retention
+	}
+	if next.Events != previous.Events {
+		ignored = append(ignored, "events")
+		next.Events = previous.Events
+	}
+	if !reflect.DeepEqual(next.Webhooks, previous.Webhooks) {
+		ignored = append(ignored, "webhooks")
+		next.Webhooks = previous.Webhooks
+	}
+	if next.Phone != previous.Phone {
+		ignored = append(ignored, "phone")
+		next.Phone = previous.Phone
+	}
+	if next.OTP.Sender != previous.OTP.Sender || next.OTP.File != previous.OTP.File {
+		ignored = append(ignored, "otp.sender")
+		next.OTP.Sender, next.OTP.File = previous.OTP.Sender, previous.OTP.File
+	}
+	if next.Notifications != previous.Notifications {
+		ignored = append(ignored, "notifications")
+		next.Notifications = previous.Notifications
+	}
+	if next.DiscountConfig.QueueSize != previous.DiscountConfig.QueueSize {
+		ignored = append(ignored, "discount.queue_size")
+		next.DiscountConfig.QueueSize = previous.DiscountConfig.QueueSize
+	}
+	return ignored
+}
+
+// changed returns the names of the runtime settings that differ. Values are not
+// returned, so that the token does not end up in the logs.
+func changed(previous, next *Config) []string {
+	var names []string
+	if next.Token != previous.Token {
+		names = append(names, "token")
+	}
+	if next.AdminToken != previous.AdminToken {
+		names = append(names, "admin_token")
+	}
+	if next.DiscountConfig.ExpireTime != previous.DiscountConfig.ExpireTime {
+		names = append(names, "discount.expire_time")
+	}
+	if next.DiscountConfig.CodeLength != previous.DiscountConfig.CodeLength {
+		names = append(names, "discount.code_length")
+	}
+	if next.Limits != previous.Limits {
+		names = append(names, "limits")
+	}
+	if next.Tiers != previous.Tiers {
+		names = append(names, "tiers")
+	}
+	if next.OTP != previous.OTP {
+		names = append(names, "otp")
+	}
+	return names
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloaderAppliesValidChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(sample + "token: old\n")
+	initial, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	reloader := NewReloader(path, initial, logger)
+	var applied *Config
+	reloader.OnReload(func(c *Config) { applied = c })
+
+	write(strings.Replace(sample, "port: 8080", "port: 9090", 1) + "token: new\n")
+	if err = reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if applied == nil || applied.Token != "new" {
+		t.Fatalf("got %+v, want the new token applied", applied)
+	}
+	if applied.ServerPort != 8080 {
+		t.Errorf("got port %d, want the startup port kept", applied.ServerPort)
+	}
+
+	write(strings.Replace(sample, "code_length: 8", "code_length: 2", 1) + "token: newer\n")
+	if err = reloader.Reload(); err == nil {
+		t.Fatal("expected the invalid file to be rejected")
+	}
+	if got := reloader.Current().Token; got != "new" {
+		t.Errorf("got token %q after a rejected reload, want the previous one", got)
+	}
+}
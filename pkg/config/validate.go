@@ -0,0 +1,274 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"payment/pkg/phone"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinCodeLength keeps generated discount codes hard to guess.
+const MinCodeLength = 6
+
+// Bounds of the length of one-time codes: shorter ones are too easy to guess, longer ones
+// too hard to type.
+const (
+	MinOTPLength = 4
+	MaxOTPLength = 10
+)
+
+// validate returns every problem found in config rather than stopping at the first one.
+func (c *Config) validate() []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.ServerPort < 1 || c.ServerPort > 65535 {
+		problem("port: %d is not between 1 and 65535", c.ServerPort)
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		problem("grpc_port: %d is not between 0 and 65535", c.GRPCPort)
+	} else if c.GRPCPort != 0 && c.GRPCPort == c.ServerPort {
+		problem("grpc_port: %d is already used by port", c.GRPCPort)
+	}
+	if c.Token == "" {
+		problem("token: is required, set it with PAYMENT_TOKEN or PAYMENT_TOKEN_FILE")
+	}
+	if c.AdminToken != "" && c.AdminToken == c.Token {
+		problem("admin_token: must differ from token")
+	}
+
+	if c.DiscountConfig.ExpireTime < 1 {
+		problem("discount.expire_time: %d must be at least 1 minute", c.DiscountConfig.ExpireTime)
+	}
+	if c.DiscountConfig.CodeLength < MinCodeLength {
+		problem("discount.code_length: %d must be at least %d", c.DiscountConfig.CodeLength, MinCodeLength)
+	}
+	if c.DiscountConfig.QueueSize < 0 {
+		problem("discount.queue_size: %d must not be negative", c.DiscountConfig.QueueSize)
+	}
+
+	problems = append(problems, c.PostgresConfig.validate()...)
+
+	if region := c.Phone.DefaultRegion; region != "" && !phone.Supported(region) {
+		problem("phone.default_region: %q is not a supported region", region)
+	}
+
+	switch c.OTP.Sender {
+	case "", "console":
+	case "file":
+		if c.OTP.File == "" {
+			problem("otp.file: is required by the file sender")
+		}
+	default:
+		problem("otp.sender: %q must be one of console or file", c.OTP.Sender)
+	}
+	if c.OTP.CodeLength != 0 && (c.OTP.CodeLength < MinOTPLength || c.OTP.CodeLength > MaxOTPLength) {
+		problem("otp.code_length: %d is not between %d and %d", c.OTP.CodeLength, MinOTPLength, MaxOTPLength)
+	}
+	if c.OTP.MaxAttempts < 0 {
+		problem("otp.max_attempts: %d must not be negative", c.OTP.MaxAttempts)
+	}
+	if c.OTP.ClientLimit < 0 {
+		problem("otp.client_limit: %d must not be negative", c.OTP.ClientLimit)
+	}
+	if c.OTP.GlobalLimit < 0 {
+		problem("otp.global_limit: %d must not be negative", c.OTP.GlobalLimit)
+	}
+	for _, field := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"otp.code_ttl", c.OTP.CodeTTL},
+		{"otp.resend_cooldown", c.OTP.ResendCooldown},
+		{"otp.token_ttl", c.OTP.TokenTTL},
+		{"otp.lockout", c.OTP.Lockout},
+		{"otp.limit_window", c.OTP.LimitWindow},
+	} {
+		if field.value < 0 {
+			problem("%s: must not be negative", field.key)
+		}
+	}
+
+	if c.Retention.AnonymizeAfter < 0 {
+		problem("retention.anonymize_after: must not be negative")
+	}
+	if c.Retention.Interval < 0 {
+		problem("retention.interval: must not be negative")
+	}
+
+	switch c.Events.Publisher {
+	case "", "none", "log":
+	case "file":
+		if c.Events.File == "" {
+			problem("events.file: is required by the file publisher")
+		}
+	case "webhook":
+		if u, err := url.Parse(c.Events.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("events.webhook_url: %q is not an http or https URL", c.Events.WebhookURL)
+		}
+	default:
+		problem("events.publisher: %q must be one of none, log, file or webhook", c.Events.Publisher)
+	}
+	if c.Events.Interval < 0 {
+		problem("events.interval: must not be negative")
+	}
+	if c.Events.BatchSize < 0 {
+		problem("events.batch_size: %d must not be negative", c.Events.BatchSize)
+	}
+	if c.Events.KeepPublished < 0 {
+		problem("events.keep_published: must not be negative")
+	}
+	if c.Webhooks.MaxAttempts < 0 {
+		problem("webhooks.max_attempts: %d must not be negative", c.Webhooks.MaxAttempts)
+	}
+	if c.Webhooks.Backoff < 0 {
+		problem("webhooks.backoff: must not be negative")
+	}
+	if c.Webhooks.MaxBackoff < 0 {
+		problem("webhooks.max_backoff: must not be negative")
+	} else if c.Webhooks.MaxBackoff > 0 && c.Webhooks.MaxBackoff < c.Webhooks.Backoff {
+		problem("webhooks.max_backoff: must not be shorter than webhooks.backoff")
+	}
+	if c.Webhooks.Timeout < 0 {
+		problem("webhooks.timeout: must not be negative")
+	}
+	if c.Webhooks.Interval < 0 {
+		problem("webhooks.interval: must not be negative")
+	}
+	for _, host := range c.Webhooks.AllowedHosts {
+		if host == "" || strings.ContainsAny(host, ":/@ ") {
+			problem("webhooks.allowed_hosts: %q is not a host name", host)
+		}
+	}
+
+	if c.Notifications.WebhookURL != "" {
+		if u, err := url.Parse(c.Notifications.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("notifications.webhook_url: %q is not an http or https URL", c.Notifications.WebhookURL)
+		}
+	}
+	switch c.Notifications.DefaultLocale {
+	case "", "en", "fa":
+	default:
+		problem("notifications.default_locale: %q must be en or fa", c.Notifications.DefaultLocale)
+	}
+	if c.Notifications.MaxAttempts < 0 {
+		problem("notifications.max_attempts: %d must not be negative", c.Notifications.MaxAttempts)
+	}
+	if c.Notifications.Backoff < 0 {
+		problem("notifications.backoff: must not be negative")
+	}
+	if c.Notifications.Interval < 0 {
+		problem("notifications.interval: must not be negative")
+	}
+
+	problems = append(problems, c.Limits.validate("limits")...)
+	problems = append(problems, c.Tiers.Unverified.validate("tiers.unverified")...)
+	problems = append(problems, c.Tiers.Basic.validate("tiers.basic")...)
+	problems = append(problems, c.Tiers.Full.validate("tiers.full")...)
+
+	switch c.TracingConfig.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		problem("tracing.exporter: %q must be one of none, stdout or otlp", c.TracingConfig.Exporter)
+	}
+	if c.TracingConfig.SampleRatio < 0 || c.TracingConfig.SampleRatio > 1 {
+		problem("tracing.sample_ratio: %v is not between 0 and 1", c.TracingConfig.SampleRatio)
+	}
+	return problems
+}
+
+// SSL modes accepted by Postgres clients.
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func (c *PostgresConfig) validate() []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			problem("postgres.POSTGRES_URL: is not a postgres:// URL")
+		}
+	} else {
+		for _, field := range []struct{ key, value string }{
+			{"postgres.POSTGRES_HOST", c.Host},
+			{"postgres.POSTGRES_USER", c.User},
+			{"postgres.POSTGRES_DB", c.Database},
+		} {
+			if field.value == "" {
+				problem("%s: is required unless POSTGRES_URL is set", field.key)
+			}
+		}
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			problem("postgres.POSTGRES_PORT: %q is not a port between 1 and 65535", c.Port)
+		}
+	}
+
+	if c.SSLMode != "" && !slices.Contains(sslModes, c.SSLMode) {
+		problem("postgres.POSTGRES_SSLMODE: %q must be one of %s", c.SSLMode, strings.Join(sslModes, ", "))
+	}
+	if (c.SSLCert == "") != (c.SSLKey == "") {
+		problem("postgres.POSTGRES_SSLCERT and POSTGRES_SSLKEY: must be set together")
+	}
+	for _, file := range []struct{ key, path string }{
+		{"postgres.POSTGRES_SSLROOTCERT", c.SSLRootCert},
+		{"postgres.POSTGRES_SSLCERT", c.SSLCert},
+		{"postgres.POSTGRES_SSLKEY", c.SSLKey},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			problem("%s: %v", file.key, err)
+		}
+	}
+
+	for _, field := range []struct {
+		key   string
+		value int64
+	}{
+		{"postgres.MAX_OPEN_CONNS", int64(c.MaxOpenConns)},
+		{"postgres.MAX_IDLE_CONNS", int64(c.MaxIdleConns)},
+		{"postgres.STATEMENT_TIMEOUT", int64(c.StatementTimeout)},
+		{"postgres.CONN_MAX_LIFETIME", int64(c.ConnMaxLifetime)},
+		{"postgres.CONN_MAX_IDLE_TIME", int64(c.ConnMaxIdleTime)},
+		{"postgres.CONNECT_TIMEOUT", int64(c.ConnectTimeout)},
+	} {
+		if field.value < 0 {
+			problem("%s: must not be negative", field.key)
+		}
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		problem("postgres.MAX_IDLE_CONNS: %d exceeds MAX_OPEN_CONNS %d", c.MaxIdleConns, c.MaxOpenConns)
+	}
+	return problems
+}
+
+// validate reports negative limits, naming them under section.
+func (c *LimitsConfig) validate(section string) []error {
+	var problems []error
+	for _, limit := range []struct {
+		name  string
+		value int64
+	}{
+		{"single_withdrawal", c.SingleWithdrawal},
+		{"daily_withdrawal", c.DailyWithdrawal},
+		{"monthly_withdrawal", c.MonthlyWithdrawal},
+		{"daily_deposit", c.DailyDeposit},
+		{"monthly_deposit", c.MonthlyDeposit},
+		{"max_balance", c.MaxBalance},
+		{"hourly_transactions", c.HourlyTransactions},
+	} {
+		if limit.value < 0 {
+			problems = append(problems, fmt.Errorf("%s.%s: %d must not be negative", section, limit.name, limit.value))
+		}
+	}
+	return problems
+}
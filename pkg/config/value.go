@@ -0,0 +1,27 @@
+package config
+
+import "sync/atomic"
+
+// Value holds a configuration that may be replaced while it is in use. Readers always
+// see a complete configuration, either the old or the new one, never a mix of both.
+// The stored configuration must not be modified; Store a new one instead.
+type Value[T any] struct {
+	current atomic.Pointer[T]
+}
+
+// NewValue returns a Value holding initial.
+func NewValue[T any](initial *T) *Value[T] {
+	v := &Value[T]{}
+	v.current.Store(initial)
+	return v
+}
+
+// Load returns the current configuration.
+func (v *Value[T]) Load() *T {
+	return v.current.Load()
+}
+
+// Store publishes config to every subsequent Load.
+func (v *Value[T]) Store(config *T) {
+	v.current.Store(config)
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing waits between retries, with jitter so that
+// several instances restarting together do not retry in lockstep.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Next returns the wait before retry number attempt, starting at 1. It is drawn between
+// half and all of Initial*2^(attempt-1), capped at Max.
+func (b Backoff) Next(attempt int) time.Duration {
+	wait := b.Max
+	if attempt < 32 {
+		if d := b.Initial << (attempt - 1); d > 0 && d < b.Max {
+			wait = d
+		}
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
@@ -1,70 +1,160 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"net/url"
 	"payment/pkg/config"
+	"payment/pkg/tracing"
 	"strings"
+	"time"
 )
 
 type DB struct {
 	*gorm.DB
 }
 
+// Defaults used when the configuration leaves the corresponding setting empty.
+const (
+	DefaultSSLMode         = "disable"
+	DefaultApplicationName = "payment"
+	DefaultConnectTimeout  = time.Minute
+)
+
 // LoadDSN constructs a Data Source Name (DSN) string for connecting to the database
-// using the provided configuration settings.
+// using the provided configuration settings. When POSTGRES_URL is set it is used as the
+// base and the TLS and session settings are added to it unless the URL already has them.
 func LoadDSN(config *config.Config) string {
-	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=%s",
-		config.PostgresConfig.Host,
-		config.PostgresConfig.User,
-		config.PostgresConfig.Password,
-		config.PostgresConfig.Database,
-		config.PostgresConfig.Port,
-		config.PostgresConfig.Timezone)
+	c := config.PostgresConfig
+	sslMode, applicationName := c.SSLMode, c.ApplicationName
+	if sslMode == "" && c.URL == "" {
+		// A URL without sslmode keeps the driver default instead.
+		sslMode = DefaultSSLMode
+	}
+	if applicationName == "" {
+		applicationName = DefaultApplicationName
+	}
+	params := [][2]string{
+		{"sslmode", sslMode},
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+		{"application_name", applicationName},
+		{"TimeZone", c.Timezone},
+	}
+	if c.StatementTimeout > 0 {
+		params = append(params, [2]string{"statement_timeout", fmt.Sprint(c.StatementTimeout.Milliseconds())})
+	}
+
+	if c.URL != "" {
+		return withURLParams(c.URL, params)
+	}
 
+	dsn := []string{
+		"host=" + quote(c.Host),
+		"user=" + quote(c.User),
+		"password=" + quote(c.Password),
+		"dbname=" + quote(c.Database),
+		"port=" + quote(c.Port),
+	}
+	for _, param := range params {
+		if param[1] != "" {
+			dsn = append(dsn, param[0]+"="+quote(param[1]))
+		}
+	}
+	return strings.Join(dsn, " ")
+}
+
+// quote escapes a value of a key/value DSN, so that passwords may contain spaces and quotes.
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// withURLParams adds the params that are set to the query of rawURL, keeping those it already has.
+func withURLParams(rawURL string, params [][2]string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for _, param := range params {
+		if param[1] != "" && !query.Has(param[0]) {
+			query.Set(param[0], param[1])
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
 }
 
 // New initializes a new database connection using the provided DSN string.
 // It returns a pointer to a DB instance or an error if the connection fails.
 func New(dsn string) (*DB, error) {
 	dialector := postgres.Open(dsn)
-	db, err := gorm.Open(dialector, &gorm.Config{FullSaveAssociations: false})
+	db, err := gorm.Open(dialector, &gorm.Config{FullSaveAssociations: false, TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
+	if err = db.Use(tracing.GormPlugin{}); err != nil {
+		return nil, err
+	}
 	return &DB{db}, nil
 }
 
-// AutoMigrate runs the GORM AutoMigrate function for the provided models,
-// ensuring that the database schema matches the model definitions.
-func AutoMigrate(db *DB, model ...interface{}) error {
-	err := db.AutoMigrate(model...)
-	if err != nil {
-		return err
+// Connect opens the database described by config and configures its connection pool.
+// While the database is unavailable, e.g. because it is still starting, it retries with
+// exponential backoff until CONNECT_TIMEOUT has passed or ctx is done.
+func Connect(ctx context.Context, config *config.Config, logger *log.Logger) (*DB, error) {
+	timeout := config.PostgresConfig.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dsn := LoadDSN(config)
+	backoff := Backoff{Initial: 500 * time.Millisecond, Max: 10 * time.Second}
+	for attempt := 1; ; attempt++ {
+		database, err := New(dsn)
+		if err == nil {
+			return database, database.configurePool(config.PostgresConfig)
+		}
+
+		wait := backoff.Next(attempt)
+		logger.WithFields(log.Fields{
+			"attempt": attempt,
+			"retry":   wait.String(),
+		}).WithError(err).Warn("could not connect to database")
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not connect to database after %d attempts: %w", attempt, err)
+		case <-time.After(wait):
+		}
 	}
-	return nil
 }
 
-func CreateEnumType(db *DB, typeName string, values []string) error {
-	var exists bool
-	query := "SELECT EXISTS (SELECT 1 FROM pg_type WHERE typname = ?);"
-	err := db.Raw(query, typeName).Scan(&exists).Error
+func (db *DB) configurePool(c config.PostgresConfig) error {
+	sqlDB, err := db.DB.DB()
 	if err != nil {
 		return err
 	}
-
-	if !exists {
-		valuesList := "'" + strings.Join(values, "', '") + "'"
-		createTypeQuery := fmt.Sprintf("CREATE TYPE %s AS ENUM (%s);", typeName, valuesList)
-		if err := db.Exec(createTypeQuery).Error; err != nil {
-			return err
-		}
-		log.Printf("type %s created with values: %v", typeName, values)
-		return nil
+	if c.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
 	}
-
-	log.Printf("type %s already exists", typeName)
 	return nil
 }
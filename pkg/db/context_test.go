@@ -0,0 +1,52 @@
+package db
+
+import (
+	"payment/pkg/config"
+	"testing"
+	"time"
+)
+
+func TestLoadDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		postgres config.PostgresConfig
+		want     string
+	}{
+		{
+			name:     "fields with defaults",
+			postgres: config.PostgresConfig{Host: "db", User: "app", Password: "p@ss word", Database: "payment", Port: "5432"},
+			want:     `host=db user=app password='p@ss word' dbname=payment port=5432 sslmode=disable application_name=payment`,
+		},
+		{
+			name: "tls and session settings",
+			postgres: config.PostgresConfig{Host: "db", User: "app", Password: "it's", Database: "payment", Port: "5432",
+				SSLMode: "verify-full", SSLRootCert: "/certs/ca.pem", ApplicationName: "payment-api",
+				StatementTimeout: 5 * time.Second, Timezone: "UTC"},
+			want: `host=db user=app password='it\'s' dbname=payment port=5432 sslmode=verify-full sslrootcert=/certs/ca.pem application_name=payment-api TimeZone=UTC statement_timeout=5000`,
+		},
+		{
+			name: "url keeps its own parameters",
+			postgres: config.PostgresConfig{URL: "postgres://app:secret@db:5432/payment?sslmode=require",
+				SSLMode: "disable", StatementTimeout: time.Second},
+			want: `postgres://app:secret@db:5432/payment?application_name=payment&sslmode=require&statement_timeout=1000`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LoadDSN(&config.Config{PostgresConfig: tt.postgres}); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second, 100: time.Second} {
+		for i := 0; i < 20; i++ {
+			if got := backoff.Next(attempt); got < max/2 || got > max {
+				t.Fatalf("attempt %d: got %v, want between %v and %v", attempt, got, max/2, max)
+			}
+		}
+	}
+}
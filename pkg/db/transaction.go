@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// Transactor runs a function inside a single atomic unit of work.
+// Repositories called with the context passed to fn take part in the same transaction.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type txKey struct{}
+
+// WithinTransaction runs fn inside a database transaction that is committed when fn
+// returns nil and rolled back otherwise. Nested calls join the outer transaction.
+func (db *DB) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	inner, done := Deferred(ctx)
+	defer done()
+	return db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(inner, txKey{}, tx))
+	})
+}
+
+// Conn returns the transaction bound to ctx by WithinTransaction, or the
+// connection pool when ctx is not part of a transaction.
+func (db *DB) Conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.DB.WithContext(ctx)
+}
+
+type deferredKey struct{}
+
+// deferred holds the functions passed to AfterTransaction within one transaction.
+type deferred struct {
+	mu  sync.Mutex
+	fns []func(ctx context.Context)
+}
+
+// AfterTransaction runs fn once the outermost transaction of ctx has ended, whether it was
+// committed or rolled back, or right away when ctx is not part of a transaction. fn gets a
+// context outside of the transaction, so that what it writes survives a rollback; it is how a
+// nested unit of work keeps a trace of its failure when the transaction it joined is undone.
+func AfterTransaction(ctx context.Context, fn func(ctx context.Context)) {
+	if d, ok := ctx.Value(deferredKey{}).(*deferred); ok {
+		d.mu.Lock()
+		d.fns = append(d.fns, fn)
+		d.mu.Unlock()
+		return
+	}
+	fn(ctx)
+}
+
+// Deferred is meant for Transactor implementations. It returns the context to start a transaction
+// with, which collects the functions passed to AfterTransaction, and done, which runs them with ctx.
+// done must be called once the transaction has ended and no longer holds any lock.
+func Deferred(ctx context.Context) (inner context.Context, done func()) {
+	d := &deferred{}
+	return context.WithValue(ctx, deferredKey{}, d), func() {
+		d.mu.Lock()
+		fns := d.fns
+		d.fns = nil
+		d.mu.Unlock()
+		for _, fn := range fns {
+			fn(ctx)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is a stable, machine-readable identifier of a failure.
+// Clients should match on the code instead of the human-readable message.
+type Code string
+
+const (
+	CodeBadRequest     Code = "BAD_REQUEST"
+	CodeValidation     Code = "VALIDATION_FAILED"
+	CodeUnauthorized   Code = "UNAUTHORIZED"
+	CodeNotFound       Code = "NOT_FOUND"
+	CodeInternal       Code = "INTERNAL_ERROR"
+	CodeTimeout        Code = "TIMEOUT"
+	CodeInvalidPhone   Code = "INVALID_PHONE"
+	CodeMissingParam   Code = "MISSING_PARAMETER"
+	CodeInvalidPayload Code = "INVALID_PAYLOAD"
+	CodeTooLarge       Code = "PAYLOAD_TOO_LARGE"
+
+	CodeWalletNotFound         Code = "WALLET_NOT_FOUND"
+	CodeWalletExists           Code = "WALLET_ALREADY_EXISTS"
+	CodeInsufficientFunds      Code = "INSUFFICIENT_FUNDS"
+	CodeInvalidTransactionType Code = "INVALID_TRANSACTION_TYPE"
+	CodeTransactionFailed      Code = "TRANSACTION_FAILED"
+	CodeWalletFrozen           Code = "WALLET_FROZEN"
+	CodeWalletSuspended        Code = "WALLET_SUSPENDED"
+	CodeWalletClosed           Code = "WALLET_CLOSED"
+	CodeInvalidWalletStatus    Code = "INVALID_WALLET_STATUS"
+	CodeWalletNotEmpty         Code = "WALLET_NOT_EMPTY"
+	CodeLimitExceeded          Code = "LIMIT_EXCEEDED"
+	CodeTierNotAllowed         Code = "TIER_NOT_ALLOWED"
+
+	CodeDiscountNotFound     Code = "DISCOUNT_NOT_FOUND"
+	CodeDiscountExpired      Code = "DISCOUNT_EXPIRED"
+	CodeDiscountNotActive    Code = "DISCOUNT_NOT_ACTIVE"
+	CodeDiscountLimitReached Code = "DISCOUNT_USAGE_LIMIT_REACHED"
+	CodeDiscountAlreadyUsed  Code = "DISCOUNT_ALREADY_USED"
+	CodeInvalidDiscountType  Code = "INVALID_DISCOUNT_TYPE"
+
+	CodeOTPCooldown       Code = "OTP_COOLDOWN"
+	CodeOTPInvalid        Code = "OTP_INVALID"
+	CodeOTPAttempts       Code = "OTP_ATTEMPTS_EXCEEDED"
+	CodeOTPRateLimited    Code = "OTP_RATE_LIMITED"
+	CodeOwnershipRequired Code = "PHONE_OWNERSHIP_REQUIRED"
+)
+
+// Domain errors returned by the services. Handlers map them to HTTP responses with Respond.
+var (
+	ErrBadRequest   = NewError(CodeBadRequest, http.StatusBadRequest, "bad request")
+	ErrUnauthorized = NewError(CodeUnauthorized, http.StatusUnauthorized, "unauthorized")
+	ErrNotFound     = NewError(CodeNotFound, http.StatusNotFound, "resource not found")
+	ErrInternal     = NewError(CodeInternal, http.StatusInternalServerError, "internal server error")
+	ErrTimeout      = NewError(CodeTimeout, http.StatusGatewayTimeout, "request timed out")
+	ErrInvalidPhone = NewError(CodeInvalidPhone, http.StatusBadRequest, "invalid phone")
+	ErrInvalidBody  = NewError(CodeInvalidPayload, http.StatusBadRequest, "invalid request body")
+	ErrBodyTooLarge = NewError(CodeTooLarge, http.StatusRequestEntityTooLarge, "request body too large")
+
+	ErrWalletNotFound         = NewError(CodeWalletNotFound, http.StatusNotFound, "wallet not found")
+	ErrWalletExists           = NewError(CodeWalletExists, http.StatusConflict, "wallet already exist")
+	ErrInsufficientFunds      = NewError(CodeInsufficientFunds, http.StatusUnprocessableEntity, "insufficient funds")
+	ErrInvalidTransactionType = NewError(CodeInvalidTransactionType, http.StatusBadRequest, "unknown transaction type")
+	ErrTransactionFailed      = NewError(CodeTransactionFailed, http.StatusInternalServerError, "transaction failed")
+	ErrWalletFrozen           = NewError(CodeWalletFrozen, http.StatusLocked, "wallet is frozen, only deposits are allowed")
+	ErrWalletSuspended        = NewError(CodeWalletSuspended, http.StatusLocked, "wallet is suspended")
+	ErrWalletClosed           = NewError(CodeWalletClosed, http.StatusGone, "wallet is closed")
+	ErrInvalidWalletStatus    = NewError(CodeInvalidWalletStatus, http.StatusConflict, "invalid wallet status change")
+	ErrWalletNotEmpty         = NewError(CodeWalletNotEmpty, http.StatusConflict, "wallet balance is not zero")
+	ErrLimitExceeded          = NewError(CodeLimitExceeded, http.StatusUnprocessableEntity, "transaction limit exceeded")
+	ErrTierNotAllowed         = NewError(CodeTierNotAllowed, http.StatusForbidden, "operation not allowed for the wallet tier")
+
+	ErrDiscountNotFound     = NewError(CodeDiscountNotFound, http.StatusNotFound, "discount not found")
+	ErrDiscountExpired      = NewError(CodeDiscountExpired, http.StatusGone, "discount expired")
+	ErrDiscountNotActive    = NewError(CodeDiscountNotActive, http.StatusUnprocessableEntity, "invalid discount")
+	ErrDiscountLimitReached = NewError(CodeDiscountLimitReached, http.StatusConflict, "usage limit exceed")
+	ErrDiscountAlreadyUsed  = NewError(CodeDiscountAlreadyUsed, http.StatusConflict, "discount code used before")
+	ErrInvalidDiscountType  = NewError(CodeInvalidDiscountType, http.StatusBadRequest, "discount type is invalid")
+
+	ErrOTPCooldown       = NewError(CodeOTPCooldown, http.StatusTooManyRequests, "a code was sent recently, wait before asking again")
+	ErrOTPInvalid        = NewError(CodeOTPInvalid, http.StatusUnauthorized, "code is invalid or expired")
+	ErrOTPAttempts       = NewError(CodeOTPAttempts, http.StatusTooManyRequests, "too many wrong codes, ask for a new one")
+	ErrOTPRateLimited    = NewError(CodeOTPRateLimited, http.StatusTooManyRequests, "too many codes were asked for, try again later")
+	ErrOwnershipRequired = NewError(CodeOwnershipRequired, http.StatusForbidden, "proof of phone ownership is required")
+)
+
+// DomainError is a typed error carrying a stable Code and the HTTP status it maps to.
+// Two DomainErrors match with errors.Is when their codes are equal, so a sentinel
+// still matches after WithMessage, WithDetails or Wrap.
+type DomainError struct {
+	Code    Code
+	Status  int
+	Message string
+	Details []FieldError
+	Data    interface{}
+	cause   error
+}
+
+// NewError creates a DomainError with the given code, HTTP status and message.
+func NewError(code Code, status int, message string) *DomainError {
+	return &DomainError{Code: code, Status: status, Message: message}
+}
+
+func (e *DomainError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying cause, if any.
+func (e *DomainError) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is a DomainError with the same code.
+func (e *DomainError) Is(target error) bool {
+	t, ok := target.(*DomainError)
+	return ok && t.Code == e.Code
+}
+
+// WithMessage returns a copy of the error with a more specific message.
+func (e *DomainError) WithMessage(format string, args ...interface{}) *DomainError {
+	c := *e
+	c.Message = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// WithDetails returns a copy of the error carrying per-field details.
+func (e *DomainError) WithDetails(details ...FieldError) *DomainError {
+	c := *e
+	c.Details = append(append([]FieldError(nil), e.Details...), details...)
+	return &c
+}
+
+// WithData returns a copy of the error carrying structured data for the client,
+// such as the limit that was exceeded.
+func (e *DomainError) WithData(data interface{}) *DomainError {
+	c := *e
+	c.Data = data
+	return &c
+}
+
+// Wrap returns a copy of the error with cause attached. The cause is logged
+// but never sent to the client.
+func (e *DomainError) Wrap(cause error) *DomainError {
+	c := *e
+	c.cause = cause
+	return &c
+}
+
+// Is reports whether any error in err's tree matches target. It mirrors the standard library.
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As finds the first error in err's tree that matches target. It mirrors the standard library.
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
+
+// FromError converts any error into a DomainError. Errors that are not
+// DomainErrors are reported as internal errors with the original error as cause.
+func FromError(err error) *DomainError {
+	var de *DomainError
+	if stderrors.As(err, &de) {
+		return de
+	}
+	return ErrInternal.Wrap(err)
+}
@@ -2,16 +2,23 @@ package errors
 
 import (
 	"encoding/json"
+	stderrors "errors"
 	"net/http"
 )
 
 type ErrorMessage struct {
-	Message    string `json:"message"`
-	StatusCode int    `json:"status"`
+	Code       Code         `json:"code"`
+	Message    string       `json:"message"`
+	StatusCode int          `json:"status"`
+	Details    []FieldError `json:"details,omitempty"`
+	Data       interface{}  `json:"data,omitempty"`
 }
 
 func Error(w http.ResponseWriter, statusCode int, args ...interface{}) {
 	message := http.StatusText(statusCode) // default message
+	code := codeForStatus(statusCode)
+	var details []FieldError
+	var data interface{}
 
 	// determine if an error or string arg was passed in
 	// set the message accordingly
@@ -20,16 +27,61 @@ func Error(w http.ResponseWriter, statusCode int, args ...interface{}) {
 		case string:
 			message = v
 		case error:
-			message = v.Error()
+			var de *DomainError
+			if stderrors.As(v, &de) {
+				message, code, details, data = de.Message, de.Code, de.Details, de.Data
+			} else {
+				message = v.Error()
+			}
 		}
 	}
 
-	errString, _ := json.Marshal(ErrorMessage{
+	write(w, ErrorMessage{
+		Code:       code,
 		Message:    message,
 		StatusCode: statusCode,
+		Details:    details,
+		Data:       data,
 	})
+}
+
+// Respond writes err to the client using the status and code of its DomainError.
+// Errors that are not DomainErrors are reported as a generic internal error
+// so that no implementation details leak to the client.
+func Respond(w http.ResponseWriter, err error) {
+	de := FromError(err)
+	write(w, ErrorMessage{
+		Code:       de.Code,
+		Message:    de.Message,
+		StatusCode: de.Status,
+		Details:    de.Details,
+		Data:       de.Data,
+	})
+}
+
+func write(w http.ResponseWriter, message ErrorMessage) {
+	errString, _ := json.Marshal(message)
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(statusCode)
+	w.WriteHeader(message.StatusCode)
 	w.Write(errString)
 }
+
+// codeForStatus returns the generic code used when only a status is known.
+func codeForStatus(statusCode int) Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	default:
+		if statusCode >= http.StatusInternalServerError {
+			return CodeInternal
+		}
+		return CodeBadRequest
+	}
+}
@@ -0,0 +1,61 @@
+package errors
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"net/http"
+)
+
+// Domain is the domain of the ErrorInfo attached to gRPC errors.
+const Domain = "payment"
+
+// GRPCStatus is the gRPC counterpart of Respond: it converts err into a status whose code
+// follows the HTTP status of its DomainError. The error code is attached as the reason of
+// an ErrorInfo detail, and field errors as a BadRequest detail.
+func GRPCStatus(err error) *status.Status {
+	de := FromError(err)
+	st := status.New(grpcCode(de), de.Message)
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: string(de.Code), Domain: Domain}}
+	if len(de.Details) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(de.Details))
+		for _, detail := range de.Details {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: detail.Field, Description: detail.Message})
+		}
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+func grpcCode(de *DomainError) codes.Code {
+	switch de.Status {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		if de.Code == CodeWalletExists {
+			return codes.AlreadyExists
+		}
+		return codes.FailedPrecondition
+	case http.StatusGone, http.StatusLocked, http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
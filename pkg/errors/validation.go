@@ -0,0 +1,55 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+)
+
+// ErrValidation is returned when a request payload does not pass validation.
+var ErrValidation = NewError(CodeValidation, http.StatusBadRequest, "validation failed")
+
+// FieldError describes why a single field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Validation converts the error returned by validator.Struct into an ErrValidation
+// carrying one FieldError per failed field. Other errors are returned as a plain ErrValidation.
+func Validation(err error) *DomainError {
+	var validationErrors validator.ValidationErrors
+	if !stderrors.As(err, &validationErrors) {
+		return ErrValidation.Wrap(err)
+	}
+
+	details := make([]FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		details = append(details, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(fe),
+		})
+	}
+	return ErrValidation.WithDetails(details...)
+}
+
+// fieldMessage renders a short human-readable explanation for a failed rule.
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "description":
+		return fmt.Sprintf("%s may only contain letters, digits, spaces and common punctuation", fe.Field())
+	case "gt", "gte", "lt", "lte", "min", "max":
+		return fmt.Sprintf("%s must be %s %s", fe.Field(), fe.Tag(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of [%s]", fe.Field(), fe.Param())
+	default:
+		return fmt.Sprintf("%s failed the %s rule", fe.Field(), fe.Tag())
+	}
+}
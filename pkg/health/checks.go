@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"payment/pkg/db"
+	"payment/pkg/migrations"
+)
+
+// Database checks that the database answers a ping.
+func Database(database *db.DB) Check {
+	return func(ctx context.Context) (string, error) {
+		sqlDB, err := database.DB.DB()
+		if err != nil {
+			return "", err
+		}
+		if err = sqlDB.PingContext(ctx); err != nil {
+			return "", err
+		}
+		stats := sqlDB.Stats()
+		return fmt.Sprintf("%d/%d connections in use", stats.InUse, stats.OpenConnections), nil
+	}
+}
+
+// Migrations checks that every embedded migration has been applied, so that the
+// service does not take traffic against an outdated schema.
+func Migrations(migrator *migrations.Migrator) Check {
+	return func(ctx context.Context) (string, error) {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return "", err
+		}
+		detail := fmt.Sprintf("latest version %d", migrator.Latest())
+		if len(pending) > 0 {
+			return detail, fmt.Errorf("%d pending migrations, first is %d", len(pending), pending[0].Version)
+		}
+		return detail, nil
+	}
+}
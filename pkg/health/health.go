@@ -0,0 +1,133 @@
+// Package health serves the liveness, readiness and build information endpoints
+// used by docker-compose and Kubernetes probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"payment/pkg/openapi"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is ready. detail describes the state that was
+// observed, e.g. the queue length, and is reported whether the check passed or not.
+type Check func(ctx context.Context) (detail string, err error)
+
+// Statuses reported by the endpoints.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Result is the outcome of a single readiness check.
+type Result struct {
+	Status     string  `json:"status"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report is the body of the readiness endpoint.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Health runs the registered readiness checks.
+type Health struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+// New creates a Health whose checks are cancelled after timeout.
+func New(timeout time.Duration) *Health {
+	return &Health{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Add registers a readiness check under name.
+func (h *Health) Add(name string, check Check) {
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+		sort.Strings(h.names)
+	}
+	h.checks[name] = check
+}
+
+// Ready runs every check concurrently and reports the service as ready when all of them pass.
+func (h *Health) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(h.names))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range h.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := check(ctx)
+
+			result := Result{Status: StatusOK, Detail: detail, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = StatusUnavailable
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusUnavailable
+			}
+		}(name, h.checks[name])
+	}
+	wg.Wait()
+	return report
+}
+
+// RegisterRoutes adds the probe endpoints to router. None of them requires authentication.
+func (h *Health) RegisterRoutes(router *mux.Router, info BuildInfo) {
+	openapi.Describe(router.HandleFunc("/healthz", h.liveHandler).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Report that the process is up",
+		Tag:       "meta",
+		Responses: map[int]interface{}{http.StatusOK: map[string]string{}},
+	})
+	openapi.Describe(router.HandleFunc("/readyz", h.readyHandler).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Report whether every dependency is ready",
+		Tag:       "meta",
+		Responses: map[int]interface{}{http.StatusOK: Report{}, http.StatusServiceUnavailable: Report{}},
+	})
+	openapi.Describe(router.HandleFunc("/version", info.handler).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Report the build of the service",
+		Tag:       "meta",
+		Responses: map[int]interface{}{http.StatusOK: info},
+	})
+}
+
+// liveHandler reports that the process is up and serving requests. It checks no dependencies,
+// so that a database outage does not get the service restarted.
+func (h *Health) liveHandler(w http.ResponseWriter, _ *http.Request) {
+	write(w, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+func (h *Health) readyHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	write(w, status, report)
+}
+
+func write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, h *Health, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := mux.NewRouter()
+	h.RegisterRoutes(router, NewBuildInfo("Payment Service", "1.0.0", "abc123"))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func TestReadyReportsEveryCheck(t *testing.T) {
+	h := New(time.Second)
+	h.Add("database", func(context.Context) (string, error) { return "1/2 connections in use", nil })
+	h.Add("worker", func(context.Context) (string, error) {
+		return "100/100 redemptions queued", fmt.Errorf("discount worker queue is saturated")
+	})
+
+	recorder := serve(t, h, "/readyz")
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want 503", recorder.Code)
+	}
+	var report Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != StatusUnavailable {
+		t.Errorf("got status %q, want %q", report.Status, StatusUnavailable)
+	}
+	if got := report.Checks["database"]; got.Status != StatusOK || got.Detail != "1/2 connections in use" {
+		t.Errorf("got database check %+v", got)
+	}
+	if got := report.Checks["worker"]; got.Status != StatusUnavailable || got.Error != "discount worker queue is saturated" {
+		t.Errorf("got worker check %+v", got)
+	}
+}
+
+func TestReadyTimesOutSlowChecks(t *testing.T) {
+	h := New(10 * time.Millisecond)
+	h.Add("database", func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+
+	if report := h.Ready(context.Background()); report.Status != StatusUnavailable {
+		t.Fatalf("got status %q, want %q", report.Status, StatusUnavailable)
+	}
+}
+
+func TestLiveAndVersion(t *testing.T) {
+	h := New(time.Second)
+	h.Add("database", func(context.Context) (string, error) { return "", fmt.Errorf("down") })
+
+	if recorder := serve(t, h, "/healthz"); recorder.Code != http.StatusOK {
+		t.Errorf("got liveness status %d, want 200 regardless of dependencies", recorder.Code)
+	}
+
+	var info BuildInfo
+	if err := json.NewDecoder(serve(t, h, "/version").Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" || info.Build != "abc123" || info.GoVersion == "" {
+		t.Errorf("got build info %+v", info)
+	}
+}
@@ -0,0 +1,30 @@
+package health
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Build     string `json:"build"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// NewBuildInfo completes the given program info with the Go runtime it was built with.
+func NewBuildInfo(name, version, build string) BuildInfo {
+	return BuildInfo{
+		Name:      name,
+		Version:   version,
+		Build:     build,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (b BuildInfo) handler(w http.ResponseWriter, _ *http.Request) {
+	write(w, http.StatusOK, b)
+}
@@ -0,0 +1,108 @@
+// Package logging correlates log lines with the request that produced them.
+// The request ID and the trace ID travel in the context, and FromContext adds
+// them to every entry logged through it.
+package logging
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+type contextKey struct{}
+
+// request holds what is known about the current request. It is stored by pointer,
+// so that inner middlewares such as authentication can fill in the key ID for the
+// access log written by an outer one.
+type request struct {
+	id       string
+	keyID    string
+	clientIP string
+	operator string
+}
+
+// NewContext returns a copy of ctx that carries requestID.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &request{id: requestID})
+}
+
+func fromContext(ctx context.Context) *request {
+	req, _ := ctx.Value(contextKey{}).(*request)
+	return req
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	if req := fromContext(ctx); req != nil {
+		return req.id
+	}
+	return ""
+}
+
+// SetKeyID records the ID of the API key that authenticated the request of ctx.
+func SetKeyID(ctx context.Context, keyID string) {
+	if req := fromContext(ctx); req != nil {
+		req.keyID = keyID
+	}
+}
+
+// KeyID returns the ID of the API key that authenticated the request of ctx, or an empty string.
+func KeyID(ctx context.Context) string {
+	if req := fromContext(ctx); req != nil {
+		return req.keyID
+	}
+	return ""
+}
+
+// SetClientIP records the address of the client that sent the request of ctx.
+func SetClientIP(ctx context.Context, ip string) {
+	if req := fromContext(ctx); req != nil {
+		req.clientIP = ip
+	}
+}
+
+// ClientIP returns the address of the client that sent the request of ctx, or an empty string.
+func ClientIP(ctx context.Context) string {
+	if req := fromContext(ctx); req != nil {
+		return req.clientIP
+	}
+	return ""
+}
+
+// SetOperator records the name of the operator who runs the command of ctx from the admin CLI.
+func SetOperator(ctx context.Context, name string) {
+	if req := fromContext(ctx); req != nil {
+		req.operator = name
+	}
+}
+
+// Operator returns the name of the operator who runs the command of ctx, or an empty string.
+func Operator(ctx context.Context) string {
+	if req := fromContext(ctx); req != nil {
+		return req.operator
+	}
+	return ""
+}
+
+// FromContext returns an entry of logger that includes the request ID and trace ID of ctx.
+func FromContext(ctx context.Context, logger *log.Logger) *log.Entry {
+	fields := log.Fields{}
+	if id := RequestID(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		fields["trace_id"] = span.TraceID().String()
+	}
+	return logger.WithContext(ctx).WithFields(fields)
+}
+
+// MaskPhone hides the subscriber number of phone, keeping the first four and the
+// last two digits, e.g. 989121234567 becomes 9891******67.
+func MaskPhone(phone string) string {
+	const prefix, suffix = 4, 2
+	if len(phone) <= prefix+suffix {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:prefix] + strings.Repeat("*", len(phone)-prefix-suffix) + phone[len(phone)-suffix:]
+}
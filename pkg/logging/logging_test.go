@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMaskPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"989121234567", "9891******67"},
+		{"+989121234567", "+989*******67"},
+		{"12345", "*****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := MaskPhone(tt.phone); got != tt.want {
+			t.Errorf("MaskPhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestKeyIDIsVisibleToOuterContext(t *testing.T) {
+	ctx := NewContext(context.Background(), "req-1")
+	inner := context.WithValue(ctx, struct{}{}, "inner")
+
+	SetKeyID(inner, "key-1")
+	SetClientIP(inner, "192.0.2.1")
+	if got := KeyID(ctx); got != "key-1" {
+		t.Fatalf("got key ID %q, want key-1", got)
+	}
+	if got := ClientIP(ctx); got != "192.0.2.1" {
+		t.Fatalf("got client IP %q, want 192.0.2.1", got)
+	}
+	if got := RequestID(inner); got != "req-1" {
+		t.Fatalf("got request ID %q, want req-1", got)
+	}
+}
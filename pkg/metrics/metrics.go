@@ -0,0 +1,174 @@
+// Package metrics exposes the service metrics in the Prometheus text format.
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"payment/pkg/db"
+	"payment/pkg/openapi"
+	"time"
+)
+
+const namespace = "payment"
+
+// Registry holds every collector of the service. It is separate from the global
+// Prometheus registry so that tests can gather it without side effects.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of gRPC calls by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	walletTransactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "transactions_total",
+		Help:      "Number of wallet deposits and withdrawals by type and result.",
+	}, []string{"type", "result"})
+
+	walletAmount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "transaction_amount_total",
+		Help:      "Sum of the amounts of completed wallet deposits and withdrawals by type.",
+	}, []string{"type"})
+
+	discountRedemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "discount",
+		Name:      "redemptions_total",
+		Help:      "Number of discount redemptions by outcome.",
+	}, []string{"outcome"})
+
+	workerQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "queue_depth",
+		Help:      "Number of redemptions waiting for the discount worker.",
+	})
+
+	workerDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "worker",
+		Name:      "processing_duration_seconds",
+		Help:      "Time the discount worker spends allocating a redemption.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// Transaction results reported by ObserveTransaction.
+const (
+	ResultCompleted = "completed"
+	ResultRejected  = "rejected"
+	ResultFailed    = "failed"
+)
+
+// Redemption outcomes reported by ObserveRedemption.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeExpired  = "expired"
+	OutcomeLimit    = "limit"
+	OutcomeUsed     = "used"
+	OutcomeNotFound = "not_found"
+	OutcomeTimeout  = "timeout"
+	OutcomeBlocked  = "wallet_blocked"
+	OutcomeError    = "error"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		grpcRequests,
+		grpcDuration,
+		walletTransactions,
+		walletAmount,
+		discountRedemptions,
+		workerQueueDepth,
+		workerDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterRoutes serves the metrics on /metrics.
+func RegisterRoutes(router *mux.Router) {
+	openapi.Describe(router.Handle("/metrics", Handler()).Methods(http.MethodGet), openapi.Operation{
+		Summary:   "Service metrics in the Prometheus text format",
+		Tag:       "meta",
+		Responses: map[int]interface{}{http.StatusOK: "metrics"},
+	})
+}
+
+// RegisterDB exports the connection pool statistics of database.
+func RegisterDB(database *db.DB) error {
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	return Registry.Register(collectors.NewDBStatsCollector(sqlDB, "payment"))
+}
+
+// ObserveTransaction records a wallet transaction attempt. The amount is only
+// added for completed transactions.
+func ObserveTransaction(transactionType, result string, amount int64) {
+	walletTransactions.WithLabelValues(transactionType, result).Inc()
+	if result == ResultCompleted {
+		walletAmount.WithLabelValues(transactionType).Add(float64(amount))
+	}
+}
+
+// ObserveRedemption records the outcome of a discount redemption.
+func ObserveRedemption(outcome string) {
+	discountRedemptions.WithLabelValues(outcome).Inc()
+}
+
+// WorkerEnqueued records a redemption waiting for the worker.
+func WorkerEnqueued() {
+	workerQueueDepth.Inc()
+}
+
+// WorkerDequeued records that the worker picked up a redemption.
+func WorkerDequeued() {
+	workerQueueDepth.Dec()
+}
+
+// ObserveWorker records how long the worker spent on a redemption.
+func ObserveWorker(duration time.Duration) {
+	workerDuration.Observe(duration.Seconds())
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"payment/pkg/middleware"
+	"strconv"
+	"time"
+)
+
+// Middleware records the count and latency of every request served by next,
+// labelled by the matching route template of router instead of the raw path.
+func Middleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := middleware.NewStatusRecorder(w)
+
+			next.ServeHTTP(recorder, r)
+
+			labels := []string{r.Method, middleware.RouteTemplate(router, r), strconv.Itoa(recorder.Status())}
+			httpRequests.WithLabelValues(labels...).Inc()
+			httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// UnaryInterceptor is the gRPC counterpart of Middleware: it records the count and latency of
+// every call, labelled by its full method name and status code.
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	return middleware.ObserveUnary(ctx, req, handler, func(code codes.Code) {
+		labels := []string{info.FullMethod, code.String()}
+		grpcRequests.WithLabelValues(labels...).Inc()
+		grpcDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"payment/pkg/middleware"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareLabelsByRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/wallet/{phoneNumber}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}).Methods(http.MethodDelete)
+
+	handler := Middleware(router)(router)
+	for _, path := range []string{"/wallet/989121234567", "/wallet/989127654321", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, path, nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodDelete, "/wallet/{phoneNumber}", "202")); got != 2 {
+		t.Errorf("got %v requests for the wallet route, want 2", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodDelete, middleware.UnmatchedRoute, "404")); got != 1 {
+		t.Errorf("got %v unmatched requests, want 1", got)
+	}
+}
+
+func TestUnaryInterceptorCountsCallsByCode(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/payment.WalletService/GetWallet"}
+	for _, err := range []error{nil, status.Error(codes.NotFound, "no wallet"), status.Error(codes.NotFound, "no wallet")} {
+		_, _ = UnaryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	if got := testutil.ToFloat64(grpcRequests.WithLabelValues(info.FullMethod, codes.NotFound.String())); got != 2 {
+		t.Errorf("got %v failed calls, want 2", got)
+	}
+	if got := testutil.ToFloat64(grpcRequests.WithLabelValues(info.FullMethod, codes.OK.String())); got != 1 {
+		t.Errorf("got %v successful calls, want 1", got)
+	}
+}
+
+func TestHandlerExposesTextFormat(t *testing.T) {
+	ObserveTransaction("deposit", ResultCompleted, 250)
+	ObserveRedemption(OutcomeLimit)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`payment_wallet_transaction_amount_total{type="deposit"} 250`,
+		`payment_discount_redemptions_total{outcome="limit"} 1`,
+		`payment_worker_queue_depth 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"payment/pkg/logging"
+	"time"
+)
+
+// AccessLog writes one line per request with the method, route template, status,
+// latency and the ID of the API key that authenticated it. Phone numbers taken
+// from the path or the query string are masked.
+func AccessLog(logger *log.Logger, router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := NewStatusRecorder(w)
+
+			next.ServeHTTP(recorder, r)
+
+			fields := log.Fields{
+				"type":       "access",
+				"method":     r.Method,
+				"route":      RouteTemplate(router, r),
+				"status":     recorder.Status(),
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes":      recorder.BytesWritten(),
+			}
+			if keyID := logging.KeyID(r.Context()); keyID != "" {
+				fields["key_id"] = keyID
+			}
+			if phone := requestPhone(router, r); phone != "" {
+				fields["phone"] = logging.MaskPhone(phone)
+			}
+			logging.FromContext(r.Context(), logger).WithFields(fields).Info("request served")
+		})
+	}
+}
+
+// requestPhone returns the phone number addressed by r, either as the phoneNumber
+// path variable or the phone query parameter.
+func requestPhone(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) {
+		if phone := match.Vars["phoneNumber"]; phone != "" {
+			return phone
+		}
+	}
+	return r.URL.Query().Get("phone")
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"payment/pkg/logging"
+	"testing"
+)
+
+func TestRequestIDIsPropagatedOrAssigned(t *testing.T) {
+	var seen, ip string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, ip = logging.RequestID(r.Context()), logging.ClientIP(r.Context())
+	}))
+
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"bad id\n", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(RequestIDHeader, tt.header)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		got := recorder.Header().Get(RequestIDHeader)
+		if got == "" || got != seen {
+			t.Errorf("header %q: got response ID %q and context ID %q, want the same non-empty ID", tt.header, got, seen)
+		}
+		if tt.keep != (got == tt.header) {
+			t.Errorf("header %q: got ID %q, want it kept: %v", tt.header, got, tt.keep)
+		}
+		if ip != "192.0.2.1" {
+			t.Errorf("got client IP %q, want 192.0.2.1", ip)
+		}
+	}
+}
+
+func TestAccessLogMasksPhoneNumbers(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	router := mux.NewRouter()
+	router.HandleFunc("/wallet/{phoneNumber}", func(w http.ResponseWriter, r *http.Request) {
+		logging.SetKeyID(r.Context(), "key-1")
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/wallet/989121234567", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	RequestID(AccessLog(logger, router)(router)).ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("no access log written")
+	}
+	want := logrus.Fields{
+		"route":      "/wallet/{phoneNumber}",
+		"status":     http.StatusNotFound,
+		"phone":      "9891******67",
+		"key_id":     "key-1",
+		"request_id": "req-1",
+	}
+	for key, value := range want {
+		if entry.Data[key] != value {
+			t.Errorf("got %s=%v, want %v", key, entry.Data[key], value)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// Deprecated marks the responses of routes that moved under prefix with a Deprecation header
+// and a Link to the path that replaces them, so that clients notice before the old paths go away.
+func Deprecated(prefix string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+prefix+r.URL.Path+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
+	"runtime/debug"
+	"time"
+)
+
+// requestIDMetadata is the metadata key that carries the request ID of gRPC calls.
+const requestIDMetadata = "x-request-id"
+
+// UnaryRequestID is the gRPC counterpart of RequestID. It propagates the x-request-id metadata
+// of the call, or assigns a new ID, stores it with the address of the client in the context and
+// returns it in the response header.
+func UnaryRequestID(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := ""
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDMetadata); len(values) > 0 {
+		id = values[0]
+	}
+	if !requestIDPattern.MatchString(id) {
+		id = uuid.NewString()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+	ctx = logging.NewContext(ctx, id)
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			logging.SetClientIP(ctx, host)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// UnaryAccessLog is the gRPC counterpart of AccessLog: it writes one line per call with the
+// method, status code, latency and the ID of the API key that authenticated it.
+func UnaryAccessLog(logger *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		return ObserveUnary(ctx, req, handler, func(code codes.Code) {
+			fields := log.Fields{
+				"type":       "access",
+				"method":     info.FullMethod,
+				"status":     code.String(),
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if keyID := logging.KeyID(ctx); keyID != "" {
+				fields["key_id"] = keyID
+			}
+			logging.FromContext(ctx, logger).WithFields(fields).Info("call served")
+		})
+	}
+}
+
+// ObserveUnary calls handler and then done with the status code of the call. When handler
+// panics, done gets codes.Internal, which is what UnaryRecover answers, before the panic goes
+// on to UnaryRecover; calls that panic are thus logged, counted and traced like the others.
+func ObserveUnary(ctx context.Context, req interface{}, handler grpc.UnaryHandler, done func(code codes.Code)) (interface{}, error) {
+	returned := false
+	defer func() {
+		if !returned {
+			done(codes.Internal)
+		}
+	}()
+	resp, err := handler(ctx, req)
+	returned = true
+	done(status.Code(err))
+	return resp, err
+}
+
+// UnaryRecover is the gRPC counterpart of utils.RecoverHandler: a panic is logged and the call
+// fails with an internal error. It comes first in the chain, so that a panic in any other
+// interceptor is recovered too.
+func UnaryRecover(logger *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logging.FromContext(ctx, logger).WithField("stack", string(debug.Stack())).Errorf("panic: %+v", r)
+				err = errors.GRPCStatus(errors.ErrInternal).Err()
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
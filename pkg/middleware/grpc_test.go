@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestPanickingCallIsRecoveredAndLogged(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	info := &grpc.UnaryServerInfo{FullMethod: "/payment.WalletService/GetWallet"}
+	accessLog := UnaryAccessLog(logger)
+
+	_, err := UnaryRecover(logger)(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return accessLog(ctx, req, info, func(context.Context, interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got %v, want an internal error", err)
+	}
+
+	var logged bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["type"] == "access" {
+			logged = entry.Data["status"] == codes.Internal.String() && entry.Data["method"] == info.FullMethod
+		}
+	}
+	if !logged {
+		t.Fatalf("got %v, want the panicking call in the access log as %s", hook.AllEntries(), codes.Internal)
+	}
+}
@@ -3,17 +3,21 @@ package middleware
 import (
 	"net/http"
 	"payment/pkg/errors"
-	"payment/pkg/utils"
+	"payment/pkg/phone"
 )
 
-// PhoneValidatorMiddleware is the middleware function to validate phone numbers
+// PhoneValidatorMiddleware rejects requests whose phone query parameter is not a mobile number,
+// and replaces it with its E.164 form for the handlers that follow.
 func PhoneValidatorMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		phone := r.URL.Query().Get("phone")
-		if !utils.CellphoneValidator(phone) {
-			errors.Error(w, http.StatusBadRequest, "Invalid phone number")
+		query := r.URL.Query()
+		number, err := phone.Normalize(query.Get("phone"))
+		if err != nil {
+			errors.Respond(w, err)
 			return
 		}
+		query.Set("phone", number)
+		r.URL.RawQuery = query.Encode()
 		next.ServeHTTP(w, r)
 	})
 }
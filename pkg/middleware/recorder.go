@@ -0,0 +1,42 @@
+package middleware
+
+import "net/http"
+
+// StatusRecorder wraps a http.ResponseWriter and remembers the status code and
+// the number of bytes written, so that middlewares can report on the response.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// NewStatusRecorder wraps w. The status defaults to 200 when the handler never calls WriteHeader.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Status returns the status code sent to the client.
+func (r *StatusRecorder) Status() int {
+	return r.status
+}
+
+// BytesWritten returns the size of the response body.
+func (r *StatusRecorder) BytesWritten() int {
+	return r.bytes
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/google/uuid"
+	"net"
+	"net/http"
+	"payment/pkg/logging"
+	"regexp"
+)
+
+// RequestIDHeader carries the ID that correlates a request with its log lines.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits client supplied IDs to something that is safe to log.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID propagates the X-Request-ID header of the request, or assigns a new one when it is
+// missing or malformed. The ID is stored in the request context and returned in the response,
+// together with the address of the client for the audit log.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := logging.NewContext(r.Context(), id)
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			logging.SetClientIP(ctx, host)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// UnmatchedRoute names requests that did not match any registered route, so that
+// scanners cannot create an unbounded number of metric labels or span names.
+const UnmatchedRoute = "unmatched"
+
+// RouteTemplate returns the path template of the route matching r,
+// e.g. /wallet/{phoneNumber} instead of the raw path.
+func RouteTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		return UnmatchedRoute
+	}
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return UnmatchedRoute
+	}
+	return template
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import "testing"
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, migration := range migrations {
+		if want := int64(i + 1); migration.Version != want {
+			t.Errorf("migration %d_%s: got version %d, want %d", migration.Version, migration.Name, migration.Version, want)
+		}
+	}
+}
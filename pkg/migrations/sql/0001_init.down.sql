@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS discount_transactions;
+DROP TABLE IF EXISTS discounts;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS wallets;
+
+DROP TYPE IF EXISTS transaction_status;
+DROP TYPE IF EXISTS transaction_type;
+DROP TYPE IF EXISTS discount_type;
@@ -0,0 +1,59 @@
+-- Baseline schema. Every statement is idempotent so databases created by the
+-- former AutoMigrate tool can adopt versioned migrations without changes.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'discount_type') THEN
+        CREATE TYPE discount_type AS ENUM ('voucher', 'charge');
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'transaction_type') THEN
+        CREATE TYPE transaction_type AS ENUM ('withdrawal', 'deposit');
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'transaction_status') THEN
+        CREATE TYPE transaction_status AS ENUM ('pending', 'completed', 'failed');
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS wallets
+(
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ,
+    phone      VARCHAR(20) UNIQUE,
+    amount     INTEGER DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_wallets_deleted_at ON wallets (deleted_at);
+
+CREATE TABLE IF NOT EXISTS transactions
+(
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at  TIMESTAMPTZ NOT NULL,
+    wallet_id   UUID NOT NULL REFERENCES wallets (id) ON DELETE CASCADE,
+    type        transaction_type NOT NULL,
+    amount      BIGINT,
+    status      transaction_status NOT NULL,
+    description TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_id ON transactions (wallet_id);
+
+CREATE TABLE IF NOT EXISTS discounts
+(
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at      TIMESTAMPTZ NOT NULL,
+    code            TEXT NOT NULL UNIQUE,
+    description     VARCHAR(255) NOT NULL,
+    amount          INTEGER NOT NULL DEFAULT 0,
+    usage_limit     BIGINT,
+    expiration_time TIMESTAMPTZ NOT NULL,
+    type            discount_type NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS discount_transactions
+(
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at  TIMESTAMPTZ NOT NULL,
+    discount_id UUID NOT NULL REFERENCES discounts (id),
+    wallet_id   UUID NOT NULL,
+    phone_num   TEXT
+);
@@ -0,0 +1,6 @@
+CREATE TYPE wallet_status AS ENUM ('active', 'frozen', 'suspended', 'closed');
+
+ALTER TABLE wallets
+    ADD COLUMN status            wallet_status NOT NULL DEFAULT 'active',
+    ADD COLUMN status_reason     TEXT          NOT NULL DEFAULT '',
+    ADD COLUMN status_changed_at TIMESTAMPTZ;
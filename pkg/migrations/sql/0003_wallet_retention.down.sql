@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_wallets_retention;
+
+ALTER TABLE wallets
+    DROP COLUMN IF EXISTS anonymized_at;
+
+ALTER TABLE transactions
+    DROP CONSTRAINT IF EXISTS fk_transactions_wallet;
+
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_wallet_id_fkey FOREIGN KEY (wallet_id) REFERENCES wallets (id) ON DELETE CASCADE;
@@ -0,0 +1,24 @@
+-- Wallets are closed instead of deleted, so their ledger must never be removed with them.
+DO $$
+DECLARE
+    name TEXT;
+BEGIN
+    FOR name IN
+        SELECT conname FROM pg_constraint
+        WHERE conrelid = 'transactions'::regclass
+          AND confrelid = 'wallets'::regclass
+          AND contype = 'f'
+    LOOP
+        EXECUTE format('ALTER TABLE transactions DROP CONSTRAINT %I', name);
+    END LOOP;
+END
+$$;
+
+ALTER TABLE transactions
+    ADD CONSTRAINT fk_transactions_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id) ON DELETE RESTRICT;
+
+ALTER TABLE wallets
+    ADD COLUMN anonymized_at TIMESTAMPTZ;
+
+CREATE INDEX idx_wallets_retention ON wallets (status_changed_at)
+    WHERE status = 'closed' AND anonymized_at IS NULL;
@@ -0,0 +1,16 @@
+-- Per-wallet overrides of the configured transaction limits. NULL falls back to the global limit.
+CREATE TABLE wallet_limits
+(
+    wallet_id           UUID PRIMARY KEY REFERENCES wallets (id) ON DELETE RESTRICT,
+    single_withdrawal   BIGINT CHECK (single_withdrawal >= 0),
+    daily_withdrawal    BIGINT CHECK (daily_withdrawal >= 0),
+    monthly_withdrawal  BIGINT CHECK (monthly_withdrawal >= 0),
+    daily_deposit       BIGINT CHECK (daily_deposit >= 0),
+    monthly_deposit     BIGINT CHECK (monthly_deposit >= 0),
+    max_balance         BIGINT CHECK (max_balance >= 0),
+    hourly_transactions BIGINT CHECK (hourly_transactions >= 0),
+    updated_at          TIMESTAMPTZ NOT NULL
+);
+
+-- Limits sum the recent transactions of a wallet on every balance change.
+CREATE INDEX idx_transactions_wallet_created_at ON transactions (wallet_id, created_at);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS wallet_tier_changes;
+
+ALTER TABLE wallets
+    DROP COLUMN IF EXISTS tier;
+
+DROP TYPE IF EXISTS wallet_tier;
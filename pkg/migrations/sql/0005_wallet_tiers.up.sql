@@ -0,0 +1,20 @@
+CREATE TYPE wallet_tier AS ENUM ('unverified', 'basic', 'full');
+
+-- Wallets created before tiers existed keep their capabilities as basic wallets;
+-- new wallets start unverified.
+ALTER TABLE wallets
+    ADD COLUMN tier wallet_tier NOT NULL DEFAULT 'basic';
+ALTER TABLE wallets
+    ALTER COLUMN tier SET DEFAULT 'unverified';
+
+CREATE TABLE wallet_tier_changes
+(
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL,
+    wallet_id  UUID NOT NULL REFERENCES wallets (id) ON DELETE RESTRICT,
+    from_tier  wallet_tier NOT NULL,
+    to_tier    wallet_tier NOT NULL,
+    reason     TEXT NOT NULL,
+    actor      TEXT NOT NULL
+);
+CREATE INDEX idx_wallet_tier_changes_wallet_id ON wallet_tier_changes (wallet_id, created_at);
@@ -0,0 +1,34 @@
+CREATE TABLE audit_log
+(
+    sequence    BIGINT PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL,
+    actor       TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id   TEXT NOT NULL,
+    -- json rather than jsonb keeps the values byte for byte, as they were hashed.
+    before      JSON,
+    after       JSON,
+    request_id  TEXT NOT NULL,
+    ip          TEXT NOT NULL,
+    prev_hash   TEXT NOT NULL,
+    hash        TEXT NOT NULL
+);
+CREATE INDEX idx_audit_log_entity ON audit_log (entity_type, entity_id, sequence);
+CREATE INDEX idx_audit_log_actor ON audit_log (actor, sequence);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+
+-- The audit log is append-only: rows can be inserted but never changed or removed.
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_delete
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
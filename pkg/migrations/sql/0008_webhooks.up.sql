@@ -0,0 +1,32 @@
+CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'succeeded', 'dead');
+
+CREATE TABLE webhook_subscriptions
+(
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at  TIMESTAMPTZ NOT NULL,
+    url         TEXT NOT NULL,
+    event_types JSONB NOT NULL,
+    secret      TEXT NOT NULL,
+    active      BOOLEAN NOT NULL DEFAULT TRUE
+);
+CREATE INDEX idx_webhook_subscriptions_event_types ON webhook_subscriptions USING GIN (event_types) WHERE active;
+
+CREATE TABLE webhook_deliveries
+(
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at       TIMESTAMPTZ NOT NULL,
+    subscription_id  UUID NOT NULL REFERENCES webhook_subscriptions (id),
+    event_id         UUID NOT NULL,
+    event_type       TEXT NOT NULL,
+    body             JSON NOT NULL,
+    status           webhook_delivery_status NOT NULL DEFAULT 'pending',
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ NOT NULL,
+    last_attempt_at  TIMESTAMPTZ,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT NOT NULL DEFAULT '',
+    delivered_at     TIMESTAMPTZ,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at);
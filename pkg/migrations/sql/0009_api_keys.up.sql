@@ -0,0 +1,9 @@
+CREATE TABLE api_keys
+(
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL,
+    name       TEXT NOT NULL,
+    key_id     TEXT NOT NULL UNIQUE,
+    hash       TEXT NOT NULL UNIQUE,
+    revoked_at TIMESTAMPTZ
+);
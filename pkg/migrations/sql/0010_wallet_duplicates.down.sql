@@ -0,0 +1,2 @@
+ALTER TABLE wallets
+    DROP COLUMN IF EXISTS duplicate_of;
@@ -0,0 +1,4 @@
+-- Phone numbers are stored in E.164 form. Wallets registered before under another form of a
+-- number that already has a wallet point at the wallet that kept the number.
+ALTER TABLE wallets
+    ADD COLUMN duplicate_of UUID REFERENCES wallets (id) ON DELETE RESTRICT;
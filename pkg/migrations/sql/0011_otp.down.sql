@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ownership_tokens;
+DROP TABLE IF EXISTS otp_codes;
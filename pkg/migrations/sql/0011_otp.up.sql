@@ -0,0 +1,22 @@
+-- One-time codes sent to phone numbers, and the tokens that prove a code was verified.
+-- Only hashes are stored.
+CREATE TABLE otp_codes
+(
+    phone      VARCHAR(20) PRIMARY KEY,
+    hash       TEXT        NOT NULL,
+    salt       TEXT        NOT NULL,
+    attempts   INT         NOT NULL DEFAULT 0,
+    sent_at    TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_otp_codes_expires_at ON otp_codes (expires_at);
+
+CREATE TABLE ownership_tokens
+(
+    hash       TEXT PRIMARY KEY,
+    phone      VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_ownership_tokens_expires_at ON ownership_tokens (expires_at);
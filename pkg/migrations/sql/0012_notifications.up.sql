@@ -0,0 +1,33 @@
+-- Messages sent to wallet owners about changes to their balance, and their choices about them.
+CREATE TYPE notification_status AS ENUM ('pending', 'sent', 'failed', 'skipped');
+
+CREATE TABLE notification_preferences
+(
+    wallet_id  UUID PRIMARY KEY REFERENCES wallets (id),
+    locale     TEXT        NOT NULL DEFAULT '',
+    email      TEXT        NOT NULL DEFAULT '',
+    opt_out    JSONB       NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE notifications
+(
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at      TIMESTAMPTZ NOT NULL,
+    event_id        UUID        NOT NULL,
+    event_type      TEXT        NOT NULL,
+    wallet_id       UUID        NOT NULL REFERENCES wallets (id),
+    channel         TEXT        NOT NULL,
+    locale          TEXT        NOT NULL,
+    subject         TEXT        NOT NULL DEFAULT '',
+    body            TEXT        NOT NULL,
+    status          notification_status NOT NULL DEFAULT 'pending',
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_attempt_at TIMESTAMPTZ,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    sent_at         TIMESTAMPTZ,
+    UNIQUE (event_id, wallet_id, channel)
+);
+CREATE INDEX idx_notifications_due ON notifications (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_notifications_wallet ON notifications (wallet_id, created_at);
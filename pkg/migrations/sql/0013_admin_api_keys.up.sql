@@ -0,0 +1,4 @@
+-- API keys with the admin scope are accepted on the routes that manage the service: wallet
+-- statuses, limits and tiers, webhooks and the audit log. Existing keys are client keys.
+ALTER TABLE api_keys
+    ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
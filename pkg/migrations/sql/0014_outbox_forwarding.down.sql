@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_outbox_events_unforwarded;
+ALTER TABLE outbox_events
+    DROP COLUMN IF EXISTS forward_error,
+    DROP COLUMN IF EXISTS forward_attempts,
+    DROP COLUMN IF EXISTS forwarded_at;
@@ -0,0 +1,9 @@
+-- The configured publisher has its own position in the outbox, so that a publisher that is
+-- down holds back neither the webhook deliveries nor the notifications. Events published so
+-- far went to every publisher at once.
+ALTER TABLE outbox_events
+    ADD COLUMN forwarded_at     TIMESTAMPTZ,
+    ADD COLUMN forward_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN forward_error    TEXT    NOT NULL DEFAULT '';
+UPDATE outbox_events SET forwarded_at = published_at WHERE published_at IS NOT NULL;
+CREATE INDEX idx_outbox_events_unforwarded ON outbox_events (created_at, id) WHERE forwarded_at IS NULL;
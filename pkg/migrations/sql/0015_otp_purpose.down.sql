@@ -0,0 +1,7 @@
+ALTER TABLE ownership_tokens
+    DROP COLUMN IF EXISTS purpose;
+DROP INDEX IF EXISTS idx_otp_codes_keep_until;
+CREATE INDEX IF NOT EXISTS idx_otp_codes_expires_at ON otp_codes (expires_at);
+ALTER TABLE otp_codes
+    DROP COLUMN IF EXISTS keep_until,
+    DROP COLUMN IF EXISTS purpose;
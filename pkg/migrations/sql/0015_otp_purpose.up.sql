@@ -0,0 +1,12 @@
+-- Codes and ownership tokens are bound to the operation they were requested for, and wrong
+-- codes are remembered across resends until keep_until. The codes and tokens issued so far
+-- live for minutes and have no purpose, so they are dropped.
+DELETE FROM otp_codes;
+DELETE FROM ownership_tokens;
+ALTER TABLE otp_codes
+    ADD COLUMN purpose    TEXT        NOT NULL,
+    ADD COLUMN keep_until TIMESTAMPTZ NOT NULL;
+DROP INDEX IF EXISTS idx_otp_codes_expires_at;
+CREATE INDEX idx_otp_codes_keep_until ON otp_codes (keep_until);
+ALTER TABLE ownership_tokens
+    ADD COLUMN purpose TEXT NOT NULL;
@@ -0,0 +1,4 @@
+-- Deliverers record the outcome of an attempt only while the delivery still carries the lease
+-- of their claim, so that a redelivery made during the attempt is not overwritten.
+ALTER TABLE webhook_deliveries
+    ADD COLUMN lease UUID;
@@ -0,0 +1,4 @@
+-- Events are forwarded to the configured publisher outside of a transaction. A relay leases
+-- the events it forwards, so that other relays skip them until the lease expires.
+ALTER TABLE outbox_events
+    ADD COLUMN forward_leased_until TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+-- Dispatchers claim notifications in a short transaction and send them outside of it. The
+-- outcome of an attempt is recorded only while the notification still carries their lease.
+ALTER TABLE notifications
+    ADD COLUMN lease UUID;
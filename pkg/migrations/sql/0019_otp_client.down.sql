@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_otp_codes_client_ip;
+DROP INDEX IF EXISTS idx_otp_codes_sent_at;
+ALTER TABLE otp_codes
+    DROP COLUMN IF EXISTS client_ip;
@@ -0,0 +1,6 @@
+-- Codes are counted by the client that asked for them and in total, to limit how many are
+-- sent within a window.
+ALTER TABLE otp_codes
+    ADD COLUMN client_ip TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_otp_codes_sent_at ON otp_codes (sent_at);
+CREATE INDEX idx_otp_codes_client_ip ON otp_codes (client_ip, sent_at);
@@ -0,0 +1,15 @@
+-- Changes are recorded here in the transaction that makes them, and chained into audit_log
+-- by a single sealer once they have committed, so that writers do not wait for each other.
+CREATE TABLE audit_pending
+(
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ NOT NULL,
+    actor       TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id   TEXT NOT NULL,
+    before      JSON,
+    after       JSON,
+    request_id  TEXT NOT NULL,
+    ip          TEXT NOT NULL
+);
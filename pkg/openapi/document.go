@@ -0,0 +1,276 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"payment/pkg/errors"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Info identifies the API in the document.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Document is an OpenAPI 3.0 document.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Names of the security schemes in the document: the API token and the admin token.
+const (
+	securityName      = "token"
+	adminSecurityName = "admin"
+)
+
+// Route is a method and path served by a router. Path uses the OpenAPI syntax for path parameters.
+type Route struct {
+	Method string
+	Path   string
+}
+
+// entry is a route of the router with the path and methods it serves.
+type entry struct {
+	route   *mux.Route
+	path    string
+	methods []string
+}
+
+// pathParameter matches the variables of a mux path template, with their optional pattern.
+var pathParameter = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// walk returns every route of router that serves requests. Every such route must restrict its methods.
+func walk(router *mux.Router) ([]entry, error) {
+	var entries []entry
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("route %s does not restrict its methods", template)
+		}
+		entries = append(entries, entry{route: route, path: pathParameter.ReplaceAllString(template, "{$1}"), methods: methods})
+		return nil
+	})
+	return entries, err
+}
+
+// Routes lists the method and path of every route of router.
+func Routes(router *mux.Router) ([]Route, error) {
+	entries, err := walk(router)
+	if err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, e := range entries {
+		for _, method := range e.methods {
+			routes = append(routes, Route{Method: method, Path: e.path})
+		}
+	}
+	return routes, nil
+}
+
+// Generate builds the document of the routes of router that were documented with Describe.
+// Routes that were not are left out. An unversioned route that is also served under Version
+// is marked deprecated.
+func Generate(router *mux.Router, info Info) (*Document, error) {
+	entries, err := walk(router)
+	if err != nil {
+		return nil, err
+	}
+	served := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		served[e.path] = true
+	}
+
+	s := newSchemas()
+	errorSchema := s.of(reflect.TypeOf(errors.ErrorMessage{}))
+	document := &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   map[string]map[string]*operation{},
+		Components: components{
+			Schemas: s.components,
+			SecuritySchemes: map[string]securityScheme{
+				securityName: {Type: "apiKey", In: "header", Name: "Authorization",
+					Description: "the API token or an API key"},
+				adminSecurityName: {Type: "apiKey", In: "header", Name: "Authorization",
+					Description: "the admin token or an API key with the admin scope"},
+			},
+		},
+	}
+	for _, e := range entries {
+		value, ok := operations.Load(e.route)
+		if !ok {
+			continue
+		}
+		described := value.(Operation)
+		deprecated := !strings.HasPrefix(e.path, Version+"/") && served[Version+e.path]
+		for _, method := range e.methods {
+			if document.Paths[e.path] == nil {
+				document.Paths[e.path] = map[string]*operation{}
+			}
+			document.Paths[e.path][strings.ToLower(method)] = build(s, described, e.path, deprecated, errorSchema)
+		}
+	}
+	return document, nil
+}
+
+// parameterOf turns a described parameter into one found in, either the query or the headers.
+func parameterOf(described Parameter, in string) parameter {
+	schema := &Schema{Type: described.Type, Format: described.Format, Enum: described.Enum}
+	if schema.Type == "" {
+		schema.Type = "string"
+	}
+	return parameter{Name: described.Name, In: in, Description: described.Description, Required: described.Required, Schema: schema}
+}
+
+// build turns what Describe was told into the operation of path.
+func build(s *schemas, described Operation, path string, deprecated bool, errorSchema *Schema) *operation {
+	op := &operation{
+		Summary:     described.Summary,
+		Description: described.Description,
+		Deprecated:  deprecated,
+		Responses: map[string]*response{
+			"default": {Description: "Error", Content: map[string]mediaType{"application/json": {Schema: errorSchema}}},
+		},
+	}
+	if described.Tag != "" {
+		op.Tags = []string{described.Tag}
+	}
+	switch {
+	case described.Admin:
+		op.Security = []map[string][]string{{adminSecurityName: {}}}
+	case described.Secured:
+		op.Security = []map[string][]string{{securityName: {}}}
+	}
+
+	for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, parameter{Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	for _, query := range described.Query {
+		op.Parameters = append(op.Parameters, parameterOf(query, "query"))
+	}
+	for _, header := range described.Headers {
+		op.Parameters = append(op.Parameters, parameterOf(header, "header"))
+	}
+
+	if described.Request != nil {
+		op.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{
+			"application/json": {Schema: s.of(reflect.TypeOf(described.Request))},
+		}}
+	}
+	codes := make([]int, 0, len(described.Responses))
+	for code := range described.Responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		result := &response{Description: http.StatusText(code)}
+		switch body := described.Responses[code].(type) {
+		case nil:
+		case string:
+			result.Content = map[string]mediaType{"text/plain": {Schema: &Schema{Type: "string"}}}
+		default:
+			result.Content = map[string]mediaType{"application/json": {Schema: s.of(reflect.TypeOf(body))}}
+		}
+		op.Responses[strconv.Itoa(code)] = result
+	}
+	return op
+}
+
+// Handler serves the document of router as JSON. It is generated on the first request, by
+// which time every route has been registered.
+func Handler(router *mux.Router, info Info) http.Handler {
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		once.Do(func() {
+			var document *Document
+			if document, err = Generate(router, info); err == nil {
+				data, err = json.Marshal(document)
+			}
+		})
+		if err != nil {
+			errors.Respond(w, errors.ErrInternal.WithMessage("could not generate the API document").Wrap(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	})
+}
+
+// RegisterRoutes serves the document of router on /openapi.json.
+func RegisterRoutes(router *mux.Router, info Info) {
+	Describe(router.Handle("/openapi.json", Handler(router, info)).Methods(http.MethodGet), Operation{
+		Summary:   "OpenAPI 3 document of this API",
+		Tag:       "meta",
+		Responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+	})
+}
@@ -0,0 +1,69 @@
+// Package openapi describes the HTTP API of the service. Handlers document each route where
+// they register it, with Describe, and Generate walks the router to build the OpenAPI 3
+// document, so the document cannot drift from the routes. Schemas are derived from the json
+// and validate tags of the types the operations name.
+package openapi
+
+import (
+	"github.com/gorilla/mux"
+	"payment/pkg/middleware"
+	"sync"
+)
+
+// Version prefixes every API route. The routes are still served without it, as deprecated.
+const Version = "/v1"
+
+// Registrar is a handler that registers its routes on a router.
+type Registrar interface {
+	RegisterRoutes(router *mux.Router)
+}
+
+// Mount registers the routes of handlers under Version, and at their unversioned paths
+// behind the Deprecated middleware.
+func Mount(router *mux.Router, handlers ...Registrar) {
+	versioned := router.PathPrefix(Version).Subrouter()
+	legacy := router.NewRoute().Subrouter()
+	legacy.Use(middleware.Deprecated(Version))
+	for _, handler := range handlers {
+		handler.RegisterRoutes(versioned)
+		handler.RegisterRoutes(legacy)
+	}
+}
+
+// Operation documents what a route does, what it takes and what it returns.
+type Operation struct {
+	Summary     string
+	Description string
+	Tag         string
+	// Secured operations need the API token, or an API key, in the Authorization header.
+	Secured bool
+	// Admin operations need the admin token, or an API key with the admin scope, instead.
+	Admin   bool
+	Query   []Parameter
+	Headers []Parameter
+	// Request is a value of the type of the JSON request body, or nil when there is none.
+	Request interface{}
+	// Responses maps status codes to a value of the type of the response body, which is nil
+	// when there is no body and a string when the body is plain text.
+	Responses map[int]interface{}
+}
+
+// Parameter documents a query parameter or a request header.
+type Parameter struct {
+	Name        string
+	Description string
+	// Type is a JSON schema type; string when it is empty.
+	Type     string
+	Format   string
+	Enum     []string
+	Required bool
+}
+
+// operations holds what Describe was told about each route.
+var operations sync.Map
+
+// Describe documents route with operation and returns route.
+func Describe(route *mux.Route, operation Operation) *mux.Route {
+	operations.Store(route, operation)
+	return route
+}
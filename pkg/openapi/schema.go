@@ -0,0 +1,237 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI 3.0 schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas derives schemas from Go types. Named structs become components, which are
+// referenced wherever they are used.
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// of returns the schema of t, as encoding/json would encode a value of it.
+func (s *schemas) of(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		schema := s.of(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	case rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + s.component(t)}
+	}
+	// Interfaces may hold anything.
+	return &Schema{}
+}
+
+// component registers the named struct t and returns its component name. Types of different
+// packages that share a name are told apart by the name of their package.
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := s.components[name]; taken {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	s.names[t] = name
+	// Reserved before the fields are walked, so that recursive types refer to themselves.
+	s.components[name] = &Schema{}
+	object := s.object(t)
+	s.components[name] = object
+	return name
+}
+
+// object returns the schema of the struct t. Embedded structs without a JSON name are
+// flattened into it, as encoding/json does.
+func (s *schemas) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded := s.object(fieldType)
+			for property, value := range embedded.Properties {
+				schema.Properties[property] = value
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.of(field.Type)
+		if constrain(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// constrain applies the rules of a validate tag that a schema can express to schema, and
+// reports whether the tag makes the field required. Rules after dive apply to the items.
+func constrain(schema *Schema, tag string) (required bool) {
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		name, value, _ := strings.Cut(rule, "=")
+		if target.Ref != "" {
+			// Components are shared, so the rules of one field cannot be written into them.
+			return required
+		}
+		switch name {
+		case "required":
+			required = required || target == schema
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "oneof":
+			target.Enum = strings.Fields(value)
+		case "min", "max", "len":
+			bound(target, name, value)
+		case "gt", "gte", "lt", "lte":
+			limit(target, name, value)
+		case "url", "http_url":
+			target.Format = "uri"
+		case "email", "uuid":
+			target.Format = name
+		}
+	}
+	return required
+}
+
+// bound applies a min, max or len rule, which counts characters of strings, items of arrays
+// and is the value itself for numbers.
+func bound(schema *Schema, rule, value string) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	lower, upper := rule != "max", rule != "min"
+	switch schema.Type {
+	case "string":
+		if lower {
+			schema.MinLength = &n
+		}
+		if upper {
+			schema.MaxLength = &n
+		}
+	case "array":
+		if lower {
+			schema.MinItems = &n
+		}
+		if upper {
+			schema.MaxItems = &n
+		}
+	case "integer", "number":
+		f := float64(n)
+		if lower {
+			schema.Minimum = &f
+		}
+		if upper {
+			schema.Maximum = &f
+		}
+	}
+}
+
+// limit applies a gt, gte, lt or lte rule to a number.
+func limit(schema *Schema, rule, value string) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || (schema.Type != "integer" && schema.Type != "number") {
+		return
+	}
+	switch rule {
+	case "gt", "gte":
+		schema.Minimum, schema.ExclusiveMinimum = &f, rule == "gt"
+	case "lt", "lte":
+		schema.Maximum, schema.ExclusiveMaximum = &f, rule == "lt"
+	}
+}
@@ -0,0 +1,46 @@
+package openapi
+
+import (
+	"payment/api/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFollowsValidateTags(t *testing.T) {
+	s := newSchemas()
+	ref := s.of(reflect.TypeOf(models.WebhookSubscriptionRequest{}))
+	if ref.Ref != "#/components/schemas/WebhookSubscriptionRequest" {
+		t.Fatalf("got %+v, want a reference to the component", ref)
+	}
+	schema := s.components["WebhookSubscriptionRequest"]
+
+	if got := strings.Join(schema.Required, ","); got != "url,event_types" {
+		t.Errorf("got required %q, want url and event_types", got)
+	}
+	if schema.Properties["url"].Format != "uri" {
+		t.Errorf("got url format %q, want uri", schema.Properties["url"].Format)
+	}
+	events := schema.Properties["event_types"]
+	if events.Type != "array" || events.MinItems == nil || *events.MinItems != 1 || len(events.Items.Enum) != 4 {
+		t.Errorf("got event_types %+v, want at least one of the four event types", events)
+	}
+	if secret := schema.Properties["secret"]; *secret.MinLength != 16 || *secret.MaxLength != 255 {
+		t.Errorf("got secret %+v, want between 16 and 255 characters", secret)
+	}
+}
+
+func TestSchemaFlattensEmbeddedStructs(t *testing.T) {
+	s := newSchemas()
+	s.of(reflect.TypeOf(models.APIKey{}))
+	schema := s.components["APIKey"]
+	if _, ok := schema.Properties["id"]; !ok {
+		t.Errorf("got properties %v, want the fields of the embedded model", schema.Properties)
+	}
+	if _, ok := schema.Properties["hash"]; ok {
+		t.Error("a field that is not encoded is in the schema")
+	}
+	if schema.Properties["revoked_at"].Format != "date-time" || !schema.Properties["revoked_at"].Nullable {
+		t.Errorf("got revoked_at %+v, want a nullable date-time", schema.Properties["revoked_at"])
+	}
+}
@@ -0,0 +1,74 @@
+package phone
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// metadata lists the numbering rules of each supported region.
+//
+//go:embed metadata.csv
+var metadata string
+
+// country holds the numbering rules of a region.
+type country struct {
+	region              string
+	code                string
+	internationalPrefix string
+	nationalPrefix      string
+	// mobile matches the whole national significant number of a mobile line.
+	mobile *regexp.Regexp
+}
+
+// format returns the E.164 form of the national significant number nsn.
+func (c *country) format(nsn string) string {
+	return "+" + c.code + nsn
+}
+
+// regions holds the rules of each region by its ISO 3166-1 alpha-2 code, and codes the regions
+// that share each country code, in the order of the table.
+var regions, codes = mustParse(metadata)
+
+func mustParse(table string) (map[string]*country, map[string][]*country) {
+	byRegion, byCode, err := parse(table)
+	if err != nil {
+		panic(fmt.Sprintf("phone: invalid metadata: %v", err))
+	}
+	return byRegion, byCode
+}
+
+// parse reads the metadata table.
+func parse(table string) (map[string]*country, map[string][]*country, error) {
+	reader := csv.NewReader(strings.NewReader(table))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byRegion := make(map[string]*country, len(records))
+	byCode := make(map[string][]*country, len(records))
+	for _, record := range records {
+		mobile, err := regexp.Compile(`^(?:` + record[4] + `)$`)
+		if err != nil {
+			return nil, nil, fmt.Errorf("region %s: %w", record[0], err)
+		}
+		c := &country{
+			region:              record[0],
+			code:                record[1],
+			internationalPrefix: record[2],
+			nationalPrefix:      record[3],
+			mobile:              mobile,
+		}
+		if _, ok := byRegion[c.region]; ok {
+			return nil, nil, fmt.Errorf("region %s is listed twice", c.region)
+		}
+		byRegion[c.region] = c
+		byCode[c.code] = append(byCode[c.code], c)
+	}
+	return byRegion, byCode, nil
+}
@@ -0,0 +1,101 @@
+// Package phone normalizes phone numbers to E.164, so that the same line is stored and looked
+// up under one number whichever way it was written. Numbers are checked against the mobile
+// ranges of their country, from a table compiled into the binary; numbers written without a
+// country code are read as numbers of the default region.
+package phone
+
+import (
+	"payment/pkg/errors"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultRegion is the default region until SetDefaultRegion is called.
+const defaultRegion = "IR"
+
+var current atomic.Pointer[country]
+
+func init() {
+	current.Store(regions[defaultRegion])
+}
+
+// SetDefaultRegion sets the region, as an ISO 3166-1 alpha-2 code, that numbers without a
+// country code belong to.
+func SetDefaultRegion(region string) error {
+	c, ok := regions[strings.ToUpper(region)]
+	if !ok {
+		return errors.ErrBadRequest.WithMessage("unknown phone region %q", region)
+	}
+	current.Store(c)
+	return nil
+}
+
+// Supported reports whether the numbering rules of region are known.
+func Supported(region string) bool {
+	_, ok := regions[strings.ToUpper(region)]
+	return ok
+}
+
+// DefaultRegion returns the region that numbers without a country code belong to.
+func DefaultRegion() string {
+	return current.Load().region
+}
+
+// Normalize returns number in E.164 form, reading a national number as a number of the
+// default region. It fails with ErrInvalidPhone unless number is a mobile number.
+func Normalize(number string) (string, error) {
+	return Parse(number, DefaultRegion())
+}
+
+// Parse returns number in E.164 form, reading a national number as a number of region.
+// Spaces, dashes, dots and parentheses are ignored. A number is international when it starts
+// with + or the international prefix of region, and national when it starts with the national
+// prefix of region; digits that are neither are tried as a national number of region first,
+// and as an international number without the + otherwise.
+func Parse(number, region string) (string, error) {
+	home, ok := regions[strings.ToUpper(region)]
+	if !ok {
+		return "", errors.ErrBadRequest.WithMessage("unknown phone region %q", region)
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, number)
+	international := strings.HasPrefix(digits, "+")
+	digits = strings.TrimPrefix(digits, "+")
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return "", invalid(number)
+	}
+
+	switch {
+	case international:
+	case strings.HasPrefix(digits, home.internationalPrefix):
+		digits, international = digits[len(home.internationalPrefix):], true
+	case strings.HasPrefix(digits, home.nationalPrefix) && home.mobile.MatchString(digits[len(home.nationalPrefix):]):
+		return home.format(digits[len(home.nationalPrefix):]), nil
+	case home.mobile.MatchString(digits):
+		return home.format(digits), nil
+	}
+
+	// Country codes are prefix free, so at most one of their lengths matches.
+	for length := 1; length <= 3 && length < len(digits); length++ {
+		for _, c := range codes[digits[:length]] {
+			if c.mobile.MatchString(digits[length:]) {
+				return c.format(digits[length:]), nil
+			}
+		}
+	}
+	return "", invalid(number)
+}
+
+// invalid reports number as invalid without repeating it, since error messages are logged.
+func invalid(number string) error {
+	if number == "" {
+		return errors.ErrInvalidPhone.WithMessage("phone number is required")
+	}
+	return errors.ErrInvalidPhone.WithMessage("phone number is not a valid mobile number")
+}
@@ -0,0 +1,108 @@
+package phone
+
+import (
+	"payment/pkg/errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		number string
+		region string
+		want   string
+	}{
+		{"+989121234567", "IR", "+989121234567"},
+		{"00989121234567", "IR", "+989121234567"},
+		{"989121234567", "IR", "+989121234567"},
+		{"09121234567", "IR", "+989121234567"},
+		{"9121234567", "IR", "+989121234567"},
+		{"+98 912 123-4567", "IR", "+989121234567"},
+		{"0912 (123) 4567", "IR", "+989121234567"},
+		{"+447911123456", "IR", "+447911123456"},
+		{"07911123456", "GB", "+447911123456"},
+		{"00989121234567", "GB", "+989121234567"},
+		{"(415) 555-2671", "US", "+14155552671"},
+		{"1 415 555 2671", "US", "+14155552671"},
+		{"011989121234567", "US", "+989121234567"},
+		{"+4915123456789", "DE", "+4915123456789"},
+
+		{"", "IR", ""},
+		{"+", "IR", ""},
+		{"+98", "IR", ""},
+		// A landline of Tehran.
+		{"02112345678", "IR", ""},
+		{"98912123456", "IR", ""},
+		{"9891212345678", "IR", ""},
+		{"98912abc4567", "IR", ""},
+		{"+989121234567\n", "IR", ""},
+		{"۰۹۱۲۱۲۳۴۵۶۷", "IR", ""},
+		{"+123456789012345", "IR", ""},
+		// The national prefix of Iran means nothing after a country code.
+		{"+9809121234567", "IR", ""},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.number, tt.region)
+		if tt.want == "" {
+			if !errors.Is(err, errors.ErrInvalidPhone) {
+				t.Errorf("Parse(%q, %s) = %q, %v, want %v", tt.number, tt.region, got, err, errors.ErrInvalidPhone)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q, %s) = %q, %v, want %q", tt.number, tt.region, got, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultRegion(t *testing.T) {
+	if DefaultRegion() != defaultRegion {
+		t.Fatalf("got default region %s, want %s", DefaultRegion(), defaultRegion)
+	}
+	if err := SetDefaultRegion("xx"); err == nil {
+		t.Error("set an unknown region")
+	}
+	if err := SetDefaultRegion("gb"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetDefaultRegion(defaultRegion) })
+
+	if got, err := Normalize("07911 123456"); err != nil || got != "+447911123456" {
+		t.Errorf("got %q, %v, want the number read as British", got, err)
+	}
+	if got, err := Normalize("989121234567"); err != nil || got != "+989121234567" {
+		t.Errorf("got %q, %v, want the international number kept", got, err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	if _, _, err := parse("IR,98,00,0,9(\n"); err == nil {
+		t.Error("parsed an invalid pattern")
+	}
+	if _, _, err := parse("IR,98,00,0,9\\d{9}\nIR,98,00,0,9\\d{9}\n"); err == nil {
+		t.Error("parsed a region listed twice")
+	}
+	for region, c := range regions {
+		if c.region != region || c.code == "" || c.internationalPrefix == "" {
+			t.Errorf("incomplete metadata for %s: %+v", region, c)
+		}
+	}
+}
+
+func FuzzNormalize(f *testing.F) {
+	for _, seed := range []string{"", "+989121234567", "09121234567", "00989121234567", "+", "abc", "+98 912", "۰۹۱۲"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, number string) {
+		normalized, err := Normalize(number)
+		if err != nil {
+			return
+		}
+		if len(normalized) < 3 || len(normalized) > 16 || normalized[0] != '+' {
+			t.Fatalf("Normalize(%q) = %q, which is not E.164", number, normalized)
+		}
+		again, err := Normalize(normalized)
+		if err != nil || again != normalized {
+			t.Fatalf("Normalize(%q) = %q, %v, want it unchanged", normalized, again, err)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"net/http"
+	"payment/pkg/errors"
+	"reflect"
+	"strings"
+)
+
+// MaxBodySize caps the size of a JSON request body in bytes.
+const MaxBodySize = 1 << 20
+
+// DecodeJSON decodes the JSON body of r into v and validates it. The body must hold a single
+// object of no more than MaxBodySize bytes, with no fields that v does not declare. The error
+// is a DomainError that can be passed to errors.Respond as it is, with one FieldError per
+// rejected field when the fault lies with particular fields.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, validate *validator.Validate, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if err := decoder.Decode(&struct{}{}); !stderrors.Is(err, io.EOF) {
+		return errors.ErrInvalidBody.WithMessage("request body must hold a single JSON object").Wrap(err)
+	}
+	if err := validate.Struct(v); err != nil {
+		return errors.Validation(err)
+	}
+	return nil
+}
+
+// decodeError converts an error of json.Decoder into the DomainError reported to the client.
+func decodeError(err error) *errors.DomainError {
+	var (
+		tooLarge  *http.MaxBytesError
+		syntax    *json.SyntaxError
+		mismatch  *json.UnmarshalTypeError
+		unmarshal *json.InvalidUnmarshalError
+	)
+	switch {
+	case stderrors.As(err, &tooLarge):
+		return errors.ErrBodyTooLarge.WithMessage("request body must not exceed %d bytes", tooLarge.Limit).Wrap(err)
+	case stderrors.Is(err, io.EOF):
+		return errors.ErrInvalidBody.WithMessage("request body is empty")
+	case stderrors.Is(err, io.ErrUnexpectedEOF), stderrors.As(err, &syntax):
+		return errors.ErrInvalidBody.WithMessage("request body is not valid JSON").Wrap(err)
+	case stderrors.As(err, &mismatch) && mismatch.Field != "":
+		return errors.ErrValidation.WithDetails(errors.FieldError{
+			Field:   mismatch.Field,
+			Rule:    "type",
+			Param:   jsonType(mismatch.Type),
+			Message: fmt.Sprintf("%s must be of type %s", mismatch.Field, jsonType(mismatch.Type)),
+		}).Wrap(err)
+	case stderrors.As(err, &unmarshal):
+		return errors.ErrInternal.Wrap(err)
+	}
+	// encoding/json reports unknown fields with a plain error.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(field, `"`)
+		return errors.ErrValidation.WithDetails(errors.FieldError{
+			Field:   field,
+			Rule:    "unknown",
+			Message: fmt.Sprintf("%s is not a known field", field),
+		}).Wrap(err)
+	}
+	return errors.ErrInvalidBody.Wrap(err)
+}
+
+// jsonType names the JSON type that decodes into t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
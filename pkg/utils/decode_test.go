@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"payment/pkg/errors"
+	"strings"
+	"testing"
+)
+
+type decodeRequest struct {
+	Name   string `json:"name" validate:"required"`
+	Amount int64  `json:"amount" validate:"gt=0"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	validate := newValidator(t)
+	validate.RegisterTagNameFunc(JSONTagName)
+
+	tests := []struct {
+		name  string
+		body  string
+		code  errors.Code
+		field string
+		rule  string
+	}{
+		{"valid", `{"name": "a", "amount": 1}`, "", "", ""},
+		{"empty", ``, errors.CodeInvalidPayload, "", ""},
+		{"malformed", `{"name": "a",`, errors.CodeInvalidPayload, "", ""},
+		{"trailing data", `{"name": "a", "amount": 1} {}`, errors.CodeInvalidPayload, "", ""},
+		{"unknown field", `{"name": "a", "amount": 1, "balance": 5}`, errors.CodeValidation, "balance", "unknown"},
+		{"wrong type", `{"name": "a", "amount": "1"}`, errors.CodeValidation, "amount", "type"},
+		{"zero amount", `{"name": "a", "amount": 0}`, errors.CodeValidation, "amount", "gt"},
+		{"too large", `{"name": "` + strings.Repeat("a", MaxBodySize) + `"}`, errors.CodeTooLarge, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			var request decodeRequest
+			err := DecodeJSON(httptest.NewRecorder(), r, validate, &request)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var domain *errors.DomainError
+			if !errors.As(err, &domain) || domain.Code != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+			if tt.field != "" && (len(domain.Details) != 1 || domain.Details[0].Field != tt.field || domain.Details[0].Rule != tt.rule) {
+				t.Fatalf("got details %+v, want %s to fail %s", domain.Details, tt.field, tt.rule)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
+	"fmt"
 	"math/big"
 )
 
@@ -10,6 +11,9 @@ var characterSet = []rune("ABCDEFGHJKLMNPQRSTUVWXYZ23456789")
 
 // GenerateDiscount generates a random code of the specified length using CSPRNG.
 func GenerateDiscount(length int) (string, error) {
+	if length < 1 {
+		return "", fmt.Errorf("invalid discount code length %d", length)
+	}
 	result := make([]rune, length)
 	for i := range result {
 		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(characterSet))))
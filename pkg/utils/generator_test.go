@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzGenerateDiscount(f *testing.F) {
+	for _, seed := range []int{-1, 0, 1, 6, 8, 64} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, length int) {
+		if length > 1024 {
+			length %= 1024
+		}
+		code, err := GenerateDiscount(length)
+		if length < 1 {
+			if err == nil {
+				t.Fatalf("GenerateDiscount(%d) returned %q without an error", length, code)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != length {
+			t.Fatalf("GenerateDiscount(%d) returned %d characters", length, len(code))
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(string(characterSet), r) {
+				t.Fatalf("GenerateDiscount(%d) returned %q with character %q outside the set", length, code, r)
+			}
+		}
+	})
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"github.com/go-playground/validator/v10"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
 // DescriptionValidator are a custom validation function for description field
@@ -12,3 +14,16 @@ func DescriptionValidator(fl validator.FieldLevel) bool {
 	description := fl.Field().String()
 	return regex.MatchString(description)
 }
+
+// JSONTagName reports struct fields by their json name so that validation
+// details use the same field names as the request payload.
+func JSONTagName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
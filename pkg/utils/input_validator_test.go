@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"github.com/go-playground/validator/v10"
+	"strings"
+	"testing"
+)
+
+func newValidator(t testing.TB) *validator.Validate {
+	t.Helper()
+	validate := validator.New()
+	if err := validate.RegisterValidation("description", DescriptionValidator); err != nil {
+		t.Fatal(err)
+	}
+	return validate
+}
+
+// allowedDescription is the reference definition of a valid description.
+func allowedDescription(description string) bool {
+	if description == "" {
+		return false
+	}
+	for _, r := range description {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(" \t\n\f\r,.!?'-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzDescriptionValidator(f *testing.F) {
+	validate := newValidator(f)
+	for _, seed := range []string{"", "Voucher for cup league", "Withdrawal for groceries!", "<script>", "50% off", "تخفیف", "a\nb"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, description string) {
+		got := validate.Var(description, "description") == nil
+		if want := allowedDescription(description); got != want {
+			t.Fatalf("DescriptionValidator(%q) = %v, want %v", description, got, want)
+		}
+	})
+}
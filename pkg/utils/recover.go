@@ -3,6 +3,8 @@ package utils
 import (
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"payment/pkg/errors"
+	"payment/pkg/logging"
 	"runtime/debug"
 )
 
@@ -14,10 +16,9 @@ func RecoverHandler(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("panic: %+v", err)
+				logging.FromContext(r.Context(), log.StandardLogger()).Errorf("panic: %+v", err)
 				debug.PrintStack()
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("Internal Server Error"))
+				errors.Respond(w, errors.ErrInternal)
 			}
 		}()
 